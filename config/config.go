@@ -3,15 +3,22 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 const (
 	// MemoryDatabase value can be used to start the service using an in-memory DB. See Service/DbType.
-	MemoryDatabase      = "memory"
+	MemoryDatabase = "memory"
 	// SQLServerDriverName value can be used to start the service using an external MsSql DB. See Service/DbType.
 	SQLServerDriverName = "mssql"
 	// PostgresDriverName value
 	PostgresDriverName = "postgres"
+	// MySQLDriverName value can be used to start the service using an external MySQL or MariaDB DB. See Service/DbType.
+	MySQLDriverName = "mysql"
+	// SQLiteDriverName value can be used to start the service using an embedded SQLite DB, see Config/SQLitePath. See Service/DbType.
+	SQLiteDriverName = "sqlite"
+	// BoltDatabase value can be used to start the service using an embedded bbolt file, see Config/BoltPath. See Service/DbType.
+	BoltDatabase = "bolt"
 )
 
 // Config is a struct used for configuring the connection and the usage of the database.
@@ -23,6 +30,62 @@ type Config struct {
 	User              string `envconfig:"username,default=test" json:"User"`
 	Pass              string `envconfig:"password,default=test" json:"-"` // hidden from logging
 	DbOrdersTableName string `envconfig:"tablename,default=orders" json:"OrdersTable"`
+	SQLitePath        string `envconfig:"sqlitepath,default=:memory:" json:"SQLitePath"`
+	BoltPath          string `envconfig:"boltpath,default=orders.db" json:"BoltPath"`
+	// SnapshotPath enables snapshots of the in-memory DB, which is then written to the file every SnapshotInterval,
+	// or only when the service stops if SnapshotInterval is not positive.
+	SnapshotPath     string        `envconfig:"snapshotpath,optional" json:"SnapshotPath"`
+	SnapshotInterval time.Duration `envconfig:"snapshotinterval,default=30s" json:"SnapshotInterval"`
+	// MaxOpenConns, MaxIdleConns, ConnMaxLifetime and ConnMaxIdleTime tune the connection pools of the SQL databases,
+	// zero keeps the default of `sql.DB`. SQLite ignores them, its single connection is kept open.
+	MaxOpenConns    int           `envconfig:"maxopenconns,default=10" json:"MaxOpenConns"`
+	MaxIdleConns    int           `envconfig:"maxidleconns,default=5" json:"MaxIdleConns"`
+	ConnMaxLifetime time.Duration `envconfig:"connmaxlifetime,default=30m" json:"ConnMaxLifetime"`
+	ConnMaxIdleTime time.Duration `envconfig:"connmaxidletime,default=5m" json:"ConnMaxIdleTime"`
+	// ConnectTimeout is how long the service waits for the database to accept connections when it starts.
+	ConnectTimeout time.Duration `envconfig:"connecttimeout,default=1m" json:"ConnectTimeout"`
+	// RetryAttempts limits the attempts of the reads and idempotent writes failing with a transient error,
+	// which are retried after a backoff growing from RetryBackoff to RetryMaxBackoff.
+	RetryAttempts   int           `envconfig:"retryattempts,default=3" json:"RetryAttempts"`
+	RetryBackoff    time.Duration `envconfig:"retrybackoff,default=100ms" json:"RetryBackoff"`
+	RetryMaxBackoff time.Duration `envconfig:"retrymaxbackoff,default=5s" json:"RetryMaxBackoff"`
+	// BreakerFailures consecutive failures of the database open its circuit breaker for BreakerCoolDown, zero disables it.
+	BreakerFailures int           `envconfig:"breakerfailures,default=5" json:"BreakerFailures"`
+	BreakerCoolDown time.Duration `envconfig:"breakercooldown,default=30s" json:"BreakerCoolDown"`
+	// CacheTTL and CacheMaxEntries bound the order listings cached for the Service/CachedTenants.
+	CacheTTL        time.Duration `envconfig:"cachettl,default=5s" json:"CacheTTL"`
+	CacheMaxEntries int           `envconfig:"cachemaxentries,default=1000" json:"CacheMaxEntries"`
+	// OutboxSink is the URL the order events of the SQL databases are delivered to, an empty URL disables the outbox.
+	// Every OutboxPollInterval up to OutboxBatchSize events are delivered, each within OutboxTimeout, and failed
+	// deliveries are retried after a backoff growing from OutboxRetryBackoff to OutboxMaxBackoff.
+	OutboxSink         string        `envconfig:"outboxsink,optional" json:"OutboxSink"`
+	OutboxPollInterval time.Duration `envconfig:"outboxpollinterval,default=1s" json:"OutboxPollInterval"`
+	OutboxBatchSize    int           `envconfig:"outboxbatchsize,default=100" json:"OutboxBatchSize"`
+	OutboxTimeout      time.Duration `envconfig:"outboxtimeout,default=10s" json:"OutboxTimeout"`
+	OutboxRetryBackoff time.Duration `envconfig:"outboxretrybackoff,default=1s" json:"OutboxRetryBackoff"`
+	OutboxMaxBackoff   time.Duration `envconfig:"outboxmaxbackoff,default=5m" json:"OutboxMaxBackoff"`
+	// IdempotencyKeyTTL is how long the response to an order creation is replayed to the requests with the same Idempotency-Key,
+	// and IdempotencyKeyLease how long the key is kept for a request in progress, after which the request is deemed
+	// lost, for example because the service crashed, and the key can be used again. The expired keys are removed
+	// every IdempotencyPurgeInterval, which must be positive.
+	IdempotencyKeyTTL        time.Duration `envconfig:"idempotencykeyttl,default=24h" json:"IdempotencyKeyTTL"`
+	IdempotencyKeyLease      time.Duration `envconfig:"idempotencykeylease,default=1m" json:"IdempotencyKeyLease"`
+	IdempotencyPurgeInterval time.Duration `envconfig:"idempotencypurgeinterval,default=10m" json:"IdempotencyPurgeInterval"`
+	// RetentionPolicies move the orders older than an age out of the SQL databases, every RetentionInterval and
+	// RetentionBatchSize orders per transaction, both of which must be positive. A policy is written
+	// `<end-user>/<namespace>=<age>[:archive|delete]`, `default` standing for the end-users without a database of
+	// their own and `*` for every other namespace, see the `db/retention` package.
+	RetentionPolicies  []string      `envconfig:"retentionpolicies,optional" json:"RetentionPolicies"`
+	RetentionInterval  time.Duration `envconfig:"retentioninterval,default=1h" json:"RetentionInterval"`
+	RetentionBatchSize int           `envconfig:"retentionbatchsize,default=500" json:"RetentionBatchSize"`
+	// EncryptionKeyFile holds the master keys wrapping the data keys of the Service/EncryptedTenants, which encrypt
+	// their EncryptedFields, `total` and `labels` if none is given. The orders not encrypted with the active data key
+	// are encrypted again every ReencryptionInterval, ReencryptionBatchSize orders per transaction, both of which
+	// must be positive. See the `db/encryption` package.
+	EncryptionKeyFile     string        `envconfig:"encryptionkeyfile,optional" json:"EncryptionKeyFile"`
+	EncryptedFields       []string      `envconfig:"encryptedfields,optional" json:"EncryptedFields"`
+	ReencryptionInterval  time.Duration `envconfig:"reencryptioninterval,default=1m" json:"ReencryptionInterval"`
+	ReencryptionBatchSize int           `envconfig:"reencryptionbatchsize,default=500" json:"ReencryptionBatchSize"`
 }
 
 // String returns a printable representation of the config as JSON.
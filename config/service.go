@@ -9,10 +9,23 @@ import (
 // by reading the values from the environment or using the default values.
 type Service struct {
 	Port   string `envconfig:"serviceport,default=8017" json:"Port"`
-	DBConnection1	string `envconfig:"dbconnection1`
-	DBConnection2	string `envconfig:"dbconnection2`
+	DbType string `envconfig:"dbtype,default=memory" json:"DbType"` // see constants in config.go
+	// EndUser1 and EndUser2 are served from the PostgreSQL databases at DBConnection1 and DBConnection2 instead of DbType.
+	EndUser1      string `envconfig:"enduser1,optional" json:"EndUser1"`
+	DBConnection1 string `envconfig:"dbconnection1,optional" json:"-"` // hidden from logging
+	EndUser2      string `envconfig:"enduser2,optional" json:"EndUser2"`
+	DBConnection2 string `envconfig:"dbconnection2,optional" json:"-"` // hidden from logging
+	// CachedTenants are the end-users whose order listings are cached, DefaultTenant standing for everyone without
+	// a database of their own. See Config/CacheTTL.
+	CachedTenants []string `envconfig:"cachedtenants,optional" json:"CachedTenants"`
+	// EncryptedTenants are the end-users whose orders are encrypted, DefaultTenant standing for everyone without
+	// a database of their own. See Config/EncryptionKeyFile.
+	EncryptedTenants []string `envconfig:"encryptedtenants,optional" json:"EncryptedTenants"`
 }
 
+// DefaultTenant names the repository serving the end-users which have no database of their own.
+const DefaultTenant = "default"
+
 // String returns a printable representation of the config as JSON.
 // Use the struct field tag `json:"-"` to hide fields that should not be revealed such as credentials and secrets.
 func (s Service) String() string {
@@ -0,0 +1,217 @@
+// Package archive writes the orders of a repository to portable archives, and restores them from such archives.
+//
+// An archive is a zip file holding the orders as JSON lines in `orders.jsonl`, followed by `manifest.json`,
+// which tells the version of the format, how many orders every namespace has and the SHA-256 checksum of the orders.
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+const (
+	// Format identifies the archives of orders.
+	Format = "http-db-service/orders"
+	// Version is the version of the format written, archives of a later version cannot be read.
+	Version = 1
+
+	manifestFile = "manifest.json"
+	ordersFile   = "orders.jsonl"
+	// maxFileBytes limits the uncompressed size of the files of an archive, which are read in memory, so that a small
+	// archive cannot expand without bounds, and maxLineBytes the size of a single order.
+	maxFileBytes = 512 << 20
+	maxLineBytes = 1 << 20
+)
+
+// Manifest describes the content of an archive.
+type Manifest struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	// EndUser is the `end-user` whose repository was archived, empty for the shared repository.
+	EndUser string `json:"endUser,omitempty"`
+	// Namespace is the archived namespace, empty if all namespaces were archived.
+	Namespace string `json:"namespace,omitempty"`
+	// Namespaces is the number of orders of every archived namespace.
+	Namespaces map[string]int `json:"namespaces"`
+	Orders     int            `json:"orders"`
+	// SHA256 is the hex-encoded checksum of the orders file.
+	SHA256 string `json:"sha256"`
+}
+
+// InvalidError tells why an archive cannot be restored.
+type InvalidError struct {
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return "invalid archive, " + e.Reason
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &InvalidError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Write writes the orders of the namespace, or of all namespaces if it is empty, to w as an archive.
+// The orders are streamed from the repository, so an error may leave an incomplete archive behind.
+func Write(w io.Writer, repo repository.OrderRepository, manifest Manifest) (Manifest, error) {
+	manifest.Format = Format
+	manifest.Version = Version
+	manifest.Namespaces = make(map[string]int)
+	manifest.Orders = 0
+
+	archive := zip.NewWriter(w)
+	file, err := archive.Create(ordersFile)
+	if err != nil {
+		return Manifest{}, errors.Wrap(err, "while writing the orders file")
+	}
+	hash := sha256.New()
+	encoder := json.NewEncoder(io.MultiWriter(file, hash))
+	err = repository.StreamOrders(repo, manifest.Namespace, nil, func(order repository.Order) error {
+		manifest.Namespaces[order.Namespace]++
+		manifest.Orders++
+		return encoder.Encode(order)
+	})
+	if err != nil {
+		return Manifest{}, errors.Wrap(err, "while archiving orders")
+	}
+	manifest.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	file, err = archive.Create(manifestFile)
+	if err != nil {
+		return Manifest{}, errors.Wrap(err, "while writing the manifest")
+	}
+	if err := json.NewEncoder(file).Encode(manifest); err != nil {
+		return Manifest{}, errors.Wrap(err, "while writing the manifest")
+	}
+	return manifest, errors.Wrap(archive.Close(), "while writing the archive")
+}
+
+// Read reads the manifest and the orders of an archive, checking the orders against the manifest.
+// It returns an InvalidError if the archive is corrupted or cannot be restored.
+func Read(b []byte) (Manifest, []repository.Order, error) {
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return Manifest{}, nil, invalid("not a zip file: %s", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	for _, name := range []string{manifestFile, ordersFile} {
+		if files[name] == nil {
+			return Manifest{}, nil, invalid("%s is missing", name)
+		}
+	}
+
+	var manifest Manifest
+	content, err := readFile(files[manifestFile])
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return Manifest{}, nil, invalid("unreadable manifest: %s", err)
+	}
+	if manifest.Format != Format {
+		return Manifest{}, nil, invalid("unknown format '%s'", manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > Version {
+		return Manifest{}, nil, invalid("unsupported version %d, up to %d is supported", manifest.Version, Version)
+	}
+	if manifest.Orders < 0 {
+		return Manifest{}, nil, invalid("negative order count %d", manifest.Orders)
+	}
+
+	content, err = readFile(files[ordersFile])
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	checksum := sha256.Sum256(content)
+	if hex.EncodeToString(checksum[:]) != manifest.SHA256 {
+		return Manifest{}, nil, invalid("the checksum of %s does not match the manifest", ordersFile)
+	}
+	orders, err := readOrders(content, manifest)
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	return manifest, orders, nil
+}
+
+// readFile reads a file of the archive, up to maxFileBytes whatever size its header tells.
+func readFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxFileBytes {
+		return nil, invalid("%s is larger than %d bytes", file.Name, maxFileBytes)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, invalid("unreadable %s: %s", file.Name, err)
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(reader, maxFileBytes+1))
+	if err != nil {
+		return nil, invalid("unreadable %s: %s", file.Name, err)
+	}
+	if len(content) > maxFileBytes {
+		return nil, invalid("%s is larger than %d bytes", file.Name, maxFileBytes)
+	}
+	return content, nil
+}
+
+// readOrders decodes and validates the orders, which must match the counts of the manifest. The counts are not
+// trusted to size the orders read, which are only checked against them once read.
+func readOrders(content []byte, manifest Manifest) ([]repository.Order, error) {
+	type orderKey struct{ namespace, orderID string }
+	var orders []repository.Order
+	seen := make(map[orderKey]bool)
+	namespaces := make(map[string]int)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	for line := 1; scanner.Scan(); line++ {
+		var order repository.Order
+		if err := json.Unmarshal(scanner.Bytes(), &order); err != nil {
+			return nil, invalid("unreadable order on line %d: %s", line, err)
+		}
+		if order.OrderId == "" || order.Namespace == "" {
+			return nil, invalid("the order on line %d has no ID or namespace", line)
+		}
+		if err := repository.ValidateNamespace(order.Namespace); err != nil {
+			return nil, invalid("the order on line %d has an invalid namespace, %s", line, err)
+		}
+		if err := repository.ValidateLabels(order.Labels); err != nil {
+			return nil, invalid("the order on line %d has an %s", line, err)
+		}
+		key := orderKey{order.Namespace, order.OrderId}
+		if seen[key] {
+			return nil, invalid("order %s of namespace %s is given twice", order.OrderId, order.Namespace)
+		}
+		seen[key] = true
+		namespaces[order.Namespace]++
+		orders = append(orders, order)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, invalid("unreadable %s: %s", ordersFile, err)
+	}
+
+	if len(orders) != manifest.Orders || len(namespaces) != len(manifest.Namespaces) {
+		return nil, invalid("the orders do not match the manifest")
+	}
+	for ns, count := range namespaces {
+		if manifest.Namespaces[ns] != count {
+			return nil, invalid("the orders of namespace %s do not match the manifest", ns)
+		}
+	}
+	return orders, nil
+}
@@ -0,0 +1,266 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+var orders = []repository.Order{
+	{OrderId: "orderId1", Namespace: "n7", Total: 10, Labels: map[string]string{"channel": "web"}},
+	{OrderId: "orderId2", Namespace: "n7", Total: 20},
+	{OrderId: "orderId1", Namespace: "n8", Total: 30},
+}
+
+func newRepository(t *testing.T, orders ...repository.Order) repository.OrderRepository {
+	repo := repository.NewOrderRepositoryMemory()
+	for _, order := range orders {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+	return repo
+}
+
+func write(t *testing.T, repo repository.OrderRepository, ns string) (Manifest, []byte) {
+	var buffer bytes.Buffer
+	manifest, err := Write(&buffer, repo, Manifest{CreatedAt: time.Date(2026, time.March, 1, 10, 0, 0, 0, time.UTC), EndUser: "alice", Namespace: ns})
+	require.NoError(t, err)
+	return manifest, buffer.Bytes()
+}
+
+// rewrite returns the archive with its files changed by fn.
+func rewrite(t *testing.T, b []byte, fn func(files map[string][]byte)) []byte {
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		content, err := readFile(file)
+		require.NoError(t, err)
+		files[file.Name] = content
+	}
+	fn(files)
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		require.NoError(t, err)
+		_, err = file.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+// oversized returns the archive with an orders file whose header tells it is larger than maxFileBytes.
+func oversized(t *testing.T, b []byte) []byte {
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, file := range archive.File {
+		header := file.FileHeader
+		if file.Name == ordersFile {
+			header.UncompressedSize64 = maxFileBytes + 1
+		}
+		raw, err := file.OpenRaw()
+		require.NoError(t, err)
+		target, err := writer.CreateRaw(&header)
+		require.NoError(t, err)
+		_, err = io.Copy(target, raw)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestWriteAndRead(t *testing.T) {
+	repo := newRepository(t, orders...)
+
+	// when
+	written, b := write(t, repo, "")
+	manifest, result, err := Read(b)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, written, manifest)
+	assert.Equal(t, Format, manifest.Format)
+	assert.Equal(t, Version, manifest.Version)
+	assert.Equal(t, "alice", manifest.EndUser)
+	assert.Equal(t, 3, manifest.Orders)
+	assert.Equal(t, map[string]int{"n7": 2, "n8": 1}, manifest.Namespaces)
+	assert.ElementsMatch(t, orders, result)
+}
+
+func TestWriteNamespace(t *testing.T) {
+	repo := newRepository(t, orders...)
+
+	// when
+	_, b := write(t, repo, "n8")
+	manifest, result, err := Read(b)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "n8", manifest.Namespace)
+	assert.Equal(t, map[string]int{"n8": 1}, manifest.Namespaces)
+	assert.Equal(t, []repository.Order{orders[2]}, result)
+}
+
+func TestReadInvalidArchives(t *testing.T) {
+	_, b := write(t, newRepository(t, orders...), "")
+	manifest := func(fn func(m map[string]interface{})) func(files map[string][]byte) {
+		return func(files map[string][]byte) {
+			m := make(map[string]interface{})
+			require.NoError(t, json.Unmarshal(files[manifestFile], &m))
+			fn(m)
+			content, err := json.Marshal(m)
+			require.NoError(t, err)
+			files[manifestFile] = content
+		}
+	}
+
+	for name, archive := range map[string][]byte{
+		"not a zip file":   []byte("orders"),
+		"missing manifest": rewrite(t, b, func(files map[string][]byte) { delete(files, manifestFile) }),
+		"missing orders":   rewrite(t, b, func(files map[string][]byte) { delete(files, ordersFile) }),
+		"tampered orders": rewrite(t, b, func(files map[string][]byte) {
+			files[ordersFile] = bytes.Replace(files[ordersFile], []byte(`"total":30`), []byte(`"total":31`), 1)
+		}),
+		"unknown format":    rewrite(t, b, manifest(func(m map[string]interface{}) { m["format"] = "tar" })),
+		"later version":     rewrite(t, b, manifest(func(m map[string]interface{}) { m["version"] = Version + 1 })),
+		"wrong order count": rewrite(t, b, manifest(func(m map[string]interface{}) { m["orders"] = 2 })),
+		"negative orders":   rewrite(t, b, manifest(func(m map[string]interface{}) { m["orders"] = -1 })),
+		"huge order count":  rewrite(t, b, manifest(func(m map[string]interface{}) { m["orders"] = 1 << 40 })),
+		"wrong namespaces":  rewrite(t, b, manifest(func(m map[string]interface{}) { m["namespaces"] = map[string]int{"n7": 3} })),
+		"invalid namespace": rewrite(t, b, func(files map[string][]byte) {
+			files[ordersFile] = []byte(`{"orderId":"orderId1","namespace":"N_7","total":10}` + "\n")
+			checksum := sha256.Sum256(files[ordersFile])
+			manifest(func(m map[string]interface{}) {
+				m["orders"], m["namespaces"], m["sha256"] = 1, map[string]int{"N_7": 1}, hex.EncodeToString(checksum[:])
+			})(files)
+		}),
+		"too long order": rewrite(t, b, func(files map[string][]byte) {
+			long := `{"orderId":"` + strings.Repeat("o", maxLineBytes) + `","namespace":"n7","total":10}` + "\n"
+			files[ordersFile] = []byte(long)
+			checksum := sha256.Sum256(files[ordersFile])
+			manifest(func(m map[string]interface{}) {
+				m["orders"], m["namespaces"], m["sha256"] = 1, map[string]int{"n7": 1}, hex.EncodeToString(checksum[:])
+			})(files)
+		}),
+		"too large file": oversized(t, b),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := Read(archive)
+
+			require.Error(t, err)
+			assert.IsType(t, &InvalidError{}, err)
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	_, b := write(t, newRepository(t, orders...), "")
+	_, archived, err := Read(b)
+	require.NoError(t, err)
+	changed := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 99}
+
+	for _, tc := range []struct {
+		policy   Policy
+		result   Result
+		expected []repository.Order
+	}{
+		{Skip, Result{Created: 2, Skipped: 1}, []repository.Order{changed, orders[1], orders[2]}},
+		{Overwrite, Result{Created: 2, Updated: 1}, orders},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			repo := newRepository(t, changed)
+
+			// when
+			result, err := Restore(repo, archived, tc.policy)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, tc.result, result)
+			restored, err := repo.GetOrders()
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expected, restored)
+		})
+	}
+
+	t.Run(string(Fail), func(t *testing.T) {
+		repo := newRepository(t, changed)
+
+		// when
+		_, err := Restore(repo, archived, Fail)
+
+		// then
+		assert.Equal(t, &ConflictError{Orders: 1}, err)
+		restored, err := repo.GetOrders()
+		require.NoError(t, err)
+		assert.Equal(t, []repository.Order{changed}, restored)
+
+		result, err := Restore(newRepository(t), archived, Fail)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Created: 3}, result)
+	})
+}
+
+// hiddenRepository stands for a repository whose orders were created after they were read.
+type hiddenRepository struct {
+	repository.OrderRepository
+}
+
+func (hiddenRepository) GetNamespaceOrders(string) ([]repository.Order, error) {
+	return []repository.Order{}, nil
+}
+
+func (hiddenRepository) GetOrdersBySelector(string, repository.LabelSelector) ([]repository.Order, error) {
+	return []repository.Order{}, nil
+}
+
+func TestRestoreNeverOverwritesOrdersCreatedMeanwhile(t *testing.T) {
+	_, b := write(t, newRepository(t, orders...), "")
+	_, archived, err := Read(b)
+	require.NoError(t, err)
+	changed := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 99}
+
+	for policy, expected := range map[Policy]Result{Skip: {Created: 2, Skipped: 1}, Fail: {}} {
+		t.Run(string(policy), func(t *testing.T) {
+			repo := newRepository(t, changed)
+
+			// when
+			result, err := Restore(hiddenRepository{repo}, archived, policy)
+
+			// then
+			if policy == Fail {
+				assert.IsType(t, &ConflictError{}, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, expected, result)
+			restored, err := repo.GetNamespaceOrders("n7")
+			require.NoError(t, err)
+			assert.Contains(t, restored, changed)
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for name, expected := range map[string]Policy{"": Fail, "skip": Skip, "overwrite": Overwrite, "fail": Fail} {
+		policy, err := ParsePolicy(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, policy)
+	}
+	_, err := ParsePolicy("merge")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package archive
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// Policy tells what Restore does with the orders of an archive which already exist in the repository.
+type Policy string
+
+const (
+	// Skip keeps the existing orders, only the other orders are restored.
+	Skip Policy = "skip"
+	// Overwrite replaces the existing orders with the ones of the archive.
+	Overwrite Policy = "overwrite"
+	// Fail restores nothing if any order exists.
+	Fail Policy = "fail"
+)
+
+// ParsePolicy returns the Policy with the name, Fail if it is empty.
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(name); policy {
+	case "":
+		return Fail, nil
+	case Skip, Overwrite, Fail:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown conflict policy '%s', expected one of %s, %s or %s", name, Skip, Overwrite, Fail)
+	}
+}
+
+// Result tells how many orders of an archive were restored.
+type Result struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// ConflictError is returned by Restore with the Fail policy, when orders of the archive already exist.
+type ConflictError struct {
+	Orders int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d orders of the archive already exist", e.Orders)
+}
+
+// Restore writes the orders to the repository, all of them or none. With the Skip and Fail policies the orders are
+// inserted, so that the existing orders, created meanwhile included, are never overwritten: they are left out, or
+// fail the restore. Orders of the repository which are not in the archive are kept.
+func Restore(repo repository.OrderRepository, orders []repository.Order, policy Policy) (Result, error) {
+	var (
+		result  Result
+		created []bool
+		err     error
+	)
+	switch policy {
+	case Overwrite:
+		created, err = repo.UpsertOrders(orders)
+	default:
+		created, err = repo.InsertOrders(orders, policy == Skip)
+	}
+	if errors.Cause(err) == repository.ErrDuplicateKey && policy == Fail {
+		return Result{}, conflict(repo, orders)
+	}
+	if err != nil {
+		return Result{}, errors.Wrap(err, "while restoring orders")
+	}
+	for _, c := range created {
+		switch {
+		case c:
+			result.Created++
+		case policy == Skip:
+			result.Skipped++
+		default:
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// conflict returns the ConflictError of the orders, counting the ones which exist in the repository.
+func conflict(repo repository.OrderRepository, orders []repository.Order) error {
+	existing, err := existingOrders(repo, orders)
+	if err != nil {
+		return err
+	}
+	return &ConflictError{Orders: len(existing)}
+}
+
+type orderKey struct {
+	namespace, orderID string
+}
+
+// existingOrders returns the orders which exist in the repository, reading the namespaces of the orders.
+func existingOrders(repo repository.OrderRepository, orders []repository.Order) (map[orderKey]bool, error) {
+	archived := make(map[orderKey]bool, len(orders))
+	for _, order := range orders {
+		archived[orderKey{order.Namespace, order.OrderId}] = true
+	}
+
+	existing := make(map[orderKey]bool)
+	read := make(map[string]bool)
+	for _, order := range orders {
+		if read[order.Namespace] {
+			continue
+		}
+		read[order.Namespace] = true
+		err := repository.StreamOrders(repo, order.Namespace, nil, func(order repository.Order) error {
+			if key := (orderKey{order.Namespace, order.OrderId}); archived[key] {
+				existing[key] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "while reading the orders of namespace %s", order.Namespace)
+		}
+	}
+	return existing, nil
+}
@@ -0,0 +1,203 @@
+package audit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// Operations recorded in the audit log.
+const (
+	InsertOrder           = "InsertOrder"
+	UpsertOrders          = "UpsertOrders"
+	ImportOrders          = "ImportOrders"
+	RestoreOrders         = "RestoreOrders"
+	RenameNamespace       = "RenameNamespace"
+	MergeNamespace        = "MergeNamespace"
+	DeleteOrders          = "DeleteOrders"
+	DeleteNamespaceOrders = "DeleteNamespaceOrders"
+	// ArchiveExpiredOrders and DeleteExpiredOrders are recorded by the retention jobs, see the `db/retention` package.
+	ArchiveExpiredOrders = "ArchiveExpiredOrders"
+	DeleteExpiredOrders  = "DeleteExpiredOrders"
+)
+
+const (
+	// DefaultLimit is the number of entries returned when a Filter has no Limit.
+	DefaultLimit = 100
+	// MaxLimit is the largest number of entries returned at once.
+	MaxLimit = 1000
+	// memoryCapacity is the number of entries kept by a memory log, older ones are dropped.
+	memoryCapacity = 10000
+)
+
+// Entry records a call changing the orders, successful or not.
+type Entry struct {
+	Time      time.Time
+	EndUser   string
+	RequestID string
+	SourceIP  string
+	Operation string
+	// Namespace is empty when the operation affected all namespaces.
+	Namespace string
+	// TargetNamespace is the namespace the orders were moved to by a rename or a merge.
+	TargetNamespace string `json:",omitempty"`
+	OrderID         string
+	Selector        string
+	// Rows is the number of inserted or deleted orders.
+	Rows int64
+	// Error is the reason the operation failed, empty if it succeeded.
+	Error string
+}
+
+// Filter restricts the entries returned by a Log, empty fields match every entry.
+type Filter struct {
+	EndUser   string
+	Operation string
+	// Namespace matches the namespace of the entries, and the namespace orders were moved to.
+	Namespace string
+	RequestID string
+	// Since and Until bound the time of the entries, Since being inclusive and Until exclusive.
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of entries, DefaultLimit if zero and at most MaxLimit.
+	Limit int
+}
+
+// Matches tells whether the entry passes the filter, ignoring the Limit.
+func (f Filter) Matches(entry Entry) bool {
+	return (f.EndUser == "" || f.EndUser == entry.EndUser) &&
+		(f.Operation == "" || f.Operation == entry.Operation) &&
+		(f.Namespace == "" || f.Namespace == entry.Namespace || f.Namespace == entry.TargetNamespace) &&
+		(f.RequestID == "" || f.RequestID == entry.RequestID) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until))
+}
+
+// EntryLimit returns the number of entries to return for the filter, see Limit.
+func (f Filter) EntryLimit() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultLimit
+	case f.Limit > MaxLimit:
+		return MaxLimit
+	default:
+		return f.Limit
+	}
+}
+
+// Log is an append-only record of the changes to the orders of a database.
+type Log interface {
+	Record(entry Entry) error
+	// Entries returns the entries matching the filter, the most recent first.
+	Entries(filter Filter) ([]Entry, error)
+}
+
+// Provider is implemented by repositories which keep the audit log in their own database.
+type Provider interface {
+	AuditLog() Log
+}
+
+// For returns the audit log stored in the database of the repository.
+// Repositories which cannot store one, such as the in-memory repository, get a Log kept in memory.
+func For(repo repository.OrderRepository) Log {
+	switch r := repo.(type) {
+	case Provider:
+		return r.AuditLog()
+	case *repository.OrderRepositorySQL:
+		return NewSQL(r.Database, r.Dialect)
+	default:
+		return NewMemory()
+	}
+}
+
+// memoryLog is a Log of the most recent entries, kept in memory. It is safe for concurrent use.
+type memoryLog struct {
+	mutex   sync.RWMutex
+	entries []Entry
+}
+
+// NewMemory returns a Log kept in memory, which only holds the most recent entries and is lost on restart.
+func NewMemory() Log {
+	return &memoryLog{}
+}
+
+func (l *memoryLog) Record(entry Entry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.entries) == memoryCapacity {
+		l.entries = append(l.entries[:0], l.entries[1:]...)
+	}
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func (l *memoryLog) Entries(filter Filter) ([]Entry, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	entries := make([]Entry, 0)
+	for i := len(l.entries) - 1; i >= 0 && len(entries) < filter.EntryLimit(); i-- {
+		if filter.Matches(l.entries[i]) {
+			entries = append(entries, l.entries[i])
+		}
+	}
+	return entries, nil
+}
+
+// Registry keeps the audit logs of the tenant databases by end-user. It is safe for concurrent use.
+type Registry struct {
+	mutex sync.RWMutex
+	logs  map[string]Log
+}
+
+// Default is the Registry of the audit logs of the tenant repositories.
+var Default = &Registry{}
+
+// Register adds the log of the end-user's database, config.DefaultTenant standing for the shared database.
+func (r *Registry) Register(endUser string, log Log) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.logs == nil {
+		r.logs = make(map[string]Log)
+	}
+	r.logs[endUser] = log
+}
+
+// Log returns the log of the end-user's database, or the one of the shared database if the end-user has none.
+// It returns nil if neither is registered.
+func (r *Registry) Log(endUser string) Log {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if log, exists := r.logs[endUser]; exists {
+		return log
+	}
+	return r.logs[config.DefaultTenant]
+}
+
+// Entries returns the entries matching the filter from every log, the most recent first.
+func (r *Registry) Entries(filter Filter) ([]Entry, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	entries := make([]Entry, 0)
+	for _, log := range r.logs {
+		logEntries, err := log.Entries(filter)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, logEntries...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	if len(entries) > filter.EntryLimit() {
+		entries = entries[:filter.EntryLimit()]
+	}
+	return entries, nil
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/migrations"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+var start = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// entries returns one insert by alice, and one namespace and one full deletion by bob, a minute apart.
+func entries() []Entry {
+	return []Entry{
+		{Time: start, EndUser: "alice", RequestID: "r1", SourceIP: "10.0.0.1", Operation: InsertOrder, Namespace: "N7", OrderID: "orderId1", Rows: 1},
+		{Time: start.Add(time.Minute), EndUser: "bob", RequestID: "r2", SourceIP: "10.0.0.2", Operation: DeleteNamespaceOrders, Namespace: "N7", Selector: "channel=web", Rows: 1},
+		{Time: start.Add(2 * time.Minute), EndUser: "bob", RequestID: "r3", SourceIP: "10.0.0.2", Operation: DeleteOrders, Error: "Database unavailable"},
+	}
+}
+
+func testLog(t *testing.T, log Log) {
+	all := entries()
+	for _, entry := range all {
+		require.NoError(t, log.Record(entry))
+	}
+
+	for name, tc := range map[string]struct {
+		filter   Filter
+		expected []Entry
+	}{
+		"all entries, the most recent first": {Filter{}, []Entry{all[2], all[1], all[0]}},
+		"by end-user":                        {Filter{EndUser: "bob"}, []Entry{all[2], all[1]}},
+		"by operation":                       {Filter{Operation: InsertOrder}, []Entry{all[0]}},
+		"by namespace":                       {Filter{Namespace: "N7"}, []Entry{all[1], all[0]}},
+		"by request":                         {Filter{RequestID: "r3"}, []Entry{all[2]}},
+		"by time":                            {Filter{Since: start.Add(time.Minute), Until: start.Add(2 * time.Minute)}, []Entry{all[1]}},
+		"limited":                            {Filter{Limit: 2}, []Entry{all[2], all[1]}},
+		"no match":                           {Filter{EndUser: "carol"}, []Entry{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result, err := log.Entries(tc.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestMemoryLog(t *testing.T) {
+	testLog(t, NewMemory())
+}
+
+func TestSQLLog(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	require.NoError(t, migrations.Migrate(db, repository.SQLiteDialect{}, "orders"))
+
+	testLog(t, For(&repository.OrderRepositorySQL{Database: db, OrdersTableName: "orders", Dialect: repository.SQLiteDialect{}}))
+}
+
+func TestRegistry(t *testing.T) {
+	all := entries()
+	registry := &Registry{}
+	assert.Nil(t, registry.Log("alice"))
+
+	shared, own := NewMemory(), NewMemory()
+	registry.Register(config.DefaultTenant, shared)
+	registry.Register("alice", own)
+	require.NoError(t, own.Record(all[0]))
+	require.NoError(t, shared.Record(all[1]))
+	require.NoError(t, shared.Record(all[2]))
+
+	assert.Equal(t, own, registry.Log("alice"))
+	assert.Equal(t, shared, registry.Log("bob"))
+
+	result, err := registry.Entries(Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{all[2], all[1], all[0]}, result)
+
+	result, err = registry.Entries(Filter{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{all[2]}, result)
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+const (
+	insertQuery = "INSERT INTO %s (occurred_at, end_user, request_id, source_ip, operation, namespace, target_namespace, order_id, selector, affected_rows, failure) VALUES (%s)"
+	selectQuery = "SELECT occurred_at, end_user, request_id, source_ip, operation, namespace, target_namespace, order_id, selector, affected_rows, failure FROM %s WHERE %s ORDER BY occurred_at DESC, id DESC"
+)
+
+// sqlLog is a Log stored in the `audit_log` table, which is created by the migrations of every SQL database.
+type sqlLog struct {
+	db      repository.DBQuerier
+	dialect repository.Dialect
+}
+
+// NewSQL returns the Log stored in the database, whose queries are written in the SQL flavour of the dialect,
+// PostgreSQL if it is nil.
+func NewSQL(db repository.DBQuerier, dialect repository.Dialect) Log {
+	if dialect == nil {
+		dialect = repository.PostgresDialect{}
+	}
+	return &sqlLog{db: db, dialect: dialect}
+}
+
+func (l *sqlLog) Record(entry Entry) error {
+	q := fmt.Sprintf(insertQuery, repository.AuditTable, placeholders(l.dialect, 11))
+	// values longer than their column are cut, so that they never prevent recording the entry
+	_, err := l.db.Exec(q, entry.Time.UnixNano(), truncate(entry.EndUser, 255), truncate(entry.RequestID, 255),
+		truncate(entry.SourceIP, 64), truncate(entry.Operation, 64), truncate(entry.Namespace, 255),
+		truncate(entry.TargetNamespace, 255), truncate(entry.OrderID, 255), truncate(entry.Selector, 1024), entry.Rows, truncate(entry.Error, 1024))
+	return errors.Wrap(repository.TranslateError(err), "while recording audit entry")
+}
+
+func (l *sqlLog) Entries(filter Filter) ([]Entry, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	add := func(condition string, v interface{}) {
+		args = append(args, v)
+		conditions = append(conditions, fmt.Sprintf(condition, l.dialect.Placeholder(len(args))))
+	}
+	for _, condition := range []struct{ column, value string }{
+		{"end_user", filter.EndUser},
+		{"operation", filter.Operation},
+		{"request_id", filter.RequestID},
+	} {
+		if condition.value != "" {
+			add(condition.column+" = %s", condition.value)
+		}
+	}
+	if filter.Namespace != "" {
+		args = append(args, filter.Namespace, filter.Namespace)
+		conditions = append(conditions, fmt.Sprintf("(namespace = %s OR target_namespace = %s)",
+			l.dialect.Placeholder(len(args)-1), l.dialect.Placeholder(len(args))))
+	}
+	if !filter.Since.IsZero() {
+		add("occurred_at >= %s", filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		add("occurred_at < %s", filter.Until.UnixNano())
+	}
+	where := "1 = 1"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	q := repository.Limit(l.dialect, fmt.Sprintf(selectQuery, repository.AuditTable, where), filter.EntryLimit())
+	rows, err := l.db.Query(q, args...)
+	if err != nil {
+		return nil, errors.Wrap(repository.TranslateError(err), "while reading audit entries")
+	}
+	defer rows.Close()
+
+	entries := make([]Entry, 0)
+	for rows.Next() {
+		var (
+			entry      Entry
+			occurredAt int64
+		)
+		err := rows.Scan(&occurredAt, &entry.EndUser, &entry.RequestID, &entry.SourceIP, &entry.Operation,
+			&entry.Namespace, &entry.TargetNamespace, &entry.OrderID, &entry.Selector, &entry.Rows, &entry.Error)
+		if err != nil {
+			return nil, errors.Wrap(err, "while reading audit entries")
+		}
+		entry.Time = time.Unix(0, occurredAt).UTC()
+		entries = append(entries, entry)
+	}
+	return entries, errors.Wrap(repository.TranslateError(rows.Err()), "while reading audit entries")
+}
+
+func placeholders(dialect repository.Dialect, n int) string {
+	params := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		params = append(params, dialect.Placeholder(i))
+	}
+	return strings.Join(params, ", ")
+}
+
+// truncate cuts s to at most n characters.
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/boltdb"
+	"github.com/yemramirezca/http-db-service/db/mssql"
+	"github.com/yemramirezca/http-db-service/db/mysql"
+	"github.com/yemramirezca/http-db-service/db/postgres"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/sqlite"
+)
+
+// Factory creates the OrderRepository of a backend from the DB configuration.
+type Factory func(cfg config.Config) (repository.OrderRepository, error)
+
+var (
+	mutex     sync.RWMutex
+	factories = map[string]Factory{
+		config.MemoryDatabase: func(cfg config.Config) (repository.OrderRepository, error) {
+			if cfg.SnapshotPath != "" {
+				return repository.NewOrderRepositoryMemoryWithSnapshot(cfg.SnapshotPath, cfg.SnapshotInterval)
+			}
+			return repository.NewOrderRepositoryMemory(), nil
+		},
+		config.PostgresDriverName: func(cfg config.Config) (repository.OrderRepository, error) {
+			return (&postgres.Postgres{DBCfg: cfg}).NewOrderRepositoryDb()
+		},
+		config.SQLServerDriverName: func(cfg config.Config) (repository.OrderRepository, error) {
+			return (&mssql.Mssql{DBCfg: cfg}).NewOrderRepositoryDb()
+		},
+		config.MySQLDriverName: func(cfg config.Config) (repository.OrderRepository, error) {
+			return (&mysql.MySQL{DBCfg: cfg}).NewOrderRepositoryDb()
+		},
+		config.SQLiteDriverName: func(cfg config.Config) (repository.OrderRepository, error) {
+			return (&sqlite.SQLite{DBCfg: cfg}).NewOrderRepositoryDb()
+		},
+		config.BoltDatabase: func(cfg config.Config) (repository.OrderRepository, error) {
+			return (&boltdb.BoltDB{DBCfg: cfg}).NewOrderRepositoryDb()
+		},
+	}
+)
+
+// sqlBackends connect to the databases whose schema is managed by the `db/migrations` package.
+var sqlBackends = map[string]func(cfg config.Config) (*sql.DB, repository.Dialect, error){
+	config.PostgresDriverName: func(cfg config.Config) (*sql.DB, repository.Dialect, error) {
+		db, err := (&postgres.Postgres{DBCfg: cfg}).OpenDb()
+		return db, repository.PostgresDialect{}, err
+	},
+	config.SQLServerDriverName: func(cfg config.Config) (*sql.DB, repository.Dialect, error) {
+		db, err := (&mssql.Mssql{DBCfg: cfg}).OpenDb()
+		return db, repository.MSSQLDialect{}, err
+	},
+	config.MySQLDriverName: func(cfg config.Config) (*sql.DB, repository.Dialect, error) {
+		db, err := (&mysql.MySQL{DBCfg: cfg}).OpenDb()
+		return db, mysql.Dialect{}, err
+	},
+	config.SQLiteDriverName: func(cfg config.Config) (*sql.DB, repository.Dialect, error) {
+		db, err := (&sqlite.SQLite{DBCfg: cfg}).OpenDb()
+		return db, sqlite.Dialect{}, err
+	},
+}
+
+// OpenSQL connects to the database of an SQL backend without migrating it, returning the dialect of its queries.
+func OpenSQL(dbType string, cfg config.Config) (*sql.DB, repository.Dialect, error) {
+	open, exists := sqlBackends[dbType]
+	if !exists {
+		return nil, nil, errors.Errorf("Database type %s has no SQL schema", dbType)
+	}
+	return open(cfg)
+}
+
+// Register makes a backend available under the given database type, replacing any backend already registered for it.
+func Register(dbType string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	factories[dbType] = factory
+}
+
+// New creates the OrderRepository of the backend registered for the database type.
+func New(dbType string, cfg config.Config) (repository.OrderRepository, error) {
+	mutex.RLock()
+	factory, exists := factories[dbType]
+	mutex.RUnlock()
+
+	if !exists {
+		return nil, errors.Errorf("Unsupported database type %s, expected one of %v", dbType, Types())
+	}
+	return factory(cfg)
+}
+
+// Types returns the database types of every registered backend.
+func Types() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	types := make([]string, 0, len(factories))
+	for dbType := range factories {
+		types = append(types, dbType)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,37 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+func TestNewMemoryRepository(t *testing.T) {
+	repo, err := New(config.MemoryDatabase, config.Config{})
+
+	require.NoError(t, err)
+	assert.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+}
+
+func TestNewUnsupportedRepository(t *testing.T) {
+	_, err := New("oracle", config.Config{})
+
+	assert.EqualError(t, err, "Unsupported database type oracle, expected one of [bolt memory mssql mysql postgres sqlite]")
+}
+
+func TestRegister(t *testing.T) {
+	repoMock := &repository.MockOrderRepository{}
+	Register("custom", func(cfg config.Config) (repository.OrderRepository, error) {
+		return repoMock, nil
+	})
+
+	repo, err := New("custom", config.Config{})
+
+	require.NoError(t, err)
+	assert.Equal(t, repoMock, repo)
+	assert.Contains(t, Types(), "custom")
+}
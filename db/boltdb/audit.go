@@ -0,0 +1,62 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	bolt "go.etcd.io/bbolt"
+)
+
+// auditBucket holds the audit entries as JSON, under their sequence number in big endian so that they are sorted by age.
+var auditBucket = []byte("audit")
+
+// auditLog is the audit.Log stored in the bolt file of the orders.
+type auditLog struct {
+	db *bolt.DB
+}
+
+// AuditLog returns the audit log kept in the bolt file, see audit.Provider.
+func (repo *orderRepositoryBolt) AuditLog() audit.Log {
+	return &auditLog{db: repo.db}
+}
+
+func (l *auditLog) Record(entry audit.Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "while recording audit entry")
+	}
+	err = l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, value)
+	})
+	return errors.Wrap(err, "while recording audit entry")
+}
+
+func (l *auditLog) Entries(filter audit.Filter) ([]audit.Entry, error) {
+	entries := make([]audit.Entry, 0)
+	err := l.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(auditBucket).Cursor()
+		for key, value := cursor.Last(); key != nil && len(entries) < filter.EntryLimit(); key, value = cursor.Prev() {
+			var entry audit.Entry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return errors.Wrapf(err, "while reading audit entry %d", binary.BigEndian.Uint64(key))
+			}
+			if filter.Matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading audit entries")
+	}
+	return entries, nil
+}
@@ -0,0 +1,343 @@
+package boltdb
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	bolt "go.etcd.io/bbolt"
+)
+
+// namespacesBucket holds one nested bucket per namespace, in which orders are stored as JSON under their OrderId.
+var namespacesBucket = []byte("namespaces")
+
+type orderRepositoryBolt struct {
+	db *bolt.DB
+}
+
+func newOrderRepositoryBolt(db *bolt.DB) (*orderRepositoryBolt, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(namespacesBucket); err != nil {
+			return err
+		}
+		for _, bucket := range [][]byte{auditBucket, idempotencyBucket, versionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "while initiating bolt buckets")
+	}
+	return &orderRepositoryBolt{db: db}, nil
+}
+
+// InsertOrder stores the order, checking for an existing order with the same OrderId and namespace in the same transaction.
+func (repo *orderRepositoryBolt) InsertOrder(order repository.Order) error {
+	value, err := json.Marshal(order)
+	if err != nil {
+		return errors.Wrap(err, "while inserting order")
+	}
+
+	err = repo.db.Update(func(tx *bolt.Tx) error {
+		ns, err := tx.Bucket(namespacesBucket).CreateBucketIfNotExists([]byte(order.Namespace))
+		if err != nil {
+			return err
+		}
+		if ns.Get([]byte(order.OrderId)) != nil {
+			return repository.ErrDuplicateKey
+		}
+		if err := ns.Put([]byte(order.OrderId), value); err != nil {
+			return err
+		}
+		return touch(tx, order.Namespace)
+	})
+	if err == repository.ErrDuplicateKey {
+		return err
+	}
+	return errors.Wrap(err, "while inserting order")
+}
+
+// InsertOrders stores the orders in a single transaction, leaving out or failing on the existing ones.
+func (repo *orderRepositoryBolt) InsertOrders(orders []repository.Order, skipExisting bool) ([]bool, error) {
+	created := make([]bool, 0, len(orders))
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		for _, order := range orders {
+			value, err := json.Marshal(order)
+			if err != nil {
+				return err
+			}
+			ns, err := tx.Bucket(namespacesBucket).CreateBucketIfNotExists([]byte(order.Namespace))
+			if err != nil {
+				return err
+			}
+			if ns.Get([]byte(order.OrderId)) != nil {
+				if !skipExisting {
+					return repository.ErrDuplicateKey
+				}
+				created = append(created, false)
+				continue
+			}
+			created = append(created, true)
+			if err := ns.Put([]byte(order.OrderId), value); err != nil {
+				return err
+			}
+			if err := touch(tx, order.Namespace); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == repository.ErrDuplicateKey {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "while inserting orders")
+	}
+	return created, nil
+}
+
+// UpsertOrders stores the orders in a single transaction, replacing the existing ones.
+func (repo *orderRepositoryBolt) UpsertOrders(orders []repository.Order) ([]bool, error) {
+	created := make([]bool, 0, len(orders))
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		for _, order := range orders {
+			value, err := json.Marshal(order)
+			if err != nil {
+				return err
+			}
+			ns, err := tx.Bucket(namespacesBucket).CreateBucketIfNotExists([]byte(order.Namespace))
+			if err != nil {
+				return err
+			}
+			created = append(created, ns.Get([]byte(order.OrderId)) == nil)
+			if err := ns.Put([]byte(order.OrderId), value); err != nil {
+				return err
+			}
+			if err := touch(tx, order.Namespace); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "while upserting orders")
+	}
+	return created, nil
+}
+
+func (repo *orderRepositoryBolt) GetOrders() ([]repository.Order, error) {
+	return repo.GetOrdersBySelector("", nil)
+}
+
+func (repo *orderRepositoryBolt) GetNamespaceOrders(ns string) ([]repository.Order, error) {
+	return repo.GetOrdersBySelector(ns, nil)
+}
+
+func (repo *orderRepositoryBolt) GetOrdersBySelector(ns string, selector repository.LabelSelector) ([]repository.Order, error) {
+	orders := make([]repository.Order, 0)
+	err := repo.StreamOrders(ns, selector, func(order repository.Order) error {
+		orders = append(orders, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// StreamOrders passes the matching orders to fn within a single read transaction.
+// Only the bucket of the namespace is read when one is given.
+func (repo *orderRepositoryBolt) StreamOrders(ns string, selector repository.LabelSelector, fn func(repository.Order) error) error {
+	return repo.db.View(func(tx *bolt.Tx) error {
+		return forEachOrder(tx, ns, func(order repository.Order) error {
+			if !selector.Matches(order.Labels) {
+				return nil
+			}
+			return fn(order)
+		})
+	})
+}
+
+func (repo *orderRepositoryBolt) DeleteOrders() (int64, error) {
+	var deleted int64
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		deleted = countOrders(tx, "")
+		err := tx.Bucket(namespacesBucket).ForEach(func(name, _ []byte) error {
+			return touch(tx, string(name))
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(namespacesBucket); err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket(namespacesBucket)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "while deleting orders")
+	}
+	return deleted, nil
+}
+
+func (repo *orderRepositoryBolt) DeleteNamespaceOrders(ns string) (int64, error) {
+	var deleted int64
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		deleted = countOrders(tx, ns)
+		if err := tx.Bucket(namespacesBucket).DeleteBucket([]byte(ns)); err != nil {
+			if err == bolt.ErrBucketNotFound {
+				return nil
+			}
+			return err
+		}
+		return touch(tx, ns)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "while deleting orders")
+	}
+	return deleted, nil
+}
+
+func (repo *orderRepositoryBolt) DeleteOrdersBySelector(ns string, selector repository.LabelSelector) (int64, error) {
+	var deleted int64
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		var matching []repository.Order
+		err := forEachOrder(tx, ns, func(order repository.Order) error {
+			if selector.Matches(order.Labels) {
+				matching = append(matching, order)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// keys are deleted after iterating, bolt cursors do not support deleting while moving forward
+		root := tx.Bucket(namespacesBucket)
+		for _, order := range matching {
+			if err := root.Bucket([]byte(order.Namespace)).Delete([]byte(order.OrderId)); err != nil {
+				return err
+			}
+			if err := touch(tx, order.Namespace); err != nil {
+				return err
+			}
+		}
+		deleted = int64(len(matching))
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "while deleting orders matching '%s'", selector)
+	}
+	return deleted, nil
+}
+
+// MoveNamespaceOrders moves the orders to the bucket of the target namespace in a single transaction.
+func (repo *orderRepositoryBolt) MoveNamespaceOrders(from, to string, merge bool) (int64, error) {
+	var moved int64
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(namespacesBucket)
+		source := root.Bucket([]byte(from))
+		if source == nil || source.Stats().KeyN == 0 {
+			return repository.ErrNotFound
+		}
+		target, err := root.CreateBucketIfNotExists([]byte(to))
+		if err != nil {
+			return err
+		}
+		if !merge && target.Stats().KeyN > 0 {
+			return repository.ErrDuplicateKey
+		}
+
+		var orders []repository.Order
+		err = forEachInBucket(source, func(order repository.Order) error {
+			if target.Get([]byte(order.OrderId)) != nil {
+				return repository.ErrDuplicateKey
+			}
+			orders = append(orders, order)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, order := range orders {
+			order.Namespace = to
+			value, err := json.Marshal(order)
+			if err != nil {
+				return err
+			}
+			if err := target.Put([]byte(order.OrderId), value); err != nil {
+				return err
+			}
+		}
+		if err := root.DeleteBucket([]byte(from)); err != nil {
+			return err
+		}
+		moved = int64(len(orders))
+		if err := touch(tx, from); err != nil {
+			return err
+		}
+		return touch(tx, to)
+	})
+	if err == repository.ErrNotFound || err == repository.ErrDuplicateKey {
+		return 0, err
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "while moving the orders of namespace '%s'", from)
+	}
+	return moved, nil
+}
+
+// Close closes the bolt file, releasing its lock for the next process.
+func (repo *orderRepositoryBolt) Close() error {
+	return errors.Wrap(repo.db.Close(), "while closing the bolt file")
+}
+
+// CleanUp removes every order and closes the bolt file.
+func (repo *orderRepositoryBolt) CleanUp() error {
+	if _, err := repo.DeleteOrders(); err != nil {
+		return err
+	}
+	return repo.Close()
+}
+
+// forEachOrder passes every order of the namespace, or of all namespaces if ns is empty, to fn.
+func forEachOrder(tx *bolt.Tx, ns string, fn func(repository.Order) error) error {
+	root := tx.Bucket(namespacesBucket)
+	if ns != "" {
+		return forEachInBucket(root.Bucket([]byte(ns)), fn)
+	}
+	return root.ForEach(func(name, _ []byte) error {
+		return forEachInBucket(root.Bucket(name), fn)
+	})
+}
+
+// countOrders returns the number of orders of the namespace, or of all namespaces if ns is empty.
+func countOrders(tx *bolt.Tx, ns string) int64 {
+	root := tx.Bucket(namespacesBucket)
+	if ns != "" {
+		if bucket := root.Bucket([]byte(ns)); bucket != nil {
+			return int64(bucket.Stats().KeyN)
+		}
+		return 0
+	}
+	var count int64
+	root.ForEach(func(name, _ []byte) error {
+		count += int64(root.Bucket(name).Stats().KeyN)
+		return nil
+	})
+	return count
+}
+
+func forEachInBucket(bucket *bolt.Bucket, fn func(repository.Order) error) error {
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(key, value []byte) error {
+		var order repository.Order
+		if err := json.Unmarshal(value, &order); err != nil {
+			return errors.Wrapf(err, "while reading order '%s'", key)
+		}
+		return fn(order)
+	})
+}
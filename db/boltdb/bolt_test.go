@@ -0,0 +1,293 @@
+package boltdb
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/idempotency"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+var _ repository.Database = &BoltDB{}
+
+func newRepository(t *testing.T, path string) repository.OrderRepository {
+	db := BoltDB{config.Config{BoltPath: path}}
+	repo, err := db.NewOrderRepositoryDb()
+	require.NoError(t, err)
+	return repo
+}
+
+func TestBoltCreateGetAndDelete(t *testing.T) {
+	repo := newRepository(t, filepath.Join(t.TempDir(), "orders.db"))
+	defer repo.CleanUp()
+
+	web := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "web"}}
+	shop := repository.Order{OrderId: "orderId2", Namespace: "N7", Total: 20, Labels: map[string]string{"channel": "shop"}}
+	other := repository.Order{OrderId: "orderId1", Namespace: "N8", Total: 30}
+	for _, order := range []repository.Order{web, shop, other} {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+	assert.Equal(t, repository.ErrDuplicateKey, repo.InsertOrder(web))
+
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{web, shop, other}, orders)
+
+	orders, err = repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{web, shop}, orders)
+
+	orders, err = repo.GetNamespaceOrders("unknown")
+	require.NoError(t, err)
+	assert.Len(t, orders, 0)
+
+	selector, err := repository.ParseLabelSelector("channel!=shop")
+	require.NoError(t, err)
+	orders, err = repo.GetOrdersBySelector("", selector)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{web, other}, orders)
+
+	deleted, err := repo.DeleteOrdersBySelector("", selector)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	orders, err = repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{shop}, orders)
+
+	deleted, err = repo.DeleteNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	deleted, err = repo.DeleteNamespaceOrders("unknown")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+	orders, err = repo.GetOrders()
+	require.NoError(t, err)
+	assert.Len(t, orders, 0)
+}
+
+func TestBoltOrdersSurviveReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	order := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+
+	repo := newRepository(t, path)
+	require.NoError(t, repo.InsertOrder(order))
+	require.NoError(t, repo.(*orderRepositoryBolt).Close())
+
+	repo = newRepository(t, path)
+	defer repo.CleanUp()
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{order}, orders)
+}
+
+func TestBoltConcurrentDuplicateInserts(t *testing.T) {
+	repo := newRepository(t, filepath.Join(t.TempDir(), "orders.db"))
+	defer repo.CleanUp()
+
+	var (
+		wg         sync.WaitGroup
+		mutex      sync.Mutex
+		created    int
+		duplicates int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10})
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == repository.ErrDuplicateKey {
+				duplicates++
+			} else if assert.NoError(t, err) {
+				created++
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 9, duplicates)
+}
+
+func TestBoltAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	repo := newRepository(t, path)
+	deletion := audit.Entry{Time: time.Now().UTC(), EndUser: "alice", Operation: audit.DeleteOrders, Rows: 2}
+	insertion := audit.Entry{Time: time.Now().UTC(), EndUser: "bob", Operation: audit.InsertOrder, Namespace: "N7", OrderID: "orderId1", Rows: 1}
+	require.NoError(t, audit.For(repo).Record(deletion))
+	require.NoError(t, audit.For(repo).Record(insertion))
+	require.NoError(t, repo.CleanUp())
+
+	// the entries are kept across restarts
+	repo = newRepository(t, path)
+	defer repo.CleanUp()
+	entries, err := audit.For(repo).Entries(audit.Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Entry{insertion, deletion}, entries)
+
+	entries, err = audit.For(repo).Entries(audit.Filter{EndUser: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Entry{deletion}, entries)
+}
+
+func TestBoltIdempotencyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	repo := newRepository(t, path)
+	store := idempotency.For(repo, time.Hour, time.Minute)
+	record, reserved, err := store.Reserve("alice", "k1", "h1")
+	require.NoError(t, err)
+	require.True(t, reserved)
+	record.Status, record.Body = 201, []byte("{}")
+	require.NoError(t, store.Complete(record))
+	require.NoError(t, repo.CleanUp())
+
+	// the records are kept across restarts
+	repo = newRepository(t, path)
+	defer repo.CleanUp()
+	store = idempotency.For(repo, time.Hour, time.Minute)
+	existing, reserved, err := store.Reserve("alice", "k1", "h2")
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, "h1", existing.RequestHash)
+	assert.Equal(t, 201, existing.Status)
+
+	// only a reservation in progress is released
+	require.NoError(t, store.Release(existing))
+	_, reserved, err = store.Reserve("alice", "k1", "h2")
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	record, reserved, err = store.Reserve("alice", "k2", "h2")
+	require.NoError(t, err)
+	require.True(t, reserved)
+	require.NoError(t, store.Release(record))
+	_, reserved, err = store.Reserve("alice", "k2", "h2")
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	// an expired record is replaced
+	store.(*idempotencyStore).now = func() time.Time { return time.Now().Add(time.Hour) }
+	_, reserved, err = store.Reserve("alice", "k1", "h3")
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	// the expired records whose keys are not used again are purged
+	removed, err := store.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+}
+
+func TestBoltUpsertOrders(t *testing.T) {
+	repo := newRepository(t, filepath.Join(t.TempDir(), "orders.db"))
+	defer repo.CleanUp()
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+
+	// when
+	replaced := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 20}
+	created := repository.Order{OrderId: "orderId1", Namespace: "N8", Total: 30}
+	result, err := repo.UpsertOrders([]repository.Order{replaced, created})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, result)
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{replaced, created}, orders)
+}
+
+func TestBoltInsertOrders(t *testing.T) {
+	repo := newRepository(t, filepath.Join(t.TempDir(), "orders.db"))
+	defer repo.CleanUp()
+	existing := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	require.NoError(t, repo.InsertOrder(existing))
+	created := repository.Order{OrderId: "orderId1", Namespace: "N8", Total: 30}
+	orders := []repository.Order{created, {OrderId: "orderId1", Namespace: "N7", Total: 20}}
+
+	// when
+	_, err := repo.InsertOrders(orders, false)
+
+	// then
+	assert.Equal(t, repository.ErrDuplicateKey, err)
+	read, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{existing}, read)
+
+	// when
+	result, err := repo.InsertOrders(orders, true)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, result)
+	read, err = repo.GetOrders()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{existing, created}, read)
+}
+
+func TestBoltOrdersVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	repo := newRepository(t, path)
+	versioner := repo.(repository.OrderVersioner)
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+	inserted, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.NotZero(t, inserted.Counter)
+	assert.False(t, inserted.Modified.IsZero())
+
+	// when
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N8", Total: 10}))
+	_, err = repo.DeleteOrdersBySelector("N8", nil)
+	require.NoError(t, err)
+
+	// then
+	unchanged, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.Equal(t, inserted.Counter, unchanged.Counter)
+	all, err := versioner.OrdersVersion("")
+	require.NoError(t, err)
+	assert.Equal(t, inserted.Counter+2, all.Counter)
+
+	// when
+	require.NoError(t, repo.(*orderRepositoryBolt).db.Close())
+	repo = newRepository(t, path)
+	defer repo.CleanUp()
+
+	// then
+	reopened, err := repo.(repository.OrderVersioner).OrdersVersion("")
+	require.NoError(t, err)
+	assert.Equal(t, all.Counter, reopened.Counter)
+}
+
+func TestBoltMoveNamespaceOrders(t *testing.T) {
+	repo := newRepository(t, filepath.Join(t.TempDir(), "orders.db"))
+	defer repo.CleanUp()
+	for _, order := range []repository.Order{{OrderId: "orderId1", Namespace: "N7", Total: 10}, {OrderId: "orderId2", Namespace: "N7", Total: 20}, {OrderId: "orderId1", Namespace: "N8", Total: 30}} {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+
+	// when
+	_, notFound := repo.MoveNamespaceOrders("N9", "N10", false)
+	_, renamed := repo.MoveNamespaceOrders("N7", "N8", false)
+	_, merged := repo.MoveNamespaceOrders("N7", "N8", true)
+	moved, err := repo.MoveNamespaceOrders("N7", "N9", true)
+
+	// then
+	assert.Equal(t, repository.ErrNotFound, notFound)
+	assert.Equal(t, repository.ErrDuplicateKey, renamed)
+	assert.Equal(t, repository.ErrDuplicateKey, merged)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), moved)
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{
+		{OrderId: "orderId1", Namespace: "N9", Total: 10},
+		{OrderId: "orderId2", Namespace: "N9", Total: 20},
+		{OrderId: "orderId1", Namespace: "N8", Total: 30},
+	}, orders)
+}
@@ -0,0 +1,36 @@
+package boltdb
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	bolt "go.etcd.io/bbolt"
+)
+
+const openTimeout = 5 * time.Second
+
+// BoltDB creates OrderRepositories backed by an embedded bbolt key-value file,
+// for single node deployments which keep their orders across restarts without running an SQL server.
+type BoltDB struct {
+	DBCfg config.Config
+}
+
+// DBConnectionString returns the path of the bbolt file.
+func (db *BoltDB) DBConnectionString() string {
+	return db.DBCfg.BoltPath
+}
+
+func (ds *BoltDB) NewOrderRepositoryDb() (repository.OrderRepository, error) {
+	db, err := bolt.Open(ds.DBConnectionString(), 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while opening '%s'", ds.DBConnectionString())
+	}
+	repo, err := newOrderRepositoryBolt(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
@@ -0,0 +1,144 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/idempotency"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	bolt "go.etcd.io/bbolt"
+)
+
+// idempotencyBucket holds the idempotency records as JSON, under the end-user and the key separated by a zero byte.
+var idempotencyBucket = []byte("idempotency")
+
+// idempotencyStore is the idempotency.Store kept in the bolt file of the orders.
+type idempotencyStore struct {
+	db    *bolt.DB
+	ttl   time.Duration
+	lease time.Duration
+	now   func() time.Time
+}
+
+// IdempotencyStore returns the idempotency store kept in the bolt file, see idempotency.Provider.
+func (repo *orderRepositoryBolt) IdempotencyStore(ttl, lease time.Duration) idempotency.Store {
+	return &idempotencyStore{db: repo.db, ttl: ttl, lease: lease, now: time.Now}
+}
+
+func recordKey(endUser, key string) []byte {
+	return []byte(endUser + "\x00" + key)
+}
+
+func (s *idempotencyStore) Reserve(endUser, key, requestHash string) (idempotency.Record, bool, error) {
+	now := s.now()
+	record := idempotency.Record{EndUser: endUser, Key: key, RequestHash: requestHash, ExpiresAt: now.Add(s.lease)}
+	reserved := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		if value := bucket.Get(recordKey(endUser, key)); value != nil {
+			var existing idempotency.Record
+			if err := json.Unmarshal(value, &existing); err != nil {
+				return err
+			}
+			// an expired record gives up its key
+			if now.Before(existing.ExpiresAt) {
+				record = existing
+				return nil
+			}
+		}
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		reserved = true
+		return bucket.Put(recordKey(endUser, key), value)
+	})
+	if err != nil {
+		return idempotency.Record{}, false, errors.Wrapf(err, "while reserving idempotency key %s", key)
+	}
+	return record, reserved, nil
+}
+
+func (s *idempotencyStore) Purge() (int64, error) {
+	var removed int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		removed, err = removeExpired(tx.Bucket(idempotencyBucket), s.now())
+		return err
+	})
+	return removed, errors.Wrap(err, "while removing expired idempotency keys")
+}
+
+// removeExpired deletes the records which expired and returns how many it deleted.
+func removeExpired(bucket *bolt.Bucket, now time.Time) (int64, error) {
+	var expired [][]byte
+	err := bucket.ForEach(func(key, value []byte) error {
+		var record idempotency.Record
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		if !now.Before(record.ExpiresAt) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range expired {
+		if err := bucket.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(expired)), nil
+}
+
+// reservation tells whether the stored record is still the reservation which expires at reservedUntil.
+func reservation(bucket *bolt.Bucket, endUser, key string, reservedUntil time.Time) (bool, error) {
+	value := bucket.Get(recordKey(endUser, key))
+	if value == nil {
+		return false, nil
+	}
+	var stored idempotency.Record
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return false, err
+	}
+	return idempotency.IsReservation(stored, reservedUntil), nil
+}
+
+func (s *idempotencyStore) Complete(record idempotency.Record) error {
+	reservedUntil := record.ExpiresAt
+	record.ExpiresAt = s.now().Add(s.ttl)
+	value, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrapf(err, "while saving the response of idempotency key %s", record.Key)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		reserved, err := reservation(bucket, record.EndUser, record.Key, reservedUntil)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return repository.ErrNotFound
+		}
+		return bucket.Put(recordKey(record.EndUser, record.Key), value)
+	})
+	if err == repository.ErrNotFound {
+		return err
+	}
+	return errors.Wrapf(err, "while saving the response of idempotency key %s", record.Key)
+}
+
+func (s *idempotencyStore) Release(record idempotency.Record) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		reserved, err := reservation(bucket, record.EndUser, record.Key, record.ExpiresAt)
+		if err != nil || !reserved {
+			return err
+		}
+		return bucket.Delete(recordKey(record.EndUser, record.Key))
+	})
+	return errors.Wrapf(err, "while releasing idempotency key %s", record.Key)
+}
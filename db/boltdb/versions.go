@@ -0,0 +1,59 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	bolt "go.etcd.io/bbolt"
+)
+
+// versionsBucket holds the version of every namespace under its name, as the counter of its changes followed by the
+// time of the last one in nanoseconds, both in big endian.
+var versionsBucket = []byte("versions")
+
+// OrdersVersion returns the version of the orders of the namespace, or of all namespaces if ns is empty,
+// whose counters are summed up since they only grow. See repository.OrderVersioner.
+func (repo *orderRepositoryBolt) OrdersVersion(ns string) (repository.Version, error) {
+	var version repository.Version
+	err := repo.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(versionsBucket)
+		if ns != "" {
+			version = decodeVersion(bucket.Get([]byte(ns)))
+			return nil
+		}
+		return bucket.ForEach(func(_, value []byte) error {
+			v := decodeVersion(value)
+			version.Counter += v.Counter
+			if v.Modified.After(version.Modified) {
+				version.Modified = v.Modified
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return repository.Version{}, errors.Wrapf(err, "while reading the version of namespace '%s'", ns)
+	}
+	return version, nil
+}
+
+// touch records a change to the orders of the namespace in the transaction.
+func touch(tx *bolt.Tx, ns string) error {
+	bucket := tx.Bucket(versionsBucket)
+	version := decodeVersion(bucket.Get([]byte(ns)))
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, uint64(version.Counter+1))
+	binary.BigEndian.PutUint64(value[8:], uint64(time.Now().UnixNano()))
+	return bucket.Put([]byte(ns), value)
+}
+
+func decodeVersion(value []byte) repository.Version {
+	if len(value) != 16 {
+		return repository.Version{}
+	}
+	return repository.Version{
+		Counter:  int64(binary.BigEndian.Uint64(value)),
+		Modified: time.Unix(0, int64(binary.BigEndian.Uint64(value[8:]))),
+	}
+}
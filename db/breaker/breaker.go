@@ -0,0 +1,171 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets every call through, counting the consecutive failures.
+	Closed State = iota
+	// Open rejects every call until the cool-down has elapsed.
+	Open
+	// HalfOpen lets a single probe call through, which closes the breaker if it succeeds and opens it again otherwise.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText reports the state by its name.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Settings configure when a Breaker opens and for how long.
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures opening the breaker.
+	FailureThreshold int
+	// CoolDown is how long the breaker stays open before a probe call is let through.
+	CoolDown time.Duration
+}
+
+// Status is a snapshot of the state of a Breaker.
+type Status struct {
+	Name       string
+	State      State
+	Failures   int
+	RetryAfter time.Duration `json:",omitempty"`
+}
+
+// Breaker is a circuit breaker which stops calling a database failing repeatedly, so that the requests of
+// its end-users fail fast instead of piling up, and lets single calls through again after a cool-down.
+// Only the failures of the database itself count, see IsFailure. It is safe for concurrent use.
+type Breaker struct {
+	name     string
+	dialect  repository.Dialect
+	settings Settings
+	now      func() time.Time
+
+	mutex    sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	// generation changes with the state, so that the calls let through before a change are told apart.
+	generation uint64
+}
+
+// Call is a call let through by a Breaker, tagged with the state the breaker was in.
+type Call struct {
+	generation uint64
+	probe      bool
+}
+
+// New creates a closed Breaker of a database whose errors are recognised by the dialect.
+func New(name string, dialect repository.Dialect, settings Settings) *Breaker {
+	return &Breaker{name: name, dialect: dialect, settings: settings, now: time.Now}
+}
+
+// Allow returns an `*repository.UnavailableError` if the call must not be made, otherwise the call must be reported with Done.
+func (b *Breaker) Allow() (Call, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == Open {
+		if wait := b.retryAfter(); wait > 0 {
+			return Call{}, &repository.UnavailableError{Reason: "circuit breaker " + b.name + " open", RetryAfter: wait}
+		}
+		b.setState(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.probing {
+			return Call{}, &repository.UnavailableError{Reason: "circuit breaker " + b.name + " probing", RetryAfter: time.Second}
+		}
+		b.probing = true
+		return Call{generation: b.generation, probe: true}, nil
+	}
+	return Call{generation: b.generation}, nil
+}
+
+// Done reports the result of a call let through by Allow. Only the probe of a half-open breaker closes it, the result
+// of a call which started before the state of the breaker changed is ignored.
+func (b *Breaker) Done(call Call, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if call.generation != b.generation {
+		return
+	}
+	if call.probe {
+		b.probing = false
+	}
+	if !IsFailure(b.dialect, err) {
+		b.failures = 0
+		if call.probe {
+			b.setState(Closed)
+		}
+		return
+	}
+
+	b.failures++
+	if call.probe || b.failures >= b.settings.FailureThreshold {
+		b.openedAt = b.now()
+		b.setState(Open)
+	}
+}
+
+// Status returns the current state of the breaker.
+func (b *Breaker) Status() Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := Status{Name: b.name, State: b.state, Failures: b.failures}
+	if b.state == Open {
+		status.RetryAfter = b.retryAfter()
+	}
+	return status
+}
+
+func (b *Breaker) retryAfter() time.Duration {
+	return b.openedAt.Add(b.settings.CoolDown).Sub(b.now())
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state != state {
+		log.Warnf("Circuit breaker %s is %s after %d consecutive failures.", b.name, state, b.failures)
+		b.generation++
+	}
+	b.state = state
+}
+
+// IsFailure reports whether err shows that the database is unhealthy, that is unavailable or not answering in time.
+// Other errors, such as constraint violations, are caused by the call itself and do not count.
+// The dialect gets the first chance to recognise the errors specific to its driver, see repository.TranslateDialectError.
+func IsFailure(d repository.Dialect, err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errors.Cause(repository.TranslateDialectError(d, err)) {
+	case repository.ErrUnavailable, repository.ErrTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package breaker
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// newBreaker returns a Breaker whose clock is advanced by the returned function.
+func newBreaker() (*Breaker, func(time.Duration)) {
+	now := time.Now()
+	b := New("test", repository.PostgresDialect{}, Settings{FailureThreshold: 2, CoolDown: 10 * time.Second})
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func call(b *Breaker, err error) error {
+	c, allowErr := b.Allow()
+	if allowErr != nil {
+		return allowErr
+	}
+	b.Done(c, err)
+	return err
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b, _ := newBreaker()
+
+	assert.Equal(t, repository.ErrUnavailable, call(b, repository.ErrUnavailable))
+	assert.NoError(t, call(b, nil))
+	assert.Equal(t, repository.ErrTimeout, call(b, repository.ErrTimeout))
+	assert.Equal(t, Closed, b.Status().State)
+	assert.Equal(t, repository.ErrTimeout, call(b, repository.ErrTimeout))
+
+	// then
+	assert.Equal(t, Open, b.Status().State)
+	err := call(b, nil)
+	unavailable, ok := err.(*repository.UnavailableError)
+	require.True(t, ok, "unexpected error %v", err)
+	assert.Equal(t, 10*time.Second, unavailable.RetryAfter)
+}
+
+func TestBreakerIgnoresErrorsOfTheCall(t *testing.T) {
+	b, _ := newBreaker()
+
+	for i := 0; i < 5; i++ {
+		call(b, &pq.Error{Code: "23505"})
+		call(b, repository.ErrConstraintViolation)
+	}
+
+	assert.Equal(t, Closed, b.Status().State)
+}
+
+// errDriverUnavailable stands for a driver error which only its dialect recognises.
+var errDriverUnavailable = pkgerrors.New("driver: invalid connection")
+
+type translatingDialect struct {
+	repository.MySQLDialect
+}
+
+func (translatingDialect) TranslateError(err error) error {
+	if pkgerrors.Cause(err) == errDriverUnavailable {
+		return pkgerrors.Wrap(repository.ErrUnavailable, err.Error())
+	}
+	return err
+}
+
+func TestBreakerCountsTheFailuresRecognisedByItsDialect(t *testing.T) {
+	b := New("test", translatingDialect{}, Settings{FailureThreshold: 2, CoolDown: time.Minute})
+
+	call(b, errDriverUnavailable)
+	call(b, errDriverUnavailable)
+
+	// then
+	assert.Equal(t, Open, b.Status().State)
+	assert.False(t, IsFailure(repository.MySQLDialect{}, errDriverUnavailable))
+}
+
+func TestBreakerProbesAfterCoolDown(t *testing.T) {
+	b, advance := newBreaker()
+	call(b, repository.ErrUnavailable)
+	call(b, repository.ErrUnavailable)
+
+	t.Run("Failed probe opens the breaker again", func(t *testing.T) {
+		advance(10 * time.Second)
+		probe, err := b.Allow()
+		require.NoError(t, err)
+		assert.Equal(t, HalfOpen, b.Status().State)
+		_, err = b.Allow()
+		assert.IsType(t, &repository.UnavailableError{}, err, "a single probe at a time")
+
+		b.Done(probe, repository.ErrUnavailable)
+
+		assert.Equal(t, Open, b.Status().State)
+		assert.Equal(t, 10*time.Second, b.Status().RetryAfter)
+	})
+
+	t.Run("Successful probe closes the breaker", func(t *testing.T) {
+		advance(10 * time.Second)
+
+		assert.NoError(t, call(b, nil))
+
+		assert.Equal(t, Closed, b.Status().State)
+		call(b, repository.ErrUnavailable)
+		assert.Equal(t, Closed, b.Status().State, "failures are counted from zero again")
+	})
+}
+
+func TestBreakerIgnoresCallsStartedBeforeOpening(t *testing.T) {
+	b, advance := newBreaker()
+	slow, err := b.Allow()
+	require.NoError(t, err)
+	call(b, repository.ErrUnavailable)
+	call(b, repository.ErrUnavailable)
+
+	// when the slow call succeeds once the breaker is open
+	b.Done(slow, nil)
+
+	// then
+	assert.Equal(t, Open, b.Status().State)
+	assert.Equal(t, 2, b.Status().Failures)
+
+	t.Run("nor while probing", func(t *testing.T) {
+		advance(10 * time.Second)
+		probe, err := b.Allow()
+		require.NoError(t, err)
+		b.Done(slow, nil)
+		assert.Equal(t, HalfOpen, b.Status().State)
+
+		b.Done(probe, nil)
+		assert.Equal(t, Closed, b.Status().State)
+	})
+}
+
+type querierMock struct {
+	repository.DBQuerier
+	calls int
+	err   error
+}
+
+func (q *querierMock) Exec(query string, args ...interface{}) (sql.Result, error) {
+	q.calls++
+	return nil, q.err
+}
+
+func TestQuerier(t *testing.T) {
+	db := &querierMock{err: &pq.Error{Code: "08006"}}
+	querier := &Querier{DBQuerier: db, Breaker: New("test", repository.PostgresDialect{}, Settings{FailureThreshold: 1, CoolDown: time.Minute})}
+
+	_, err := querier.Exec("DELETE FROM orders")
+	assert.Equal(t, db.err, err)
+	_, err = querier.Exec("DELETE FROM orders")
+
+	assert.Equal(t, 1, db.calls)
+	assert.IsType(t, &repository.UnavailableError{}, pkgerrors.Cause(repository.TranslateError(err)))
+	assert.False(t, repository.IsTransient(err))
+}
@@ -0,0 +1,92 @@
+package breaker
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// Querier is a repository.DBQuerier whose statements go through a Breaker.
+type Querier struct {
+	repository.DBQuerier
+	Breaker *Breaker
+}
+
+func (q *Querier) Exec(query string, args ...interface{}) (sql.Result, error) {
+	call, err := q.Breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	result, err := q.DBQuerier.Exec(query, args...)
+	q.Breaker.Done(call, err)
+	return result, err
+}
+
+func (q *Querier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	call, err := q.Breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.DBQuerier.Query(query, args...)
+	q.Breaker.Done(call, err)
+	return rows, err
+}
+
+// Begin starts a transaction through the Breaker, if the database supports them, see repository.TxBeginner.
+// The statements of the transaction then bypass the Breaker.
+func (q *Querier) Begin() (*sql.Tx, error) {
+	beginner, ok := q.DBQuerier.(repository.TxBeginner)
+	if !ok {
+		return nil, errors.New("the database does not support transactions")
+	}
+	call, err := q.Breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := beginner.Begin()
+	q.Breaker.Done(call, err)
+	return tx, err
+}
+
+// Registry keeps the breakers of the service, so that their state can be reported. It is safe for concurrent use.
+type Registry struct {
+	mutex    sync.Mutex
+	breakers []*Breaker
+}
+
+// Default is the Registry of the breakers wrapping the databases of the backends.
+var Default = &Registry{}
+
+// New creates a Breaker and adds it to the registry.
+func (r *Registry) New(name string, dialect repository.Dialect, settings Settings) *Breaker {
+	b := New(name, dialect, settings)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.breakers = append(r.breakers, b)
+	return b
+}
+
+// Status returns the state of every breaker.
+func (r *Registry) Status() []Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(r.breakers))
+	for _, b := range r.breakers {
+		statuses = append(statuses, b.Status())
+	}
+	return statuses
+}
+
+// Wrap puts the database, whose queries are written in the SQL flavour of the dialect, behind a Breaker of the
+// Default registry, configured in cfg. The database is returned unchanged if the breaker is disabled by a zero BreakerFailures.
+func Wrap(name string, db repository.DBQuerier, dialect repository.Dialect, cfg config.Config) repository.DBQuerier {
+	if cfg.BreakerFailures <= 0 {
+		return db
+	}
+	return &Querier{DBQuerier: db, Breaker: Default.New(name, dialect, Settings{FailureThreshold: cfg.BreakerFailures, CoolDown: cfg.BreakerCoolDown})}
+}
@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// Settings bound the entries of a cache.
+type Settings struct {
+	// TTL is how long a listing is served from the cache. Changes made by other replicas become visible after it at
+	// the latest, or as soon as the version of the namespace changes if the repository tracks versions.
+	TTL time.Duration
+	// MaxEntries limits the number of cached listings, the least recently used one being evicted first.
+	MaxEntries int
+}
+
+// Metrics count how a cache was used.
+type Metrics struct {
+	Name      string
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// orderRepositoryCache is an OrderRepository serving the listings of the wrapped repository from memory
+// until they expire or the orders they contain change through it. If the wrapped repository tracks versions, a
+// listing is also read again once the version of its namespace differs from the one read before it was, so that
+// it is never older than the version, and the ETag, of the listing. It is safe for concurrent use.
+type orderRepositoryCache struct {
+	repository.OrderRepository
+	name     string
+	settings Settings
+	now      func() time.Time
+
+	mutex   sync.Mutex
+	entries map[key]*list.Element
+	lru     *list.List
+	// generation is incremented on every change, so that a listing read while orders changed is not cached.
+	generation uint64
+	metrics    Metrics
+}
+
+// key identifies a listing, an empty namespace standing for all namespaces.
+type key struct {
+	ns       string
+	selector string
+}
+
+type entry struct {
+	key     key
+	orders  []repository.Order
+	version repository.Version
+	expires time.Time
+}
+
+// New returns an OrderRepository caching the listings of repo, see Settings.
+func New(name string, repo repository.OrderRepository, settings Settings) repository.OrderRepository {
+	return newCache(name, repo, settings)
+}
+
+// Registry keeps the caches of the service, so that their metrics can be reported. It is safe for concurrent use.
+type Registry struct {
+	mutex  sync.Mutex
+	caches []*orderRepositoryCache
+}
+
+// Default is the Registry of the caches of the tenant repositories.
+var Default = &Registry{}
+
+// New returns an OrderRepository caching the listings of repo, whose metrics are reported by the registry.
+func (r *Registry) New(name string, repo repository.OrderRepository, settings Settings) repository.OrderRepository {
+	c := newCache(name, repo, settings)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.caches = append(r.caches, c)
+	return c
+}
+
+// Metrics returns the metrics of every cache.
+func (r *Registry) Metrics() []Metrics {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	metrics := make([]Metrics, 0, len(r.caches))
+	for _, c := range r.caches {
+		metrics = append(metrics, c.Metrics())
+	}
+	return metrics
+}
+
+// Invalidate drops the listings of the named caches which may contain orders of the namespace, or every listing
+// if ns is empty, after the orders were changed bypassing the caches.
+func (r *Registry) Invalidate(name, ns string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, c := range r.caches {
+		if c.name == name {
+			c.invalidate(ns)
+		}
+	}
+}
+
+func newCache(name string, repo repository.OrderRepository, settings Settings) *orderRepositoryCache {
+	return &orderRepositoryCache{
+		OrderRepository: repo,
+		name:            name,
+		settings:        settings,
+		now:             time.Now,
+		entries:         make(map[key]*list.Element),
+		lru:             list.New(),
+	}
+}
+
+func (c *orderRepositoryCache) GetOrders() ([]repository.Order, error) {
+	return c.get(key{}, c.OrderRepository.GetOrders)
+}
+
+func (c *orderRepositoryCache) GetNamespaceOrders(ns string) ([]repository.Order, error) {
+	return c.get(key{ns: ns}, func() ([]repository.Order, error) {
+		return c.OrderRepository.GetNamespaceOrders(ns)
+	})
+}
+
+func (c *orderRepositoryCache) GetOrdersBySelector(ns string, selector repository.LabelSelector) ([]repository.Order, error) {
+	return c.get(key{ns: ns, selector: selector.String()}, func() ([]repository.Order, error) {
+		return c.OrderRepository.GetOrdersBySelector(ns, selector)
+	})
+}
+
+// OrdersVersion returns the version tracked by the cached repository, or the zero Version if it tracks none.
+func (c *orderRepositoryCache) OrdersVersion(ns string) (repository.Version, error) {
+	if versioner, ok := c.OrderRepository.(repository.OrderVersioner); ok {
+		return versioner.OrdersVersion(ns)
+	}
+	return repository.Version{}, nil
+}
+
+func (c *orderRepositoryCache) InsertOrder(order repository.Order) error {
+	defer c.invalidate(order.Namespace)
+	return c.OrderRepository.InsertOrder(order)
+}
+
+func (c *orderRepositoryCache) InsertOrders(orders []repository.Order, skipExisting bool) ([]bool, error) {
+	defer func() {
+		for _, order := range orders {
+			c.invalidate(order.Namespace)
+		}
+	}()
+	return c.OrderRepository.InsertOrders(orders, skipExisting)
+}
+
+func (c *orderRepositoryCache) UpsertOrders(orders []repository.Order) ([]bool, error) {
+	defer func() {
+		for _, order := range orders {
+			c.invalidate(order.Namespace)
+		}
+	}()
+	return c.OrderRepository.UpsertOrders(orders)
+}
+
+func (c *orderRepositoryCache) DeleteOrders() (int64, error) {
+	defer c.invalidate("")
+	return c.OrderRepository.DeleteOrders()
+}
+
+func (c *orderRepositoryCache) DeleteNamespaceOrders(ns string) (int64, error) {
+	defer c.invalidate(ns)
+	return c.OrderRepository.DeleteNamespaceOrders(ns)
+}
+
+func (c *orderRepositoryCache) DeleteOrdersBySelector(ns string, selector repository.LabelSelector) (int64, error) {
+	defer c.invalidate(ns)
+	return c.OrderRepository.DeleteOrdersBySelector(ns, selector)
+}
+
+func (c *orderRepositoryCache) MoveNamespaceOrders(from, to string, merge bool) (int64, error) {
+	defer c.invalidate(from)
+	defer c.invalidate(to)
+	return c.OrderRepository.MoveNamespaceOrders(from, to, merge)
+}
+
+// Namespaces summarizes the namespaces of the cached repository, which are not cached.
+func (c *orderRepositoryCache) Namespaces() ([]repository.Namespace, error) {
+	return repository.Namespaces(c.OrderRepository)
+}
+
+func (c *orderRepositoryCache) CleanUp() error {
+	defer c.invalidate("")
+	return c.OrderRepository.CleanUp()
+}
+
+// Metrics returns the usage of the cache.
+func (c *orderRepositoryCache) Metrics() Metrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	metrics := c.metrics
+	metrics.Name = c.name
+	metrics.Entries = c.lru.Len()
+	return metrics
+}
+
+// get returns the cached listing, or reads it with read and caches it unless the orders changed meanwhile.
+func (c *orderRepositoryCache) get(k key, read func() ([]repository.Order, error)) ([]repository.Order, error) {
+	// the version is read before the listing, so that the listing is at least as recent as the version it is cached with
+	version, versioned := c.version(k.ns)
+
+	c.mutex.Lock()
+	if element, exists := c.entries[k]; exists {
+		e := element.Value.(*entry)
+		if c.now().Before(e.expires) && (!versioned || e.version == version) {
+			c.lru.MoveToFront(element)
+			c.metrics.Hits++
+			c.mutex.Unlock()
+			return copyOrders(e.orders), nil
+		}
+		c.remove(element)
+	}
+	c.metrics.Misses++
+	generation := c.generation
+	c.mutex.Unlock()
+
+	orders, err := read()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation == c.generation {
+		c.add(&entry{key: k, orders: copyOrders(orders), version: version, expires: c.now().Add(c.settings.TTL)})
+	}
+	return orders, nil
+}
+
+// version returns the version of the namespace in the cached repository, and false if it tracks none or reading
+// it failed, the listings then being cached until they expire only.
+func (c *orderRepositoryCache) version(ns string) (repository.Version, bool) {
+	version, err := c.OrdersVersion(ns)
+	if err != nil || version == (repository.Version{}) {
+		return repository.Version{}, false
+	}
+	return version, true
+}
+
+// add caches the entry, evicting the least recently used entries above MaxEntries. The caller must hold the lock.
+func (c *orderRepositoryCache) add(e *entry) {
+	if element, exists := c.entries[e.key]; exists {
+		c.remove(element)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.settings.MaxEntries > 0 && c.lru.Len() > c.settings.MaxEntries {
+		c.remove(c.lru.Back())
+		c.metrics.Evictions++
+	}
+}
+
+// remove drops a cached entry. The caller must hold the lock.
+func (c *orderRepositoryCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
+
+// invalidate drops the listings which may contain orders of the namespace, or every listing if ns is empty.
+func (c *orderRepositoryCache) invalidate(ns string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	for k, element := range c.entries {
+		if ns == "" || k.ns == "" || k.ns == ns {
+			c.remove(element)
+		}
+	}
+}
+
+func copyOrders(orders []repository.Order) []repository.Order {
+	return append(make([]repository.Order, 0, len(orders)), orders...)
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+var (
+	n7Order = repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	n8Order = repository.Order{OrderId: "orderId1", Namespace: "N8", Total: 20}
+)
+
+// newTestCache returns a cache of a mock repository whose clock is advanced by the returned function.
+func newTestCache(settings Settings) (*orderRepositoryCache, *repository.MockOrderRepository, func(time.Duration)) {
+	repoMock := &repository.MockOrderRepository{}
+	c := newCache("test", repoMock, settings)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return c, repoMock, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCacheServesListingsUntilTheyExpire(t *testing.T) {
+	c, repoMock, advance := newTestCache(Settings{TTL: time.Second})
+	defer repoMock.AssertExpectations(t)
+	repoMock.On("GetNamespaceOrders", "N7").Return([]repository.Order{n7Order}, nil).Twice()
+
+	for i := 0; i < 3; i++ {
+		orders, err := c.GetNamespaceOrders("N7")
+		require.NoError(t, err)
+		assert.Equal(t, []repository.Order{n7Order}, orders)
+	}
+	advance(time.Second)
+	_, err := c.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+
+	metrics := c.Metrics()
+	assert.Equal(t, uint64(2), metrics.Hits)
+	assert.Equal(t, uint64(2), metrics.Misses)
+	assert.Equal(t, 1, metrics.Entries)
+}
+
+func TestCacheInvalidatesChangedNamespaces(t *testing.T) {
+	c, repoMock, _ := newTestCache(Settings{TTL: time.Minute})
+	defer repoMock.AssertExpectations(t)
+	repoMock.On("GetOrders").Return([]repository.Order{n7Order, n8Order}, nil).Twice()
+	repoMock.On("GetNamespaceOrders", "N7").Return([]repository.Order{n7Order}, nil).Twice()
+	repoMock.On("GetNamespaceOrders", "N8").Return([]repository.Order{n8Order}, nil).Once()
+	repoMock.On("InsertOrder", n7Order).Return(repository.ErrDuplicateKey).Once()
+
+	c.GetOrders()
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N8")
+
+	// when
+	assert.Equal(t, repository.ErrDuplicateKey, c.InsertOrder(n7Order))
+
+	// then
+	c.GetOrders()
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N8")
+}
+
+func TestCacheInvalidatesMovedNamespaces(t *testing.T) {
+	c, repoMock, _ := newTestCache(Settings{TTL: time.Minute})
+	defer repoMock.AssertExpectations(t)
+	repoMock.On("GetNamespaceOrders", "N7").Return([]repository.Order{n7Order}, nil).Twice()
+	repoMock.On("GetNamespaceOrders", "N9").Return([]repository.Order{}, nil).Twice()
+	repoMock.On("MoveNamespaceOrders", "N7", "N9", false).Return(int64(1), nil).Once()
+
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N9")
+
+	// when
+	moved, err := c.MoveNamespaceOrders("N7", "N9", false)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), moved)
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N9")
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, repoMock, _ := newTestCache(Settings{TTL: time.Minute, MaxEntries: 2})
+	defer repoMock.AssertExpectations(t)
+	for _, ns := range []string{"N7", "N8", "N9"} {
+		repoMock.On("GetNamespaceOrders", ns).Return([]repository.Order{}, nil).Once()
+	}
+	repoMock.On("GetNamespaceOrders", "N8").Return([]repository.Order{}, nil).Once()
+
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N8")
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N9")
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N8")
+
+	assert.Equal(t, uint64(2), c.Metrics().Evictions)
+}
+
+func TestCacheSkipsListingsReadDuringAChange(t *testing.T) {
+	c, repoMock, _ := newTestCache(Settings{TTL: time.Minute})
+	defer repoMock.AssertExpectations(t)
+	repoMock.On("DeleteNamespaceOrders", "N7").Return(int64(0), nil).Once()
+	repoMock.On("GetNamespaceOrders", "N7").Return([]repository.Order{n7Order}, nil).Once().
+		Run(func(mock.Arguments) { c.DeleteNamespaceOrders("N7") })
+	repoMock.On("GetNamespaceOrders", "N7").Return([]repository.Order{}, nil).Once()
+
+	c.GetNamespaceOrders("N7")
+	orders, err := c.GetNamespaceOrders("N7")
+
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
+
+// versionedRepository is a mock repository tracking the version set in its field.
+type versionedRepository struct {
+	*repository.MockOrderRepository
+	version repository.Version
+}
+
+func (r *versionedRepository) OrdersVersion(string) (repository.Version, error) {
+	return r.version, nil
+}
+
+func TestCacheReadsListingsAgainOnceTheirVersionChanged(t *testing.T) {
+	repoMock := &repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	repo := &versionedRepository{MockOrderRepository: repoMock, version: repository.Version{Counter: 1}}
+	c := newCache("test", repo, Settings{TTL: time.Minute})
+	changed := n7Order
+	changed.Total = 30
+	repoMock.On("GetNamespaceOrders", "N7").Return([]repository.Order{n7Order}, nil).Once()
+	repoMock.On("GetNamespaceOrders", "N7").Return([]repository.Order{changed}, nil).Once()
+
+	c.GetNamespaceOrders("N7")
+	c.GetNamespaceOrders("N7")
+	// when another replica changes the namespace
+	repo.version = repository.Version{Counter: 2}
+	orders, err := c.GetNamespaceOrders("N7")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{changed}, orders)
+	assert.Equal(t, uint64(1), c.Metrics().Hits)
+	assert.Equal(t, uint64(2), c.Metrics().Misses)
+}
@@ -0,0 +1,108 @@
+package connection
+
+import (
+	"database/sql"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retry"
+)
+
+// Default is the Manager of the connections opened by the backends of the service.
+var Default = NewManager()
+
+// Stats are the statistics of a named connection pool.
+type Stats struct {
+	Name string
+	sql.DBStats
+}
+
+// Manager opens the database connection pools of the service with the pool settings of their configuration,
+// and keeps track of them so that their statistics can be reported and all of them closed on shutdown.
+// It is safe for concurrent use.
+type Manager struct {
+	mutex sync.Mutex
+	pools []pool
+}
+
+type pool struct {
+	name string
+	db   *sql.DB
+}
+
+// NewManager creates a Manager without any connection.
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Open opens a connection pool to the database, applies the pool settings of cfg and tests the connection,
+// waiting up to the ConnectTimeout of cfg for a database which is still starting.
+// The pool is closed by Close, or by the caller once it is not used anymore.
+// The name identifies the pool in the statistics and must not contain credentials.
+func (m *Manager) Open(name, driverName, dsn string, cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while establishing connection to '%s'", driverName)
+	}
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+
+	log.Debug("Testing connection")
+	if err := retry.ForStartup(cfg).Do(repository.IsTransient, db.Ping); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "while testing DB connection")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pools = append(m.pools, pool{name: name, db: db})
+	return db, nil
+}
+
+// Stats returns the statistics of every open connection pool.
+func (m *Manager) Stats() []Stats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := make([]Stats, 0, len(m.pools))
+	for _, pool := range m.pools {
+		stats = append(stats, Stats{Name: pool.name, DBStats: pool.db.Stats()})
+	}
+	return stats
+}
+
+// Close closes every connection pool, returning the first error.
+func (m *Manager) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var first error
+	for _, pool := range m.pools {
+		if err := pool.db.Close(); err != nil {
+			log.Errorf("Error closing connection %s: %s", pool.name, err)
+			if first == nil {
+				first = errors.Wrapf(err, "while closing connection %s", pool.name)
+			}
+		}
+	}
+	m.pools = nil
+	return first
+}
+
+// Open opens a connection pool with the Default Manager, see Manager.Open.
+func Open(name, driverName, dsn string, cfg config.Config) (*sql.DB, error) {
+	return Default.Open(name, driverName, dsn, cfg)
+}
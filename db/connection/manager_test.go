@@ -0,0 +1,64 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+func TestManager(t *testing.T) {
+	manager := NewManager()
+	cfg := config.Config{MaxOpenConns: 2, MaxIdleConns: 1, ConnMaxLifetime: time.Minute, ConnMaxIdleTime: time.Second}
+
+	// when
+	db1, err := manager.Open("first", "sqlite", ":memory:", cfg)
+	require.NoError(t, err)
+	_, err = manager.Open("second", "sqlite", ":memory:", cfg)
+	require.NoError(t, err)
+
+	// then
+	stats := manager.Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "first", stats[0].Name)
+	assert.Equal(t, 2, stats[0].MaxOpenConnections)
+	assert.Equal(t, "second", stats[1].Name)
+
+	// when
+	require.NoError(t, manager.Close())
+
+	// then
+	assert.Empty(t, manager.Stats())
+	assert.Error(t, db1.Ping())
+}
+
+func TestManagerOpenFailure(t *testing.T) {
+	manager := NewManager()
+
+	_, err := manager.Open("unknown", "unknown", "", config.Config{})
+
+	assert.Error(t, err)
+	assert.Empty(t, manager.Stats())
+}
+
+func TestManagerWaitsForDatabase(t *testing.T) {
+	manager := NewManager()
+	cfg := config.Config{ConnectTimeout: 300 * time.Millisecond, RetryBackoff: 50 * time.Millisecond, RetryMaxBackoff: 100 * time.Millisecond}
+	start := time.Now()
+
+	// when
+	_, err := manager.Open("refused", "postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1", cfg)
+
+	// then
+	require.Error(t, err)
+	assert.True(t, repository.IsTransient(pkgerrors.Cause(err)))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "gave up after %s", time.Since(start))
+	assert.True(t, time.Since(start) < time.Second, "gave up after %s", time.Since(start))
+}
@@ -0,0 +1,218 @@
+package encryption
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// Settings tune how a Job encrypts the orders again.
+type Settings struct {
+	// Interval is waited between two runs.
+	Interval time.Duration
+	// BatchSize limits the orders encrypted again in a single transaction.
+	BatchSize int
+}
+
+// SettingsFor returns the Settings configured in cfg.
+func SettingsFor(cfg config.Config) Settings {
+	return Settings{Interval: cfg.ReencryptionInterval, BatchSize: cfg.ReencryptionBatchSize}
+}
+
+// Validate returns an error if the settings cannot run a Job: an Interval or a BatchSize which is not positive
+// would make it run without pause.
+func (s Settings) Validate() error {
+	if s.Interval <= 0 {
+		return errors.Errorf("the reencryption interval must be positive, not %s", s.Interval)
+	}
+	if s.BatchSize <= 0 {
+		return errors.Errorf("the reencryption batch size must be positive, not %d", s.BatchSize)
+	}
+	return nil
+}
+
+// Status reports the keys of a database and the runs of its Job.
+type Status struct {
+	Name      string
+	Fields    []string
+	ActiveKey string
+	Keys      int
+	// LastRun is when the last run started, it is left out before the first run.
+	LastRun      *time.Time    `json:",omitempty"`
+	LastDuration time.Duration `json:",omitempty"`
+	// Resealed counts the orders encrypted again by the last run, TotalResealed by every run since the service started.
+	Resealed      int64
+	TotalResealed int64
+	// Error is why the last run failed, the orders of the batches committed before being counted.
+	Error string `json:",omitempty"`
+}
+
+// Job encrypts with the active data key the orders of a database which are not yet, such as the orders written
+// before the key was rotated or before encryption was enabled.
+type Job struct {
+	name     string
+	repo     *repository.OrderRepositorySQL
+	keyring  *Keyring
+	settings Settings
+	now      func() time.Time
+
+	mutex  sync.Mutex
+	status Status
+}
+
+// NewJob creates the Job of the repository, whose Cipher is the keyring.
+func NewJob(name string, repo *repository.OrderRepositorySQL, keyring *Keyring, settings Settings) *Job {
+	return &Job{name: name, repo: repo, keyring: keyring, settings: settings, now: time.Now, status: Status{Name: name}}
+}
+
+// Enable encrypts the fields of the orders of the repository's database, named after the database, with the data
+// keys wrapped by the master keys, and returns the Job encrypting the existing orders.
+// It fails if the repository is not an SQL one.
+func Enable(name string, repo repository.OrderRepository, master *MasterKeys, fields []string, settings Settings) (*Job, error) {
+	sqlRepo, ok := repo.(*repository.OrderRepositorySQL)
+	if !ok {
+		return nil, errors.Errorf("the orders of %s cannot be encrypted, its database is not an SQL one", name)
+	}
+	dialect := sqlRepo.Dialect
+	if dialect == nil {
+		dialect = repository.PostgresDialect{}
+	}
+	keyring, err := NewKeyring(name, sqlRepo.Database, dialect, master, fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while loading the data keys of %s", name)
+	}
+	sqlRepo.Cipher = keyring
+	return NewJob(name, sqlRepo, keyring, settings), nil
+}
+
+// Run encrypts the orders again every Interval until the context is done.
+func (j *Job) Run(ctx context.Context) {
+	log.Infof("Encrypting the orders of %s with data key %s", j.name, j.keyring.Active())
+	ticker := time.NewTicker(j.settings.Interval)
+	defer ticker.Stop()
+	for {
+		j.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce reads the data keys again, so that a key rotated by another replica is used, and encrypts in batches the
+// orders which are not encrypted with the active key. It returns the updated status of the job.
+func (j *Job) RunOnce(ctx context.Context) Status {
+	started := j.now()
+	resealed, err := j.run(ctx)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.status.LastRun = &started
+	j.status.LastDuration = j.now().Sub(started)
+	j.status.Resealed = resealed
+	j.status.TotalResealed += resealed
+	j.status.Error = ""
+	if err != nil {
+		j.status.Error = err.Error()
+		log.Warnf("Encrypting the orders of %s again failed: %s", j.name, err)
+	} else if resealed > 0 {
+		log.Infof("%d orders of %s encrypted with data key %s", resealed, j.name, j.keyring.Active())
+	}
+	return j.withKeys(j.status)
+}
+
+func (j *Job) run(ctx context.Context) (int64, error) {
+	if err := j.keyring.Refresh(); err != nil {
+		return 0, err
+	}
+	var total int64
+	for ctx.Err() == nil {
+		n, err := j.repo.ResealOrders(j.settings.BatchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < int64(j.settings.BatchSize) {
+			break
+		}
+	}
+	return total, nil
+}
+
+// Rotate creates the data key encrypting the orders from now on, and returns the status of the job. The existing
+// orders remain readable, and are encrypted with the new key by the next runs.
+func (j *Job) Rotate() (Status, error) {
+	if _, err := j.keyring.Rotate(); err != nil {
+		return Status{}, errors.Wrapf(err, "while rotating the data key of %s", j.name)
+	}
+	return j.Status(), nil
+}
+
+// Status returns the status of the job.
+func (j *Job) Status() Status {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.withKeys(j.status)
+}
+
+// withKeys completes the status with the current keys of the keyring.
+func (j *Job) withKeys(status Status) Status {
+	status.Fields = j.keyring.EncryptedFields()
+	status.ActiveKey = j.keyring.Active()
+	status.Keys = j.keyring.Keys()
+	return status
+}
+
+// Registry keeps the jobs of the service, so that their status can be reported and their keys rotated.
+// It is safe for concurrent use.
+type Registry struct {
+	mutex sync.Mutex
+	jobs  []*Job
+}
+
+// Default is the Registry of the jobs of the tenant databases.
+var Default = &Registry{}
+
+// New enables encryption for the repository's database like Enable, and registers its Job.
+func (r *Registry) New(name string, repo repository.OrderRepository, master *MasterKeys, fields []string, settings Settings) (*Job, error) {
+	job, err := Enable(name, repo, master, fields, settings)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.jobs = append(r.jobs, job)
+	return job, nil
+}
+
+// Status returns the status of every job.
+func (r *Registry) Status() []Status {
+	r.mutex.Lock()
+	jobs := append([]*Job(nil), r.jobs...)
+	r.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(jobs))
+	for _, job := range jobs {
+		statuses = append(statuses, job.Status())
+	}
+	return statuses
+}
+
+// Get returns the job of the named database, and false if its orders are not encrypted.
+func (r *Registry) Get(name string) (*Job, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, job := range r.jobs {
+		if job.name == name {
+			return job, true
+		}
+	}
+	return nil, false
+}
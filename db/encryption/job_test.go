@@ -0,0 +1,196 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/migrations"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/sqlite"
+)
+
+func TestJobEncryptsTheExistingOrders(t *testing.T) {
+	repo := newRepository(t)
+	var orders []repository.Order
+	for _, id := range []string{"o1", "o2", "o3", "o4", "o5"} {
+		order := repository.Order{OrderId: id, Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "web"}}
+		require.NoError(t, repo.InsertOrder(order))
+		orders = append(orders, order)
+	}
+	job := encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+
+	// when
+	status := job.RunOnce(context.Background())
+
+	// then
+	assert.Empty(t, status.Error)
+	assert.Equal(t, int64(5), status.Resealed)
+	assert.NotNil(t, status.LastRun)
+	for _, order := range orders {
+		assert.True(t, strings.HasPrefix(readStored(t, repo, order.OrderId).sealed.String, status.ActiveKey+":"))
+	}
+	read, err := repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.Equal(t, orders, read)
+
+	// when
+	status = job.RunOnce(context.Background())
+
+	// then
+	assert.Zero(t, status.Resealed)
+	assert.Equal(t, int64(5), status.TotalResealed)
+}
+
+func TestJobEncryptsTheOrdersWithTheRotatedKey(t *testing.T) {
+	repo := newRepository(t)
+	job := encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+	order := repository.Order{OrderId: "o1", Namespace: "N7", Total: 10}
+	require.NoError(t, repo.InsertOrder(order))
+	first := job.Status().ActiveKey
+
+	// when
+	status, err := job.Rotate()
+
+	// then the order is still readable
+	require.NoError(t, err)
+	assert.NotEqual(t, first, status.ActiveKey)
+	assert.Equal(t, 2, status.Keys)
+	read, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{order}, read)
+	assert.True(t, strings.HasPrefix(readStored(t, repo, "o1").sealed.String, first+":"))
+
+	// when
+	status = job.RunOnce(context.Background())
+
+	// then
+	assert.Equal(t, int64(1), status.Resealed)
+	assert.True(t, strings.HasPrefix(readStored(t, repo, "o1").sealed.String, status.ActiveKey+":"))
+}
+
+func TestJobUsesTheKeyRotatedByAnotherReplica(t *testing.T) {
+	repo := newRepository(t)
+	job := encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "o1", Namespace: "N7", Total: 10}))
+	replica, err := NewKeyring("replica", repo.Database, repository.SQLiteDialect{}, parseMasterKeys(t, masterKey1), Fields)
+	require.NoError(t, err)
+
+	// when
+	rotated, err := replica.Rotate()
+	require.NoError(t, err)
+	status := job.RunOnce(context.Background())
+
+	// then
+	assert.Equal(t, rotated, status.ActiveKey)
+	assert.Equal(t, int64(1), status.Resealed)
+}
+
+// interleavingConnector opens SQLite connections which run the write once, right before the first statement
+// starting with the prefix. As SQLite has a single writer, this stands for a write committed meanwhile by another one.
+type interleavingConnector struct {
+	driver driver.Driver
+	path   string
+	prefix string
+	write  func(driver.ExecerContext) error
+}
+
+func (c *interleavingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	return &interleavingConn{Conn: conn, connector: c}, nil
+}
+
+func (c *interleavingConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type interleavingConn struct {
+	driver.Conn
+	connector *interleavingConnector
+}
+
+func (c *interleavingConn) Prepare(query string) (driver.Stmt, error) {
+	if write := c.connector.write; write != nil && strings.HasPrefix(query, c.connector.prefix) {
+		c.connector.write = nil
+		if err := write(c.Conn.(driver.ExecerContext)); err != nil {
+			return nil, err
+		}
+	}
+	return c.Conn.Prepare(query)
+}
+
+func TestJobKeepsTheOrdersWrittenWhileResealing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	opened, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	connector := &interleavingConnector{driver: opened.Driver(), path: path, prefix: `UPDATE "orders" SET total`}
+	require.NoError(t, opened.Close())
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, migrations.Migrate(db, sqlite.Dialect{}, "orders"))
+	repo := &repository.OrderRepositorySQL{Database: db, OrdersTableName: "orders", Dialect: sqlite.Dialect{}}
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "o1", Namespace: "N7", Total: 10}))
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "o2", Namespace: "N7", Total: 10}))
+	job := encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+	changed := repository.Order{OrderId: "o1", Namespace: "N7", Total: 30}
+	sealed, err := repo.Cipher.Seal(changed)
+	require.NoError(t, err)
+	// the order is upserted once read by the job, before the job writes it
+	connector.write = func(conn driver.ExecerContext) error {
+		_, err := conn.ExecContext(context.Background(), `UPDATE "orders" SET total = NULL, labels = '{}', sealed = ? WHERE order_id = 'o1'`,
+			[]driver.NamedValue{{Ordinal: 1, Value: sealed}})
+		return err
+	}
+
+	// when
+	status := job.RunOnce(context.Background())
+
+	// then
+	assert.Empty(t, status.Error)
+	assert.Nil(t, connector.write)
+	assert.Equal(t, int64(1), status.Resealed)
+	read, err := repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{changed, {OrderId: "o2", Namespace: "N7", Total: 10}}, read)
+}
+
+func TestRegistry(t *testing.T) {
+	registry := &Registry{}
+	master := parseMasterKeys(t, masterKey1)
+
+	// when
+	_, err := registry.New("memory", repository.NewOrderRepositoryMemory(), master, Fields, Settings{BatchSize: 10})
+	assert.Error(t, err)
+	_, err = registry.New("sqlite", newRepository(t), master, Fields, Settings{BatchSize: 10})
+	require.NoError(t, err)
+
+	// then
+	statuses := registry.Status()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "sqlite", statuses[0].Name)
+	assert.Equal(t, []string{"total", "labels"}, statuses[0].Fields)
+	assert.Equal(t, 1, statuses[0].Keys)
+	assert.Nil(t, statuses[0].LastRun)
+	_, exists := registry.Get("sqlite")
+	assert.True(t, exists)
+	_, exists = registry.Get("memory")
+	assert.False(t, exists)
+}
+
+func TestSettingsValidate(t *testing.T) {
+	assert.NoError(t, Settings{Interval: time.Minute, BatchSize: 1}.Validate())
+	for _, settings := range []Settings{{Interval: time.Minute}, {BatchSize: 10}, {Interval: -time.Minute, BatchSize: 10}} {
+		assert.Error(t, settings.Validate(), settings)
+	}
+}
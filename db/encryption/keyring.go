@@ -0,0 +1,268 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+const (
+	selectKeysQuery = "SELECT id, master_key_id, wrapped_key FROM %s ORDER BY created_at, id"
+	insertKeyQuery  = "INSERT INTO %s (id, master_key_id, wrapped_key, created_at) VALUES (%s, %s, %s, %s)"
+	rewrapKeyQuery  = "UPDATE %s SET master_key_id = %s, wrapped_key = %s WHERE id = %s"
+)
+
+// Fields are the fields of the orders which can be encrypted.
+var Fields = []string{repository.TotalField, repository.LabelsField}
+
+// sealedFields holds the values of the encrypted fields of an order, a field which is not encrypted being left out.
+type sealedFields struct {
+	Total  *float64          `json:"total,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Keyring is the repository.FieldCipher of a database, encrypting the fields with the most recent of its data keys.
+// The ciphertext is bound to the ID of its order, so that it cannot be copied to another order, and is prefixed
+// with the ID of the data key which sealed it. It is safe for concurrent use.
+type Keyring struct {
+	name    string
+	db      repository.DBQuerier
+	dialect repository.Dialect
+	master  *MasterKeys
+	fields  map[string]bool
+	now     func() time.Time
+
+	mutex  sync.RWMutex
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// NewKeyring returns the Keyring encrypting the fields with the data keys of the database, whose queries are written
+// in the SQL flavour of the dialect. The data keys wrapped by a master key other than the current one are wrapped
+// again with it, and the first data key is created if the database has none.
+func NewKeyring(name string, db repository.DBQuerier, dialect repository.Dialect, master *MasterKeys, fields []string) (*Keyring, error) {
+	k := &Keyring{name: name, db: db, dialect: dialect, master: master, fields: make(map[string]bool), now: time.Now}
+	for _, field := range fields {
+		if !isField(field) {
+			return nil, errors.Errorf("field '%s' cannot be encrypted, expected one of %s", field, strings.Join(Fields, ", "))
+		}
+		k.fields[field] = true
+	}
+	if len(k.fields) == 0 {
+		return nil, errors.New("no field to encrypt")
+	}
+	if err := k.Refresh(); err != nil {
+		return nil, err
+	}
+	if k.Active() == "" {
+		if _, err := k.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Refresh reads the data keys of the database again, so that the keys created by other replicas of the service are
+// known, wrapping the keys with the current master key when they are not yet.
+func (k *Keyring) Refresh() error {
+	d := k.dialect
+	rows, err := k.db.Query(fmt.Sprintf(selectKeysQuery, repository.DataKeysTable))
+	if err != nil {
+		return errors.Wrap(repository.TranslateError(err), "while reading the data keys")
+	}
+	type storedKey struct{ id, masterKeyID, wrapped string }
+	var stored []storedKey
+	for rows.Next() {
+		var key storedKey
+		if err := rows.Scan(&key.id, &key.masterKeyID, &key.wrapped); err != nil {
+			rows.Close()
+			return errors.Wrap(repository.TranslateError(err), "while reading the data keys")
+		}
+		stored = append(stored, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(repository.TranslateError(err), "while reading the data keys")
+	}
+
+	keys := make(map[string]cipher.AEAD, len(stored))
+	active := ""
+	for _, key := range stored {
+		plain, err := k.master.unwrap(key.masterKeyID, key.id, key.wrapped)
+		if err != nil {
+			return err
+		}
+		if key.masterKeyID != k.master.Current() {
+			wrapped, err := k.master.wrap(key.id, plain)
+			if err != nil {
+				return err
+			}
+			q := fmt.Sprintf(rewrapKeyQuery, repository.DataKeysTable, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3))
+			if _, err := k.db.Exec(q, k.master.Current(), wrapped, key.id); err != nil {
+				return errors.Wrapf(repository.TranslateError(err), "while wrapping data key %s with the current master key", key.id)
+			}
+			log.Infof("Data key %s of %s wrapped with master key %s", key.id, k.name, k.master.Current())
+		}
+		if keys[key.id], err = newAEAD(plain); err != nil {
+			return errors.Wrapf(err, "invalid data key %s", key.id)
+		}
+		active = key.id
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys = keys
+	k.active = active
+	return nil
+}
+
+// Rotate creates a data key, which becomes the active one, and returns its ID.
+// The orders sealed with the previous keys remain readable until a Job seals them with the new one.
+func (k *Keyring) Rotate() (string, error) {
+	key := make([]byte, keySize)
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "while generating a data key")
+	}
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errors.Wrap(err, "while generating a data key")
+	}
+	id := hex.EncodeToString(suffix)
+	wrapped, err := k.master.wrap(id, key)
+	if err != nil {
+		return "", err
+	}
+	d := k.dialect
+	q := fmt.Sprintf(insertKeyQuery, repository.DataKeysTable, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3), d.Placeholder(4))
+	if _, err := k.db.Exec(q, id, k.master.Current(), wrapped, k.now().UnixNano()); err != nil {
+		return "", errors.Wrap(repository.TranslateError(err), "while storing a data key")
+	}
+	log.Infof("Data key %s of %s created", id, k.name)
+	return id, k.Refresh()
+}
+
+// Active returns the ID of the data key sealing the orders.
+func (k *Keyring) Active() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.active
+}
+
+// Keys returns the number of data keys.
+func (k *Keyring) Keys() int {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return len(k.keys)
+}
+
+// EncryptedFields returns the encrypted fields, in the order of Fields.
+func (k *Keyring) EncryptedFields() []string {
+	fields := make([]string, 0, len(k.fields))
+	for _, field := range Fields {
+		if k.fields[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Encrypts tells whether the field is encrypted.
+func (k *Keyring) Encrypts(field string) bool {
+	return k.fields[field]
+}
+
+// Seal encrypts the encrypted fields of the order with the active data key.
+func (k *Keyring) Seal(order repository.Order) (string, error) {
+	var fields sealedFields
+	if k.fields[repository.TotalField] {
+		total := order.Total
+		fields.Total = &total
+	}
+	if k.fields[repository.LabelsField] {
+		fields.Labels = order.Labels
+	}
+	plaintext, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	k.mutex.RLock()
+	active, aead := k.active, k.keys[k.active]
+	k.mutex.RUnlock()
+	sealed, err := seal(aead, plaintext, []byte(order.OrderId))
+	if err != nil {
+		return "", err
+	}
+	return active + ":" + sealed, nil
+}
+
+// Open restores the fields sealed in the ciphertext, whatever fields are encrypted now. A data key created by another
+// replica since the last Refresh is read from the database.
+func (k *Keyring) Open(order *repository.Order, sealed string) error {
+	parts := strings.SplitN(sealed, ":", 2)
+	if len(parts) != 2 {
+		return errors.New("malformed ciphertext")
+	}
+	aead, err := k.key(parts[0])
+	if err != nil {
+		return err
+	}
+	plaintext, err := open(aead, parts[1], []byte(order.OrderId))
+	if err != nil {
+		return err
+	}
+	var fields sealedFields
+	if err := json.Unmarshal(plaintext, &fields); err != nil {
+		return errors.Wrap(err, "while reading the decrypted fields")
+	}
+	if fields.Total != nil {
+		order.Total = *fields.Total
+	}
+	if fields.Labels != nil {
+		order.Labels = fields.Labels
+	}
+	return nil
+}
+
+// ActivePrefix is the prefix of the ciphertexts sealed with the active data key.
+func (k *Keyring) ActivePrefix() string {
+	return k.Active() + ":"
+}
+
+// key returns the data key with the ID, refreshing the keys once if it is unknown.
+func (k *Keyring) key(id string) (cipher.AEAD, error) {
+	k.mutex.RLock()
+	aead, exists := k.keys[id]
+	k.mutex.RUnlock()
+	if exists {
+		return aead, nil
+	}
+	if err := k.Refresh(); err != nil {
+		return nil, err
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if aead, exists = k.keys[id]; !exists {
+		return nil, errors.Errorf("unknown data key %s", id)
+	}
+	return aead, nil
+}
+
+func isField(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
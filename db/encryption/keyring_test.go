@@ -0,0 +1,195 @@
+package encryption
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/sqlite"
+)
+
+// newRepository returns an SQLite repository.
+func newRepository(t *testing.T) *repository.OrderRepositorySQL {
+	ds := sqlite.SQLite{DBCfg: config.Config{SQLitePath: ":memory:", DbOrdersTableName: "orders"}}
+	repo, err := ds.NewOrderRepositoryDb()
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.CleanUp() })
+	return repo.(*repository.OrderRepositorySQL)
+}
+
+func parseMasterKeys(t *testing.T, keys ...string) *MasterKeys {
+	content := ""
+	for _, key := range keys {
+		content += key + "\n"
+	}
+	master, err := ParseMasterKeys([]byte(content))
+	require.NoError(t, err)
+	return master
+}
+
+// encrypt enables encryption of the fields of the repository.
+func encrypt(t *testing.T, repo *repository.OrderRepositorySQL, master *MasterKeys, fields ...string) *Job {
+	job, err := Enable("test", repo, master, fields, Settings{BatchSize: 2})
+	require.NoError(t, err)
+	return job
+}
+
+// storedOrder is an order as read from the orders table.
+type storedOrder struct {
+	total  sql.NullFloat64
+	labels string
+	sealed sql.NullString
+}
+
+func readStored(t *testing.T, repo *repository.OrderRepositorySQL, orderID string) storedOrder {
+	rows, err := repo.Database.Query(`SELECT total, labels, sealed FROM "orders" WHERE order_id = ?`, orderID)
+	require.NoError(t, err)
+	defer rows.Close()
+	require.True(t, rows.Next())
+	var stored storedOrder
+	require.NoError(t, rows.Scan(&stored.total, &stored.labels, &stored.sealed))
+	return stored
+}
+
+func TestEncryptedOrders(t *testing.T) {
+	repo := newRepository(t)
+	job := encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+	web := repository.Order{OrderId: "o1", Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "web"}}
+	shop := repository.Order{OrderId: "o2", Namespace: "N7", Total: 5.5, Labels: map[string]string{"channel": "shop"}}
+
+	// when
+	require.NoError(t, repo.InsertOrder(web))
+	_, err := repo.UpsertOrders([]repository.Order{shop, {OrderId: "o3", Namespace: "N8"}})
+	require.NoError(t, err)
+
+	// then the fields are unreadable in the database
+	stored := readStored(t, repo, "o1")
+	assert.False(t, stored.total.Valid)
+	assert.Equal(t, "{}", stored.labels)
+	require.True(t, stored.sealed.Valid)
+	assert.Contains(t, stored.sealed.String, job.Status().ActiveKey+":")
+	assert.NotContains(t, stored.sealed.String, "web")
+
+	orders, err := repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{web, shop}, orders)
+
+	selector, err := repository.ParseLabelSelector("channel=web")
+	require.NoError(t, err)
+	orders, err = repo.GetOrdersBySelector("N7", selector)
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{web}, orders)
+
+	namespaces, err := repository.Namespaces(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Namespace{{Name: "N7", Orders: 2, Total: 15.5}, {Name: "N8", Orders: 1}}, namespaces)
+
+	deleted, err := repo.DeleteOrdersBySelector("N7", selector)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	orders, err = repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{shop}, orders)
+}
+
+func TestEncryptedLabelsOnly(t *testing.T) {
+	repo := newRepository(t)
+	encrypt(t, repo, parseMasterKeys(t, masterKey1), repository.LabelsField)
+	order := repository.Order{OrderId: "o1", Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "web"}}
+
+	// when
+	require.NoError(t, repo.InsertOrder(order))
+
+	// then
+	stored := readStored(t, repo, "o1")
+	assert.Equal(t, sql.NullFloat64{Float64: 10, Valid: true}, stored.total)
+	assert.Equal(t, "{}", stored.labels)
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{order}, orders)
+}
+
+func TestEncryptedOrderCannotBeMoved(t *testing.T) {
+	repo := newRepository(t)
+	encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "o1", Namespace: "N7", Total: 10}))
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "o2", Namespace: "N7", Total: 20}))
+
+	// when the ciphertext of o1 is copied to o2
+	_, err := repo.Database.Exec(`UPDATE "orders" SET sealed = (SELECT sealed FROM "orders" WHERE order_id = 'o1') WHERE order_id = 'o2'`)
+	require.NoError(t, err)
+
+	// then
+	_, err = repo.GetNamespaceOrders("N7")
+	assert.Error(t, err)
+}
+
+func TestEncryptedOrdersNeedTheKeys(t *testing.T) {
+	repo := newRepository(t)
+	encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "o1", Namespace: "N7", Total: 10}))
+
+	t.Run("Without encryption", func(t *testing.T) {
+		repo.Cipher = nil
+		_, err := repo.GetOrders()
+		assert.Error(t, err)
+	})
+
+	t.Run("Without the master key", func(t *testing.T) {
+		_, err := NewKeyring("test", repo.Database, repository.SQLiteDialect{}, parseMasterKeys(t, masterKey2), Fields)
+		assert.Error(t, err)
+	})
+}
+
+func TestNewKeyringReusesTheDataKeys(t *testing.T) {
+	repo := newRepository(t)
+	first, err := NewKeyring("test", repo.Database, repository.SQLiteDialect{}, parseMasterKeys(t, masterKey1), Fields)
+	require.NoError(t, err)
+
+	// when
+	second, err := NewKeyring("test", repo.Database, repository.SQLiteDialect{}, parseMasterKeys(t, masterKey1), Fields)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, first.Active(), second.Active())
+	assert.Equal(t, 1, second.Keys())
+}
+
+func TestNewKeyringWrapsTheDataKeysWithTheCurrentMasterKey(t *testing.T) {
+	repo := newRepository(t)
+	encrypt(t, repo, parseMasterKeys(t, masterKey1), Fields...)
+	order := repository.Order{OrderId: "o1", Namespace: "N7", Total: 10}
+	require.NoError(t, repo.InsertOrder(order))
+
+	// when m2 is added to the key file
+	encrypt(t, repo, parseMasterKeys(t, masterKey1, masterKey2), Fields...)
+
+	// then m1 can be removed
+	var masterKeyID string
+	rows, err := repo.Database.Query(`SELECT master_key_id FROM data_keys`)
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&masterKeyID))
+	require.NoError(t, rows.Close())
+	assert.Equal(t, "m2", masterKeyID)
+
+	encrypt(t, repo, parseMasterKeys(t, masterKey2), Fields...)
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{order}, orders)
+}
+
+func TestNewKeyringRejectsUnknownFields(t *testing.T) {
+	repo := newRepository(t)
+
+	for name, fields := range map[string][]string{"No field": nil, "Unknown field": {"namespace"}} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewKeyring("test", repo.Database, repository.SQLiteDialect{}, parseMasterKeys(t, masterKey1), fields)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Package encryption encrypts fields of the orders stored in the SQL databases, so that they are unreadable to whoever
+// reads the database, with envelope encryption: every database has its own data keys, which encrypt the fields with
+// AES-GCM and are stored in the `data_keys` table wrapped by a master key. The master keys are read from a local key
+// file and never stored in a database.
+//
+// Keys are rotated without downtime: a new data key becomes active for the orders written from then on, while a Job
+// encrypts the other orders again in the background. A new master key is added at the end of the key file, the data
+// keys are wrapped with it when the service starts, after which the previous master key can be removed.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// keySize is the size of the master and data keys, which select AES-256.
+const keySize = 32
+
+var keyIDRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// MasterKeys are the keys wrapping the data keys, the last one of the key file being the current one.
+type MasterKeys struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// LoadMasterKeys reads the master keys of the key file, see ParseMasterKeys.
+func LoadMasterKeys(path string) (*MasterKeys, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading the master key file")
+	}
+	return ParseMasterKeys(content)
+}
+
+// ParseMasterKeys parses the master keys written one per line as `<id>:<key>`, the key being 32 random bytes encoded
+// in standard base64. Empty lines and lines starting with `#` are ignored.
+func ParseMasterKeys(content []byte) (*MasterKeys, error) {
+	master := &MasterKeys{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || !keyIDRegex.MatchString(parts[0]) {
+			return nil, errors.Errorf("invalid master key on line %d, expected <id>:<base64 key>", line)
+		}
+		id := parts[0]
+		if master.keys[id] != nil {
+			return nil, errors.Errorf("master key %s is given twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil || len(key) != keySize {
+			return nil, errors.Errorf("invalid master key %s, expected %d bytes encoded in base64", id, keySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid master key %s", id)
+		}
+		master.keys[id] = aead
+		master.current = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "while reading the master keys")
+	}
+	if master.current == "" {
+		return nil, errors.New("no master key is given")
+	}
+	return master, nil
+}
+
+// Current returns the ID of the master key wrapping the new data keys.
+func (m *MasterKeys) Current() string {
+	return m.current
+}
+
+// wrap encrypts the data key with the current master key, binding it to its ID.
+func (m *MasterKeys) wrap(dataKeyID string, key []byte) (string, error) {
+	return seal(m.keys[m.current], key, []byte(dataKeyID))
+}
+
+// unwrap decrypts the data key wrapped by the master key.
+func (m *MasterKeys) unwrap(masterKeyID, dataKeyID, wrapped string) ([]byte, error) {
+	aead, exists := m.keys[masterKeyID]
+	if !exists {
+		return nil, errors.Errorf("data key %s is wrapped by the unknown master key %s", dataKeyID, masterKeyID)
+	}
+	key, err := open(aead, wrapped, []byte(dataKeyID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "while unwrapping data key %s", dataKeyID)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, and returns the nonce followed by the ciphertext in base64.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "while generating a nonce")
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, additionalData)), nil
+}
+
+// open decrypts the output of seal, failing if it was altered or the additional data differs.
+func open(aead cipher.AEAD, sealed string, additionalData []byte) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, errors.New("malformed ciphertext")
+	}
+	plaintext, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, errors.New("the ciphertext cannot be authenticated")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,52 @@
+package encryption
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	masterKey1 = "m1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	masterKey2 = "m2:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+)
+
+func TestLoadMasterKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.keys")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# rotated on 2020-01-31\n"+masterKey1+"\n\n"+masterKey2+"\n"), 0600))
+
+	// when
+	master, err := LoadMasterKeys(path)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "m2", master.Current())
+	wrapped, err := master.wrap("k1", []byte("data key"))
+	require.NoError(t, err)
+	key, err := master.unwrap("m2", "k1", wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data key"), key)
+	_, err = master.unwrap("m2", "k2", wrapped)
+	assert.Error(t, err, "the wrapped key is bound to its ID")
+	_, err = master.unwrap("m1", "k1", wrapped)
+	assert.Error(t, err)
+}
+
+func TestParseInvalidMasterKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"No key":          "# no key\n",
+		"No ID":           "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"Invalid ID":      "m 1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"Invalid base64":  "m1:not base64",
+		"Short key":       "m1:AAAAAAAAAAAAAAAAAAAAAA==",
+		"Key given twice": masterKey1 + "\n" + masterKey1,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseMasterKeys([]byte(content))
+			assert.Error(t, err)
+		})
+	}
+}
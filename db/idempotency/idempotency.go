@@ -0,0 +1,230 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// MaxKeyLength is the longest idempotency key which can be stored.
+const MaxKeyLength = 255
+
+// Record is the outcome of a request carrying an idempotency key, replayed when the request is repeated with the same key.
+type Record struct {
+	// EndUser and Key identify the record, keys of different end-users never collide.
+	EndUser string
+	Key     string
+	// RequestHash fingerprints the body of the request, a repeated request with another body is not a retry.
+	RequestHash string
+	// Status is the status code of the response, zero while the request is still in progress.
+	Status      int
+	ContentType string
+	Body        []byte
+	// ExpiresAt is when the key can be used for another request: the end of the lease of a record in progress,
+	// after which the request is deemed lost, or the end of the TTL of a completed one.
+	ExpiresAt time.Time
+}
+
+// InProgress tells whether the response of the request is not known yet.
+func (r Record) InProgress() bool {
+	return r.Status == 0
+}
+
+// Store keeps the records of the requests of a database, until they expire.
+type Store interface {
+	// Reserve saves a record in progress for the request, expiring after the lease, unless there is an unexpired
+	// record with the same key, which is returned instead. It returns true if the record was saved.
+	Reserve(endUser, key, requestHash string) (Record, bool, error)
+	// Complete saves the response of a reserved record, which then expires after the TTL. It returns
+	// repository.ErrNotFound if the reservation expired, since the key may be reserved by another request.
+	Complete(record Record) error
+	// Release removes a reserved record, so that the request can be retried, unless its lease expired.
+	Release(record Record) error
+	// Purge removes the expired records, which Reserve only replaces when their key is used again, and returns
+	// how many were removed.
+	Purge() (int64, error)
+}
+
+// Provider is implemented by repositories which keep the idempotency records in their own database.
+type Provider interface {
+	IdempotencyStore(ttl, lease time.Duration) Store
+}
+
+// For returns the Store of the database of the repository, whose records in progress expire after lease and
+// completed records after ttl. Repositories which cannot store them, such as the in-memory repository, get a Store
+// kept in memory.
+func For(repo repository.OrderRepository, ttl, lease time.Duration) Store {
+	switch r := repo.(type) {
+	case Provider:
+		return r.IdempotencyStore(ttl, lease)
+	case *repository.OrderRepositorySQL:
+		return NewSQL(r.Database, r.Dialect, ttl, lease)
+	default:
+		return NewMemory(ttl, lease)
+	}
+}
+
+// IsReservation tells whether the stored record is still the reservation, which expires at reservedUntil.
+func IsReservation(stored Record, reservedUntil time.Time) bool {
+	return stored.InProgress() && stored.ExpiresAt.Equal(reservedUntil)
+}
+
+// memoryStore is a Store kept in memory. It is safe for concurrent use.
+type memoryStore struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	lease   time.Duration
+	records map[recordID]Record
+	now     func() time.Time
+}
+
+type recordID struct {
+	endUser, key string
+}
+
+// NewMemory returns a Store kept in memory, whose records in progress expire after lease and completed records
+// after ttl. The records are lost on restart.
+func NewMemory(ttl, lease time.Duration) Store {
+	return &memoryStore{ttl: ttl, lease: lease, records: make(map[recordID]Record), now: time.Now}
+}
+
+func (s *memoryStore) Reserve(endUser, key, requestHash string) (Record, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	id := recordID{endUser, key}
+	// an expired record gives up its key
+	if record, exists := s.records[id]; exists && now.Before(record.ExpiresAt) {
+		return record, false, nil
+	}
+	record := Record{EndUser: endUser, Key: key, RequestHash: requestHash, ExpiresAt: now.Add(s.lease)}
+	s.records[id] = record
+	return record, true, nil
+}
+
+func (s *memoryStore) Complete(record Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := recordID{record.EndUser, record.Key}
+	if stored, exists := s.records[id]; !exists || !IsReservation(stored, record.ExpiresAt) {
+		return repository.ErrNotFound
+	}
+	record.ExpiresAt = s.now().Add(s.ttl)
+	s.records[id] = record
+	return nil
+}
+
+func (s *memoryStore) Release(record Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := recordID{record.EndUser, record.Key}
+	if stored, exists := s.records[id]; exists && IsReservation(stored, record.ExpiresAt) {
+		delete(s.records, id)
+	}
+	return nil
+}
+
+func (s *memoryStore) Purge() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	var removed int64
+	for id, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Registry keeps the idempotency stores of the tenant databases by end-user. It is safe for concurrent use.
+type Registry struct {
+	mutex  sync.RWMutex
+	stores map[string]Store
+}
+
+// Default is the Registry of the idempotency stores of the tenant repositories.
+var Default = &Registry{}
+
+// Register adds the store of the end-user's database, config.DefaultTenant standing for the shared database.
+func (r *Registry) Register(endUser string, store Store) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stores == nil {
+		r.stores = make(map[string]Store)
+	}
+	r.stores[endUser] = store
+}
+
+// Store returns the store of the end-user's database, or the one of the shared database if the end-user has none.
+// It returns nil if neither is registered.
+func (r *Registry) Store(endUser string) Store {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if store, exists := r.stores[endUser]; exists {
+		return store
+	}
+	return r.stores[config.DefaultTenant]
+}
+
+// Purge removes the expired records of every store, a store failing to does not stop the others.
+func (r *Registry) Purge() {
+	r.mutex.RLock()
+	stores := make(map[string]Store, len(r.stores))
+	for endUser, store := range r.stores {
+		stores[endUser] = store
+	}
+	r.mutex.RUnlock()
+
+	for endUser, store := range stores {
+		removed, err := store.Purge()
+		if err != nil {
+			log.Warnf("Removing the expired idempotency keys of %s failed: %s", endUser, err)
+			continue
+		}
+		if removed > 0 {
+			log.Debugf("Removed %d expired idempotency keys of %s", removed, endUser)
+		}
+	}
+}
+
+// Purger removes the expired records of the stores of a Registry periodically.
+type Purger struct {
+	stores   *Registry
+	interval time.Duration
+}
+
+// NewPurger creates a Purger of the stores, running every interval, which must be positive.
+func NewPurger(stores *Registry, interval time.Duration) (*Purger, error) {
+	if interval <= 0 {
+		return nil, errors.Errorf("the idempotency key purge interval must be positive, not %s", interval)
+	}
+	return &Purger{stores: stores, interval: interval}, nil
+}
+
+// Run purges the stores every interval until the context is done.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p.stores.Purge()
+	}
+}
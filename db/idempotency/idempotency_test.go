@@ -0,0 +1,154 @@
+package idempotency
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/migrations"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/sqlite"
+)
+
+const (
+	ttl   = time.Hour
+	lease = time.Minute
+)
+
+// clock returns a time source and the function advancing it.
+func clock() (func() time.Time, func(time.Duration)) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	// the first request reserves the key
+	record, reserved, err := store.Reserve("alice", "k1", "h1")
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	assert.True(t, record.InProgress())
+
+	// a repeated request finds the request in progress, whatever its body
+	existing, reserved, err := store.Reserve("alice", "k1", "h2")
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, "h1", existing.RequestHash)
+	assert.True(t, existing.InProgress())
+
+	// the keys of different end-users do not collide
+	bobRecord, reserved, err := store.Reserve("bob", "k1", "h2")
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	// the response is replayed once completed
+	record.Status, record.ContentType, record.Body = 201, "application/json", []byte(`{"status":201}`)
+	require.NoError(t, store.Complete(record))
+	existing, reserved, err = store.Reserve("alice", "k1", "h1")
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, 201, existing.Status)
+	assert.Equal(t, "application/json", existing.ContentType)
+	assert.Equal(t, `{"status":201}`, string(existing.Body))
+
+	// a released key is reserved again
+	require.NoError(t, store.Release(bobRecord))
+	_, reserved, err = store.Reserve("bob", "k1", "h3")
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	// a request which did not complete within its lease loses its key, the completed ones keep theirs
+	lost, reserved, err := store.Reserve("alice", "k2", "h1")
+	require.NoError(t, err)
+	require.True(t, reserved)
+	advance(lease)
+	retried, reserved, err := store.Reserve("alice", "k2", "h1")
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	lost.Status = 201
+	assert.Equal(t, repository.ErrNotFound, store.Complete(lost))
+	require.NoError(t, store.Release(lost))
+	existing, reserved, err = store.Reserve("alice", "k2", "h1")
+	require.NoError(t, err)
+	assert.False(t, reserved, "the lost request does not release the key of its retry")
+	assert.True(t, existing.InProgress())
+	retried.Status = 201
+	require.NoError(t, store.Complete(retried))
+	existing, reserved, err = store.Reserve("alice", "k1", "h1")
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, 201, existing.Status)
+
+	// an expired key is reserved again
+	advance(ttl)
+	_, reserved, err = store.Reserve("alice", "k1", "h2")
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	// the expired records whose keys are not used again are purged
+	removed, err := store.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+	_, reserved, err = store.Reserve("alice", "k1", "h2")
+	require.NoError(t, err)
+	assert.False(t, reserved)
+
+	assert.Equal(t, repository.ErrNotFound, store.Complete(Record{EndUser: "carol", Key: "k1", Status: 201}))
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemory(ttl, lease).(*memoryStore)
+	now, advance := clock()
+	store.now = now
+
+	testStore(t, store, advance)
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	require.NoError(t, migrations.Migrate(db, repository.SQLiteDialect{}, "orders"))
+
+	store := For(&repository.OrderRepositorySQL{Database: db, OrdersTableName: "orders", Dialect: sqlite.Dialect{}}, ttl, lease).(*sqlStore)
+	now, advance := clock()
+	store.now = now
+
+	testStore(t, store, advance)
+}
+
+func TestRegistry(t *testing.T) {
+	registry := &Registry{}
+	assert.Nil(t, registry.Store("alice"))
+
+	shared, own := NewMemory(ttl, lease), NewMemory(ttl, lease)
+	registry.Register(config.DefaultTenant, shared)
+	registry.Register("alice", own)
+
+	assert.Equal(t, own, registry.Store("alice"))
+	assert.Equal(t, shared, registry.Store("bob"))
+
+	// every store is purged
+	now, advance := clock()
+	shared.(*memoryStore).now, own.(*memoryStore).now = now, now
+	_, _, err := shared.Reserve("bob", "k1", "h1")
+	require.NoError(t, err)
+	_, _, err = own.Reserve("alice", "k1", "h1")
+	require.NoError(t, err)
+	advance(lease)
+	registry.Purge()
+	assert.Empty(t, shared.(*memoryStore).records)
+	assert.Empty(t, own.(*memoryStore).records)
+}
+
+func TestNewPurger(t *testing.T) {
+	_, err := NewPurger(&Registry{}, 0)
+	assert.Error(t, err)
+	_, err = NewPurger(&Registry{}, time.Minute)
+	assert.NoError(t, err)
+}
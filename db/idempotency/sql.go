@@ -0,0 +1,146 @@
+package idempotency
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+const (
+	insertQuery = "INSERT INTO %s (end_user, idempotency_key, request_hash, status, content_type, response, expires_at) VALUES (%s)"
+	selectQuery = "SELECT request_hash, status, content_type, response, expires_at FROM %s WHERE end_user = %s AND idempotency_key = %s"
+	// completeQuery and releaseQuery change the record only while it is still the reservation of the request,
+	// which is told by its expiry
+	completeQuery = "UPDATE %s SET status = %s, content_type = %s, response = %s, expires_at = %s " +
+		"WHERE end_user = %s AND idempotency_key = %s AND status = 0 AND expires_at = %s"
+	releaseQuery   = "DELETE FROM %s WHERE end_user = %s AND idempotency_key = %s AND status = 0 AND expires_at = %s"
+	expireKeyQuery = "DELETE FROM %s WHERE end_user = %s AND idempotency_key = %s AND expires_at <= %s"
+	expireQuery    = "DELETE FROM %s WHERE expires_at <= %s"
+
+	// reserveAttempts bounds the attempts of Reserve when the record it collides with is released in between.
+	reserveAttempts = 3
+)
+
+// sqlStore is a Store kept in the `idempotency_keys` table, which is created by the migrations of every SQL database.
+type sqlStore struct {
+	db      repository.DBQuerier
+	dialect repository.Dialect
+	ttl     time.Duration
+	lease   time.Duration
+	now     func() time.Time
+}
+
+// NewSQL returns the Store kept in the database, whose queries are written in the SQL flavour of the dialect,
+// PostgreSQL if it is nil. Its records in progress expire after lease and completed records after ttl.
+func NewSQL(db repository.DBQuerier, dialect repository.Dialect, ttl, lease time.Duration) Store {
+	if dialect == nil {
+		dialect = repository.PostgresDialect{}
+	}
+	return &sqlStore{db: db, dialect: dialect, ttl: ttl, lease: lease, now: time.Now}
+}
+
+func (s *sqlStore) Reserve(endUser, key, requestHash string) (Record, bool, error) {
+	d := s.dialect
+	now := s.now()
+	// an expired record of the key is removed first, so that the key can be reserved again, including the reservation
+	// of a request which did not complete within its lease
+	expire := fmt.Sprintf(expireKeyQuery, repository.IdempotencyTable, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3))
+	if _, err := s.db.Exec(expire, endUser, key, now.UnixNano()); err != nil {
+		return Record{}, false, errors.Wrapf(s.translateError(err), "while removing expired idempotency key %s", key)
+	}
+
+	record := Record{EndUser: endUser, Key: key, RequestHash: requestHash, ExpiresAt: now.Add(s.lease)}
+	insert := fmt.Sprintf(insertQuery, repository.IdempotencyTable, placeholders(d, 7))
+	for attempt := 0; attempt < reserveAttempts; attempt++ {
+		_, err := s.db.Exec(insert, endUser, key, requestHash, 0, "", "", record.ExpiresAt.UnixNano())
+		if err == nil {
+			return record, true, nil
+		}
+		if err = s.translateError(err); err != repository.ErrDuplicateKey {
+			return Record{}, false, errors.Wrapf(err, "while reserving idempotency key %s", key)
+		}
+
+		existing, err := s.get(endUser, key)
+		if errors.Cause(err) == repository.ErrNotFound {
+			// the existing record was released meanwhile
+			continue
+		}
+		return existing, false, err
+	}
+	return Record{}, false, errors.Errorf("Reserving idempotency key %s failed %d times", key, reserveAttempts)
+}
+
+func (s *sqlStore) get(endUser, key string) (Record, error) {
+	d := s.dialect
+	record := Record{EndUser: endUser, Key: key}
+	var (
+		body      string
+		expiresAt int64
+	)
+	q := fmt.Sprintf(selectQuery, repository.IdempotencyTable, d.Placeholder(1), d.Placeholder(2))
+	rows, err := s.db.Query(q, endUser, key)
+	if err != nil {
+		return Record{}, errors.Wrapf(s.translateError(err), "while reading idempotency key %s", key)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Record{}, errors.Wrapf(s.translateError(err), "while reading idempotency key %s", key)
+		}
+		return Record{}, repository.ErrNotFound
+	}
+	if err := rows.Scan(&record.RequestHash, &record.Status, &record.ContentType, &body, &expiresAt); err != nil {
+		return Record{}, errors.Wrapf(err, "while reading idempotency key %s", key)
+	}
+	record.Body = []byte(body)
+	record.ExpiresAt = time.Unix(0, expiresAt)
+	return record, nil
+}
+
+func (s *sqlStore) Complete(record Record) error {
+	d := s.dialect
+	q := fmt.Sprintf(completeQuery, repository.IdempotencyTable, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3),
+		d.Placeholder(4), d.Placeholder(5), d.Placeholder(6), d.Placeholder(7))
+	result, err := s.db.Exec(q, record.Status, record.ContentType, string(record.Body), s.now().Add(s.ttl).UnixNano(),
+		record.EndUser, record.Key, record.ExpiresAt.UnixNano())
+	if err != nil {
+		return errors.Wrapf(s.translateError(err), "while saving the response of idempotency key %s", record.Key)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) Release(record Record) error {
+	d := s.dialect
+	q := fmt.Sprintf(releaseQuery, repository.IdempotencyTable, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3))
+	_, err := s.db.Exec(q, record.EndUser, record.Key, record.ExpiresAt.UnixNano())
+	return errors.Wrapf(s.translateError(err), "while releasing idempotency key %s", record.Key)
+}
+
+func (s *sqlStore) Purge() (int64, error) {
+	q := fmt.Sprintf(expireQuery, repository.IdempotencyTable, s.dialect.Placeholder(1))
+	result, err := s.db.Exec(q, s.now().UnixNano())
+	if err != nil {
+		return 0, errors.Wrap(s.translateError(err), "while removing expired idempotency keys")
+	}
+	removed, err := result.RowsAffected()
+	return removed, errors.Wrap(err, "while removing expired idempotency keys")
+}
+
+func (s *sqlStore) translateError(err error) error {
+	return repository.TranslateDialectError(s.dialect, err)
+}
+
+func placeholders(dialect repository.Dialect, n int) string {
+	params := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		params = append(params, dialect.Placeholder(i))
+	}
+	return strings.Join(params, ", ")
+}
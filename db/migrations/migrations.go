@@ -0,0 +1,345 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"hash/crc32"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// files holds the migrations of every dialect in `sql/<dialect name>/<version>_<name>.<up|down>.sql`.
+// Their statements are separated by a `;` at the end of a line, and `{table}` is replaced by the quoted
+// orders table while `{table_name}` is replaced by its plain name.
+//
+//go:embed sql
+var files embed.FS
+
+var fileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// DefaultLockTimeout is how long a Migrator waits for another replica to finish migrating the database.
+const DefaultLockTimeout = time.Minute
+
+// Migration is a versioned change of the schema and the statements reverting it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Status tells whether a migration was applied to the database, and when.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the migrations of its dialect to a database, recording them in the `schema_migrations` table.
+// It holds an advisory lock while migrating, so that replicas starting together do not race each other.
+type Migrator struct {
+	db          *sql.DB
+	dialect     repository.Dialect
+	migrations  []Migration
+	LockTimeout time.Duration
+}
+
+// New creates a Migrator of the orders table of the database, which is written in the SQL flavour of the dialect.
+func New(db *sql.DB, dialect repository.Dialect, table string) (*Migrator, error) {
+	table = repository.SanitizeSQLArg(table)
+	migrations, err := load(dialect, table)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations, LockTimeout: DefaultLockTimeout}, nil
+}
+
+// Migrate applies the pending migrations of the dialect to the orders table of the database.
+func Migrate(db *sql.DB, dialect repository.Dialect, table string) error {
+	migrator, err := New(db, dialect, table)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(migrator.Up(), "while migrating DB table")
+}
+
+// Up applies every migration which was not applied yet, in order of their version.
+func (m *Migrator) Up() error {
+	return m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, exists := applied[migration.Version]; exists {
+				continue
+			}
+			log.Infof("Applying migration %d %s.", migration.Version, migration.Name)
+			if err := m.run(ctx, conn, migration.Up, m.insertQuery(), migration.Version, migration.Name, time.Now().Unix()); err != nil {
+				return errors.Wrapf(err, "while applying migration %d %s", migration.Version, migration.Name)
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the latest applied migration. It does nothing if no migration is applied.
+func (m *Migrator) Down() error {
+	return m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, exists := applied[migration.Version]; !exists {
+				continue
+			}
+			log.Infof("Reverting migration %d %s.", migration.Version, migration.Name)
+			if err := m.run(ctx, conn, migration.Down, m.deleteQuery(), migration.Version); err != nil {
+				return errors.Wrapf(err, "while reverting migration %d %s", migration.Version, migration.Name)
+			}
+			return nil
+		}
+		return nil
+	})
+}
+
+// Status returns every migration of the dialect together with whether it was applied.
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			appliedAt, exists := applied[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: exists, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// locked runs fn on a single connection holding the advisory lock of the dialect, after ensuring `schema_migrations` exists.
+func (m *Migrator) locked(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	engine, exists := engines[m.dialect.Name()]
+	if !exists {
+		return errors.Errorf("no migrations for dialect '%s'", m.dialect.Name())
+	}
+
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "while connecting to migrate")
+	}
+	defer conn.Close()
+
+	lockCtx, cancel := context.WithTimeout(ctx, m.LockTimeout)
+	defer cancel()
+	if err := engine.lock(lockCtx, conn); err != nil {
+		return errors.Wrap(err, "while acquiring the migration lock")
+	}
+	defer func() {
+		if err := engine.unlock(ctx, conn); err != nil {
+			log.Errorf("Error releasing the migration lock: %s", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, engine.createTable); err != nil {
+		return errors.Wrapf(err, "while creating %s", repository.MigrationsTable)
+	}
+	return fn(ctx, conn)
+}
+
+// applied returns the time every applied migration was applied at, by version.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", repository.MigrationsTable))
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading %s", repository.MigrationsTable)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version, appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, errors.Wrapf(err, "while reading %s", repository.MigrationsTable)
+		}
+		applied[int(version)] = time.Unix(appliedAt, 0)
+	}
+	return applied, errors.Wrapf(rows.Err(), "while reading %s", repository.MigrationsTable)
+}
+
+// run executes the statements of a migration and records it with query in a single transaction.
+// MySQL commits implicitly after every DDL statement, so a failed migration may be left half applied there.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, statements []string, query string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		log.Debugf("Running migration statement: '%q'.", statement)
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) insertQuery() string {
+	return fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (%s, %s, %s)",
+		repository.MigrationsTable, m.dialect.Placeholder(1), m.dialect.Placeholder(2), m.dialect.Placeholder(3))
+}
+
+func (m *Migrator) deleteQuery() string {
+	return fmt.Sprintf("DELETE FROM %s WHERE version = %s", repository.MigrationsTable, m.dialect.Placeholder(1))
+}
+
+// load reads the embedded migrations of the dialect, ordered by version.
+func load(dialect repository.Dialect, table string) ([]Migration, error) {
+	dir := path.Join("sql", dialect.Name())
+	entries, err := files.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Errorf("no migrations for dialect '%s'", dialect.Name())
+	}
+
+	replacer := strings.NewReplacer("{table}", dialect.QuoteIdentifier(table), "{table_name}", table)
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, errors.Errorf("invalid migration file name '%s'", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		b, err := files.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "while reading migration '%s'", entry.Name())
+		}
+		statements := split(replacer.Replace(string(b)))
+		if match[3] == "up" {
+			migration.Up = statements
+		} else {
+			migration.Down = statements
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == nil || migration.Down == nil {
+			return nil, errors.Errorf("migration %d %s of dialect '%s' must have both an up and a down file", migration.Version, migration.Name, dialect.Name())
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// split returns the statements of a migration file, which end with a `;` at the end of a line.
+func split(content string) []string {
+	statements := make([]string, 0)
+	var statement []string
+	for _, line := range strings.Split(content, "\n") {
+		statement = append(statement, line)
+		trimmed := strings.TrimSpace(line)
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(strings.Join(statement, "\n")), ";"))
+			statement = nil
+		}
+	}
+	if rest := strings.TrimSpace(strings.Join(statement, "\n")); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// engine contains what differs between databases when managing `schema_migrations`.
+type engine struct {
+	createTable string
+	lock        func(ctx context.Context, conn *sql.Conn) error
+	unlock      func(ctx context.Context, conn *sql.Conn) error
+}
+
+// lockKey identifies the PostgreSQL advisory lock of the migrations.
+var lockKey = int64(crc32.ChecksumIEEE([]byte(repository.MigrationsTable)))
+
+var engines = map[string]engine{
+	"postgres": {
+		createTable: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)", repository.MigrationsTable),
+		lock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey)
+			return err
+		},
+	},
+	"sqlserver": {
+		createTable: fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s (version BIGINT PRIMARY KEY, name NVARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)",
+			repository.MigrationsTable, repository.MigrationsTable),
+		lock: func(ctx context.Context, conn *sql.Conn) error {
+			timeout := -1
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = int(time.Until(deadline) / time.Millisecond)
+			}
+			_, err := conn.ExecContext(ctx, fmt.Sprintf(`DECLARE @result INT;
+EXEC @result = sp_getapplock @Resource = '%s', @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = %d;
+IF @result < 0 THROW 50000, 'timed out waiting for the migration lock', 1;`, repository.MigrationsTable, timeout))
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, fmt.Sprintf("EXEC sp_releaseapplock @Resource = '%s', @LockOwner = 'Session'", repository.MigrationsTable))
+			return err
+		},
+	},
+	"mysql": {
+		createTable: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)", repository.MigrationsTable),
+		lock: func(ctx context.Context, conn *sql.Conn) error {
+			timeout := -1
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = int(time.Until(deadline) / time.Second)
+			}
+			var locked sql.NullInt64
+			if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", repository.MigrationsTable, timeout).Scan(&locked); err != nil {
+				return err
+			}
+			if locked.Int64 != 1 {
+				return errors.New("timed out waiting for the migration lock")
+			}
+			return nil
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", repository.MigrationsTable)
+			return err
+		},
+	},
+	// SQLite is embedded in a single process, which only opens one connection to it.
+	"sqlite": {
+		createTable: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)", repository.MigrationsTable),
+		lock:        func(context.Context, *sql.Conn) error { return nil },
+		unlock:      func(context.Context, *sql.Conn) error { return nil },
+	},
+}
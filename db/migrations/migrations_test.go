@@ -0,0 +1,211 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+func newMigrator(t *testing.T) (*Migrator, *sql.DB) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "orders.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := New(db, repository.SQLiteDialect{}, "orders")
+	require.NoError(t, err)
+	return migrator, db
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range []repository.Dialect{repository.PostgresDialect{}, repository.MSSQLDialect{}, repository.MySQLDialect{}, repository.SQLiteDialect{}} {
+		t.Run(dialect.Name(), func(t *testing.T) {
+			migrations, err := load(dialect, "orders")
+			require.NoError(t, err)
+
+			require.NotEmpty(t, migrations)
+			for i, migration := range migrations {
+				assert.Equal(t, i+1, migration.Version)
+				assert.NotEmpty(t, migration.Up)
+				assert.NotEmpty(t, migration.Down)
+			}
+			_, exists := engines[dialect.Name()]
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestMySQLMigrationsGuardAddedColumns(t *testing.T) {
+	migrations, err := load(repository.MySQLDialect{}, "orders")
+	require.NoError(t, err)
+
+	// MySQL has no ADD COLUMN IF NOT EXISTS, so the columns must be added by a statement chosen from information_schema
+	for _, migration := range migrations {
+		for _, statement := range migration.Up {
+			if strings.Contains(statement, "ADD COLUMN") || strings.Contains(statement, "CREATE INDEX") {
+				assert.Contains(t, statement, "SET @statement = IF(", "migration %d: %s", migration.Version, statement)
+			}
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	migrator, db := newMigrator(t)
+
+	// when
+	require.NoError(t, migrator.Up())
+	require.NoError(t, migrator.Up())
+
+	// then
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 9)
+	for _, status := range statuses {
+		assert.True(t, status.Applied)
+	}
+	_, err = db.Exec(`INSERT INTO "orders" (order_id, namespace, total) VALUES ('orderId1', 'N7', 10)`)
+	require.NoError(t, err)
+	var labels string
+	require.NoError(t, db.QueryRow(`SELECT labels FROM "orders"`).Scan(&labels))
+	assert.Equal(t, "{}", labels)
+	var version, modifiedAt int64
+	require.NoError(t, db.QueryRow(`SELECT version, modified_at FROM order_versions WHERE namespace = 'N7'`).Scan(&version, &modifiedAt))
+	assert.Equal(t, int64(1), version)
+	assert.NotZero(t, modifiedAt)
+
+	_, err = db.Exec(`INSERT INTO audit_log (occurred_at, operation, affected_rows) VALUES (1, 'DeleteOrders', 1)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM audit_log`)
+	assert.Error(t, err, "the audit log is append-only")
+	var leases int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM outbox_lease`).Scan(&leases))
+	assert.Equal(t, 1, leases)
+	var createdAt int64
+	require.NoError(t, db.QueryRow(`SELECT created_at FROM "orders"`).Scan(&createdAt))
+	assert.Zero(t, createdAt, "the repository writes the creation time")
+	var archived int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM orders_archive`).Scan(&archived))
+	assert.Zero(t, archived)
+	var sealed *string
+	require.NoError(t, db.QueryRow(`SELECT sealed FROM "orders"`).Scan(&sealed))
+	assert.Nil(t, sealed, "orders are not encrypted by default")
+	var keys int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM data_keys`).Scan(&keys))
+	assert.Zero(t, keys)
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[7].Applied)
+	assert.False(t, statuses[8].Applied)
+	_, err = db.Exec(`SELECT target_namespace FROM audit_log`)
+	assert.Error(t, err)
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[6].Applied)
+	assert.False(t, statuses[7].Applied)
+	_, err = db.Exec(`SELECT sealed FROM "orders"`)
+	assert.Error(t, err)
+	_, err = db.Exec(`SELECT sealed FROM orders_archive`)
+	assert.Error(t, err)
+	_, err = db.Exec(`SELECT id FROM data_keys`)
+	assert.Error(t, err)
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[5].Applied)
+	assert.False(t, statuses[6].Applied)
+	_, err = db.Exec(`SELECT created_at FROM "orders"`)
+	assert.Error(t, err)
+	_, err = db.Exec(`SELECT order_id FROM orders_archive`)
+	assert.Error(t, err)
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[4].Applied)
+	assert.False(t, statuses[5].Applied)
+	_, err = db.Exec(`SELECT version FROM order_versions`)
+	assert.Error(t, err)
+	_, err = db.Exec(`INSERT INTO "orders" (order_id, namespace, total) VALUES ('orderId2', 'N7', 10)`)
+	assert.NoError(t, err, "the triggers are dropped with the table")
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[3].Applied)
+	assert.False(t, statuses[4].Applied)
+	_, err = db.Exec(`SELECT idempotency_key FROM idempotency_keys`)
+	assert.Error(t, err)
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[2].Applied)
+	assert.False(t, statuses[3].Applied)
+	_, err = db.Exec(`SELECT id FROM outbox`)
+	assert.Error(t, err)
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[1].Applied)
+	assert.False(t, statuses[2].Applied)
+	_, err = db.Exec(`SELECT id FROM audit_log`)
+	assert.Error(t, err)
+
+	// when
+	require.NoError(t, migrator.Down())
+
+	// then
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+	_, err = db.Exec(`SELECT labels FROM "orders"`)
+	assert.Error(t, err)
+
+	// when
+	require.NoError(t, migrator.Down())
+	require.NoError(t, migrator.Down())
+
+	// then
+	_, err = db.Exec(`SELECT order_id FROM "orders"`)
+	assert.Error(t, err)
+}
+
+func TestSplit(t *testing.T) {
+	statements := split("-- comment\nALTER TABLE t ADD COLUMN c TEXT;\nUPDATE t\nSET c = 'a;b';\n")
+
+	assert.Equal(t, []string{"-- comment\nALTER TABLE t ADD COLUMN c TEXT", "UPDATE t\nSET c = 'a;b'"}, statements)
+}
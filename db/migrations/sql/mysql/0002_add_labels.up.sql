@@ -0,0 +1,9 @@
+-- MySQL cannot add a column only if it does not exist, so the statement is chosen from information_schema to allow
+-- migrating a table which already has the column.
+SET @statement = IF((SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = '{table_name}' AND column_name = 'labels') = 0, 'ALTER TABLE {table} ADD COLUMN labels JSON NULL', 'DO 0');
+PREPARE statement FROM @statement;
+EXECUTE statement;
+DEALLOCATE PREPARE statement;
+-- JSON columns cannot have a literal default before MySQL 8.0.13, so existing rows are filled before adding the constraint.
+UPDATE {table} SET labels = JSON_OBJECT() WHERE labels IS NULL;
+ALTER TABLE {table} MODIFY labels JSON NOT NULL;
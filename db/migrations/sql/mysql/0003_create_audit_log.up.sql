@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  occurred_at BIGINT NOT NULL,
+  end_user VARCHAR(255) NOT NULL DEFAULT '',
+  request_id VARCHAR(255) NOT NULL DEFAULT '',
+  source_ip VARCHAR(64) NOT NULL DEFAULT '',
+  operation VARCHAR(64) NOT NULL,
+  namespace VARCHAR(255) NOT NULL DEFAULT '',
+  order_id VARCHAR(255) NOT NULL DEFAULT '',
+  selector VARCHAR(1024) NOT NULL DEFAULT '',
+  affected_rows BIGINT NOT NULL,
+  failure VARCHAR(1024) NOT NULL DEFAULT '',
+  INDEX audit_log_occurred_at (occurred_at)
+);
+DROP TRIGGER IF EXISTS audit_log_no_update;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+DROP TRIGGER IF EXISTS audit_log_no_delete;
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  end_user VARCHAR(255) NOT NULL,
+  idempotency_key VARCHAR(255) NOT NULL,
+  request_hash VARCHAR(64) NOT NULL,
+  status INT NOT NULL DEFAULT 0,
+  content_type VARCHAR(255) NOT NULL DEFAULT '',
+  response TEXT NOT NULL,
+  expires_at BIGINT NOT NULL,
+  PRIMARY KEY (end_user, idempotency_key),
+  INDEX idempotency_keys_expires_at (expires_at)
+);
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS order_versions_insert;
+DROP TRIGGER IF EXISTS order_versions_update;
+DROP TRIGGER IF EXISTS order_versions_delete;
+DROP TABLE IF EXISTS order_versions;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS order_versions (
+  namespace VARCHAR(64) PRIMARY KEY,
+  version BIGINT NOT NULL,
+  modified_at BIGINT NOT NULL
+);
+INSERT IGNORE INTO order_versions (namespace, version, modified_at) SELECT DISTINCT namespace, 1, ROUND(UNIX_TIMESTAMP(NOW(6)) * 1000000000) FROM {table};
+DROP TRIGGER IF EXISTS order_versions_insert;
+CREATE TRIGGER order_versions_insert AFTER INSERT ON {table} FOR EACH ROW INSERT INTO order_versions (namespace, version, modified_at) VALUES (NEW.namespace, 1, ROUND(UNIX_TIMESTAMP(NOW(6)) * 1000000000)) ON DUPLICATE KEY UPDATE version = version + 1, modified_at = VALUES(modified_at);
+DROP TRIGGER IF EXISTS order_versions_update;
+CREATE TRIGGER order_versions_update AFTER UPDATE ON {table} FOR EACH ROW BEGIN INSERT INTO order_versions (namespace, version, modified_at) VALUES (OLD.namespace, 1, ROUND(UNIX_TIMESTAMP(NOW(6)) * 1000000000)) ON DUPLICATE KEY UPDATE version = version + 1, modified_at = VALUES(modified_at); INSERT INTO order_versions (namespace, version, modified_at) VALUES (NEW.namespace, 1, ROUND(UNIX_TIMESTAMP(NOW(6)) * 1000000000)) ON DUPLICATE KEY UPDATE version = version + 1, modified_at = VALUES(modified_at); END;
+DROP TRIGGER IF EXISTS order_versions_delete;
+CREATE TRIGGER order_versions_delete AFTER DELETE ON {table} FOR EACH ROW INSERT INTO order_versions (namespace, version, modified_at) VALUES (OLD.namespace, 1, ROUND(UNIX_TIMESTAMP(NOW(6)) * 1000000000)) ON DUPLICATE KEY UPDATE version = version + 1, modified_at = VALUES(modified_at);
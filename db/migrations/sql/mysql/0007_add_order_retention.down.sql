@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS orders_archive;
+DROP INDEX `{table_name}_created_at` ON {table};
+ALTER TABLE {table} DROP COLUMN created_at;
@@ -0,0 +1,21 @@
+-- MySQL cannot add a column or an index only if it does not exist, so the statements are chosen from
+-- information_schema to allow migrating a table which already has them.
+SET @statement = IF((SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = '{table_name}' AND column_name = 'created_at') = 0, 'ALTER TABLE {table} ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0', 'DO 0');
+PREPARE statement FROM @statement;
+EXECUTE statement;
+DEALLOCATE PREPARE statement;
+-- the age of the existing orders is unknown, they are aged from the migration on
+UPDATE {table} SET created_at = ROUND(UNIX_TIMESTAMP(NOW(6)) * 1000000000) WHERE created_at = 0;
+SET @statement = IF((SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = '{table_name}' AND index_name = '{table_name}_created_at') = 0, 'CREATE INDEX `{table_name}_created_at` ON {table} (namespace, created_at)', 'DO 0');
+PREPARE statement FROM @statement;
+EXECUTE statement;
+DEALLOCATE PREPARE statement;
+CREATE TABLE IF NOT EXISTS orders_archive (
+  order_id VARCHAR(64) NOT NULL,
+  namespace VARCHAR(64) NOT NULL,
+  total DECIMAL(8,2),
+  labels JSON NOT NULL,
+  created_at BIGINT NOT NULL,
+  archived_at BIGINT NOT NULL,
+  PRIMARY KEY (order_id, namespace, created_at)
+);
@@ -0,0 +1,16 @@
+-- MySQL cannot add a column only if it does not exist, so the statements are chosen from information_schema to allow
+-- migrating tables which already have the column.
+SET @statement = IF((SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = '{table_name}' AND column_name = 'sealed') = 0, 'ALTER TABLE {table} ADD COLUMN sealed TEXT NULL', 'DO 0');
+PREPARE statement FROM @statement;
+EXECUTE statement;
+DEALLOCATE PREPARE statement;
+SET @statement = IF((SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'orders_archive' AND column_name = 'sealed') = 0, 'ALTER TABLE orders_archive ADD COLUMN sealed TEXT NULL', 'DO 0');
+PREPARE statement FROM @statement;
+EXECUTE statement;
+DEALLOCATE PREPARE statement;
+CREATE TABLE IF NOT EXISTS data_keys (
+  id VARCHAR(64) PRIMARY KEY,
+  master_key_id VARCHAR(64) NOT NULL,
+  wrapped_key TEXT NOT NULL,
+  created_at BIGINT NOT NULL
+);
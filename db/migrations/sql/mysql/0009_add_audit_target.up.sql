@@ -0,0 +1,6 @@
+-- MySQL cannot add a column only if it does not exist, so the statement is chosen from information_schema to allow
+-- migrating tables which already have the column.
+SET @statement = IF((SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'audit_log' AND column_name = 'target_namespace') = 0, 'ALTER TABLE audit_log ADD COLUMN target_namespace VARCHAR(255) NOT NULL DEFAULT ''''', 'DO 0');
+PREPARE statement FROM @statement;
+EXECUTE statement;
+DEALLOCATE PREPARE statement;
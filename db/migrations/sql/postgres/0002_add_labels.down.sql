@@ -0,0 +1 @@
+ALTER TABLE {table} DROP COLUMN IF EXISTS labels;
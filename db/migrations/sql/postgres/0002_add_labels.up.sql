@@ -0,0 +1 @@
+ALTER TABLE {table} ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  occurred_at BIGINT NOT NULL,
+  end_user VARCHAR(255) NOT NULL DEFAULT '',
+  request_id VARCHAR(255) NOT NULL DEFAULT '',
+  source_ip VARCHAR(64) NOT NULL DEFAULT '',
+  operation VARCHAR(64) NOT NULL,
+  namespace VARCHAR(255) NOT NULL DEFAULT '',
+  order_id VARCHAR(255) NOT NULL DEFAULT '',
+  selector VARCHAR(1024) NOT NULL DEFAULT '',
+  affected_rows BIGINT NOT NULL,
+  failure VARCHAR(1024) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_occurred_at ON audit_log (occurred_at);
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'audit_log is append-only'; END $$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
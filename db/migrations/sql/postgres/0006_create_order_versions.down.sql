@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS order_versions_touch ON {table};
+DROP FUNCTION IF EXISTS order_versions_touch();
+DROP TABLE IF EXISTS order_versions;
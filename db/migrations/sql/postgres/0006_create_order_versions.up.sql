@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS order_versions (
+  namespace VARCHAR(64) PRIMARY KEY,
+  version BIGINT NOT NULL,
+  modified_at BIGINT NOT NULL
+);
+INSERT INTO order_versions (namespace, version, modified_at) SELECT DISTINCT namespace, 1, (EXTRACT(EPOCH FROM now()) * 1000000000)::BIGINT FROM {table} ON CONFLICT DO NOTHING;
+CREATE OR REPLACE FUNCTION order_versions_touch() RETURNS trigger AS $$ BEGIN IF TG_OP <> 'INSERT' THEN INSERT INTO order_versions (namespace, version, modified_at) VALUES (OLD.namespace, 1, (EXTRACT(EPOCH FROM now()) * 1000000000)::BIGINT) ON CONFLICT (namespace) DO UPDATE SET version = order_versions.version + 1, modified_at = EXCLUDED.modified_at; END IF; IF TG_OP <> 'DELETE' THEN INSERT INTO order_versions (namespace, version, modified_at) VALUES (NEW.namespace, 1, (EXTRACT(EPOCH FROM now()) * 1000000000)::BIGINT) ON CONFLICT (namespace) DO UPDATE SET version = order_versions.version + 1, modified_at = EXCLUDED.modified_at; END IF; RETURN NULL; END $$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS order_versions_touch ON {table};
+CREATE TRIGGER order_versions_touch AFTER INSERT OR UPDATE OR DELETE ON {table} FOR EACH ROW EXECUTE PROCEDURE order_versions_touch();
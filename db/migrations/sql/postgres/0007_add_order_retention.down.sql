@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS orders_archive;
+DROP INDEX IF EXISTS "{table_name}_created_at";
+ALTER TABLE {table} DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1 @@
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS target_namespace VARCHAR(255) NOT NULL DEFAULT '';
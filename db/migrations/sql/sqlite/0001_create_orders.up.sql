@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS {table} (
+  order_id VARCHAR(64) NOT NULL,
+  namespace VARCHAR(64) NOT NULL,
+  total DECIMAL(8,2),
+  PRIMARY KEY (order_id, namespace)
+);
@@ -0,0 +1 @@
+ALTER TABLE {table} ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  occurred_at BIGINT NOT NULL,
+  end_user VARCHAR(255) NOT NULL DEFAULT '',
+  request_id VARCHAR(255) NOT NULL DEFAULT '',
+  source_ip VARCHAR(64) NOT NULL DEFAULT '',
+  operation VARCHAR(64) NOT NULL,
+  namespace VARCHAR(255) NOT NULL DEFAULT '',
+  order_id VARCHAR(255) NOT NULL DEFAULT '',
+  selector VARCHAR(1024) NOT NULL DEFAULT '',
+  affected_rows BIGINT NOT NULL,
+  failure VARCHAR(1024) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_occurred_at ON audit_log (occurred_at);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS outbox (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  namespace VARCHAR(64) NOT NULL,
+  event_type VARCHAR(64) NOT NULL,
+  payload TEXT NOT NULL,
+  created_at BIGINT NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at BIGINT NOT NULL DEFAULT 0,
+  last_error VARCHAR(1024) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS outbox_namespace ON outbox (namespace, id);
+CREATE TABLE IF NOT EXISTS outbox_lease (
+  id INT PRIMARY KEY,
+  owner VARCHAR(255) NOT NULL,
+  expires_at BIGINT NOT NULL
+);
+INSERT OR IGNORE INTO outbox_lease (id, owner, expires_at) VALUES (1, '', 0);
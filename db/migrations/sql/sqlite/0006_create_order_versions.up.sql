@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS order_versions (
+  namespace VARCHAR(64) PRIMARY KEY,
+  version BIGINT NOT NULL,
+  modified_at BIGINT NOT NULL
+);
+INSERT OR IGNORE INTO order_versions (namespace, version, modified_at) SELECT DISTINCT namespace, 1, CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000 FROM {table};
+CREATE TRIGGER IF NOT EXISTS order_versions_insert AFTER INSERT ON {table} BEGIN INSERT INTO order_versions (namespace, version, modified_at) VALUES (NEW.namespace, 1, CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000) ON CONFLICT (namespace) DO UPDATE SET version = version + 1, modified_at = excluded.modified_at; END;
+CREATE TRIGGER IF NOT EXISTS order_versions_update AFTER UPDATE ON {table} BEGIN INSERT INTO order_versions (namespace, version, modified_at) VALUES (OLD.namespace, 1, CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000) ON CONFLICT (namespace) DO UPDATE SET version = version + 1, modified_at = excluded.modified_at; INSERT INTO order_versions (namespace, version, modified_at) VALUES (NEW.namespace, 1, CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000) ON CONFLICT (namespace) DO UPDATE SET version = version + 1, modified_at = excluded.modified_at; END;
+CREATE TRIGGER IF NOT EXISTS order_versions_delete AFTER DELETE ON {table} BEGIN INSERT INTO order_versions (namespace, version, modified_at) VALUES (OLD.namespace, 1, CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000) ON CONFLICT (namespace) DO UPDATE SET version = version + 1, modified_at = excluded.modified_at; END;
@@ -0,0 +1,13 @@
+ALTER TABLE {table} ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0;
+-- the age of the existing orders is unknown, they are aged from the migration on
+UPDATE {table} SET created_at = CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER) * 1000000 WHERE created_at = 0;
+CREATE INDEX IF NOT EXISTS "{table_name}_created_at" ON {table} (namespace, created_at);
+CREATE TABLE IF NOT EXISTS orders_archive (
+  order_id VARCHAR(64) NOT NULL,
+  namespace VARCHAR(64) NOT NULL,
+  total DECIMAL(8,2),
+  labels TEXT NOT NULL DEFAULT '{}',
+  created_at BIGINT NOT NULL,
+  archived_at BIGINT NOT NULL,
+  PRIMARY KEY (order_id, namespace, created_at)
+);
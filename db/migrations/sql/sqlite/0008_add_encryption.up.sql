@@ -0,0 +1,8 @@
+ALTER TABLE {table} ADD COLUMN sealed TEXT NULL;
+ALTER TABLE orders_archive ADD COLUMN sealed TEXT NULL;
+CREATE TABLE IF NOT EXISTS data_keys (
+  id VARCHAR(64) PRIMARY KEY,
+  master_key_id VARCHAR(64) NOT NULL,
+  wrapped_key TEXT NOT NULL,
+  created_at BIGINT NOT NULL
+);
@@ -0,0 +1,7 @@
+IF OBJECT_ID(N'{table_name}', N'U') IS NULL
+CREATE TABLE {table} (
+  order_id NVARCHAR(64) NOT NULL,
+  namespace NVARCHAR(64) NOT NULL,
+  total DECIMAL(8,2),
+  PRIMARY KEY (order_id, namespace)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE {table} DROP CONSTRAINT [DF_{table_name}_labels];
+ALTER TABLE {table} DROP COLUMN labels;
@@ -0,0 +1,2 @@
+IF COL_LENGTH(N'{table_name}', 'labels') IS NULL
+ALTER TABLE {table} ADD labels NVARCHAR(MAX) NOT NULL CONSTRAINT [DF_{table_name}_labels] DEFAULT '{}';
@@ -0,0 +1,17 @@
+IF OBJECT_ID(N'audit_log', N'U') IS NULL
+CREATE TABLE audit_log (
+  id BIGINT IDENTITY PRIMARY KEY,
+  occurred_at BIGINT NOT NULL,
+  end_user NVARCHAR(255) NOT NULL DEFAULT '',
+  request_id NVARCHAR(255) NOT NULL DEFAULT '',
+  source_ip NVARCHAR(64) NOT NULL DEFAULT '',
+  operation NVARCHAR(64) NOT NULL,
+  namespace NVARCHAR(255) NOT NULL DEFAULT '',
+  order_id NVARCHAR(255) NOT NULL DEFAULT '',
+  selector NVARCHAR(1024) NOT NULL DEFAULT '',
+  affected_rows BIGINT NOT NULL,
+  failure NVARCHAR(1024) NOT NULL DEFAULT '',
+  INDEX audit_log_occurred_at (occurred_at)
+);
+IF OBJECT_ID(N'audit_log_append_only', N'TR') IS NULL
+EXEC('CREATE TRIGGER audit_log_append_only ON audit_log INSTEAD OF UPDATE, DELETE AS THROW 50000, ''audit_log is append-only'', 1');
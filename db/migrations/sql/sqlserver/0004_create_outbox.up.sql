@@ -0,0 +1,20 @@
+IF OBJECT_ID(N'outbox', N'U') IS NULL
+CREATE TABLE outbox (
+  id BIGINT IDENTITY PRIMARY KEY,
+  namespace NVARCHAR(64) NOT NULL,
+  event_type NVARCHAR(64) NOT NULL,
+  payload NVARCHAR(MAX) NOT NULL,
+  created_at BIGINT NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at BIGINT NOT NULL DEFAULT 0,
+  last_error NVARCHAR(1024) NOT NULL DEFAULT '',
+  INDEX outbox_namespace (namespace, id)
+);
+IF OBJECT_ID(N'outbox_lease', N'U') IS NULL
+CREATE TABLE outbox_lease (
+  id INT PRIMARY KEY,
+  owner NVARCHAR(255) NOT NULL,
+  expires_at BIGINT NOT NULL
+);
+IF NOT EXISTS (SELECT 1 FROM outbox_lease WHERE id = 1)
+INSERT INTO outbox_lease (id, owner, expires_at) VALUES (1, '', 0);
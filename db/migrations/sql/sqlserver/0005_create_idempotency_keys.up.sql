@@ -0,0 +1,12 @@
+IF OBJECT_ID(N'idempotency_keys', N'U') IS NULL
+CREATE TABLE idempotency_keys (
+  end_user NVARCHAR(255) NOT NULL,
+  idempotency_key NVARCHAR(255) NOT NULL,
+  request_hash NVARCHAR(64) NOT NULL,
+  status INT NOT NULL DEFAULT 0,
+  content_type NVARCHAR(255) NOT NULL DEFAULT '',
+  response NVARCHAR(MAX) NOT NULL DEFAULT '',
+  expires_at BIGINT NOT NULL,
+  PRIMARY KEY (end_user, idempotency_key),
+  INDEX idempotency_keys_expires_at (expires_at)
+);
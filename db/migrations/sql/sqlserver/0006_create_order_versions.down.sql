@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS order_versions_touch;
+DROP TABLE IF EXISTS order_versions;
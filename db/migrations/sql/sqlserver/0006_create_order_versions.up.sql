@@ -0,0 +1,9 @@
+IF OBJECT_ID(N'order_versions', N'U') IS NULL
+CREATE TABLE order_versions (
+  namespace NVARCHAR(64) PRIMARY KEY,
+  version BIGINT NOT NULL,
+  modified_at BIGINT NOT NULL
+);
+INSERT INTO order_versions (namespace, version, modified_at) SELECT DISTINCT namespace, 1, DATEDIFF_BIG(MICROSECOND, '1970-01-01', SYSUTCDATETIME()) * 1000 FROM {table} WHERE namespace NOT IN (SELECT namespace FROM order_versions);
+IF OBJECT_ID(N'order_versions_touch', N'TR') IS NULL
+EXEC('CREATE TRIGGER order_versions_touch ON {table} AFTER INSERT, UPDATE, DELETE AS SET NOCOUNT ON; MERGE INTO order_versions AS target USING (SELECT namespace, COUNT(*) AS changes FROM (SELECT namespace FROM inserted UNION ALL SELECT namespace FROM deleted) AS changed GROUP BY namespace) AS source ON target.namespace = source.namespace WHEN MATCHED THEN UPDATE SET version = target.version + source.changes, modified_at = DATEDIFF_BIG(MICROSECOND, ''1970-01-01'', SYSUTCDATETIME()) * 1000 WHEN NOT MATCHED THEN INSERT (namespace, version, modified_at) VALUES (source.namespace, source.changes, DATEDIFF_BIG(MICROSECOND, ''1970-01-01'', SYSUTCDATETIME()) * 1000);');
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS orders_archive;
+DROP INDEX IF EXISTS [IX_{table_name}_created_at] ON {table};
+ALTER TABLE {table} DROP CONSTRAINT [DF_{table_name}_created_at];
+ALTER TABLE {table} DROP COLUMN created_at;
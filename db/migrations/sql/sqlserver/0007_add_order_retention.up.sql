@@ -0,0 +1,16 @@
+IF COL_LENGTH(N'{table_name}', 'created_at') IS NULL
+ALTER TABLE {table} ADD created_at BIGINT NOT NULL CONSTRAINT [DF_{table_name}_created_at] DEFAULT 0;
+-- the age of the existing orders is unknown, they are aged from the migration on
+UPDATE {table} SET created_at = DATEDIFF_BIG(MICROSECOND, '1970-01-01', SYSUTCDATETIME()) * 1000 WHERE created_at = 0;
+IF INDEXPROPERTY(OBJECT_ID(N'{table_name}'), 'IX_{table_name}_created_at', 'IndexID') IS NULL
+CREATE INDEX [IX_{table_name}_created_at] ON {table} (namespace, created_at);
+IF OBJECT_ID(N'orders_archive', N'U') IS NULL
+CREATE TABLE orders_archive (
+  order_id NVARCHAR(64) NOT NULL,
+  namespace NVARCHAR(64) NOT NULL,
+  total DECIMAL(8,2),
+  labels NVARCHAR(MAX) NOT NULL DEFAULT '{}',
+  created_at BIGINT NOT NULL,
+  archived_at BIGINT NOT NULL,
+  PRIMARY KEY (order_id, namespace, created_at)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS data_keys;
+ALTER TABLE orders_archive DROP COLUMN sealed;
+ALTER TABLE {table} DROP COLUMN sealed;
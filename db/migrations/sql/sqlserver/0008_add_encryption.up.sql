@@ -0,0 +1,11 @@
+IF COL_LENGTH(N'{table_name}', 'sealed') IS NULL
+ALTER TABLE {table} ADD sealed NVARCHAR(MAX) NULL;
+IF COL_LENGTH(N'orders_archive', 'sealed') IS NULL
+ALTER TABLE orders_archive ADD sealed NVARCHAR(MAX) NULL;
+IF OBJECT_ID(N'data_keys', N'U') IS NULL
+CREATE TABLE data_keys (
+  id NVARCHAR(64) PRIMARY KEY,
+  master_key_id NVARCHAR(64) NOT NULL,
+  wrapped_key NVARCHAR(MAX) NOT NULL,
+  created_at BIGINT NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE audit_log DROP CONSTRAINT [DF_audit_log_target_namespace];
+ALTER TABLE audit_log DROP COLUMN target_namespace;
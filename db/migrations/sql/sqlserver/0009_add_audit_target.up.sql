@@ -0,0 +1,2 @@
+IF COL_LENGTH(N'audit_log', 'target_namespace') IS NULL
+ALTER TABLE audit_log ADD target_namespace NVARCHAR(255) NOT NULL CONSTRAINT [DF_audit_log_target_namespace] DEFAULT '';
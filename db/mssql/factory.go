@@ -0,0 +1,68 @@
+package mssql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/breaker"
+	"github.com/yemramirezca/http-db-service/db/connection"
+	"github.com/yemramirezca/http-db-service/db/migrations"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retry"
+)
+
+// Mssql creates OrderRepositories backed by a Microsoft SQL Server database.
+type Mssql struct {
+	DBCfg config.Config
+}
+
+// DBConnectionString returns the `sqlserver://` URL of the configured database.
+func (db *Mssql) DBConnectionString() string {
+	query := url.Values{}
+	query.Add("database", db.DBCfg.Name)
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(db.DBCfg.User, db.DBCfg.Pass),
+		Host:     fmt.Sprintf("%s:%d", db.DBCfg.Host, db.DBCfg.Port),
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+func (ds *Mssql) NewOrderRepositoryDb() (repository.OrderRepository, error) {
+	var (
+		database repository.DBQuerier
+		err      error
+	)
+
+	if database, err = ds.InitDb(); err != nil {
+		return nil, errors.Wrap(err, "Error loading db configuration.")
+	}
+	return &repository.OrderRepositorySQL{Database: breaker.Wrap(ds.name(), database, repository.MSSQLDialect{}, ds.DBCfg), OrdersTableName: ds.DBCfg.DbOrdersTableName, Dialect: repository.MSSQLDialect{}, Retry: retry.ForQueries(ds.DBCfg), Outbox: ds.DBCfg.OutboxSink != ""}, nil
+}
+
+// InitDb connects to the database and migrates the orders table to the latest schema.
+func (ds *Mssql) InitDb() (*sql.DB, error) {
+	db, err := ds.OpenDb()
+	if err != nil {
+		return nil, err
+	}
+	if err := migrations.Migrate(db, repository.MSSQLDialect{}, ds.DBCfg.DbOrdersTableName); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenDb connects to the database without migrating it.
+func (ds *Mssql) OpenDb() (*sql.DB, error) {
+	return connection.Open(ds.name(), repository.MSSQLDialect{}.Name(), ds.DBConnectionString(), ds.DBCfg)
+}
+
+func (ds *Mssql) name() string {
+	return fmt.Sprintf("%s %s@%s", config.SQLServerDriverName, ds.DBCfg.Name, ds.DBCfg.Host)
+}
@@ -0,0 +1,51 @@
+package mysql
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// MySQL server error numbers recognised by Dialect.
+const (
+	DuplicateEntry       = 1062
+	RowIsReferenced      = 1451
+	NoReferencedRow      = 1452
+	BadNullError         = 1048
+	CheckConstraintError = 3819
+	LockDeadlock         = 1213
+	LockWaitTimeout      = 1205
+	TooManyConnections   = 1040
+	ServerShutdown       = 1053
+)
+
+// Dialect is the MySQL dialect which, on top of repository.MySQLDialect, recognises the errors of the MySQL driver.
+type Dialect struct {
+	repository.MySQLDialect
+}
+
+// TranslateError maps MySQL server errors to repository errors, a duplicate entry (1062) becoming ErrDuplicateKey.
+func (Dialect) TranslateError(err error) error {
+	if errors.Cause(err) == mysql.ErrInvalidConn {
+		return errors.Wrap(repository.ErrUnavailable, err.Error())
+	}
+	mysqlErr, ok := errors.Cause(err).(*mysql.MySQLError)
+	if !ok {
+		return err
+	}
+
+	switch mysqlErr.Number {
+	case DuplicateEntry:
+		return repository.ErrDuplicateKey
+	case RowIsReferenced, NoReferencedRow, BadNullError, CheckConstraintError:
+		return errors.Wrap(repository.ErrConstraintViolation, err.Error())
+	case LockDeadlock:
+		return errors.Wrap(repository.ErrSerializationFailure, err.Error())
+	case LockWaitTimeout:
+		return errors.Wrap(repository.ErrTimeout, err.Error())
+	case TooManyConnections, ServerShutdown:
+		return errors.Wrap(repository.ErrUnavailable, err.Error())
+	default:
+		return err
+	}
+}
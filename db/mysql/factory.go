@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/breaker"
+	"github.com/yemramirezca/http-db-service/db/connection"
+	"github.com/yemramirezca/http-db-service/db/migrations"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retry"
+)
+
+// MySQL creates OrderRepositories backed by a MySQL or MariaDB database.
+type MySQL struct {
+	DBCfg config.Config
+}
+
+// DBConnectionString returns the go-sql-driver DSN of the configured database.
+func (db *MySQL) DBConnectionString() string {
+	cfg := mysql.NewConfig()
+	cfg.User = db.DBCfg.User
+	cfg.Passwd = db.DBCfg.Pass
+	cfg.Net = "tcp"
+	cfg.Addr = fmt.Sprintf("%s:%d", db.DBCfg.Host, db.DBCfg.Port)
+	cfg.DBName = db.DBCfg.Name
+	return cfg.FormatDSN()
+}
+
+func (ds *MySQL) NewOrderRepositoryDb() (repository.OrderRepository, error) {
+	var (
+		database repository.DBQuerier
+		err      error
+	)
+
+	if database, err = ds.InitDb(); err != nil {
+		return nil, errors.Wrap(err, "Error loading db configuration.")
+	}
+	return &repository.OrderRepositorySQL{Database: breaker.Wrap(ds.name(), database, Dialect{}, ds.DBCfg), OrdersTableName: ds.DBCfg.DbOrdersTableName, Dialect: Dialect{}, Retry: retry.ForQueries(ds.DBCfg), Outbox: ds.DBCfg.OutboxSink != ""}, nil
+}
+
+// InitDb connects to the database and migrates the orders table to the latest schema.
+func (ds *MySQL) InitDb() (*sql.DB, error) {
+	db, err := ds.OpenDb()
+	if err != nil {
+		return nil, err
+	}
+	if err := migrations.Migrate(db, Dialect{}, ds.DBCfg.DbOrdersTableName); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenDb connects to the database without migrating it.
+func (ds *MySQL) OpenDb() (*sql.DB, error) {
+	return connection.Open(ds.name(), config.MySQLDriverName, ds.DBConnectionString(), ds.DBCfg)
+}
+
+func (ds *MySQL) name() string {
+	return fmt.Sprintf("%s %s@%s", config.MySQLDriverName, ds.DBCfg.Name, ds.DBCfg.Host)
+}
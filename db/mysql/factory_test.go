@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+var _ repository.Database = &MySQL{}
+
+func TestDBConnectionString(t *testing.T) {
+	db := MySQL{config.Config{Name: "orderservice", Host: "db.local", Port: 3306, User: "test", Pass: "secret"}}
+
+	assert.Equal(t, "test:secret@tcp(db.local:3306)/orderservice", db.DBConnectionString())
+}
+
+func TestDialectTranslateError(t *testing.T) {
+	dialect := Dialect{}
+
+	assert.Equal(t, repository.ErrDuplicateKey, dialect.TranslateError(&mysql.MySQLError{Number: DuplicateEntry}))
+	assert.Equal(t, repository.ErrConstraintViolation, pkgerrors.Cause(dialect.TranslateError(&mysql.MySQLError{Number: NoReferencedRow})))
+	assert.Equal(t, repository.ErrSerializationFailure, pkgerrors.Cause(dialect.TranslateError(&mysql.MySQLError{Number: LockDeadlock})))
+	assert.Equal(t, repository.ErrUnavailable, pkgerrors.Cause(dialect.TranslateError(mysql.ErrInvalidConn)))
+
+	other := errors.New("unexpected error")
+	assert.Equal(t, other, dialect.TranslateError(other))
+}
+
+func TestDuplicateEntryOnInsert(t *testing.T) {
+	databaseMock := &duplicateQuerier{}
+	repo := repository.OrderRepositorySQL{Database: databaseMock, OrdersTableName: "orders", Dialect: Dialect{}}
+
+	err := repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10})
+
+	assert.Equal(t, repository.ErrDuplicateKey, err)
+	assert.Equal(t, "INSERT INTO `orders` (order_id, namespace, total, labels, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?)", databaseMock.query)
+}
+
+// duplicateQuerier fails every statement the way MySQL reports a duplicate primary key.
+type duplicateQuerier struct {
+	query string
+}
+
+func (q *duplicateQuerier) Exec(query string, args ...interface{}) (sql.Result, error) {
+	q.query = query
+	return nil, &mysql.MySQLError{Number: DuplicateEntry, Message: "Duplicate entry 'orderId1-N7' for key 'PRIMARY'"}
+}
+
+func (q *duplicateQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	q.query = query
+	return nil, &mysql.MySQLError{Number: DuplicateEntry}
+}
+
+func (q *duplicateQuerier) Close() error {
+	return nil
+}
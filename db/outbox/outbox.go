@@ -0,0 +1,85 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Headers describing the event delivered by HTTPSink, whose body is the payload of the event.
+const (
+	// EventIDHeader identifies the event, so that the sink can recognise an event delivered more than once.
+	EventIDHeader        = "X-Event-Id"
+	EventTypeHeader      = "X-Event-Type"
+	EventNamespaceHeader = "X-Event-Namespace"
+	EventTimeHeader      = "X-Event-Time"
+)
+
+// Event is an entry of the outbox, written in the same transaction as the change it reports.
+type Event struct {
+	// ID identifies the event within its database, events of the same namespace are delivered in the order of their ID.
+	ID        int64
+	Namespace string
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+	// Attempts counts the failed deliveries of the event, which is not delivered again before NextAttempt.
+	Attempts    int
+	NextAttempt time.Time
+}
+
+// Sink receives the events of the outbox. An event is removed from the outbox only once Deliver succeeded,
+// so the same event may be delivered again if the relay stops in between.
+type Sink interface {
+	Deliver(ctx context.Context, source string, event Event) error
+}
+
+// SinkFunc is a function used as Sink.
+type SinkFunc func(ctx context.Context, source string, event Event) error
+
+func (f SinkFunc) Deliver(ctx context.Context, source string, event Event) error {
+	return f(ctx, source, event)
+}
+
+// HTTPSink posts the payload of the events to URL, any status but 2xx being a failed delivery.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPSink returns a Sink posting the events to url, giving up on a delivery after timeout.
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+// Deliver posts the event, identified by the source database and its ID in the EventIDHeader.
+func (s *HTTPSink) Deliver(ctx context.Context, source string, event Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(event.Payload))
+	if err != nil {
+		return errors.Wrap(err, "while creating event request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, fmt.Sprintf("%s/%d", source, event.ID))
+	req.Header.Set(EventTypeHeader, event.Type)
+	req.Header.Set(EventNamespaceHeader, event.Namespace)
+	req.Header.Set(EventTimeHeader, event.CreatedAt.UTC().Format(time.RFC3339Nano))
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "while delivering event %d", event.ID)
+	}
+	defer res.Body.Close()
+	// the body is drained so that the connection can be reused
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Errorf("Delivering event %d failed with status %d", event.ID, res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package outbox
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSinkPostsThePayload(t *testing.T) {
+	// given
+	var received *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		payload, _ := ioutil.ReadAll(r.Body)
+		body = string(payload)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	event := Event{ID: 7, Namespace: "N7", Type: "order.created", Payload: []byte(`{"orderCode":"o1"}`),
+		CreatedAt: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)}
+
+	// when
+	err := NewHTTPSink(server.URL, time.Second).Deliver(context.Background(), "default", event)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPost, received.Method)
+	assert.Equal(t, `{"orderCode":"o1"}`, body)
+	assert.Equal(t, "default/7", received.Header.Get(EventIDHeader))
+	assert.Equal(t, "order.created", received.Header.Get(EventTypeHeader))
+	assert.Equal(t, "N7", received.Header.Get(EventNamespaceHeader))
+	assert.Equal(t, "2020-01-01T00:00:00Z", received.Header.Get(EventTimeHeader))
+}
+
+func TestHTTPSinkFailsWithoutSuccessStatus(t *testing.T) {
+	// given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// when
+	err := NewHTTPSink(server.URL, time.Second).Deliver(context.Background(), "default", Event{ID: 7, Payload: []byte("{}")})
+
+	// then
+	assert.Error(t, err)
+}
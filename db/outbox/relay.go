@@ -0,0 +1,214 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retry"
+)
+
+const (
+	// pendingQuery selects the events which are due, leaving out the ones queued behind an event waiting to be retried,
+	// so that the events of a namespace are never delivered out of order.
+	pendingQuery = "SELECT id, namespace, event_type, payload, created_at, attempts, next_attempt_at FROM %[1]s o " +
+		"WHERE o.next_attempt_at <= %[2]s AND NOT EXISTS " +
+		"(SELECT 1 FROM %[1]s p WHERE p.namespace = o.namespace AND p.id < o.id AND p.next_attempt_at > %[3]s) ORDER BY o.id"
+	deleteQuery     = "DELETE FROM %s WHERE id = %s"
+	rescheduleQuery = "UPDATE %s SET attempts = %s, next_attempt_at = %s, last_error = %s WHERE id = %s"
+	// leaseQuery extends the lease of its owner, or takes over an expired one.
+	leaseQuery = "UPDATE %s SET owner = %s, expires_at = %s WHERE id = 1 AND (owner = %s OR expires_at < %s)"
+
+	// leaseFactor is how many delivery timeouts the lease lasts.
+	leaseFactor = 3
+	// maxErrorLength is the size of the last_error column.
+	maxErrorLength = 1024
+)
+
+// Settings tune how a Relay delivers the events of the outbox.
+type Settings struct {
+	// PollInterval is waited between two reads of the outbox.
+	PollInterval time.Duration
+	// BatchSize limits the events read at once.
+	BatchSize int
+	// Lease is how long the relay remains the only one delivering the events of the database without renewing it.
+	Lease time.Duration
+	// Retry schedules the next attempts of the failed deliveries.
+	Retry retry.Policy
+}
+
+// SettingsFor returns the Settings configured in cfg.
+func SettingsFor(cfg config.Config) Settings {
+	return Settings{
+		PollInterval: cfg.OutboxPollInterval,
+		BatchSize:    cfg.OutboxBatchSize,
+		Lease:        leaseFactor * cfg.OutboxTimeout,
+		Retry:        retry.ForOutbox(cfg),
+	}
+}
+
+// Relay delivers the events of the outbox of a database to a Sink, at least once and in order within a namespace.
+// An event whose delivery fails holds back the events of its namespace until it is delivered, while the events
+// of other namespaces go on. Only the relay holding the lease of the database delivers, so that the replicas
+// of the service do not deliver the same events concurrently.
+type Relay struct {
+	name     string
+	db       repository.DBQuerier
+	dialect  repository.Dialect
+	sink     Sink
+	settings Settings
+	owner    string
+	now      func() time.Time
+}
+
+// NewRelay creates a Relay of the outbox of the database, whose queries are written in the SQL flavour of the dialect.
+func NewRelay(name string, db repository.DBQuerier, dialect repository.Dialect, sink Sink, settings Settings) *Relay {
+	return &Relay{name: name, db: db, dialect: dialect, sink: sink, settings: settings, owner: newOwner(), now: time.Now}
+}
+
+// For creates the Relay of the outbox of the repository's database, named after the database.
+// It returns false if the repository does not write events to an outbox.
+func For(name string, repo repository.OrderRepository, sink Sink, settings Settings) (*Relay, bool) {
+	sqlRepo, ok := repo.(*repository.OrderRepositorySQL)
+	if !ok || !sqlRepo.Outbox {
+		return nil, false
+	}
+	dialect := sqlRepo.Dialect
+	if dialect == nil {
+		dialect = repository.PostgresDialect{}
+	}
+	return NewRelay(name, sqlRepo.Database, dialect, sink, settings), true
+}
+
+// Run delivers the events of the outbox every PollInterval until the context is done.
+func (r *Relay) Run(ctx context.Context) {
+	log.Infof("Relaying the outbox of %s", r.name)
+	ticker := time.NewTicker(r.settings.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Poll(ctx); err != nil {
+			log.Warnf("Relaying the outbox of %s failed: %s", r.name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll delivers the pending events once, if the relay holds the lease, and returns the number of delivered events.
+func (r *Relay) Poll(ctx context.Context) (int, error) {
+	if held, err := r.lease(); err != nil || !held {
+		return 0, err
+	}
+	events, err := r.pending()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	failed := make(map[string]bool)
+	for _, event := range events {
+		if ctx.Err() != nil {
+			break
+		}
+		if failed[event.Namespace] {
+			continue
+		}
+		// the lease is renewed before every delivery, since a batch may take longer than the lease
+		if held, err := r.lease(); err != nil || !held {
+			return delivered, err
+		}
+		if err := r.sink.Deliver(ctx, r.name, event); err != nil {
+			failed[event.Namespace] = true
+			if err := r.reschedule(event, err); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+		if _, err := r.db.Exec(fmt.Sprintf(deleteQuery, repository.OutboxTable, r.dialect.Placeholder(1)), event.ID); err != nil {
+			return delivered, errors.Wrapf(err, "while removing delivered event %d", event.ID)
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// lease tells whether the relay holds the lease of the outbox, extending it if so or taking it over if it expired.
+func (r *Relay) lease() (bool, error) {
+	d := r.dialect
+	now := r.now()
+	q := fmt.Sprintf(leaseQuery, repository.OutboxLeaseTable, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3), d.Placeholder(4))
+	result, err := r.db.Exec(q, r.owner, now.Add(r.settings.Lease).UnixNano(), r.owner, now.UnixNano())
+	if err != nil {
+		return false, errors.Wrap(err, "while taking the outbox lease")
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "while taking the outbox lease")
+	}
+	return updated == 1, nil
+}
+
+// pending reads the next events to deliver, in the order of their ID.
+func (r *Relay) pending() ([]Event, error) {
+	q := fmt.Sprintf(pendingQuery, repository.OutboxTable, r.dialect.Placeholder(1), r.dialect.Placeholder(2))
+	now := r.now().UnixNano()
+	rows, err := r.db.Query(repository.Limit(r.dialect, q, r.settings.BatchSize), now, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading the outbox")
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var (
+			event                  Event
+			payload                string
+			createdAt, nextAttempt int64
+		)
+		if err := rows.Scan(&event.ID, &event.Namespace, &event.Type, &payload, &createdAt, &event.Attempts, &nextAttempt); err != nil {
+			return nil, errors.Wrap(err, "while reading the outbox")
+		}
+		event.Payload = []byte(payload)
+		event.CreatedAt = time.Unix(0, createdAt)
+		event.NextAttempt = time.Unix(0, nextAttempt)
+		events = append(events, event)
+	}
+	return events, errors.Wrap(rows.Err(), "while reading the outbox")
+}
+
+// reschedule records the failed delivery of the event and when to try again.
+func (r *Relay) reschedule(event Event, failure error) error {
+	attempts := event.Attempts + 1
+	wait := r.settings.Retry.Backoff(attempts)
+	log.Warnf("Delivering event %d of %s failed %d times, retrying in %s: %s", event.ID, r.name, attempts, wait, failure)
+
+	message := failure.Error()
+	if runes := []rune(message); len(runes) > maxErrorLength {
+		message = string(runes[:maxErrorLength])
+	}
+	d := r.dialect
+	q := fmt.Sprintf(rescheduleQuery, repository.OutboxTable, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3), d.Placeholder(4))
+	if _, err := r.db.Exec(q, attempts, r.now().Add(wait).UnixNano(), message, event.ID); err != nil {
+		return errors.Wrapf(err, "while rescheduling event %d", event.ID)
+	}
+	return nil
+}
+
+// newOwner identifies the relay among the replicas of the service.
+func newOwner() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", host, hex.EncodeToString(suffix))
+}
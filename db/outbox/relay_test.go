@@ -0,0 +1,171 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retry"
+	"github.com/yemramirezca/http-db-service/db/sqlite"
+)
+
+var testSettings = Settings{PollInterval: time.Second, BatchSize: 10, Lease: time.Minute, Retry: retry.Policy{InitialBackoff: time.Second}}
+
+// newRepository returns an SQLite repository writing its events to the outbox.
+func newRepository(t *testing.T) repository.OrderRepository {
+	ds := sqlite.SQLite{DBCfg: config.Config{SQLitePath: ":memory:", DbOrdersTableName: "orders", OutboxSink: "http://sink"}}
+	repo, err := ds.NewOrderRepositoryDb()
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.CleanUp() })
+	return repo
+}
+
+// newRelay returns the relay of the repository's outbox, whose clock is advanced by the returned function.
+func newRelay(t *testing.T, repo repository.OrderRepository, sink Sink) (*Relay, func(time.Duration)) {
+	relay, ok := For("test", repo, sink, testSettings)
+	require.True(t, ok)
+	now := time.Now()
+	relay.now = func() time.Time { return now }
+	return relay, func(d time.Duration) { now = now.Add(d) }
+}
+
+// recorder is a Sink recording the order codes it received, failing the deliveries of the codes found in failures once.
+type recorder struct {
+	received []string
+	failures map[string]bool
+}
+
+func (r *recorder) Deliver(_ context.Context, _ string, event Event) error {
+	var created repository.OrderCreatedEvent
+	if err := json.Unmarshal(event.Payload, &created); err != nil {
+		return err
+	}
+	code := created.Namespace + "/" + created.OrderCode
+	if r.failures[code] {
+		delete(r.failures, code)
+		return errors.New("sink unavailable")
+	}
+	r.received = append(r.received, code)
+	return nil
+}
+
+func insert(t *testing.T, repo repository.OrderRepository, codes ...string) {
+	for _, code := range codes {
+		var order repository.Order
+		order.Total = 10
+		parts := []rune(code)
+		order.Namespace, order.OrderId = string(parts[:2]), string(parts[3:])
+		require.NoError(t, repo.InsertOrder(order))
+	}
+}
+
+func TestRelayDeliversInOrderWithinNamespace(t *testing.T) {
+	repo := newRepository(t)
+	sink := &recorder{failures: map[string]bool{"N7/o1": true}}
+	relay, advance := newRelay(t, repo, sink)
+	insert(t, repo, "N7/o1", "N8/o1", "N7/o2", "N8/o2")
+
+	// when
+	delivered, err := relay.Poll(context.Background())
+
+	// then the events of N7 wait for the failed one
+	require.NoError(t, err)
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, []string{"N8/o1", "N8/o2"}, sink.received)
+
+	// when
+	delivered, err = relay.Poll(context.Background())
+
+	// then the failed event is not retried before its backoff
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+
+	// when
+	advance(time.Second)
+	delivered, err = relay.Poll(context.Background())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, []string{"N8/o1", "N8/o2", "N7/o1", "N7/o2"}, sink.received)
+
+	delivered, err = relay.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+}
+
+func TestRelayDeliversOnlyWithTheLease(t *testing.T) {
+	repo := newRepository(t)
+	sink := &recorder{}
+	first, _ := newRelay(t, repo, sink)
+	second, advance := newRelay(t, repo, sink)
+
+	// when
+	insert(t, repo, "N7/o1")
+	delivered, err := first.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+
+	insert(t, repo, "N7/o2")
+	delivered, err = second.Poll(context.Background())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+
+	// when the lease of the first relay expired
+	advance(2 * testSettings.Lease)
+	delivered, err = second.Poll(context.Background())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, []string{"N7/o1", "N7/o2"}, sink.received)
+}
+
+func TestFailedInsertWritesNoEvent(t *testing.T) {
+	repo := newRepository(t)
+	sink := &recorder{}
+	relay, _ := newRelay(t, repo, sink)
+	insert(t, repo, "N7/o1")
+
+	// when
+	err := repo.InsertOrder(repository.Order{OrderId: "o1", Namespace: "N7", Total: 20})
+
+	// then
+	assert.Equal(t, repository.ErrDuplicateKey, err)
+	delivered, err := relay.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+}
+
+func TestForRequiresAnOutbox(t *testing.T) {
+	_, ok := For("memory", repository.NewOrderRepositoryMemory(), &recorder{}, testSettings)
+	assert.False(t, ok)
+
+	_, ok = For("sql", &repository.OrderRepositorySQL{}, &recorder{}, testSettings)
+	assert.False(t, ok)
+}
+
+func TestUpsertWritesEventsOfCreatedOrders(t *testing.T) {
+	repo := newRepository(t)
+	sink := &recorder{}
+	relay, _ := newRelay(t, repo, sink)
+	insert(t, repo, "N7/o1")
+
+	// when
+	_, err := repo.UpsertOrders([]repository.Order{{OrderId: "o1", Namespace: "N7", Total: 20}, {OrderId: "o2", Namespace: "N7", Total: 20}})
+	require.NoError(t, err)
+	_, err = relay.Poll(context.Background())
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []string{"N7/o1", "N7/o2"}, sink.received)
+}
@@ -4,11 +4,13 @@ import (
 	"database/sql"
 	"fmt"
 	_ "github.com/lib/pq"
-	log "github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
 	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/breaker"
+	"github.com/yemramirezca/http-db-service/db/connection"
+	"github.com/yemramirezca/http-db-service/db/migrations"
 	"github.com/yemramirezca/http-db-service/db/repository"
-	"strings"
+	"github.com/yemramirezca/http-db-service/db/retry"
 )
 
 type Postgres struct {
@@ -27,31 +29,42 @@ func (db *Postgres) DBConnectionString() string {
 func (ds *Postgres) NewOrderRepositoryDb() (repository.OrderRepository, error) {
 	var (
 		database repository.DBQuerier
-		err error
+		err      error
 	)
 
 	if database, err = ds.InitDb(); err != nil {
-		return nil, errors.Wrap(err, "Error loading db configuration %v.")
+		return nil, errors.Wrap(err, "Error loading db configuration.")
 	}
-	return &repository.OrderRepositorySQL{database, ds.DBCfg.DbOrdersTableName}, nil
+	return &repository.OrderRepositorySQL{Database: breaker.Wrap(ds.name(), database, repository.PostgresDialect{}, ds.DBCfg), OrdersTableName: ds.DBCfg.DbOrdersTableName, Dialect: repository.PostgresDialect{}, Retry: retry.ForQueries(ds.DBCfg), Outbox: ds.DBCfg.OutboxSink != ""}, nil
 }
 
-func (ds *Postgres)InitDb() (*sql.DB, error) {
-	conexionString := ds.DBConnectionString()
-	db, err := sql.Open(config.PostgresDriverName, conexionString)
-	if err != nil {
-		return nil, errors.Wrapf(err, "while establishing connection to '%s'", config.PostgresDriverName)
-	}
+func (ds *Postgres) InitDb() (*sql.DB, error) {
+	return InitDb(ds.name(), ds.DBConnectionString(), ds.DBCfg)
+}
+
+// OpenDb connects to the database without migrating it.
+func (ds *Postgres) OpenDb() (*sql.DB, error) {
+	return OpenDb(ds.name(), ds.DBConnectionString(), ds.DBCfg)
+}
+
+func (ds *Postgres) name() string {
+	return fmt.Sprintf("%s %s@%s", config.PostgresDriverName, ds.DBCfg.Name, ds.DBCfg.Host)
+}
 
-	log.Debug("Testing connection")
-	if err := db.Ping(); err != nil {
-		return nil, errors.Wrap(err, "while testing DB connection")
+// InitDb connects to the PostgreSQL database and migrates the orders table of cfg to the latest schema.
+func InitDb(name, conexionString string, cfg config.Config) (*sql.DB, error) {
+	db, err := OpenDb(name, conexionString, cfg)
+	if err != nil {
+		return nil, err
 	}
-	q := strings.Replace(PostgresTableCreationQuery, "{name}", SanitizeSQLArg(ds.DBCfg.DbOrdersTableName), -1)
-	log.Debugf("Ensuring table exists. Running query: '%q'.", q)
-	if _, err := db.Exec(q); err != nil {
-		return nil, errors.Wrap(err, "while initiating DB table")
+	if err := migrations.Migrate(db, repository.PostgresDialect{}, cfg.DbOrdersTableName); err != nil {
+		db.Close()
+		return nil, err
 	}
-
 	return db, nil
-}
\ No newline at end of file
+}
+
+// OpenDb connects to the PostgreSQL database with the pool settings of cfg, without migrating it.
+func OpenDb(name, conexionString string, cfg config.Config) (*sql.DB, error) {
+	return connection.Open(name, config.PostgresDriverName, conexionString, cfg)
+}
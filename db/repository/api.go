@@ -1,12 +1,19 @@
 package repository
 
-import "errors"
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 // Order contains the details of an order entity.
 type Order struct {
-	OrderId   string  `json:"orderId"`
-	Namespace string  `json:"namespace"`
-	Total     float64 `json:"total"`
+	OrderId   string            `json:"orderId"`
+	Namespace string            `json:"namespace"`
+	Total     float64           `json:"total"`
+	Labels    map[string]string `json:"labels,omitempty"`
 }
 
 // OrderRepository interface defines the basic operations needed for the order service
@@ -14,16 +21,149 @@ type Order struct {
 //go:generate mockery -name OrderRepository -inpkg
 type OrderRepository interface {
 	InsertOrder(o Order) error
+	// InsertOrders creates the orders, all of them or none if it fails, and returns for every order whether it was
+	// created. An order with the same OrderId and namespace as an existing one is left out if skipExisting, otherwise
+	// no order is created and ErrDuplicateKey is returned.
+	InsertOrders(orders []Order, skipExisting bool) ([]bool, error)
+	// UpsertOrders creates the orders, replacing the existing ones with the same OrderId and namespace, all of them
+	// or none if it fails. It returns for every order whether it was created rather than replaced.
+	UpsertOrders(orders []Order) ([]bool, error)
 	GetOrders() ([]Order, error)
 	GetNamespaceOrders(ns string) ([]Order, error)
-	DeleteOrders() error
-	DeleteNamespaceOrders(ns string) error
+	// GetOrdersBySelector returns the orders matching the label selector. An empty namespace matches all namespaces.
+	GetOrdersBySelector(ns string, selector LabelSelector) ([]Order, error)
+	// DeleteOrders, DeleteNamespaceOrders and DeleteOrdersBySelector return the number of deleted orders.
+	DeleteOrders() (int64, error)
+	DeleteNamespaceOrders(ns string) (int64, error)
+	// DeleteOrdersBySelector deletes the orders matching the label selector. An empty namespace matches all namespaces.
+	DeleteOrdersBySelector(ns string, selector LabelSelector) (int64, error)
+	// MoveNamespaceOrders moves every order of namespace from to namespace to, all of them or none, and returns the
+	// number of moved orders. It returns ErrNotFound if from has no orders, and ErrDuplicateKey if to has orders,
+	// or with merge only if to has an order with the same OrderId as an order of from.
+	MoveNamespaceOrders(from, to string, merge bool) (int64, error)
 	CleanUp() error
 }
 
-// ErrDuplicateKey is thrown when there is an attempt to create an order with an OrderId which already is used.
-var ErrDuplicateKey = errors.New("Duplicate key")
+// Namespace summarizes the orders of a namespace.
+type Namespace struct {
+	Name   string  `json:"name"`
+	Orders int64   `json:"orders"`
+	Total  float64 `json:"total"`
+}
+
+// maxNamespaceLength is the longest namespace, as for DNS labels.
+const maxNamespaceLength = 63
+
+var namespaceRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// ValidateNamespace checks that the name of a namespace is a DNS label as of RFC 1123: lowercase letters, digits
+// and hyphens, starting and ending with a letter or a digit.
+func ValidateNamespace(name string) error {
+	if len(name) > maxNamespaceLength || !namespaceRegex.MatchString(name) {
+		return errors.Errorf("namespace '%s' must be a DNS label of at most %d lowercase letters, digits or hyphens, starting and ending with a letter or a digit", name, maxNamespaceLength)
+	}
+	return nil
+}
+
+// NamespaceLister is implemented by repositories which can summarize the namespaces without reading every order.
+type NamespaceLister interface {
+	Namespaces() ([]Namespace, error)
+}
+
+// Namespaces returns the namespaces having orders, sorted by name. Repositories which do not implement
+// NamespaceLister have all their orders streamed to summarize them.
+func Namespaces(repo OrderRepository) ([]Namespace, error) {
+	var namespaces []Namespace
+	if lister, ok := repo.(NamespaceLister); ok {
+		var err error
+		if namespaces, err = lister.Namespaces(); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if namespaces, err = streamNamespaces(repo); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces, nil
+}
+
+// streamNamespaces summarizes the namespaces of the orders streamed from the repository, in no particular order.
+func streamNamespaces(repo OrderRepository) ([]Namespace, error) {
+	byName := make(map[string]*Namespace)
+	err := StreamOrders(repo, "", nil, func(order Order) error {
+		ns, exists := byName[order.Namespace]
+		if !exists {
+			ns = &Namespace{Name: order.Namespace}
+			byName[order.Namespace] = ns
+		}
+		ns.Orders++
+		ns.Total += order.Total
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make([]Namespace, 0, len(byName))
+	for _, ns := range byName {
+		namespaces = append(namespaces, *ns)
+	}
+	return namespaces, nil
+}
+
+// OrderStreamer is implemented by repositories which can hand over orders one at a time while reading them,
+// so that large listings never have to be held in memory. An empty namespace matches all namespaces.
+// Streaming stops with the error returned by fn, if any.
+type OrderStreamer interface {
+	StreamOrders(ns string, selector LabelSelector, fn func(Order) error) error
+}
+
+// StreamOrders passes the orders of the repository matching the namespace and selector to fn.
+// Repositories implementing OrderStreamer hand the orders over while reading them, others are read fully first.
+func StreamOrders(repo OrderRepository, ns string, selector LabelSelector, fn func(Order) error) error {
+	if streamer, ok := repo.(OrderStreamer); ok {
+		return streamer.StreamOrders(ns, selector, fn)
+	}
+
+	var (
+		orders []Order
+		err    error
+	)
+	switch {
+	case len(selector) > 0:
+		orders, err = repo.GetOrdersBySelector(ns, selector)
+	case ns != "":
+		orders, err = repo.GetNamespaceOrders(ns)
+	default:
+		orders, err = repo.GetOrders()
+	}
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version identifies the state of the orders of a namespace, or of all namespaces. The zero Version is unknown.
+type Version struct {
+	// Counter changes with every change to the orders.
+	Counter int64
+	// Modified is the time of the last change, zero if unknown.
+	Modified time.Time
+}
+
+// OrderVersioner is implemented by repositories which track the changes to the orders of every namespace, so that
+// listings are only sent again once they changed. An empty namespace stands for all namespaces.
+type OrderVersioner interface {
+	OrdersVersion(ns string) (Version, error)
+}
 
 type OrderCreatedEvent struct {
 	OrderCode string `json:"orderCode"`
+	Namespace string `json:"namespace,omitempty"`
 }
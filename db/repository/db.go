@@ -2,31 +2,54 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
-	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/retry"
 	"io"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const (
-	insertQuery         = "INSERT INTO %s (order_id, namespace, total) VALUES ($1, $2, $3)"
-	getQuery            = "SELECT * FROM %s"
-	getNSQuery          = "SELECT * FROM %s WHERE namespace = ?"
+	insertQuery         = "INSERT INTO %s (order_id, namespace, total, labels, sealed, created_at) VALUES (%s)"
+	getQuery            = "SELECT order_id, namespace, total, labels, sealed FROM %s"
+	getNSQuery          = "SELECT order_id, namespace, total, labels, sealed FROM %s WHERE namespace = %s"
+	getSelectorQuery    = "SELECT order_id, namespace, total, labels, sealed FROM %s WHERE %s"
 	deleteQuery         = "DELETE FROM %s"
-	deleteNSQuery       = "DELETE FROM %s WHERE namespace = ?"
-	PrimaryKeyViolation = 2627
+	deleteNSQuery       = "DELETE FROM %s WHERE namespace = %s"
+	deleteSelectorQuery = "DELETE FROM %s WHERE %s"
+	deleteOrderQuery    = "DELETE FROM %s WHERE order_id = %s AND namespace = %s"
+	unsealedQuery       = "SELECT order_id, namespace, total, labels, sealed FROM %s WHERE sealed IS NULL OR sealed NOT LIKE %s ORDER BY namespace, order_id"
+	resealQuery         = "UPDATE %s SET total = %s, labels = %s, sealed = %s WHERE order_id = %s AND namespace = %s AND %s"
+	insertOutboxQuery   = "INSERT INTO %s (namespace, event_type, payload, created_at) VALUES (%s)"
+	countNSQuery        = "SELECT COUNT(*) FROM %s WHERE namespace = %s"
+	moveNSQuery         = "UPDATE %s SET namespace = %s WHERE namespace = %s"
+	namespacesQuery     = "SELECT namespace, COUNT(*), COALESCE(SUM(total), 0) FROM %s GROUP BY namespace"
+	getVersionQuery     = "SELECT version, modified_at FROM %s WHERE namespace = %s"
+	getVersionsQuery    = "SELECT COALESCE(SUM(version), 0), COALESCE(MAX(modified_at), 0) FROM %s"
 	DefaultTable        = "orders"
-	TableCreationQuery  = `
-    CREATE TABLE IF NOT EXISTS {name} (
-      order_id VARCHAR(64),
-      namespace VARCHAR(64),
-      total DECIMAL(8,2),
-      PRIMARY KEY (order_id, namespace)
-    )
-`
+	// MigrationsTable records the schema migrations applied to the database, see the `db/migrations` package.
+	MigrationsTable = "schema_migrations"
+	// AuditTable is the append-only log of the changes to the orders, see the `db/audit` package.
+	AuditTable = "audit_log"
+	// OutboxTable holds the events waiting to be delivered, and OutboxLeaseTable the relay delivering them,
+	// see the `db/outbox` package.
+	OutboxTable      = "outbox"
+	OutboxLeaseTable = "outbox_lease"
+	// VersionsTable counts the changes to the orders of every namespace, it is kept up to date by triggers
+	// on the orders table.
+	VersionsTable = "order_versions"
+	// IdempotencyTable holds the responses replayed to the requests repeated with the same key, see the `db/idempotency` package.
+	IdempotencyTable = "idempotency_keys"
+	// ArchiveTable holds the orders moved out of the orders table by the retention jobs, see the `db/retention` package.
+	ArchiveTable = "orders_archive"
+	// DataKeysTable holds the keys encrypting the order fields, wrapped by a master key, see the `db/encryption` package.
+	DataKeysTable = "data_keys"
+	// OrderCreatedEventType is the type of the OrderCreatedEvent written to the outbox.
+	OrderCreatedEventType = "order.created"
 )
 
 type Database interface {
@@ -34,9 +57,40 @@ type Database interface {
 	NewOrderRepositoryDb() (OrderRepository, error)
 }
 
+// OrderRepositorySQL implements OrderRepository on top of an SQL database.
+// Queries are written in the SQL flavour of the Dialect, PostgreSQL if none is set.
+// Reads and deletes failing with a transient error are retried according to Retry, inserts are never retried
+// since an insert which failed after it was committed would then fail as a duplicate.
+// If Outbox is set, every inserted order writes an OrderCreatedEvent to the outbox table in the same transaction,
+// which requires a Database implementing TxBeginner.
+// If Cipher is set, the fields it encrypts are written to the sealed column instead of their own, see FieldCipher.
 type OrderRepositorySQL struct {
 	Database        DBQuerier
 	OrdersTableName string
+	Dialect         Dialect
+	Retry           retry.Policy
+	Outbox          bool
+	Cipher          FieldCipher
+}
+
+// Fields of the orders which a FieldCipher can encrypt.
+const (
+	TotalField  = "total"
+	LabelsField = "labels"
+)
+
+// FieldCipher encrypts the fields of the orders which must be unreadable to whoever reads the database.
+// The encrypted fields are cleared in their own columns, a NULL total and no labels, so the database can neither sum
+// the totals nor match the labels of the encrypted orders: the repository then does it after decrypting them.
+type FieldCipher interface {
+	// Encrypts tells whether the field is encrypted.
+	Encrypts(field string) bool
+	// Seal returns the ciphertext of the encrypted fields of the order.
+	Seal(order Order) (string, error)
+	// Open restores the encrypted fields of the order from their ciphertext.
+	Open(order *Order, sealed string) error
+	// ActivePrefix is the prefix of the ciphertexts sealed with the active key, the others are sealed again by ResealOrders.
+	ActivePrefix() string
 }
 
 //go:generate mockery -name DBQuerier -inpkg
@@ -46,92 +100,769 @@ type DBQuerier interface {
 	io.Closer
 }
 
+// TxBeginner is implemented by the DBQuerier which can run statements in a transaction, such as `*sql.DB`.
+type TxBeginner interface {
+	Begin() (*sql.Tx, error)
+}
+
+// orderColumns are the columns of the orders table, in the order of the arguments of the insert and upsert statements,
+// and orderKeys its primary key.
+var (
+	orderColumns = []string{"order_id", "namespace", "total", "labels", "sealed"}
+	orderKeys    = []string{"order_id", "namespace"}
+)
+
+func (repository *OrderRepositorySQL) dialect() Dialect {
+	if repository.Dialect == nil {
+		return PostgresDialect{}
+	}
+	return repository.Dialect
+}
 
-type sqlError interface {
-	sqlErrorNumber() int32
+// table returns the sanitized and quoted name of the orders table.
+func (repository *OrderRepositorySQL) table() string {
+	return repository.dialect().QuoteIdentifier(SanitizeSQLArg(repository.OrdersTableName))
 }
 
 func (repository *OrderRepositorySQL) InsertOrder(order Order) error {
-	q := fmt.Sprintf(insertQuery, SanitizeSQLArg(repository.OrdersTableName))
+	q := fmt.Sprintf(insertQuery, repository.table(), placeholders(repository.dialect(), 1, 6))
+	values, err := repository.values(order)
+	if err != nil {
+		return errors.Wrap(err, "while inserting order")
+	}
+	args := append(values, time.Now().UnixNano())
 	log.Debugf("Running insert order query: '%q'.", q)
-	_, err := repository.Database.Exec(q, order.OrderId, order.Namespace, order.Total)
+	if repository.Outbox {
+		err = repository.insertWithEvent(q, order, args)
+	} else {
+		_, err = repository.Database.Exec(q, args...)
+	}
+
+	if err = repository.translateError(err); err == ErrDuplicateKey {
+		return ErrDuplicateKey
+	}
+	return errors.Wrap(err, "while inserting order")
+}
+
+// insertWithEvent inserts the order and its OrderCreatedEvent into the outbox in a single transaction,
+// so that the event is delivered if and only if the order was created.
+func (repository *OrderRepositorySQL) insertWithEvent(q string, order Order, args []interface{}) error {
+	beginner, ok := repository.Database.(TxBeginner)
+	if !ok {
+		return errors.New("the database does not support the transactions required by the outbox")
+	}
+
+	tx, err := beginner.Begin()
+	if err != nil {
+		return err
+	}
+	// rolling back a committed transaction does nothing
+	defer tx.Rollback()
+	if _, err := tx.Exec(q, args...); err != nil {
+		return err
+	}
+	if err := repository.insertEvent(tx, order); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertEvent writes the OrderCreatedEvent of the order to the outbox in the transaction.
+func (repository *OrderRepositorySQL) insertEvent(tx *sql.Tx, order Order) error {
+	payload, err := json.Marshal(OrderCreatedEvent{OrderCode: order.OrderId, Namespace: order.Namespace})
+	if err != nil {
+		return err
+	}
+	outboxQuery := fmt.Sprintf(insertOutboxQuery, OutboxTable, placeholders(repository.dialect(), 1, 4))
+	_, err = tx.Exec(outboxQuery, order.Namespace, OrderCreatedEventType, string(payload), time.Now().UnixNano())
+	return err
+}
 
+// InsertOrders writes the orders with the insert-if-absent statement of the dialect, in a single transaction which
+// requires a Database implementing TxBeginner, an order being created if the statement affected its row. The
+// statement leaves an existing order out rather than failing, which would abort the whole transaction on PostgreSQL,
+// so that the transaction goes on with the other orders if skipExisting, and is rolled back otherwise.
+func (repository *OrderRepositorySQL) InsertOrders(orders []Order, skipExisting bool) ([]bool, error) {
+	beginner, ok := repository.Database.(TxBeginner)
+	if !ok {
+		return nil, errors.New("the database does not support the transactions required by inserting orders")
+	}
+	columns := append(append([]string(nil), orderColumns...), "created_at")
+	insertQuery := repository.dialect().InsertIfAbsentQuery(SanitizeSQLArg(repository.OrdersTableName), columns, orderKeys)
+	log.Debugf("Running insert orders query: '%q'.", insertQuery)
 
-	if errorWithNumber, ok := err.(sqlError); ok {
-		if errorWithNumber.sqlErrorNumber() == PrimaryKeyViolation {
-			return ErrDuplicateKey
+	tx, err := beginner.Begin()
+	if err != nil {
+		return nil, errors.Wrap(repository.translateError(err), "while inserting orders")
+	}
+	defer tx.Rollback()
+	created := make([]bool, 0, len(orders))
+	for _, order := range orders {
+		values, err := repository.values(order)
+		if err != nil {
+			return nil, errors.Wrap(err, "while inserting orders")
+		}
+		result, err := tx.Exec(insertQuery, append(values, time.Now().UnixNano())...)
+		if err != nil {
+			return nil, errors.Wrapf(repository.translateError(err), "while inserting order %s", order.OrderId)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return nil, errors.Wrapf(err, "while inserting order %s", order.OrderId)
 		}
+		if affected == 0 && !skipExisting {
+			return nil, ErrDuplicateKey
+		}
+		if affected > 0 && repository.Outbox {
+			if err := repository.insertEvent(tx, order); err != nil {
+				return nil, errors.Wrapf(repository.translateError(err), "while inserting order %s", order.OrderId)
+			}
+		}
+		created = append(created, affected > 0)
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(repository.translateError(err), "while inserting orders")
+	}
+	return created, nil
+}
 
-	return errors.Wrap(err, "while inserting order")
+// UpsertOrders writes the orders with the upsert statement of the dialect, in a single transaction which requires
+// a Database implementing TxBeginner. Whether an order is created is told by the upsert itself, from the time it
+// was created which the statement returns, or from the rows it affected if the dialect cannot return them, so that
+// concurrent upserts of the same order report it as created only once. Upserts are never retried, since one which
+// failed after it was committed would then report the orders as replaced. Replacing an order keeps the time it was
+// created, from which the retention jobs age it.
+func (repository *OrderRepositorySQL) UpsertOrders(orders []Order) ([]bool, error) {
+	beginner, ok := repository.Database.(TxBeginner)
+	if !ok {
+		return nil, errors.New("the database does not support the transactions required by upserts")
+	}
+	d := repository.dialect()
+	columns := append(append([]string(nil), orderColumns...), "created_at")
+	upsertQuery, returning := d.UpsertQuery(SanitizeSQLArg(repository.OrdersTableName), columns, orderKeys, "created_at")
+	log.Debugf("Running upsert order query: '%q'.", upsertQuery)
+
+	tx, err := beginner.Begin()
+	if err != nil {
+		return nil, errors.Wrap(repository.translateError(err), "while upserting orders")
+	}
+	defer tx.Rollback()
+	created := make([]bool, 0, len(orders))
+	for _, order := range orders {
+		values, err := repository.values(order)
+		if err != nil {
+			return nil, errors.Wrap(err, "while upserting orders")
+		}
+		isCreated, err := upsertOrder(tx, upsertQuery, returning, values)
+		if err != nil {
+			return nil, errors.Wrapf(repository.translateError(err), "while upserting order %s", order.OrderId)
+		}
+		if isCreated && repository.Outbox {
+			if err := repository.insertEvent(tx, order); err != nil {
+				return nil, errors.Wrapf(repository.translateError(err), "while upserting order %s", order.OrderId)
+			}
+		}
+		created = append(created, isCreated)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(repository.translateError(err), "while upserting orders")
+	}
+	return created, nil
+}
+
+// upsertOrder runs the upsert statement of the order, whose values are followed by the time it is created, and tells
+// whether it created the order: the statement then returns this time, or affects a single row if it is not returning.
+func upsertOrder(tx *sql.Tx, upsertQuery string, returning bool, values []interface{}) (bool, error) {
+	createdAt := time.Now().UnixNano()
+	values = append(values, createdAt)
+	if !returning {
+		result, err := tx.Exec(upsertQuery, values...)
+		if err != nil {
+			return false, err
+		}
+		affected, err := result.RowsAffected()
+		return affected == 1, err
+	}
+	var stored int64
+	if err := tx.QueryRow(upsertQuery, values...).Scan(&stored); err != nil {
+		return false, err
+	}
+	return stored == createdAt, nil
 }
 
 func (repository *OrderRepositorySQL) GetOrders() ([]Order, error) {
-	q := fmt.Sprintf(getQuery, SanitizeSQLArg(repository.OrdersTableName))
+	q := fmt.Sprintf(getQuery, repository.table())
 	log.Debugf("Quering orders: '%q'.", q)
-	rows, err := repository.Database.Query(q)
+	orders, err := repository.readOrders(q)
 
 	if err != nil {
 		return nil, errors.Wrap(err, "while reading orders from DB")
 	}
-
-	defer rows.Close()
-	return readFromResult(rows)
+	return orders, nil
 }
 
 func (repository *OrderRepositorySQL) GetNamespaceOrders(ns string) ([]Order, error) {
-	q := fmt.Sprintf(getNSQuery, SanitizeSQLArg(repository.OrdersTableName))
+	q := fmt.Sprintf(getNSQuery, repository.table(), repository.dialect().Placeholder(1))
 	log.Debugf("Quering orders for namespace: '%q'.", q)
-	rows, err := repository.Database.Query(q, ns)
+	orders, err := repository.readOrders(q, ns)
 
 	if err != nil {
 		return nil, errors.Wrapf(err, "while reading orders for namespace: '%q' from DB", ns)
 	}
+	return orders, nil
+}
 
-	defer rows.Close()
-	return readFromResult(rows)
+func (repository *OrderRepositorySQL) GetOrdersBySelector(ns string, selector LabelSelector) ([]Order, error) {
+	where, args, err := selectorCondition(repository.dialect(), ns, repository.dbSelector(selector))
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(getSelectorQuery, repository.table(), where)
+	log.Debugf("Quering orders by selector: '%q'.", q)
+	orders, err := repository.readOrders(q, args...)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading orders matching '%s' from DB", selector)
+	}
+	if repository.encrypts(LabelsField) {
+		orders = matching(orders, selector)
+	}
+	return orders, nil
 }
 
-func (repository *OrderRepositorySQL) DeleteOrders() error {
-	q := fmt.Sprintf(deleteQuery, SanitizeSQLArg(repository.OrdersTableName))
+func (repository *OrderRepositorySQL) DeleteOrders() (int64, error) {
+	q := fmt.Sprintf(deleteQuery, repository.table())
 	log.Debugf("Deleting orders: '%q'.", q)
-	_, err := repository.Database.Exec(q)
+	deleted, err := repository.delete(q)
 
 	if err != nil {
-		return errors.Wrap(err, "while deleting orders")
+		return 0, errors.Wrap(err, "while deleting orders")
 	}
-	return nil
+	return deleted, nil
 }
 
-func (repository *OrderRepositorySQL) DeleteNamespaceOrders(ns string) error {
-	q := fmt.Sprintf(deleteNSQuery, SanitizeSQLArg(repository.OrdersTableName))
+func (repository *OrderRepositorySQL) DeleteNamespaceOrders(ns string) (int64, error) {
+	q := fmt.Sprintf(deleteNSQuery, repository.table(), repository.dialect().Placeholder(1))
 	log.Debugf("Deleting orders: '%q'.", q)
-	_, err := repository.Database.Exec(q, ns)
+	deleted, err := repository.delete(q, ns)
 
 	if err != nil {
-		return errors.Wrap(err, "while deleting orders")
+		return 0, errors.Wrap(err, "while deleting orders")
 	}
-	return nil
+	return deleted, nil
+}
+
+func (repository *OrderRepositorySQL) DeleteOrdersBySelector(ns string, selector LabelSelector) (int64, error) {
+	if repository.encrypts(LabelsField) {
+		deleted, err := repository.deleteMatching(ns, selector)
+		return deleted, errors.Wrapf(err, "while deleting orders matching '%s'", selector)
+	}
+	where, args, err := selectorCondition(repository.dialect(), ns, selector)
+	if err != nil {
+		return 0, err
+	}
+	q := fmt.Sprintf(deleteSelectorQuery, repository.table(), where)
+	log.Debugf("Deleting orders by selector: '%q'.", q)
+	deleted, err := repository.delete(q, args...)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "while deleting orders matching '%s'", selector)
+	}
+	return deleted, nil
 }
 
-func readFromResult(rows *sql.Rows) ([]Order, error) {
+// deleteMatching deletes the orders of the namespace matching the selector one by one in a transaction,
+// since the database cannot match the encrypted labels.
+func (repository *OrderRepositorySQL) deleteMatching(ns string, selector LabelSelector) (int64, error) {
+	beginner, ok := repository.Database.(TxBeginner)
+	if !ok {
+		return 0, errors.New("the database does not support the transactions required by encrypted labels")
+	}
+	d := repository.dialect()
+	where, args, err := selectorCondition(d, ns, nil)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := beginner.Begin()
+	if err != nil {
+		return 0, repository.translateError(err)
+	}
+	defer tx.Rollback()
+	orders, err := repository.readTx(tx, fmt.Sprintf(getSelectorQuery, repository.table(), where), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	q := fmt.Sprintf(deleteOrderQuery, repository.table(), d.Placeholder(1), d.Placeholder(2))
+	var deleted int64
+	for _, order := range matching(orders, selector) {
+		result, err := tx.Exec(q, order.OrderId, order.Namespace)
+		if err != nil {
+			return 0, repository.translateError(err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += rows
+	}
+	return deleted, repository.translateError(tx.Commit())
+}
+
+// ResealOrders encrypts again, in a transaction, up to limit orders which are not encrypted with the active key
+// of the Cipher, the orders written before encryption was enabled included. An order is only written if its
+// ciphertext is still the one read, the orders written meanwhile being already sealed with the active key.
+// It returns the number of orders sealed, fewer than limit once none is left or if some were written meanwhile.
+func (repository *OrderRepositorySQL) ResealOrders(limit int) (int64, error) {
+	if repository.Cipher == nil {
+		return 0, errors.New("the repository does not encrypt orders")
+	}
+	beginner, ok := repository.Database.(TxBeginner)
+	if !ok {
+		return 0, errors.New("the database does not support the transactions required by resealing orders")
+	}
+	d := repository.dialect()
+	tx, err := beginner.Begin()
+	if err != nil {
+		return 0, errors.Wrap(repository.translateError(err), "while resealing orders")
+	}
+	defer tx.Rollback()
+	q := Limit(d, fmt.Sprintf(unsealedQuery, repository.table(), d.Placeholder(1)), limit)
+	orders, sealed, err := repository.readSealedTx(tx, q, repository.Cipher.ActivePrefix()+"%")
+	if err != nil {
+		return 0, errors.Wrap(err, "while resealing orders")
+	}
+
+	var resealed int64
+	for i, order := range orders {
+		values, err := repository.values(order)
+		if err != nil {
+			return 0, errors.Wrap(err, "while resealing orders")
+		}
+		// values follow orderColumns: order_id, namespace, total, labels and sealed
+		args := []interface{}{values[2], values[3], values[4], values[0], values[1]}
+		unchanged := "sealed IS NULL"
+		if sealed[i].Valid {
+			unchanged = "sealed = " + d.Placeholder(6)
+			args = append(args, sealed[i].String)
+		}
+		q := fmt.Sprintf(resealQuery, repository.table(), d.Placeholder(1), d.Placeholder(2), d.Placeholder(3), d.Placeholder(4), d.Placeholder(5), unchanged)
+		result, err := tx.Exec(q, args...)
+		if err != nil {
+			return 0, errors.Wrapf(repository.translateError(err), "while resealing order %s", order.OrderId)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, errors.Wrapf(err, "while resealing order %s", order.OrderId)
+		}
+		resealed += rows
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(repository.translateError(err), "while resealing orders")
+	}
+	return resealed, nil
+}
+
+// OrdersVersion reads the version of the orders of the namespace, or of all namespaces if ns is empty,
+// from the table kept up to date by the triggers of the orders table. The counters of all namespaces are summed
+// up, since they only grow.
+func (repository *OrderRepositorySQL) OrdersVersion(ns string) (Version, error) {
+	q, args := fmt.Sprintf(getVersionsQuery, VersionsTable), []interface{}{}
+	if ns != "" {
+		q, args = fmt.Sprintf(getVersionQuery, VersionsTable, repository.dialect().Placeholder(1)), []interface{}{ns}
+	}
+
+	var version Version
+	err := repository.Retry.Do(repository.isTransient, func() error {
+		rows, err := repository.Database.Query(q, args...)
+		if err != nil {
+			return repository.translateError(err)
+		}
+		defer rows.Close()
+		version = Version{}
+		if rows.Next() {
+			var modified int64
+			if err := rows.Scan(&version.Counter, &modified); err != nil {
+				return err
+			}
+			if modified > 0 {
+				version.Modified = time.Unix(0, modified)
+			}
+		}
+		return repository.translateError(rows.Err())
+	})
+	if err != nil {
+		return Version{}, errors.Wrapf(err, "while reading the version of namespace '%s'", ns)
+	}
+	return version, nil
+}
+
+// MoveNamespaceOrders updates the namespace of the orders in a single transaction, which requires a Database
+// implementing TxBeginner. An order of the target namespace with the same OrderId fails the update with
+// ErrDuplicateKey. Moves are never retried, since one which failed after it was committed would then find no orders.
+func (repository *OrderRepositorySQL) MoveNamespaceOrders(from, to string, merge bool) (int64, error) {
+	beginner, ok := repository.Database.(TxBeginner)
+	if !ok {
+		return 0, errors.New("the database does not support the transactions required by moves")
+	}
+	d := repository.dialect()
+	countQuery := fmt.Sprintf(countNSQuery, repository.table(), d.Placeholder(1))
+	moveQuery := fmt.Sprintf(moveNSQuery, repository.table(), d.Placeholder(1), d.Placeholder(2))
+	log.Debugf("Running move orders query: '%q'.", moveQuery)
+
+	tx, err := beginner.Begin()
+	if err != nil {
+		return 0, errors.Wrapf(repository.translateError(err), "while moving the orders of namespace '%s'", from)
+	}
+	defer tx.Rollback()
+	if !merge {
+		var existing int
+		if err := tx.QueryRow(countQuery, to).Scan(&existing); err != nil {
+			return 0, errors.Wrapf(repository.translateError(err), "while moving the orders of namespace '%s'", from)
+		}
+		if existing > 0 {
+			return 0, ErrDuplicateKey
+		}
+	}
+	result, err := tx.Exec(moveQuery, to, from)
+	if err != nil {
+		if err = repository.translateError(err); err == ErrDuplicateKey {
+			return 0, err
+		}
+		return 0, errors.Wrapf(err, "while moving the orders of namespace '%s'", from)
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrapf(err, "while moving the orders of namespace '%s'", from)
+	}
+	if moved == 0 {
+		return 0, ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrapf(repository.translateError(err), "while moving the orders of namespace '%s'", from)
+	}
+	return moved, nil
+}
+
+// Namespaces summarizes the orders of every namespace with a single query.
+func (repository *OrderRepositorySQL) Namespaces() ([]Namespace, error) {
+	if repository.encrypts(TotalField) {
+		// the database cannot sum the encrypted totals
+		return streamNamespaces(repository)
+	}
+	q := fmt.Sprintf(namespacesQuery, repository.table())
+	var namespaces []Namespace
+	err := repository.Retry.Do(repository.isTransient, func() error {
+		rows, err := repository.Database.Query(q)
+		if err != nil {
+			return repository.translateError(err)
+		}
+		defer rows.Close()
+		namespaces = make([]Namespace, 0)
+		for rows.Next() {
+			var ns Namespace
+			if err := rows.Scan(&ns.Name, &ns.Orders, &ns.Total); err != nil {
+				return err
+			}
+			namespaces = append(namespaces, ns)
+		}
+		return repository.translateError(rows.Err())
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading namespaces")
+	}
+	return namespaces, nil
+}
+
+// StreamOrders reads the orders matching the namespace and selector and passes each one to fn as soon as it is scanned.
+func (repository *OrderRepositorySQL) StreamOrders(ns string, selector LabelSelector, fn func(Order) error) error {
+	q := fmt.Sprintf(getQuery, repository.table())
+	var args []interface{}
+	if repository.encrypts(LabelsField) && len(selector) > 0 {
+		next := fn
+		fn = func(order Order) error {
+			if !selector.Matches(order.Labels) {
+				return nil
+			}
+			return next(order)
+		}
+		selector = nil
+	}
+	if ns != "" || len(selector) > 0 {
+		where, whereArgs, err := selectorCondition(repository.dialect(), ns, selector)
+		if err != nil {
+			return err
+		}
+		q, args = fmt.Sprintf(getSelectorQuery, repository.table(), where), whereArgs
+	}
+	log.Debugf("Streaming orders: '%q'.", q)
+	// once the first order was passed to fn the query cannot be retried anymore
+	var rows *sql.Rows
+	err := repository.Retry.Do(repository.isTransient, func() error {
+		var err error
+		rows, err = repository.Database.Query(q, args...)
+		return err
+	})
+
+	if err != nil {
+		return errors.Wrap(repository.translateError(err), "while reading orders from DB")
+	}
+
+	defer rows.Close()
+	return repository.scanOrders(rows, fn)
+}
+
+// readOrders runs the query and reads all its orders, retrying both if they fail with a transient error.
+func (repository *OrderRepositorySQL) readOrders(q string, args ...interface{}) ([]Order, error) {
+	var orders []Order
+	err := repository.Retry.Do(repository.isTransient, func() error {
+		rows, err := repository.Database.Query(q, args...)
+		if err != nil {
+			return repository.translateError(err)
+		}
+		defer rows.Close()
+		orders, err = repository.readFromResult(rows)
+		return err
+	})
+	return orders, err
+}
+
+// exec runs an idempotent statement, retrying it if it fails with a transient error.
+func (repository *OrderRepositorySQL) exec(q string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := repository.Retry.Do(repository.isTransient, func() error {
+		var err error
+		result, err = repository.Database.Exec(q, args...)
+		return repository.translateError(err)
+	})
+	return result, err
+}
+
+// delete runs a delete statement, see exec, and returns the number of deleted rows.
+// A retried delete only counts the rows deleted by its last attempt.
+func (repository *OrderRepositorySQL) delete(q string, args ...interface{}) (int64, error) {
+	result, err := repository.exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (repository *OrderRepositorySQL) isTransient(err error) bool {
+	return IsTransient(repository.translateError(err))
+}
+
+// readTx runs the query in the transaction and reads all its orders.
+func (repository *OrderRepositorySQL) readTx(tx *sql.Tx, q string, args ...interface{}) ([]Order, error) {
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return nil, repository.translateError(err)
+	}
+	defer rows.Close()
+	return repository.readFromResult(rows)
+}
+
+// readFromResult reads all the orders of the rows, decrypting their encrypted fields.
+func (repository *OrderRepositorySQL) readFromResult(rows *sql.Rows) ([]Order, error) {
 	orderList := make([]Order, 0)
+	err := repository.scanOrders(rows, func(order Order) error {
+		orderList = append(orderList, order)
+		return nil
+	})
+	if err != nil {
+		return []Order{}, err
+	}
+	return orderList, nil
+}
+
+// scanOrders scans the rows one by one and passes each order to fn.
+// Errors raised while iterating, such as a connection lost midway, are returned as well.
+func (repository *OrderRepositorySQL) scanOrders(rows *sql.Rows, fn func(Order) error) error {
+	return repository.scanSealedOrders(rows, func(order Order, _ sql.NullString) error {
+		return fn(order)
+	})
+}
+
+// readSealedTx reads the orders of the query in the transaction, along with the ciphertexts they were decrypted from.
+func (repository *OrderRepositorySQL) readSealedTx(tx *sql.Tx, q string, args ...interface{}) ([]Order, []sql.NullString, error) {
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return nil, nil, repository.translateError(err)
+	}
+	defer rows.Close()
+	var (
+		orders []Order
+		sealed []sql.NullString
+	)
+	err = repository.scanSealedOrders(rows, func(order Order, ciphertext sql.NullString) error {
+		orders = append(orders, order)
+		sealed = append(sealed, ciphertext)
+		return nil
+	})
+	return orders, sealed, err
+}
+
+// scanSealedOrders is scanOrders passing the ciphertext of every order as well, not valid if it is not encrypted.
+func (repository *OrderRepositorySQL) scanSealedOrders(rows *sql.Rows, fn func(Order, sql.NullString) error) error {
 	for rows.Next() {
 		order := Order{}
-		if err := rows.Scan(&order.OrderId, &order.Namespace, &order.Total); err != nil {
-			return []Order{}, err
+		var (
+			total  sql.NullFloat64
+			labels []byte
+			sealed sql.NullString
+		)
+		if err := rows.Scan(&order.OrderId, &order.Namespace, &total, &labels, &sealed); err != nil {
+			return repository.translateError(err)
+		}
+		order.Total = total.Float64
+		if err := json.Unmarshal(labels, &order.Labels); err != nil {
+			return errors.Wrapf(err, "while reading labels of order '%s'", order.OrderId)
+		}
+		if sealed.Valid {
+			if repository.Cipher == nil {
+				return errors.Errorf("order '%s' is encrypted but no encryption key is configured", order.OrderId)
+			}
+			if err := repository.Cipher.Open(&order, sealed.String); err != nil {
+				return errors.Wrapf(err, "while decrypting order '%s'", order.OrderId)
+			}
+		}
+		if len(order.Labels) == 0 {
+			order.Labels = nil
+		}
+		if err := fn(order, sealed); err != nil {
+			return err
 		}
-		orderList = append(orderList, order)
 	}
-	return orderList, nil
+	return repository.translateError(rows.Err())
+}
+
+// translateError maps the driver error to a repository error, see TranslateError,
+// giving the dialect the first chance to recognise errors specific to its driver.
+func (repository *OrderRepositorySQL) translateError(err error) error {
+	return TranslateDialectError(repository.dialect(), err)
+}
+
+// selectorCondition builds the WHERE condition and its arguments restricting a query to the given namespace and selector.
+// Labels are stored as a JSON object, so each requirement compares the text value of one of its keys.
+func selectorCondition(d Dialect, ns string, selector LabelSelector) (string, []interface{}, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return d.Placeholder(len(args))
+	}
+
+	if ns != "" {
+		conditions = append(conditions, "namespace = "+arg(ns))
+	}
+	for _, req := range selector {
+		// the key becomes part of the query text, so it must never be anything but a valid label key
+		if err := validateLabelKey(req.Key); err != nil {
+			return "", nil, err
+		}
+		field := d.LabelValue("labels", req.Key)
+		values := make([]string, 0, len(req.Values))
+		for _, v := range req.Values {
+			values = append(values, arg(v))
+		}
+		list := strings.Join(values, ", ")
+
+		switch req.Operator {
+		case Equals:
+			conditions = append(conditions, fmt.Sprintf("%s = %s", field, list))
+		case NotEquals:
+			conditions = append(conditions, fmt.Sprintf("(%s IS NULL OR %s <> %s)", field, field, list))
+		case In:
+			conditions = append(conditions, fmt.Sprintf("%s IN (%s)", field, list))
+		case NotIn:
+			conditions = append(conditions, fmt.Sprintf("(%s IS NULL OR %s NOT IN (%s))", field, field, list))
+		default:
+			return "", nil, errors.Errorf("unsupported operator '%s'", req.Operator)
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "1 = 1", args, nil
+	}
+	return strings.Join(conditions, " AND "), args, nil
 }
 
+// values returns the values of the orderColumns written for the order. The fields encrypted by the Cipher are
+// cleared, their values being written to the sealed column only.
+func (repository *OrderRepositorySQL) values(order Order) ([]interface{}, error) {
+	labels, err := marshalLabels(order.Labels)
+	if err != nil {
+		return nil, err
+	}
+	var total, sealed interface{} = order.Total, nil
+	if repository.Cipher != nil {
+		ciphertext, err := repository.Cipher.Seal(order)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while encrypting order %s", order.OrderId)
+		}
+		sealed = ciphertext
+		if repository.Cipher.Encrypts(TotalField) {
+			total = nil
+		}
+		if repository.Cipher.Encrypts(LabelsField) {
+			labels = "{}"
+		}
+	}
+	return []interface{}{order.OrderId, order.Namespace, total, labels, sealed}, nil
+}
+
+// encrypts tells whether the field is encrypted by the Cipher.
+func (repository *OrderRepositorySQL) encrypts(field string) bool {
+	return repository.Cipher != nil && repository.Cipher.Encrypts(field)
+}
+
+// dbSelector returns the selector matched by the database, none if the labels are encrypted.
+func (repository *OrderRepositorySQL) dbSelector(selector LabelSelector) LabelSelector {
+	if repository.encrypts(LabelsField) {
+		return nil
+	}
+	return selector
+}
+
+// matching returns the orders whose labels match the selector.
+func matching(orders []Order, selector LabelSelector) []Order {
+	matches := make([]Order, 0, len(orders))
+	for _, order := range orders {
+		if selector.Matches(order.Labels) {
+			matches = append(matches, order)
+		}
+	}
+	return matches
+}
+
+// marshalLabels returns the JSON representation stored in the labels column; orders without labels store an empty object.
+func marshalLabels(labels map[string]string) (string, error) {
+	if labels == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(labels)
+	return string(b), err
+}
+
+// schemaTables are the tables created by the migrations besides the orders table, see CleanUp.
+var schemaTables = []string{ArchiveTable, VersionsTable, IdempotencyTable, OutboxTable, OutboxLeaseTable, AuditTable, DataKeysTable}
+
+// CleanUp removes the schema of the orders table and closes the connection to the database. The orders table is
+// dropped together with the other tables created by the migrations and with the records of the migrations applied,
+// as reverting every migration would, so that the schema is created again when the database is next opened.
 func (repository *OrderRepositorySQL) CleanUp() error {
-	log.Debug("Removing DB table")
+	log.Debug("Removing DB tables")
 
-	if _, err := repository.Database.Exec("DROP TABLE " + SanitizeSQLArg(repository.OrdersTableName)); err != nil {
+	if _, err := repository.Database.Exec("DROP TABLE " + repository.table()); err != nil {
 		return errors.Wrap(err, "while removing the DB table.")
 	}
+	for _, table := range append(schemaTables, MigrationsTable) {
+		if _, err := repository.Database.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return errors.Wrapf(err, "while removing the DB table %s.", table)
+		}
+	}
 	if err := repository.Database.Close(); err != nil {
 		return errors.Wrap(err, "while closing connection to the DB.")
 	}
@@ -144,21 +875,3 @@ var safeSQLRegex = regexp.MustCompile(`[^a-zA-Z0-9\.\-_]`)
 func SanitizeSQLArg(s string) string {
 	return safeSQLRegex.ReplaceAllString(s, "")
 }
-
-func InitDb(conexionString string) (*sql.DB, error) {
-	db, err := sql.Open(config.PostgresDriverName, conexionString)
-	if err != nil {
-		return nil, errors.Wrapf(err, "while establishing connection to '%s'", config.PostgresDriverName)
-	}
-	db.Stats()
-	log.Debug("Testing connection")
-	if err := db.Ping(); err != nil {
-		return nil, errors.Wrap(err, "while testing DB connection")
-	}
-	q := strings.Replace(TableCreationQuery, "{name}", SanitizeSQLArg(DefaultTable), -1)
-	log.Debugf("Ensuring table exists. Running query: '%q'.", q)
-	if _, err := db.Exec(q); err != nil {
-		return nil, errors.Wrap(err, "while initiating DB table")
-	}
-	return db, nil
-}
\ No newline at end of file
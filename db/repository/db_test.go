@@ -5,24 +5,31 @@ import (
 	"testing"
 
 	"database/sql"
+	"database/sql/driver"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yemramirezca/http-db-service/db/retry"
 )
 
 var newOrder = Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
 
+// createdAt matches the creation time written with every inserted order.
+var createdAt = mock.AnythingOfType("int64")
+
 const (
-	parsedInsert = "INSERT INTO tableName (order_id, namespace, total) VALUES ($1, $2, $3)"
-	parsedGet    = "SELECT * FROM tableName"
-	parsedDelete = "DELETE FROM tableName"
+	parsedInsert = `INSERT INTO "tableName" (order_id, namespace, total, labels, sealed, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	parsedGet    = `SELECT order_id, namespace, total, labels, sealed FROM "tableName"`
+	parsedDelete = `DELETE FROM "tableName"`
 )
 
 func TestDbCreateSuccess(t *testing.T) {
 	databaseMock := mockDbQuerier{}
-	repo := OrderRepositorySQL{&databaseMock, "tableName"}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
 
-	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total).Return((sql.Result)(nil), nil)
+	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total, "{}", nil, createdAt).Return((sql.Result)(nil), nil)
 	//when
 	err := repo.InsertOrder(newOrder)
 	//then
@@ -30,15 +37,15 @@ func TestDbCreateSuccess(t *testing.T) {
 }
 
 type primaryKeyViolationError struct{error}
-func (e primaryKeyViolationError) sqlErrorNumber() int32 {
+func (e primaryKeyViolationError) SQLErrorNumber() int32 {
 	return PrimaryKeyViolation
 }
 
 func TestDbCreateDuplicate(t *testing.T) {
 	databaseMock := mockDbQuerier{}
-	repo := OrderRepositorySQL{&databaseMock, "tableName"}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
 
-	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total).
+	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total, "{}", nil, createdAt).
 		Return((sql.Result)(nil), primaryKeyViolationError{})
 	//when
 	err := repo.InsertOrder(newOrder)
@@ -52,9 +59,9 @@ func (e otherSQLError) SQLErrorNumber() int32 {
 }
 func TestDbRepositoryCreateOtherSqlError(t *testing.T) {
 	databaseMock := mockDbQuerier{}
-	repo := OrderRepositorySQL{&databaseMock, "tableName"}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
 
-	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total).
+	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total, "{}", nil, createdAt).
 		Return((sql.Result)(nil), otherSQLError{})
 	//when
 	err := repo.InsertOrder(newOrder)
@@ -64,9 +71,9 @@ func TestDbRepositoryCreateOtherSqlError(t *testing.T) {
 
 func TestDbCreateError(t *testing.T) {
 	databaseMock := mockDbQuerier{}
-	repo := OrderRepositorySQL{&databaseMock, "tableName"}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
 
-	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total).Return((sql.Result)(nil), errors.New("unexpected error"))
+	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total, "{}", nil, createdAt).Return((sql.Result)(nil), errors.New("unexpected error"))
 	//when
 	err := repo.InsertOrder(newOrder)
 	//then
@@ -75,7 +82,7 @@ func TestDbCreateError(t *testing.T) {
 
 func TestDbGetError(t *testing.T) {
 	databaseMock := mockDbQuerier{}
-	repo := OrderRepositorySQL{&databaseMock, "tableName"}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
 
 	databaseMock.On("Query", parsedGet).Return(&sql.Rows{}, errors.New("unexpected error"))
 	//when
@@ -86,12 +93,120 @@ func TestDbGetError(t *testing.T) {
 
 func TestDeleteOrders(t *testing.T) {
 	databaseMock := mockDbQuerier{}
-	repo := OrderRepositorySQL{&databaseMock, "tableName"}
-	databaseMock.On("Exec", parsedDelete).Return((sql.Result)(nil), nil)
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
+	databaseMock.On("Exec", parsedDelete).Return(driver.RowsAffected(2), nil)
+
+	//when
+	deleted, err := repo.DeleteOrders()
+
+	//then
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+}
+
+func TestDbCreateWithLabels(t *testing.T) {
+	databaseMock := mockDbQuerier{}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
+	labeled := Order{OrderId: "orderId1", Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "web"}}
+
+	databaseMock.On("Exec", parsedInsert, labeled.OrderId, labeled.Namespace, labeled.Total, `{"channel":"web"}`, nil, createdAt).Return((sql.Result)(nil), nil)
+	//when
+	err := repo.InsertOrder(labeled)
+	//then
+	assert.NoError(t, err)
+	databaseMock.AssertExpectations(t)
+}
+
+func TestDbGetBySelectorError(t *testing.T) {
+	databaseMock := mockDbQuerier{}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
+	selector := LabelSelector{{Key: "channel", Operator: Equals, Values: []string{"web"}}}
+
+	databaseMock.On("Query", `SELECT order_id, namespace, total, labels, sealed FROM "tableName" WHERE namespace = $1 AND labels->>'channel' = $2`, "N7", "web").
+		Return(&sql.Rows{}, errors.New("unexpected error"))
+	//when
+	_, err := repo.GetOrdersBySelector("N7", selector)
+	//then
+	assert.Error(t, err)
+	databaseMock.AssertExpectations(t)
+}
+
+func TestDeleteOrdersBySelector(t *testing.T) {
+	databaseMock := mockDbQuerier{}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
+	selector, err := ParseLabelSelector("region notin (eu,us),tier!=free")
+	assert.NoError(t, err)
+
+	databaseMock.On("Exec", `DELETE FROM "tableName" WHERE (labels->>'region' IS NULL OR labels->>'region' NOT IN ($1, $2)) AND (labels->>'tier' IS NULL OR labels->>'tier' <> $3)`,
+		"eu", "us", "free").Return(driver.RowsAffected(1), nil)
 
 	//when
-	err := repo.DeleteOrders()
+	_, err = repo.DeleteOrdersBySelector("", selector)
 
 	//then
 	assert.NoError(t, err)
+	databaseMock.AssertExpectations(t)
+}
+
+func TestDbCreatePostgresUniqueViolation(t *testing.T) {
+	databaseMock := mockDbQuerier{}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
+
+	databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total, "{}", nil, createdAt).
+		Return((sql.Result)(nil), &pq.Error{Code: "23505"})
+	//when
+	err := repo.InsertOrder(newOrder)
+	//then
+	assert.Equal(t, ErrDuplicateKey, err)
+}
+
+func TestDbGetUnavailable(t *testing.T) {
+	databaseMock := mockDbQuerier{}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
+
+	databaseMock.On("Query", parsedGet).Return(&sql.Rows{}, driver.ErrBadConn)
+	//when
+	_, err := repo.GetOrders()
+	//then
+	assert.Equal(t, ErrUnavailable, pkgerrors.Cause(err))
+}
+
+func TestDbRetriesTransientErrors(t *testing.T) {
+	databaseMock := mockDbQuerier{}
+	defer databaseMock.AssertExpectations(t)
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName", Retry: retry.Policy{Attempts: 3}}
+
+	t.Run("Reads give up after the last attempt", func(t *testing.T) {
+		databaseMock.On("Query", parsedGet).Return(&sql.Rows{}, &pq.Error{Code: "40001"}).Times(3)
+		//when
+		_, err := repo.GetOrders()
+		//then
+		assert.Equal(t, ErrSerializationFailure, pkgerrors.Cause(err))
+	})
+
+	t.Run("Deletes are retried until they succeed", func(t *testing.T) {
+		databaseMock.On("Exec", parsedDelete).Return((sql.Result)(nil), &pq.Error{Code: "08006"}).Once()
+		databaseMock.On("Exec", parsedDelete).Return(driver.RowsAffected(0), nil).Once()
+		//when
+		_, err := repo.DeleteOrders()
+		//then
+		assert.NoError(t, err)
+	})
+
+	t.Run("Inserts are not retried", func(t *testing.T) {
+		databaseMock.On("Exec", parsedInsert, newOrder.OrderId, newOrder.Namespace, newOrder.Total, "{}", nil, createdAt).
+			Return((sql.Result)(nil), &pq.Error{Code: "08006"}).Once()
+		//when
+		err := repo.InsertOrder(newOrder)
+		//then
+		assert.Equal(t, ErrUnavailable, pkgerrors.Cause(err))
+	})
+
+	t.Run("Permanent errors are not retried", func(t *testing.T) {
+		databaseMock.On("Query", parsedGet).Return(&sql.Rows{}, assert.AnError).Once()
+		//when
+		_, err := repo.GetOrders()
+		//then
+		assert.Equal(t, assert.AnError, pkgerrors.Cause(err))
+	})
 }
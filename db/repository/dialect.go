@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Dialect hides the differences between the SQL flavours of the supported databases from OrderRepositorySQL.
+type Dialect interface {
+	// Name returns the database/sql driver name the dialect is meant for.
+	Name() string
+	// Placeholder returns the bind parameter of the n-th query argument, starting at 1.
+	Placeholder(n int) string
+	// QuoteIdentifier quotes a, possibly schema qualified, table or column name.
+	QuoteIdentifier(name string) string
+	// LabelValue returns an expression evaluating to the text value stored under key in the JSON labels column, or NULL.
+	// The key must be a valid label key, see ValidateLabels.
+	LabelValue(column, key string) string
+	// UpsertQuery returns a statement inserting a row with the given columns, or updating the columns of the row
+	// with the same key columns if there is one, but for the keys and the created column, a BIGINT which is only
+	// written when the row is inserted. Arguments are passed in the order of columns. The statement returns the
+	// created column of the row it wrote and true, unless the dialect cannot return it, the statement then
+	// affecting a single row only when it inserts one.
+	UpsertQuery(table string, columns, keys []string, created string) (string, bool)
+	// InsertIfAbsentQuery returns a statement inserting a row with the given columns unless there is a row with the
+	// same key columns, affecting a single row only when it inserts one. Arguments are passed in the order of columns.
+	InsertIfAbsentQuery(table string, columns, keys []string) string
+}
+
+// DialectFor returns the Dialect of the given database/sql driver name.
+func DialectFor(driverName string) (Dialect, error) {
+	switch driverName {
+	case "postgres":
+		return PostgresDialect{}, nil
+	case "mssql", "sqlserver":
+		return MSSQLDialect{}, nil
+	case "mysql":
+		return MySQLDialect{}, nil
+	case "sqlite", "sqlite3":
+		return SQLiteDialect{}, nil
+	default:
+		return nil, errors.Errorf("no SQL dialect for driver '%s'", driverName)
+	}
+}
+
+// PostgresDialect is the Dialect of PostgreSQL.
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string { return "postgres" }
+
+func (PostgresDialect) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func (PostgresDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, `"`, `"`) }
+
+func (PostgresDialect) LabelValue(column, key string) string {
+	return fmt.Sprintf("%s->>'%s'", column, key)
+}
+
+func (d PostgresDialect) UpsertQuery(table string, columns, keys []string, created string) (string, bool) {
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s RETURNING %s",
+		insertStatement(d, table, columns), strings.Join(keys, ", "), assignments(columns, keys, created, "%s = EXCLUDED.%s"), created), true
+}
+
+func (d PostgresDialect) InsertIfAbsentQuery(table string, columns, keys []string) string {
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING", insertStatement(d, table, columns), strings.Join(keys, ", "))
+}
+
+// MSSQLDialect is the Dialect of Microsoft SQL Server, for the `sqlserver` driver which binds `@pN` parameters.
+type MSSQLDialect struct{}
+
+func (MSSQLDialect) Name() string { return "sqlserver" }
+
+func (MSSQLDialect) Placeholder(n int) string { return fmt.Sprintf("@p%d", n) }
+
+func (MSSQLDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, "[", "]") }
+
+func (MSSQLDialect) LabelValue(column, key string) string {
+	return fmt.Sprintf(`JSON_VALUE(%s, '$."%s"')`, column, key)
+}
+
+// UpsertQuery of SQL Server outputs the created column into a table variable, which is then selected, since the
+// OUTPUT clause cannot return rows by itself from a table with triggers.
+func (d MSSQLDialect) UpsertQuery(table string, columns, keys []string, created string) (string, bool) {
+	var matches []string
+	for _, key := range keys {
+		matches = append(matches, fmt.Sprintf("target.%s = source.%s", key, key))
+	}
+	var sources []string
+	for _, column := range columns {
+		sources = append(sources, "source."+column)
+	}
+	// HOLDLOCK keeps the row, or the range of its key, locked from the match to the write, so that concurrent
+	// merges of the same new row do not both insert it
+	return fmt.Sprintf("DECLARE @written TABLE (%s BIGINT); "+
+		"MERGE INTO %s WITH (HOLDLOCK) AS target USING (VALUES (%s)) AS source (%s) ON %s "+
+		"WHEN MATCHED THEN UPDATE SET %s WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s) OUTPUT inserted.%s INTO @written; "+
+		"SELECT %s FROM @written;",
+		created, d.QuoteIdentifier(table), placeholders(d, 1, len(columns)), strings.Join(columns, ", "), strings.Join(matches, " AND "),
+		assignments(columns, keys, created, "%s = source.%s"), strings.Join(columns, ", "), strings.Join(sources, ", "), created,
+		created), true
+}
+
+func (d MSSQLDialect) InsertIfAbsentQuery(table string, columns, keys []string) string {
+	var matches []string
+	for _, key := range keys {
+		matches = append(matches, fmt.Sprintf("target.%s = source.%s", key, key))
+	}
+	var sources []string
+	for _, column := range columns {
+		sources = append(sources, "source."+column)
+	}
+	return fmt.Sprintf("MERGE INTO %s WITH (HOLDLOCK) AS target USING (VALUES (%s)) AS source (%s) ON %s "+
+		"WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+		d.QuoteIdentifier(table), placeholders(d, 1, len(columns)), strings.Join(columns, ", "), strings.Join(matches, " AND "),
+		strings.Join(columns, ", "), strings.Join(sources, ", "))
+}
+
+// MySQLDialect is the Dialect of MySQL and MariaDB.
+type MySQLDialect struct{}
+
+func (MySQLDialect) Name() string { return "mysql" }
+
+func (MySQLDialect) Placeholder(int) string { return "?" }
+
+func (MySQLDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, "`", "`") }
+
+func (MySQLDialect) LabelValue(column, key string) string {
+	return fmt.Sprintf(`JSON_UNQUOTE(JSON_EXTRACT(%s, '$."%s"'))`, column, key)
+}
+
+// UpsertQuery of MySQL cannot return the row, the statement affects a single row when it inserts one, and two or
+// none when it updates one, depending on whether its values changed.
+func (d MySQLDialect) UpsertQuery(table string, columns, keys []string, created string) (string, bool) {
+	return fmt.Sprintf("%s ON DUPLICATE KEY UPDATE %s",
+		insertStatement(d, table, columns), assignments(columns, keys, created, "%s = VALUES(%s)")), false
+}
+
+// InsertIfAbsentQuery of MySQL assigns the first key to itself on a duplicate key, which affects no row, rather than
+// ignoring the insert with INSERT IGNORE, which would turn other errors into warnings as well.
+func (d MySQLDialect) InsertIfAbsentQuery(table string, columns, keys []string) string {
+	return fmt.Sprintf("%s ON DUPLICATE KEY UPDATE %s = %s", insertStatement(d, table, columns), keys[0], keys[0])
+}
+
+// SQLiteDialect is the Dialect of SQLite.
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Name() string { return "sqlite" }
+
+func (SQLiteDialect) Placeholder(int) string { return "?" }
+
+func (SQLiteDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, `"`, `"`) }
+
+func (SQLiteDialect) LabelValue(column, key string) string {
+	return fmt.Sprintf(`json_extract(%s, '$."%s"')`, column, key)
+}
+
+func (d SQLiteDialect) UpsertQuery(table string, columns, keys []string, created string) (string, bool) {
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s RETURNING %s",
+		insertStatement(d, table, columns), strings.Join(keys, ", "), assignments(columns, keys, created, "%s = excluded.%s"), created), true
+}
+
+func (d SQLiteDialect) InsertIfAbsentQuery(table string, columns, keys []string) string {
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING", insertStatement(d, table, columns), strings.Join(keys, ", "))
+}
+
+// Limit restricts the ordered query to its first n rows.
+func Limit(d Dialect, q string, n int) string {
+	if d.Name() == (MSSQLDialect{}).Name() {
+		return fmt.Sprintf("%s OFFSET 0 ROWS FETCH NEXT %d ROWS ONLY", q, n)
+	}
+	return fmt.Sprintf("%s LIMIT %d", q, n)
+}
+
+// quoteIdentifier quotes every part of a dot separated name, doubling the closing quote where it is used inside the name.
+func quoteIdentifier(name, open, close string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = open + strings.Replace(part, close, close+close, -1) + close
+	}
+	return strings.Join(parts, ".")
+}
+
+// placeholders returns n comma separated bind parameters, the first one being the from-th argument.
+func placeholders(d Dialect, from, n int) string {
+	params := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		params = append(params, d.Placeholder(from+i))
+	}
+	return strings.Join(params, ", ")
+}
+
+func insertStatement(d Dialect, table string, columns []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		d.QuoteIdentifier(table), strings.Join(columns, ", "), placeholders(d, 1, len(columns)))
+}
+
+// assignments formats every column but the keys and the created one with the given format, which receives the
+// column name twice.
+func assignments(columns, keys []string, created, format string) string {
+	var set []string
+	for _, column := range columns {
+		if !contains(keys, column) && column != created {
+			set = append(set, fmt.Sprintf(format, column, column))
+		}
+	}
+	return strings.Join(set, ", ")
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dialectQueries struct {
+	insert          string
+	getNamespace    string
+	getSelector     string
+	deleteNamespace string
+	deleteSelector  string
+	upsert          string
+	returning       bool
+	insertIfAbsent  string
+}
+
+var dialects = map[Dialect]dialectQueries{
+	PostgresDialect{}: {
+		insert:          `INSERT INTO "public"."tableName" (order_id, namespace, total, labels, sealed, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		getNamespace:    `SELECT order_id, namespace, total, labels, sealed FROM "public"."tableName" WHERE namespace = $1`,
+		getSelector:     `SELECT order_id, namespace, total, labels, sealed FROM "public"."tableName" WHERE namespace = $1 AND labels->>'channel' IN ($2, $3) AND (labels->>'region' IS NULL OR labels->>'region' <> $4)`,
+		deleteNamespace: `DELETE FROM "public"."tableName" WHERE namespace = $1`,
+		deleteSelector:  `DELETE FROM "public"."tableName" WHERE labels->>'example.com/tier' = $1`,
+		upsert:          `INSERT INTO "public"."tableName" (order_id, namespace, total, labels, sealed, created_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (order_id, namespace) DO UPDATE SET total = EXCLUDED.total, labels = EXCLUDED.labels, sealed = EXCLUDED.sealed RETURNING created_at`,
+		returning:       true,
+		insertIfAbsent:  `INSERT INTO "public"."tableName" (order_id, namespace, total, labels, sealed, created_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (order_id, namespace) DO NOTHING`,
+	},
+	MSSQLDialect{}: {
+		insert:          `INSERT INTO [public].[tableName] (order_id, namespace, total, labels, sealed, created_at) VALUES (@p1, @p2, @p3, @p4, @p5, @p6)`,
+		getNamespace:    `SELECT order_id, namespace, total, labels, sealed FROM [public].[tableName] WHERE namespace = @p1`,
+		getSelector:     `SELECT order_id, namespace, total, labels, sealed FROM [public].[tableName] WHERE namespace = @p1 AND JSON_VALUE(labels, '$."channel"') IN (@p2, @p3) AND (JSON_VALUE(labels, '$."region"') IS NULL OR JSON_VALUE(labels, '$."region"') <> @p4)`,
+		deleteNamespace: `DELETE FROM [public].[tableName] WHERE namespace = @p1`,
+		deleteSelector:  `DELETE FROM [public].[tableName] WHERE JSON_VALUE(labels, '$."example.com/tier"') = @p1`,
+		upsert: `DECLARE @written TABLE (created_at BIGINT); MERGE INTO [public].[tableName] WITH (HOLDLOCK) AS target USING (VALUES (@p1, @p2, @p3, @p4, @p5, @p6)) AS source (order_id, namespace, total, labels, sealed, created_at) ` +
+			`ON target.order_id = source.order_id AND target.namespace = source.namespace WHEN MATCHED THEN UPDATE SET total = source.total, labels = source.labels, sealed = source.sealed ` +
+			`WHEN NOT MATCHED THEN INSERT (order_id, namespace, total, labels, sealed, created_at) VALUES (source.order_id, source.namespace, source.total, source.labels, source.sealed, source.created_at) ` +
+			`OUTPUT inserted.created_at INTO @written; SELECT created_at FROM @written;`,
+		returning: true,
+		insertIfAbsent: `MERGE INTO [public].[tableName] WITH (HOLDLOCK) AS target USING (VALUES (@p1, @p2, @p3, @p4, @p5, @p6)) AS source (order_id, namespace, total, labels, sealed, created_at) ` +
+			`ON target.order_id = source.order_id AND target.namespace = source.namespace ` +
+			`WHEN NOT MATCHED THEN INSERT (order_id, namespace, total, labels, sealed, created_at) VALUES (source.order_id, source.namespace, source.total, source.labels, source.sealed, source.created_at);`,
+	},
+	MySQLDialect{}: {
+		insert:          "INSERT INTO `public`.`tableName` (order_id, namespace, total, labels, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		getNamespace:    "SELECT order_id, namespace, total, labels, sealed FROM `public`.`tableName` WHERE namespace = ?",
+		getSelector:     "SELECT order_id, namespace, total, labels, sealed FROM `public`.`tableName` WHERE namespace = ? AND JSON_UNQUOTE(JSON_EXTRACT(labels, '$.\"channel\"')) IN (?, ?) AND (JSON_UNQUOTE(JSON_EXTRACT(labels, '$.\"region\"')) IS NULL OR JSON_UNQUOTE(JSON_EXTRACT(labels, '$.\"region\"')) <> ?)",
+		deleteNamespace: "DELETE FROM `public`.`tableName` WHERE namespace = ?",
+		deleteSelector:  "DELETE FROM `public`.`tableName` WHERE JSON_UNQUOTE(JSON_EXTRACT(labels, '$.\"example.com/tier\"')) = ?",
+		upsert:          "INSERT INTO `public`.`tableName` (order_id, namespace, total, labels, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE total = VALUES(total), labels = VALUES(labels), sealed = VALUES(sealed)",
+		insertIfAbsent:  "INSERT INTO `public`.`tableName` (order_id, namespace, total, labels, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE order_id = order_id",
+	},
+	SQLiteDialect{}: {
+		insert:          `INSERT INTO "public"."tableName" (order_id, namespace, total, labels, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		getNamespace:    `SELECT order_id, namespace, total, labels, sealed FROM "public"."tableName" WHERE namespace = ?`,
+		getSelector:     `SELECT order_id, namespace, total, labels, sealed FROM "public"."tableName" WHERE namespace = ? AND json_extract(labels, '$."channel"') IN (?, ?) AND (json_extract(labels, '$."region"') IS NULL OR json_extract(labels, '$."region"') <> ?)`,
+		deleteNamespace: `DELETE FROM "public"."tableName" WHERE namespace = ?`,
+		deleteSelector:  `DELETE FROM "public"."tableName" WHERE json_extract(labels, '$."example.com/tier"') = ?`,
+		upsert:          `INSERT INTO "public"."tableName" (order_id, namespace, total, labels, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (order_id, namespace) DO UPDATE SET total = excluded.total, labels = excluded.labels, sealed = excluded.sealed RETURNING created_at`,
+		returning:       true,
+		insertIfAbsent:  `INSERT INTO "public"."tableName" (order_id, namespace, total, labels, sealed, created_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (order_id, namespace) DO NOTHING`,
+	},
+}
+
+func TestDialectQueries(t *testing.T) {
+	selector, err := ParseLabelSelector("channel in (web,shop),region!=eu")
+	require.NoError(t, err)
+	tierSelector, err := ParseLabelSelector("example.com/tier=gold")
+	require.NoError(t, err)
+
+	for dialect, expected := range dialects {
+		t.Run(dialect.Name(), func(t *testing.T) {
+			databaseMock := mockDbQuerier{}
+			defer databaseMock.AssertExpectations(t)
+			repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "public.tableName", Dialect: dialect}
+
+			databaseMock.On("Exec", expected.insert, newOrder.OrderId, newOrder.Namespace, newOrder.Total, "{}", nil, createdAt).Return((sql.Result)(nil), nil).Once()
+			assert.NoError(t, repo.InsertOrder(newOrder))
+
+			databaseMock.On("Query", expected.getNamespace, "N7").Return((*sql.Rows)(nil), assert.AnError).Once()
+			_, err := repo.GetNamespaceOrders("N7")
+			assert.Error(t, err)
+
+			databaseMock.On("Query", expected.getSelector, "N7", "web", "shop", "eu").Return((*sql.Rows)(nil), assert.AnError).Once()
+			_, err = repo.GetOrdersBySelector("N7", selector)
+			assert.Error(t, err)
+
+			databaseMock.On("Exec", expected.deleteNamespace, "N7").Return(driver.RowsAffected(1), nil).Once()
+			_, err = repo.DeleteNamespaceOrders("N7")
+			assert.NoError(t, err)
+
+			databaseMock.On("Exec", expected.deleteSelector, "gold").Return(driver.RowsAffected(1), nil).Once()
+			_, err = repo.DeleteOrdersBySelector("", tierSelector)
+			assert.NoError(t, err)
+
+			upsert, returning := dialect.UpsertQuery("public.tableName", append(orderColumns, "created_at"), orderKeys, "created_at")
+			assert.Equal(t, expected.upsert, upsert)
+			assert.Equal(t, expected.returning, returning)
+			assert.Equal(t, expected.insertIfAbsent, dialect.InsertIfAbsentQuery("public.tableName", append(orderColumns, "created_at"), orderKeys))
+		})
+	}
+}
+
+func TestDialectFor(t *testing.T) {
+	for driver, expected := range map[string]Dialect{
+		"postgres":  PostgresDialect{},
+		"mssql":     MSSQLDialect{},
+		"sqlserver": MSSQLDialect{},
+		"mysql":     MySQLDialect{},
+		"sqlite":    SQLiteDialect{},
+	} {
+		dialect, err := DialectFor(driver)
+		require.NoError(t, err)
+		assert.Equal(t, expected, dialect)
+	}
+
+	_, err := DialectFor("oracle")
+	assert.Error(t, err)
+}
+
+func TestSelectorRejectsInvalidLabelKey(t *testing.T) {
+	databaseMock := mockDbQuerier{}
+	repo := OrderRepositorySQL{Database: &databaseMock, OrdersTableName: "tableName"}
+
+	// a hand-built selector bypassing ParseLabelSelector must not end up in the query text
+	_, err := repo.GetOrdersBySelector("", LabelSelector{{Key: "x' OR '1'='1", Operator: Equals, Values: []string{"y"}}})
+
+	assert.Error(t, err)
+	assert.Len(t, databaseMock.Calls, 0)
+}
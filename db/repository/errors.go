@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// MSSQL error numbers recognised by TranslateError.
+const (
+	PrimaryKeyViolation  = 2627
+	UniqueIndexViolation = 2601
+	ForeignKeyViolation  = 547
+	NullColumnViolation  = 515
+	DeadlockVictim       = 1205
+	LockRequestTimeout   = 1222
+	DatabaseUnavailable  = 40613
+)
+
+var (
+	// ErrDuplicateKey is thrown when there is an attempt to create an order with an OrderId which already is used.
+	ErrDuplicateKey = errors.New("Duplicate key")
+	// ErrNotFound is returned when the requested data does not exist.
+	ErrNotFound = errors.New("Not found")
+	// ErrUnavailable is returned when the database cannot be reached or refuses connections.
+	ErrUnavailable = errors.New("Database unavailable")
+	// ErrTimeout is returned when the database did not answer in time or cancelled the statement.
+	ErrTimeout = errors.New("Database timeout")
+	// ErrConstraintViolation is returned when the data breaks a constraint other than the primary key.
+	ErrConstraintViolation = errors.New("Constraint violation")
+	// ErrSerializationFailure is returned when a concurrent transaction prevented the statement, which may be retried.
+	ErrSerializationFailure = errors.New("Serialization failure")
+)
+
+// UnavailableError is returned instead of running a statement when the database is known to be failing,
+// for example by a circuit breaker. It is reported like ErrUnavailable, but is not worth retrying before RetryAfter.
+type UnavailableError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %s", ErrUnavailable, e.Reason, e.RetryAfter)
+}
+
+// ErrorTranslator is implemented by dialects which recognise the errors of a specific driver.
+// TranslateError must return err unchanged if it does not recognise it.
+type ErrorTranslator interface {
+	TranslateError(err error) error
+}
+
+// sqlErrorNumber is implemented by the MSSQL driver errors.
+type sqlErrorNumber interface {
+	SQLErrorNumber() int32
+}
+
+// sqlState is implemented by driver errors exposing their five character SQLSTATE code.
+type sqlState interface {
+	SQLState() string
+}
+
+// TranslateError maps driver errors to the repository errors above, so callers can handle them without knowing the driver.
+// Duplicate keys are returned as ErrDuplicateKey itself. Other recognised errors are returned as the repository error
+// wrapped with the driver message, use `errors.Cause` to get the repository error. Unknown errors are returned unchanged.
+func TranslateError(err error) error {
+	if _, ok := errors.Cause(err).(*UnavailableError); ok {
+		return err
+	}
+	kind := classify(err)
+	switch kind {
+	case nil:
+		return err
+	case ErrDuplicateKey:
+		return ErrDuplicateKey
+	case errors.Cause(err):
+		return err
+	default:
+		return errors.Wrap(kind, err.Error())
+	}
+}
+
+// TranslateDialectError maps the driver error to a repository error, see TranslateError,
+// giving the dialect the first chance to recognise errors specific to its driver.
+func TranslateDialectError(d Dialect, err error) error {
+	if translator, ok := d.(ErrorTranslator); ok {
+		if translated := translator.TranslateError(err); translated != err {
+			return translated
+		}
+	}
+	return TranslateError(err)
+}
+
+// IsTransient reports whether err is a failure which may not happen again, such as a lost connection or a
+// serialization failure, so that a statement which is safe to repeat can be retried.
+func IsTransient(err error) bool {
+	if _, ok := errors.Cause(err).(*UnavailableError); ok {
+		return false
+	}
+	kind := classify(err)
+	return kind == ErrUnavailable || kind == ErrSerializationFailure
+}
+
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	cause := errors.Cause(err)
+	switch cause {
+	case ErrDuplicateKey, ErrNotFound, ErrUnavailable, ErrTimeout, ErrConstraintViolation, ErrSerializationFailure:
+		return cause
+	case sql.ErrNoRows:
+		return ErrNotFound
+	case driver.ErrBadConn, sql.ErrConnDone, io.EOF, io.ErrUnexpectedEOF:
+		return ErrUnavailable
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	}
+
+	switch e := cause.(type) {
+	case *UnavailableError:
+		return ErrUnavailable
+	case *pq.Error:
+		return classifySQLState(string(e.Code))
+	case sqlState:
+		return classifySQLState(e.SQLState())
+	case sqlErrorNumber:
+		return classifyMSSQL(e.SQLErrorNumber())
+	case net.Error:
+		if e.Timeout() {
+			return ErrTimeout
+		}
+		return ErrUnavailable
+	}
+
+	if strings.Contains(cause.Error(), "connection reset by peer") || strings.Contains(cause.Error(), "connection refused") {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// classifySQLState maps the standard SQLSTATE codes, as used by PostgreSQL, to repository errors.
+func classifySQLState(code string) error {
+	switch {
+	case code == "23505":
+		return ErrDuplicateKey
+	case strings.HasPrefix(code, "23"):
+		return ErrConstraintViolation
+	case code == "40001" || code == "40P01":
+		return ErrSerializationFailure
+	case code == "57014" || code == "55P03":
+		return ErrTimeout
+	case strings.HasPrefix(code, "08") || strings.HasPrefix(code, "53") || strings.HasPrefix(code, "57P"):
+		return ErrUnavailable
+	}
+	return nil
+}
+
+func classifyMSSQL(number int32) error {
+	switch number {
+	case PrimaryKeyViolation, UniqueIndexViolation:
+		return ErrDuplicateKey
+	case ForeignKeyViolation, NullColumnViolation:
+		return ErrConstraintViolation
+	case DeadlockVictim:
+		return ErrSerializationFailure
+	case LockRequestTimeout:
+		return ErrTimeout
+	case DatabaseUnavailable:
+		return ErrUnavailable
+	}
+	return nil
+}
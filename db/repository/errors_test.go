@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type mssqlError int32
+
+func (e mssqlError) Error() string         { return "mssql error" }
+func (e mssqlError) SQLErrorNumber() int32 { return int32(e) }
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sqlstate error" }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestTranslateError(t *testing.T) {
+	for _, testCase := range []struct {
+		err      error
+		expected error
+	}{
+		{&pq.Error{Code: "23505"}, ErrDuplicateKey},
+		{&pq.Error{Code: "23503"}, ErrConstraintViolation},
+		{&pq.Error{Code: "23514"}, ErrConstraintViolation},
+		{&pq.Error{Code: "40001"}, ErrSerializationFailure},
+		{&pq.Error{Code: "40P01"}, ErrSerializationFailure},
+		{&pq.Error{Code: "57014"}, ErrTimeout},
+		{&pq.Error{Code: "08006"}, ErrUnavailable},
+		{&pq.Error{Code: "57P01"}, ErrUnavailable},
+		{sqlStateError("23505"), ErrDuplicateKey},
+		{mssqlError(PrimaryKeyViolation), ErrDuplicateKey},
+		{mssqlError(UniqueIndexViolation), ErrDuplicateKey},
+		{mssqlError(ForeignKeyViolation), ErrConstraintViolation},
+		{mssqlError(DeadlockVictim), ErrSerializationFailure},
+		{sql.ErrNoRows, ErrNotFound},
+		{driver.ErrBadConn, ErrUnavailable},
+		{errors.Wrap(context.DeadlineExceeded, "while querying"), ErrTimeout},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrUnavailable},
+		{errors.New("read tcp: connection reset by peer"), ErrUnavailable},
+	} {
+		assert.Equal(t, testCase.expected, errors.Cause(TranslateError(testCase.err)), "%#v", testCase.err)
+	}
+}
+
+func TestTranslateErrorKeepsUnknownErrors(t *testing.T) {
+	err := errors.New("unexpected error")
+
+	assert.Equal(t, err, TranslateError(err))
+	assert.Equal(t, mssqlError(2), TranslateError(mssqlError(2)))
+	assert.Nil(t, TranslateError(nil))
+}
+
+func TestTranslateErrorKeepsDriverMessage(t *testing.T) {
+	err := TranslateError(&pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"})
+
+	assert.Equal(t, ErrTimeout, errors.Cause(err))
+	assert.Contains(t, err.Error(), "canceling statement due to statement timeout")
+}
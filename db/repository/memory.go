@@ -1,64 +1,272 @@
 package repository
 
 import (
-	"fmt"
+	"sync"
+	"time"
 )
 
+// orderRepositoryMemory keeps the orders in memory, indexed by namespace and OrderId.
+// It is safe for concurrent use.
 type orderRepositoryMemory struct {
-	Orders map[string]Order
+	mutex  sync.RWMutex
+	orders map[string]map[string]Order
+	// version is incremented on every change, so that snapshots are only written when something changed.
+	version uint64
+	// versions tracks the changes to every namespace and modified the time of the last change. Their counters start
+	// at epoch, the creation time of the repository, so that they never repeat the ones of a previous process.
+	versions map[string]Version
+	modified time.Time
+	epoch    int64
 }
 
 // NewOrderRepositoryMemory is used to instantiate and return the DB implementation of the OrderRepository.
 func NewOrderRepositoryMemory() OrderRepository {
-	return &orderRepositoryMemory{Orders: make(map[string]Order)}
+	return newOrderRepositoryMemory()
+}
+
+func newOrderRepositoryMemory() *orderRepositoryMemory {
+	return &orderRepositoryMemory{
+		orders:   make(map[string]map[string]Order),
+		versions: make(map[string]Version),
+		epoch:    time.Now().UnixNano(),
+	}
 }
 
 func (repository *orderRepositoryMemory) InsertOrder(order Order) error {
-	id := mapID(order)
-	if _, exists := repository.Orders[id]; exists {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	ns, exists := repository.orders[order.Namespace]
+	if !exists {
+		ns = make(map[string]Order)
+		repository.orders[order.Namespace] = ns
+	}
+	if _, exists := ns[order.OrderId]; exists {
 		return ErrDuplicateKey
 	}
-	repository.Orders[id] = order
+	ns[order.OrderId] = order
+	repository.touch(order.Namespace)
+	repository.version++
 	return nil
 }
 
-func (repository *orderRepositoryMemory) GetOrders() ([]Order, error) {
-	ret := make([]Order, 0, len(repository.Orders))
-	for _, order := range repository.Orders {
-		ret = append(ret, order)
+func (repository *orderRepositoryMemory) InsertOrders(orders []Order, skipExisting bool) ([]bool, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	created := make([]bool, 0, len(orders))
+	inserted := make(map[string]map[string]bool)
+	for _, order := range orders {
+		_, exists := repository.orders[order.Namespace][order.OrderId]
+		exists = exists || inserted[order.Namespace][order.OrderId]
+		if exists && !skipExisting {
+			return nil, ErrDuplicateKey
+		}
+		if !exists {
+			if inserted[order.Namespace] == nil {
+				inserted[order.Namespace] = make(map[string]bool)
+			}
+			inserted[order.Namespace][order.OrderId] = true
+		}
+		created = append(created, !exists)
 	}
-	return ret, nil
+	for i, order := range orders {
+		if !created[i] {
+			continue
+		}
+		ns, exists := repository.orders[order.Namespace]
+		if !exists {
+			ns = make(map[string]Order)
+			repository.orders[order.Namespace] = ns
+		}
+		ns[order.OrderId] = order
+		repository.touch(order.Namespace)
+	}
+	repository.version++
+	return created, nil
+}
+
+func (repository *orderRepositoryMemory) UpsertOrders(orders []Order) ([]bool, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	created := make([]bool, 0, len(orders))
+	for _, order := range orders {
+		ns, exists := repository.orders[order.Namespace]
+		if !exists {
+			ns = make(map[string]Order)
+			repository.orders[order.Namespace] = ns
+		}
+		_, exists = ns[order.OrderId]
+		ns[order.OrderId] = order
+		repository.touch(order.Namespace)
+		created = append(created, !exists)
+	}
+	repository.version++
+	return created, nil
+}
+
+func (repository *orderRepositoryMemory) GetOrders() ([]Order, error) {
+	return repository.GetOrdersBySelector("", nil)
 }
 
 func (repository *orderRepositoryMemory) GetNamespaceOrders(ns string) ([]Order, error) {
-	ret := make([]Order, 0, len(repository.Orders))
-	for _, order := range repository.Orders {
-		if order.Namespace == ns {
+	return repository.GetOrdersBySelector(ns, nil)
+}
+
+func (repository *orderRepositoryMemory) GetOrdersBySelector(ns string, selector LabelSelector) ([]Order, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	ret := make([]Order, 0)
+	repository.forEach(ns, func(order Order) {
+		if selector.Matches(order.Labels) {
 			ret = append(ret, order)
 		}
-	}
+	})
 	return ret, nil
 }
 
-func (repository *orderRepositoryMemory) DeleteOrders() error {
-	repository.Orders = make(map[string]Order)
+// StreamOrders passes a copy of the matching orders to fn, so that slow consumers do not hold the lock.
+func (repository *orderRepositoryMemory) StreamOrders(ns string, selector LabelSelector, fn func(Order) error) error {
+	orders, err := repository.GetOrdersBySelector(ns, selector)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (repository *orderRepositoryMemory) DeleteOrders() (int64, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	var deleted int64
+	for ns, orders := range repository.orders {
+		deleted += int64(len(orders))
+		repository.touch(ns)
+	}
+	repository.orders = make(map[string]map[string]Order)
+	repository.version++
+	return deleted, nil
+}
+
 func (repository *orderRepositoryMemory) CleanUp() error {
-	repository.Orders = make(map[string]Order)
-	return nil
+	_, err := repository.DeleteOrders()
+	return err
+}
+
+func (repository *orderRepositoryMemory) DeleteNamespaceOrders(ns string) (int64, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	deleted := int64(len(repository.orders[ns]))
+	if deleted > 0 {
+		repository.touch(ns)
+	}
+	delete(repository.orders, ns)
+	repository.version++
+	return deleted, nil
 }
 
-func (repository *orderRepositoryMemory) DeleteNamespaceOrders(ns string) error {
-	for _, order := range repository.Orders {
-		if order.Namespace == ns {
-			delete(repository.Orders, mapID(order))
+func (repository *orderRepositoryMemory) MoveNamespaceOrders(from, to string, merge bool) (int64, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	source := repository.orders[from]
+	if len(source) == 0 {
+		return 0, ErrNotFound
+	}
+	target, exists := repository.orders[to]
+	if !exists {
+		target = make(map[string]Order)
+	}
+	if len(target) > 0 && !merge {
+		return 0, ErrDuplicateKey
+	}
+	for orderID := range source {
+		if _, exists := target[orderID]; exists {
+			return 0, ErrDuplicateKey
 		}
 	}
-	return nil
+
+	for orderID, order := range source {
+		order.Namespace = to
+		target[orderID] = order
+	}
+	repository.orders[to] = target
+	delete(repository.orders, from)
+	repository.touch(from)
+	repository.touch(to)
+	repository.version++
+	return int64(len(source)), nil
 }
 
-func mapID(o Order) string {
-	return fmt.Sprintf("%s-%s", o.OrderId, o.Namespace)
+func (repository *orderRepositoryMemory) DeleteOrdersBySelector(ns string, selector LabelSelector) (int64, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	var deleted int64
+	repository.forEach(ns, func(order Order) {
+		if selector.Matches(order.Labels) {
+			repository.remove(order)
+			repository.touch(order.Namespace)
+			deleted++
+		}
+	})
+	repository.version++
+	return deleted, nil
+}
+
+// OrdersVersion returns the version of the orders of the namespace, or of all namespaces if ns is empty.
+func (repository *orderRepositoryMemory) OrdersVersion(ns string) (Version, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	if ns != "" {
+		return repository.versions[ns], nil
+	}
+	return Version{Counter: repository.epoch + int64(repository.version), Modified: repository.modified}, nil
+}
+
+// touch records a change to the orders of the namespace. The caller must hold the write lock.
+func (repository *orderRepositoryMemory) touch(ns string) {
+	now := time.Now()
+	version, exists := repository.versions[ns]
+	if !exists {
+		version.Counter = repository.epoch
+	}
+	version.Counter++
+	version.Modified = now
+	repository.versions[ns] = version
+	repository.modified = now
+}
+
+// forEach calls fn for every order of the namespace, or of all namespaces if ns is empty.
+// The caller must hold the lock.
+func (repository *orderRepositoryMemory) forEach(ns string, fn func(Order)) {
+	if ns != "" {
+		for _, order := range repository.orders[ns] {
+			fn(order)
+		}
+		return
+	}
+	for _, orders := range repository.orders {
+		for _, order := range orders {
+			fn(order)
+		}
+	}
+}
+
+// remove deletes a single order, and its namespace once empty. The caller must hold the write lock.
+func (repository *orderRepositoryMemory) remove(order Order) {
+	ns := repository.orders[order.Namespace]
+	delete(ns, order.OrderId)
+	if len(ns) == 0 {
+		delete(repository.orders, order.Namespace)
+	}
 }
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+// orderRepositorySnapshot is an in-memory repository which is periodically written to a snapshot file.
+type orderRepositorySnapshot struct {
+	*orderRepositoryMemory
+	path string
+
+	// snapshotMutex serializes writing the file, written is the repository version it contains.
+	snapshotMutex sync.Mutex
+	written       uint64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewOrderRepositoryMemoryWithSnapshot returns an in-memory OrderRepository which survives restarts. The orders are loaded
+// from the snapshot file at path if it exists, written back to it every interval if they changed, and once more on Close.
+// An interval which is not positive disables the periodic snapshots, the orders being written on Close only.
+func NewOrderRepositoryMemoryWithSnapshot(path string, interval time.Duration) (OrderRepository, error) {
+	repo := &orderRepositorySnapshot{
+		orderRepositoryMemory: newOrderRepositoryMemory(),
+		path:                  path,
+		stop:                  make(chan struct{}),
+		done:                  make(chan struct{}),
+	}
+	if err := repo.load(); err != nil {
+		return nil, err
+	}
+
+	go repo.run(interval)
+	return repo, nil
+}
+
+// Close stops the periodic snapshots and writes a final one.
+func (repository *orderRepositorySnapshot) Close() error {
+	repository.stopSnapshots()
+	return repository.snapshot()
+}
+
+// CleanUp removes every order together with the snapshot file.
+func (repository *orderRepositorySnapshot) CleanUp() error {
+	repository.stopSnapshots()
+	if err := repository.orderRepositoryMemory.CleanUp(); err != nil {
+		return err
+	}
+	if err := os.Remove(repository.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "while removing the snapshot file")
+	}
+	return nil
+}
+
+func (repository *orderRepositorySnapshot) run(interval time.Duration) {
+	defer close(repository.done)
+	if interval <= 0 {
+		<-repository.stop
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := repository.snapshot(); err != nil {
+				log.Errorf("Error writing snapshot of orders to '%s': %s", repository.path, err)
+			}
+		case <-repository.stop:
+			return
+		}
+	}
+}
+
+func (repository *orderRepositorySnapshot) stopSnapshots() {
+	repository.closeOnce.Do(func() {
+		close(repository.stop)
+		<-repository.done
+	})
+}
+
+// load reads the orders of the snapshot file, starting empty if there is none yet.
+func (repository *orderRepositorySnapshot) load() error {
+	b, err := os.ReadFile(repository.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "while reading snapshot '%s'", repository.path)
+	}
+
+	var orders []Order
+	if err := json.Unmarshal(b, &orders); err != nil {
+		return errors.Wrapf(err, "while reading snapshot '%s'", repository.path)
+	}
+	for _, order := range orders {
+		if err := repository.InsertOrder(order); err != nil {
+			return errors.Wrapf(err, "while loading order '%s' of namespace '%s' from snapshot", order.OrderId, order.Namespace)
+		}
+	}
+	repository.written = repository.version
+	log.Infof("Loaded %d orders from snapshot '%s'.", len(orders), repository.path)
+	return nil
+}
+
+// snapshot writes the orders to the snapshot file, unless they did not change since the last snapshot.
+// The file is replaced atomically so that a crash while writing never leaves a truncated snapshot behind.
+func (repository *orderRepositorySnapshot) snapshot() error {
+	repository.snapshotMutex.Lock()
+	defer repository.snapshotMutex.Unlock()
+
+	repository.mutex.RLock()
+	version := repository.version
+	if version == repository.written {
+		repository.mutex.RUnlock()
+		return nil
+	}
+	orders := make([]Order, 0)
+	repository.forEach("", func(order Order) {
+		orders = append(orders, order)
+	})
+	repository.mutex.RUnlock()
+
+	b, err := json.Marshal(orders)
+	if err != nil {
+		return errors.Wrap(err, "while writing snapshot")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(repository.path), filepath.Base(repository.path)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "while writing snapshot")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "while writing snapshot")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "while writing snapshot")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "while writing snapshot")
+	}
+	if err := os.Rename(tmp.Name(), repository.path); err != nil {
+		return errors.Wrap(err, "while writing snapshot")
+	}
+
+	repository.written = version
+	log.Debugf("Wrote %d orders to snapshot '%s'.", len(orders), repository.path)
+	return nil
+}
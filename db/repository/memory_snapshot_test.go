@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemorySnapshotSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	order := Order{OrderId: "orderId1", Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "web"}}
+
+	repo, err := NewOrderRepositoryMemoryWithSnapshot(path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, repo.InsertOrder(order))
+	require.NoError(t, repo.(*orderRepositorySnapshot).Close())
+
+	// restart
+	repo, err = NewOrderRepositoryMemoryWithSnapshot(path, time.Hour)
+	require.NoError(t, err)
+	defer repo.CleanUp()
+
+	resultOrders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []Order{order}, resultOrders)
+	assert.Equal(t, ErrDuplicateKey, repo.InsertOrder(order))
+}
+
+func TestMemorySnapshotWrittenPeriodically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+
+	repo, err := NewOrderRepositoryMemoryWithSnapshot(path, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer repo.CleanUp()
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+
+	assert.Eventually(t, func() bool {
+		b, err := os.ReadFile(path)
+		return err == nil && string(b) == `[{"orderId":"orderId1","namespace":"N7","total":10}]`
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMemorySnapshotWithoutInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	order := Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+
+	repo, err := NewOrderRepositoryMemoryWithSnapshot(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, repo.InsertOrder(order))
+	require.NoError(t, repo.(*orderRepositorySnapshot).Close())
+
+	// restart
+	repo, err = NewOrderRepositoryMemoryWithSnapshot(path, 0)
+	require.NoError(t, err)
+	defer repo.CleanUp()
+
+	resultOrders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []Order{order}, resultOrders)
+}
+
+func TestMemorySnapshotCleanUpRemovesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+
+	repo, err := NewOrderRepositoryMemoryWithSnapshot(path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+	require.NoError(t, repo.(*orderRepositorySnapshot).snapshot())
+	require.FileExists(t, path)
+
+	require.NoError(t, repo.CleanUp())
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemorySnapshotCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+
+	_, err := NewOrderRepositoryMemoryWithSnapshot(path, time.Hour)
+
+	assert.Error(t, err)
+}
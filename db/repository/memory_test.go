@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 
 	_ "github.com/lib/pq"
@@ -81,8 +84,9 @@ func TestMemoryDeleteSuccess(t *testing.T) {
 	assert.Len(t, resultOrders, 1)
 
 	// delete order and ensure it is gone
-	err = repo.DeleteOrders()
+	deleted, err := repo.DeleteOrders()
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
 
 	resultOrders, err = repo.GetOrders()
 	assert.NoError(t, err)
@@ -105,8 +109,9 @@ func TestMemoryDeleteByNamespaceSuccess(t *testing.T) {
 	assert.Len(t, resultOrders, 2)
 
 	// delete in N7
-	err = repo.DeleteNamespaceOrders("N7")
+	deleted, err := repo.DeleteNamespaceOrders("N7")
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
 
 	// No orders in N7
 	resultOrders, err = repo.GetNamespaceOrders("N7")
@@ -119,7 +124,7 @@ func TestMemoryDeleteByNamespaceSuccess(t *testing.T) {
 	assert.Len(t, resultOrders, 1)
 
 	// delete in N8
-	err = repo.DeleteNamespaceOrders("N8")
+	_, err = repo.DeleteNamespaceOrders("N8")
 	assert.NoError(t, err)
 
 	// No orders in N8
@@ -132,3 +137,211 @@ func TestMemoryDeleteByNamespaceSuccess(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, resultOrders, 0)
 }
+
+func TestMemoryGetAndDeleteBySelector(t *testing.T) {
+	repo := NewOrderRepositoryMemory()
+
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "web"}}))
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId2", Namespace: "N7", Total: 10, Labels: map[string]string{"channel": "shop"}}))
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N8", Total: 10, Labels: map[string]string{"channel": "web"}}))
+	selector, err := ParseLabelSelector("channel=web")
+	require.NoError(t, err)
+
+	// 2 web orders in total, 1 in N7
+	resultOrders, err := repo.GetOrdersBySelector("", selector)
+	assert.NoError(t, err)
+	assert.Len(t, resultOrders, 2)
+
+	resultOrders, err = repo.GetOrdersBySelector("N7", selector)
+	assert.NoError(t, err)
+	require.Len(t, resultOrders, 1)
+	assert.Equal(t, "orderId1", resultOrders[0].OrderId)
+
+	// delete web orders in N7 only
+	deleted, err := repo.DeleteOrdersBySelector("N7", selector)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	resultOrders, err = repo.GetNamespaceOrders("N7")
+	assert.NoError(t, err)
+	require.Len(t, resultOrders, 1)
+	assert.Equal(t, "orderId2", resultOrders[0].OrderId)
+
+	resultOrders, err = repo.GetNamespaceOrders("N8")
+	assert.NoError(t, err)
+	assert.Len(t, resultOrders, 1)
+}
+
+func TestMemoryStreamOrders(t *testing.T) {
+	repo := NewOrderRepositoryMemory()
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N8", Total: 10}))
+
+	var streamed []Order
+	err := StreamOrders(repo, "N8", nil, func(order Order) error {
+		streamed = append(streamed, order)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	require.Len(t, streamed, 1)
+	assert.Equal(t, "N8", streamed[0].Namespace)
+}
+
+func TestStreamOrdersStopsOnError(t *testing.T) {
+	repoMock := MockOrderRepository{}
+	repoMock.On("GetOrders").Return([]Order{{OrderId: "orderId1"}, {OrderId: "orderId2"}}, nil).Once()
+	stop := errors.New("client gone")
+
+	calls := 0
+	err := StreamOrders(&repoMock, "", nil, func(order Order) error {
+		calls++
+		return stop
+	})
+
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+	repoMock.AssertExpectations(t)
+}
+
+func TestMemoryConcurrentAccess(t *testing.T) {
+	repo := NewOrderRepositoryMemory()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ns := fmt.Sprintf("N%d", i%4)
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, repo.InsertOrder(Order{OrderId: fmt.Sprintf("order-%d-%d", i, j), Namespace: ns, Total: 10}))
+				_, err := repo.GetNamespaceOrders(ns)
+				assert.NoError(t, err)
+				_, err = repo.GetOrders()
+				assert.NoError(t, err)
+			}
+			if i%5 == 0 {
+				_, err := repo.DeleteNamespaceOrders(ns)
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	resultOrders, err := repo.GetOrders()
+	assert.NoError(t, err)
+	assert.True(t, len(resultOrders) <= 1000)
+}
+
+func TestMemoryUpsertOrders(t *testing.T) {
+	repo := NewOrderRepositoryMemory()
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+
+	// when
+	replaced := Order{OrderId: "orderId1", Namespace: "N7", Total: 20, Labels: map[string]string{"channel": "web"}}
+	created := Order{OrderId: "orderId2", Namespace: "N7", Total: 30}
+	result, err := repo.UpsertOrders([]Order{replaced, created})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, result)
+	orders, err := repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Order{replaced, created}, orders)
+}
+
+func TestMemoryInsertOrders(t *testing.T) {
+	existing := Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	repo := NewOrderRepositoryMemory()
+	require.NoError(t, repo.InsertOrder(existing))
+	created := Order{OrderId: "orderId2", Namespace: "N7", Total: 30}
+	orders := []Order{{OrderId: "orderId1", Namespace: "N7", Total: 20}, created}
+
+	// when
+	_, err := repo.InsertOrders(orders, false)
+
+	// then
+	assert.Equal(t, ErrDuplicateKey, err)
+	read, err := repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.Equal(t, []Order{existing}, read)
+
+	// when
+	result, err := repo.InsertOrders(orders, true)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, result)
+	read, err = repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Order{existing, created}, read)
+}
+
+func TestMemoryOrdersVersion(t *testing.T) {
+	repo := NewOrderRepositoryMemory()
+	versioner := repo.(OrderVersioner)
+	unknown, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.Equal(t, Version{}, unknown)
+	all, err := versioner.OrdersVersion("")
+	require.NoError(t, err)
+
+	// when
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+
+	// then
+	n7, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.NotZero(t, n7.Counter)
+	assert.False(t, n7.Modified.IsZero())
+	changed, err := versioner.OrdersVersion("")
+	require.NoError(t, err)
+	assert.NotEqual(t, all.Counter, changed.Counter)
+
+	// when
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId1", Namespace: "N8", Total: 10}))
+	_, err = repo.DeleteNamespaceOrders("N9")
+	require.NoError(t, err)
+
+	// then
+	unchanged, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.Equal(t, n7, unchanged)
+	unknown, err = versioner.OrdersVersion("N9")
+	require.NoError(t, err)
+	assert.Equal(t, Version{}, unknown)
+}
+
+func TestMemoryMoveNamespaceOrders(t *testing.T) {
+	repo := NewOrderRepositoryMemory()
+	for _, order := range []Order{{OrderId: "orderId1", Namespace: "N7", Total: 10}, {OrderId: "orderId2", Namespace: "N7", Total: 20}, {OrderId: "orderId1", Namespace: "N8", Total: 30}} {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+
+	// when
+	_, notFound := repo.MoveNamespaceOrders("N9", "N10", false)
+	_, renamed := repo.MoveNamespaceOrders("N7", "N8", false)
+	_, merged := repo.MoveNamespaceOrders("N7", "N8", true)
+	moved, err := repo.MoveNamespaceOrders("N7", "N9", false)
+
+	// then
+	assert.Equal(t, ErrNotFound, notFound)
+	assert.Equal(t, ErrDuplicateKey, renamed, "N8 has orders")
+	assert.Equal(t, ErrDuplicateKey, merged, "orderId1 is in both namespaces")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), moved)
+	orders, err := repo.GetNamespaceOrders("N9")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Order{{OrderId: "orderId1", Namespace: "N9", Total: 10}, {OrderId: "orderId2", Namespace: "N9", Total: 20}}, orders)
+
+	// when
+	require.NoError(t, repo.InsertOrder(Order{OrderId: "orderId3", Namespace: "N10", Total: 40}))
+	moved, err = repo.MoveNamespaceOrders("N10", "N9", true)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), moved)
+	namespaces, err := Namespaces(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []Namespace{{Name: "N8", Orders: 1, Total: 30}, {Name: "N9", Orders: 3, Total: 70}}, namespaces)
+}
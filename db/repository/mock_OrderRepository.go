@@ -23,31 +23,66 @@ func (_m *MockOrderRepository) CleanUp() error {
 }
 
 // DeleteNamespaceOrders provides a mock function with given fields: ns
-func (_m *MockOrderRepository) DeleteNamespaceOrders(ns string) error {
+func (_m *MockOrderRepository) DeleteNamespaceOrders(ns string) (int64, error) {
 	ret := _m.Called(ns)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(string) int64); ok {
 		r0 = rf(ns)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(int64)
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(ns)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // DeleteOrders provides a mock function with given fields:
-func (_m *MockOrderRepository) DeleteOrders() error {
+func (_m *MockOrderRepository) DeleteOrders() (int64, error) {
 	ret := _m.Called()
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
 		r0 = rf()
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(int64)
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteOrdersBySelector provides a mock function with given fields: ns, selector
+func (_m *MockOrderRepository) DeleteOrdersBySelector(ns string, selector LabelSelector) (int64, error) {
+	ret := _m.Called(ns, selector)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(string, LabelSelector) int64); ok {
+		r0 = rf(ns, selector)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, LabelSelector) error); ok {
+		r1 = rf(ns, selector)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // GetNamespaceOrders provides a mock function with given fields: ns
@@ -96,6 +131,96 @@ func (_m *MockOrderRepository) GetOrders() ([]Order, error) {
 	return r0, r1
 }
 
+// GetOrdersBySelector provides a mock function with given fields: ns, selector
+func (_m *MockOrderRepository) GetOrdersBySelector(ns string, selector LabelSelector) ([]Order, error) {
+	ret := _m.Called(ns, selector)
+
+	var r0 []Order
+	if rf, ok := ret.Get(0).(func(string, LabelSelector) []Order); ok {
+		r0 = rf(ns, selector)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Order)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, LabelSelector) error); ok {
+		r1 = rf(ns, selector)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MoveNamespaceOrders provides a mock function with given fields: from, to, merge
+func (_m *MockOrderRepository) MoveNamespaceOrders(from string, to string, merge bool) (int64, error) {
+	ret := _m.Called(from, to, merge)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(string, string, bool) int64); ok {
+		r0 = rf(from, to, merge)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, bool) error); ok {
+		r1 = rf(from, to, merge)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InsertOrders provides a mock function with given fields: orders, skipExisting
+func (_m *MockOrderRepository) InsertOrders(orders []Order, skipExisting bool) ([]bool, error) {
+	ret := _m.Called(orders, skipExisting)
+
+	var r0 []bool
+	if rf, ok := ret.Get(0).(func([]Order, bool) []bool); ok {
+		r0 = rf(orders, skipExisting)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]bool)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]Order, bool) error); ok {
+		r1 = rf(orders, skipExisting)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpsertOrders provides a mock function with given fields: orders
+func (_m *MockOrderRepository) UpsertOrders(orders []Order) ([]bool, error) {
+	ret := _m.Called(orders)
+
+	var r0 []bool
+	if rf, ok := ret.Get(0).(func([]Order) []bool); ok {
+		r0 = rf(orders)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]bool)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]Order) error); ok {
+		r1 = rf(orders)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // InsertOrder provides a mock function with given fields: o
 func (_m *MockOrderRepository) InsertOrder(o Order) error {
 	ret := _m.Called(o)
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Operator is the comparison used by a single label selector Requirement.
+type Operator string
+
+const (
+	// Equals matches orders whose label has the given value.
+	Equals Operator = "="
+	// NotEquals matches orders whose label is missing or has a different value.
+	NotEquals Operator = "!="
+	// In matches orders whose label has one of the given values.
+	In Operator = "in"
+	// NotIn matches orders whose label is missing or has none of the given values.
+	NotIn Operator = "notin"
+)
+
+const maxLabelLength = 63
+
+var labelRegex = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_./]*[A-Za-z0-9])?)?$`)
+
+// Requirement is a single `key <op> values` condition of a LabelSelector.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// LabelSelector filters orders by their labels, following the Kubernetes label selector semantics.
+// An order matches the selector when it matches every Requirement; an empty selector matches every order.
+type LabelSelector []Requirement
+
+// ParseLabelSelector parses a selector such as `channel=web,region in (eu,us),tier!=free`.
+// Supported operators are `=` (or `==`), `!=`, `in` and `notin`.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, term := range splitTerms(s) {
+		if strings.TrimSpace(term) == "" {
+			continue
+		}
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid label selector term '%s'", strings.TrimSpace(term))
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// Matches reports whether the given labels satisfy every requirement of the selector.
+func (selector LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range selector {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether the given labels satisfy the requirement.
+func (req Requirement) Matches(labels map[string]string) bool {
+	value, exists := labels[req.Key]
+	switch req.Operator {
+	case Equals, In:
+		return exists && contains(req.Values, value)
+	case NotEquals, NotIn:
+		return !exists || !contains(req.Values, value)
+	default:
+		return false
+	}
+}
+
+// String returns the selector in its canonical textual form.
+func (selector LabelSelector) String() string {
+	terms := make([]string, 0, len(selector))
+	for _, req := range selector {
+		terms = append(terms, req.String())
+	}
+	return strings.Join(terms, ",")
+}
+
+// String returns the requirement in its canonical textual form.
+func (req Requirement) String() string {
+	switch req.Operator {
+	case In, NotIn:
+		values := append([]string(nil), req.Values...)
+		sort.Strings(values)
+		return fmt.Sprintf("%s %s (%s)", req.Key, req.Operator, strings.Join(values, ","))
+	default:
+		return fmt.Sprintf("%s%s%s", req.Key, req.Operator, strings.Join(req.Values, ""))
+	}
+}
+
+// ValidateLabels checks that every label key and value is a valid Kubernetes-style label.
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if err := validateLabelKey(key); err != nil {
+			return err
+		}
+		if err := validateLabelValue(value); err != nil {
+			return errors.Wrapf(err, "label '%s'", key)
+		}
+	}
+	return nil
+}
+
+// FormatLabels writes the labels as comma-separated `key=value` pairs sorted by key, which ParseLabels reads back.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseLabels reads labels written as comma-separated `key=value` pairs and validates them, see ValidateLabels.
+// It returns nil for an empty string.
+func ParseLabels(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, errors.Errorf("label '%s' must be written as key=value", strings.TrimSpace(pair))
+		}
+		key, value := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if _, exists := labels[key]; exists {
+			return nil, errors.Errorf("label '%s' is given twice", key)
+		}
+		labels[key] = value
+	}
+	if err := ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// splitTerms splits the selector on commas which are not inside a value list.
+func splitTerms(s string) []string {
+	var (
+		terms []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	term = strings.TrimSpace(term)
+
+	if i := strings.Index(term, "!="); i >= 0 {
+		return newRequirement(term[:i], NotEquals, []string{term[i+2:]})
+	}
+	if i := strings.Index(term, "=="); i >= 0 {
+		return newRequirement(term[:i], Equals, []string{term[i+2:]})
+	}
+	if i := strings.Index(term, "="); i >= 0 {
+		return newRequirement(term[:i], Equals, []string{term[i+1:]})
+	}
+
+	fields := strings.Fields(term)
+	if len(fields) < 2 {
+		return Requirement{}, errors.New("expected one of '=', '!=', 'in' or 'notin'")
+	}
+	op := Operator(fields[1])
+	if op != In && op != NotIn {
+		return Requirement{}, errors.Errorf("unsupported operator '%s'", fields[1])
+	}
+
+	list := strings.TrimSpace(strings.Join(fields[2:], " "))
+	if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+		return Requirement{}, errors.Errorf("values for '%s' must be enclosed in parentheses", op)
+	}
+	return newRequirement(fields[0], op, strings.Split(list[1:len(list)-1], ","))
+}
+
+func newRequirement(key string, op Operator, values []string) (Requirement, error) {
+	key = strings.TrimSpace(key)
+	if err := validateLabelKey(key); err != nil {
+		return Requirement{}, err
+	}
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+		if err := validateLabelValue(values[i]); err != nil {
+			return Requirement{}, err
+		}
+	}
+	return Requirement{Key: key, Operator: op, Values: values}, nil
+}
+
+func validateLabelKey(key string) error {
+	if key == "" {
+		return errors.New("label key cannot be empty")
+	}
+	if len(key) > maxLabelLength || !labelRegex.MatchString(key) {
+		return errors.Errorf("invalid label key '%s'", key)
+	}
+	return nil
+}
+
+func validateLabelValue(value string) error {
+	if len(value) > maxLabelLength || !labelRegex.MatchString(value) {
+		return errors.Errorf("invalid label value '%s'", value)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
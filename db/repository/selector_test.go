@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("channel=web, region in (eu, us),tier!=free,env notin (dev),team==core")
+
+	require.NoError(t, err)
+	assert.Equal(t, LabelSelector{
+		{Key: "channel", Operator: Equals, Values: []string{"web"}},
+		{Key: "region", Operator: In, Values: []string{"eu", "us"}},
+		{Key: "tier", Operator: NotEquals, Values: []string{"free"}},
+		{Key: "env", Operator: NotIn, Values: []string{"dev"}},
+		{Key: "team", Operator: Equals, Values: []string{"core"}},
+	}, selector)
+	assert.Equal(t, "channel=web,region in (eu,us),tier!=free,env notin (dev),team=core", selector.String())
+}
+
+func TestParseEmptyLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("")
+
+	require.NoError(t, err)
+	assert.Len(t, selector, 0)
+	assert.True(t, selector.Matches(nil))
+}
+
+func TestParseInvalidLabelSelector(t *testing.T) {
+	for _, s := range []string{"channel", "channel exists (web)", "region in eu", "=web", "channel=we b", "region in (eu,'us')"} {
+		_, err := ParseLabelSelector(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"channel": "web", "region": "eu"}
+
+	for s, expected := range map[string]bool{
+		"channel=web":                 true,
+		"channel=shop":                false,
+		"channel!=shop":               true,
+		"tier!=free":                  true,
+		"region in (eu,us)":           true,
+		"region in (us)":              false,
+		"tier in (free)":              false,
+		"region notin (us)":           true,
+		"region notin (eu)":           false,
+		"tier notin (free)":           true,
+		"channel=web,region in (us)":  false,
+		"channel=web,region notin ()": true,
+	} {
+		selector, err := ParseLabelSelector(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, selector.Matches(labels), s)
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, ValidateLabels(map[string]string{"channel": "web", "example.com/tier": "", "a_b": "c-d"}))
+	assert.Error(t, ValidateLabels(map[string]string{"": "web"}))
+	assert.Error(t, ValidateLabels(map[string]string{"channel": "-web"}))
+	assert.Error(t, ValidateLabels(map[string]string{"channel": "we b"}))
+}
+
+func TestFormatAndParseLabels(t *testing.T) {
+	labels := map[string]string{"region": "eu", "channel": "web", "example.com/tier": ""}
+
+	s := FormatLabels(labels)
+
+	assert.Equal(t, "channel=web,example.com/tier=,region=eu", s)
+	parsed, err := ParseLabels(s)
+	require.NoError(t, err)
+	assert.Equal(t, labels, parsed)
+}
+
+func TestParseEmptyLabels(t *testing.T) {
+	labels, err := ParseLabels(" ")
+
+	require.NoError(t, err)
+	assert.Nil(t, labels)
+	assert.Equal(t, "", FormatLabels(nil))
+}
+
+func TestParseInvalidLabels(t *testing.T) {
+	for _, s := range []string{"channel", "channel=web,channel=shop", "channel=we b", "=web", "channel=web,"} {
+		_, err := ParseLabels(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestValidateNamespace(t *testing.T) {
+	for _, name := range []string{"n7", "test-namespace", "7", strings.Repeat("a", 63)} {
+		assert.NoError(t, ValidateNamespace(name), name)
+	}
+	for _, name := range []string{"", "N7", "-n7", "n7-", "n_7", "n.7", "n 7", strings.Repeat("a", 64)} {
+		assert.Error(t, ValidateNamespace(name), name)
+	}
+}
@@ -0,0 +1,397 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/cache"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+const (
+	// expiredQuery selects the oldest orders of a namespace created before a time, the orders whose creation time
+	// is unknown being left alone.
+	expiredQuery    = "SELECT order_id FROM %s WHERE namespace = %s AND created_at > 0 AND created_at < %s ORDER BY created_at, order_id"
+	namespacesQuery = "SELECT DISTINCT namespace FROM %s WHERE created_at > 0 AND created_at < %s"
+	// archiveQuery and deleteQuery check the creation time again, so that an order deleted and created again since
+	// it was selected is left alone.
+	archiveQuery = "INSERT INTO %s (order_id, namespace, total, labels, sealed, created_at, archived_at) " +
+		"SELECT order_id, namespace, total, labels, sealed, created_at, %s FROM %s " +
+		"WHERE namespace = %s AND order_id IN (%s) AND created_at > 0 AND created_at < %s"
+	deleteQuery = "DELETE FROM %s WHERE namespace = %s AND order_id IN (%s) AND created_at > 0 AND created_at < %s"
+)
+
+// Settings tune how a Job moves the orders.
+type Settings struct {
+	// Interval is waited between two runs.
+	Interval time.Duration
+	// BatchSize limits the orders moved in a single transaction.
+	BatchSize int
+}
+
+// SettingsFor returns the Settings configured in cfg.
+func SettingsFor(cfg config.Config) Settings {
+	return Settings{Interval: cfg.RetentionInterval, BatchSize: cfg.RetentionBatchSize}
+}
+
+// Validate returns an error if the settings cannot run a Job: an Interval or a BatchSize which is not positive
+// would make it run without pause.
+func (s Settings) Validate() error {
+	if s.Interval <= 0 {
+		return errors.Errorf("the retention interval must be positive, not %s", s.Interval)
+	}
+	if s.BatchSize <= 0 {
+		return errors.Errorf("the retention batch size must be positive, not %d", s.BatchSize)
+	}
+	return nil
+}
+
+// Status reports the runs of a Job.
+type Status struct {
+	Name     string
+	Policies []string
+	// LastRun is when the last run started, it is left out before the first run.
+	LastRun      *time.Time    `json:",omitempty"`
+	LastDuration time.Duration `json:",omitempty"`
+	// Namespaces are the namespaces handled by the last run, with the orders moved out of each.
+	Namespaces []NamespaceStatus
+	// Archived and Deleted count the orders moved out by every run since the service started.
+	Archived int64
+	Deleted  int64
+	// FailedRuns counts the runs which failed to handle a namespace.
+	FailedRuns int
+}
+
+// NamespaceStatus reports the orders moved out of a namespace by a run, and why the run stopped if it failed.
+// The orders moved by the batches committed before a failure are counted.
+type NamespaceStatus struct {
+	Namespace string
+	Action    Action
+	Rows      int64
+	Error     string `json:",omitempty"`
+}
+
+// Job applies the retention policies of an end-user to the orders table of its database. The replicas of the service
+// sharing a database should not all run its job: the transaction of a replica archiving the orders another one
+// just archived fails, without losing orders, and is reported as an error.
+type Job struct {
+	name     string
+	db       repository.DBQuerier
+	dialect  repository.Dialect
+	table    string
+	policies []Policy
+	settings Settings
+	now      func() time.Time
+	// audits and caches, when set, record the batches of the job and drop the cached listings of the namespaces
+	// whose orders it moved out, since it changes the orders bypassing the repository.
+	audits *audit.Registry
+	caches *cache.Registry
+
+	mutex  sync.Mutex
+	status Status
+}
+
+// NewJob creates a Job applying the policies to the orders table of the database, whose queries are written in the
+// SQL flavour of the dialect.
+func NewJob(name string, db repository.DBQuerier, dialect repository.Dialect, table string, policies []Policy, settings Settings) *Job {
+	status := Status{Name: name, Policies: make([]string, 0, len(policies)), Namespaces: make([]NamespaceStatus, 0)}
+	for _, policy := range policies {
+		status.Policies = append(status.Policies, policy.String())
+	}
+	table = dialect.QuoteIdentifier(repository.SanitizeSQLArg(table))
+	return &Job{name: name, db: db, dialect: dialect, table: table, policies: policies, settings: settings, now: time.Now, status: status}
+}
+
+// For creates the Job of the repository's database, named after the database.
+// It returns false if there are no policies or the repository is not an SQL one.
+func For(name string, repo repository.OrderRepository, policies []Policy, settings Settings) (*Job, bool) {
+	sqlRepo, ok := repo.(*repository.OrderRepositorySQL)
+	if !ok || len(policies) == 0 {
+		return nil, false
+	}
+	dialect := sqlRepo.Dialect
+	if dialect == nil {
+		dialect = repository.PostgresDialect{}
+	}
+	return NewJob(name, sqlRepo.Database, dialect, sqlRepo.OrdersTableName, policies, settings), true
+}
+
+// Run applies the policies every Interval until the context is done.
+func (j *Job) Run(ctx context.Context) {
+	log.Infof("Applying the retention policies of %s", j.name)
+	ticker := time.NewTicker(j.settings.Interval)
+	defer ticker.Stop()
+	for {
+		j.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies the policies once and returns the updated status of the job.
+// A namespace failing does not stop the run, the other namespaces are still handled.
+func (j *Job) RunOnce(ctx context.Context) Status {
+	started := j.now()
+	results := j.run(ctx, started)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.status.LastRun = &started
+	j.status.LastDuration = j.now().Sub(started)
+	j.status.Namespaces = results
+	failed := false
+	for _, result := range results {
+		switch result.Action {
+		case Archive:
+			j.status.Archived += result.Rows
+		case Delete:
+			j.status.Deleted += result.Rows
+		}
+		if result.Error != "" {
+			failed = true
+			log.Warnf("Applying the retention policy of %s to namespace %s failed: %s", j.name, result.Namespace, result.Error)
+		} else if result.Rows > 0 {
+			log.Infof("Retention policy of %s: %d orders of namespace %s moved out (%s)", j.name, result.Rows, result.Namespace, result.Action)
+		}
+	}
+	if failed {
+		j.status.FailedRuns++
+	}
+	return j.copyStatus()
+}
+
+// Status returns the status of the job.
+func (j *Job) Status() Status {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.copyStatus()
+}
+
+// copyStatus returns a copy of the status which is not changed by the next run. The caller must hold the lock.
+func (j *Job) copyStatus() Status {
+	status := j.status
+	status.Namespaces = append(make([]NamespaceStatus, 0, len(j.status.Namespaces)), j.status.Namespaces...)
+	return status
+}
+
+// run applies the policy of every namespace, the orders created before now minus the age of the policy being moved.
+func (j *Job) run(ctx context.Context, now time.Time) []NamespaceStatus {
+	var wildcard *Policy
+	explicit := make(map[string]bool)
+	results := make([]NamespaceStatus, 0, len(j.policies))
+	for i, policy := range j.policies {
+		if policy.Namespace == AnyNamespace {
+			wildcard = &j.policies[i]
+			continue
+		}
+		explicit[policy.Namespace] = true
+		results = append(results, j.apply(ctx, policy.Namespace, policy, now))
+	}
+	if wildcard == nil {
+		return results
+	}
+
+	namespaces, err := j.expiredNamespaces(now.Add(-wildcard.MaxAge))
+	if err != nil {
+		return append(results, NamespaceStatus{Namespace: AnyNamespace, Action: wildcard.Action, Error: err.Error()})
+	}
+	for _, ns := range namespaces {
+		if !explicit[ns] {
+			results = append(results, j.apply(ctx, ns, *wildcard, now))
+		}
+	}
+	return results
+}
+
+// apply moves the expired orders of the namespace batch after batch, until none is left or the context is done.
+func (j *Job) apply(ctx context.Context, ns string, policy Policy, now time.Time) NamespaceStatus {
+	result := NamespaceStatus{Namespace: ns, Action: policy.Action}
+	cutoff := now.Add(-policy.MaxAge)
+	for {
+		if err := ctx.Err(); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		moved, err := j.batch(ns, policy.Action, cutoff, now)
+		result.Rows += moved
+		if moved > 0 || err != nil {
+			j.record(ns, policy.Action, moved, err)
+		}
+		if moved > 0 && j.caches != nil {
+			j.caches.Invalidate(j.name, ns)
+		}
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if moved < int64(j.settings.BatchSize) {
+			return result
+		}
+	}
+}
+
+// record adds the entry of a batch to the audit log of the database, failing to do so being logged only.
+func (j *Job) record(ns string, action Action, moved int64, err error) {
+	if j.audits == nil {
+		return
+	}
+	auditLog := j.audits.Log(j.name)
+	if auditLog == nil {
+		return
+	}
+	entry := audit.Entry{Time: j.now().UTC(), EndUser: j.name, Operation: audit.ArchiveExpiredOrders, Namespace: ns, Rows: moved}
+	if action == Delete {
+		entry.Operation = audit.DeleteExpiredOrders
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if err := auditLog.Record(entry); err != nil {
+		log.Errorf("Error recording audit entry '%+v'. %s", entry, err)
+	}
+}
+
+// expiredNamespaces returns the namespaces having orders created before the cutoff.
+func (j *Job) expiredNamespaces(cutoff time.Time) ([]string, error) {
+	rows, err := j.db.Query(fmt.Sprintf(namespacesQuery, j.table, j.dialect.Placeholder(1)), cutoff.UnixNano())
+	if err != nil {
+		return nil, errors.Wrap(repository.TranslateDialectError(j.dialect, err), "while reading the namespaces with expired orders")
+	}
+	defer rows.Close()
+
+	namespaces := make([]string, 0)
+	for rows.Next() {
+		var ns string
+		if err := rows.Scan(&ns); err != nil {
+			return nil, errors.Wrap(repository.TranslateDialectError(j.dialect, err), "while reading the namespaces with expired orders")
+		}
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces, errors.Wrap(repository.TranslateDialectError(j.dialect, rows.Err()), "while reading the namespaces with expired orders")
+}
+
+// batch moves up to BatchSize of the oldest orders of the namespace created before the cutoff in a transaction,
+// and returns how many were moved.
+func (j *Job) batch(ns string, action Action, cutoff, now time.Time) (int64, error) {
+	beginner, ok := j.db.(repository.TxBeginner)
+	if !ok {
+		return 0, errors.New("the database does not support the transactions required by the retention policies")
+	}
+	tx, err := beginner.Begin()
+	if err != nil {
+		return 0, errors.Wrap(repository.TranslateDialectError(j.dialect, err), "while moving expired orders")
+	}
+	// rolling back a committed transaction does nothing
+	defer tx.Rollback()
+
+	d := j.dialect
+	q := repository.Limit(d, fmt.Sprintf(expiredQuery, j.table, d.Placeholder(1), d.Placeholder(2)), j.settings.BatchSize)
+	ids, err := expiredOrders(tx.Query(q, ns, cutoff.UnixNano()))
+	if err != nil || len(ids) == 0 {
+		return 0, errors.Wrap(repository.TranslateDialectError(j.dialect, err), "while reading expired orders")
+	}
+
+	if action == Archive {
+		q := fmt.Sprintf(archiveQuery, repository.ArchiveTable, d.Placeholder(1), j.table, d.Placeholder(2),
+			placeholders(d, 3, len(ids)), d.Placeholder(len(ids)+3))
+		args := append(append([]interface{}{now.UnixNano(), ns}, ids...), cutoff.UnixNano())
+		if _, err := tx.Exec(q, args...); err != nil {
+			return 0, errors.Wrap(repository.TranslateDialectError(j.dialect, err), "while archiving expired orders")
+		}
+	}
+	q = fmt.Sprintf(deleteQuery, j.table, d.Placeholder(1), placeholders(d, 2, len(ids)), d.Placeholder(len(ids)+2))
+	result, err := tx.Exec(q, append(append([]interface{}{ns}, ids...), cutoff.UnixNano())...)
+	if err != nil {
+		return 0, errors.Wrap(repository.TranslateDialectError(j.dialect, err), "while deleting expired orders")
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "while deleting expired orders")
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(repository.TranslateDialectError(j.dialect, err), "while moving expired orders")
+	}
+	return moved, nil
+}
+
+// expiredOrders reads the IDs of the orders selected by expiredQuery, closing the rows so that the transaction
+// can run the next statements.
+func expiredOrders(rows *sql.Rows, err error) ([]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]interface{}, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// placeholders returns the comma-separated bind parameters of n query arguments, starting at the from-th argument.
+func placeholders(d repository.Dialect, from, n int) string {
+	params := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		params = append(params, d.Placeholder(from+i))
+	}
+	return strings.Join(params, ", ")
+}
+
+// Registry keeps the jobs of the service, so that their status can be reported. It is safe for concurrent use.
+type Registry struct {
+	// Audits and Caches are where the jobs record their batches and drop the cached listings of the namespaces
+	// whose orders they moved out, found by the name of the job. Either can be nil.
+	Audits *audit.Registry
+	Caches *cache.Registry
+
+	mutex sync.Mutex
+	jobs  []*Job
+}
+
+// Default is the Registry of the jobs of the tenant databases, recording in the Default audit logs and dropping
+// listings from the Default caches.
+var Default = &Registry{Audits: audit.Default, Caches: cache.Default}
+
+// New creates the Job of the repository's database like For, and registers it.
+func (r *Registry) New(name string, repo repository.OrderRepository, policies []Policy, settings Settings) (*Job, bool) {
+	job, ok := For(name, repo, policies, settings)
+	if !ok {
+		return nil, false
+	}
+	job.audits = r.Audits
+	job.caches = r.Caches
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.jobs = append(r.jobs, job)
+	return job, true
+}
+
+// Status returns the status of every job.
+func (r *Registry) Status() []Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		statuses = append(statuses, job.Status())
+	}
+	return statuses
+}
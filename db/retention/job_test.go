@@ -0,0 +1,243 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/cache"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/sqlite"
+)
+
+var now = time.Date(2020, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+// newRepository returns an SQLite repository.
+func newRepository(t *testing.T) *repository.OrderRepositorySQL {
+	ds := sqlite.SQLite{DBCfg: config.Config{SQLitePath: ":memory:", DbOrdersTableName: "orders"}}
+	repo, err := ds.NewOrderRepositoryDb()
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.CleanUp() })
+	return repo.(*repository.OrderRepositorySQL)
+}
+
+// newJob returns the job of the repository, whose clock is stopped at now.
+func newJob(t *testing.T, repo repository.OrderRepository, batchSize int, specs ...string) *Job {
+	policies, err := ParsePolicies(specs)
+	require.NoError(t, err)
+	job, ok := For("test", repo, policies, Settings{Interval: time.Hour, BatchSize: batchSize})
+	require.True(t, ok)
+	job.now = func() time.Time { return now }
+	return job
+}
+
+// insert inserts an order of the namespace created the given number of days before now.
+func insert(t *testing.T, repo *repository.OrderRepositorySQL, ns, orderID string, days int) {
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: orderID, Namespace: ns, Total: 10, Labels: map[string]string{"channel": "web"}}))
+	_, err := repo.Database.Exec(`UPDATE "orders" SET created_at = ? WHERE order_id = ? AND namespace = ?`,
+		now.AddDate(0, 0, -days).UnixNano(), orderID, ns)
+	require.NoError(t, err)
+}
+
+// scanRow scans the first row of the query.
+func scanRow(t *testing.T, repo *repository.OrderRepositorySQL, q string, dest ...interface{}) {
+	rows, err := repo.Database.Query(q)
+	require.NoError(t, err)
+	defer rows.Close()
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(dest...))
+}
+
+func orderIDs(t *testing.T, repo repository.OrderRepository, ns string) []string {
+	orders, err := repo.GetNamespaceOrders(ns)
+	require.NoError(t, err)
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.OrderId)
+	}
+	return ids
+}
+
+func TestJobArchivesExpiredOrdersInBatches(t *testing.T) {
+	repo := newRepository(t)
+	for i, id := range []string{"o1", "o2", "o3", "o4", "o5"} {
+		insert(t, repo, "n7", id, 20+i)
+	}
+	insert(t, repo, "n7", "o6", 5)
+	insert(t, repo, "n8", "o1", 60)
+	job := newJob(t, repo, 2, "default/n7=240h")
+
+	// when
+	status := job.RunOnce(context.Background())
+
+	// then
+	assert.Equal(t, []NamespaceStatus{{Namespace: "n7", Action: Archive, Rows: 5}}, status.Namespaces)
+	assert.Equal(t, int64(5), status.Archived)
+	assert.Zero(t, status.FailedRuns)
+	require.NotNil(t, status.LastRun)
+	assert.Equal(t, now, *status.LastRun)
+	assert.Equal(t, []string{"o6"}, orderIDs(t, repo, "n7"))
+	assert.Equal(t, []string{"o1"}, orderIDs(t, repo, "n8"), "other namespaces are kept")
+
+	var archived int
+	var labels string
+	var archivedAt int64
+	scanRow(t, repo, `SELECT COUNT(*) FROM orders_archive WHERE namespace = 'n7'`, &archived)
+	assert.Equal(t, 5, archived)
+	scanRow(t, repo, `SELECT labels, archived_at FROM orders_archive WHERE order_id = 'o1'`, &labels, &archivedAt)
+	assert.Equal(t, `{"channel":"web"}`, labels)
+	assert.Equal(t, now.UnixNano(), archivedAt)
+
+	// when
+	status = job.RunOnce(context.Background())
+
+	// then
+	assert.Equal(t, []NamespaceStatus{{Namespace: "n7", Action: Archive}}, status.Namespaces)
+	assert.Equal(t, int64(5), status.Archived)
+}
+
+func TestJobAppliesWildcardPolicy(t *testing.T) {
+	repo := newRepository(t)
+	insert(t, repo, "n7", "o1", 3)
+	insert(t, repo, "n8", "o1", 3)
+	insert(t, repo, "n8", "o2", 1)
+	insert(t, repo, "n9", "o1", 1)
+	job := newJob(t, repo, 10, "default/*=48h:delete", "default/n7=240h")
+
+	// when
+	status := job.RunOnce(context.Background())
+
+	// then n7 has a policy of its own and n9 has no expired order
+	assert.Equal(t, []NamespaceStatus{
+		{Namespace: "n7", Action: Archive},
+		{Namespace: "n8", Action: Delete, Rows: 1},
+	}, status.Namespaces)
+	assert.Equal(t, int64(1), status.Deleted)
+	assert.Equal(t, []string{"o1"}, orderIDs(t, repo, "n7"))
+	assert.Equal(t, []string{"o2"}, orderIDs(t, repo, "n8"))
+	var archived int
+	scanRow(t, repo, `SELECT COUNT(*) FROM orders_archive`, &archived)
+	assert.Zero(t, archived)
+}
+
+func TestJobKeepsOrdersOfUnknownAge(t *testing.T) {
+	repo := newRepository(t)
+	insert(t, repo, "n7", "o1", 30)
+	_, err := repo.Database.Exec(`UPDATE "orders" SET created_at = 0`)
+	require.NoError(t, err)
+	job := newJob(t, repo, 10, "default/n7=24h:delete")
+
+	// when
+	status := job.RunOnce(context.Background())
+
+	// then
+	assert.Equal(t, int64(0), status.Deleted)
+	assert.Equal(t, []string{"o1"}, orderIDs(t, repo, "n7"))
+}
+
+func TestJobAgesUpsertedOrdersFromTheirCreation(t *testing.T) {
+	repo := newRepository(t)
+	insert(t, repo, "n7", "o1", 30)
+	_, err := repo.UpsertOrders([]repository.Order{{OrderId: "o1", Namespace: "n7", Total: 20}, {OrderId: "o2", Namespace: "n7", Total: 20}})
+	require.NoError(t, err)
+	job := newJob(t, repo, 10, "default/n7=240h")
+	job.now = time.Now
+
+	// when
+	status := job.RunOnce(context.Background())
+
+	// then the replaced order is still old, the created one is not
+	assert.Equal(t, int64(1), status.Archived)
+	assert.Equal(t, []string{"o2"}, orderIDs(t, repo, "n7"))
+}
+
+func TestJobReportsFailures(t *testing.T) {
+	repo := newRepository(t)
+	insert(t, repo, "n7", "o1", 30)
+	insert(t, repo, "n8", "o1", 30)
+	_, err := repo.Database.Exec(`INSERT INTO orders_archive (order_id, namespace, total, labels, created_at, archived_at) VALUES ('o1', 'n7', 10, '{}', ?, 1)`,
+		now.AddDate(0, 0, -30).UnixNano())
+	require.NoError(t, err)
+	job := newJob(t, repo, 10, "default/*=24h")
+
+	// when
+	status := job.RunOnce(context.Background())
+
+	// then the order already archived is kept, the other namespaces are still handled
+	require.Len(t, status.Namespaces, 2)
+	assert.Equal(t, "n7", status.Namespaces[0].Namespace)
+	assert.NotEmpty(t, status.Namespaces[0].Error)
+	assert.Equal(t, NamespaceStatus{Namespace: "n8", Action: Archive, Rows: 1}, status.Namespaces[1])
+	assert.Equal(t, 1, status.FailedRuns)
+	assert.Equal(t, []string{"o1"}, orderIDs(t, repo, "n7"))
+}
+
+func TestRegistry(t *testing.T) {
+	registry := &Registry{}
+	policies, err := ParsePolicies([]string{"default/n7=24h"})
+	require.NoError(t, err)
+
+	// when
+	_, ok := registry.New("memory", repository.NewOrderRepositoryMemory(), policies, Settings{BatchSize: 10})
+	assert.False(t, ok)
+	_, ok = registry.New("sqlite", newRepository(t), nil, Settings{BatchSize: 10})
+	assert.False(t, ok)
+	_, ok = registry.New("sqlite", newRepository(t), policies, Settings{BatchSize: 10})
+	assert.True(t, ok)
+
+	// then
+	statuses := registry.Status()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "sqlite", statuses[0].Name)
+	assert.Equal(t, []string{"default/n7=24h0m0s:archive"}, statuses[0].Policies)
+	assert.Nil(t, statuses[0].LastRun)
+}
+
+func TestRegistryAuditsBatchesAndDropsCachedListings(t *testing.T) {
+	repo := newRepository(t)
+	for _, id := range []string{"o1", "o2", "o3"} {
+		insert(t, repo, "n7", id, 30)
+	}
+	auditLog := audit.NewMemory()
+	audits := &audit.Registry{}
+	audits.Register("sqlite", auditLog)
+	caches := &cache.Registry{}
+	cached := caches.New("sqlite", repo, cache.Settings{TTL: time.Hour})
+	registry := &Registry{Audits: audits, Caches: caches}
+	policies, err := ParsePolicies([]string{"default/n7=24h:delete"})
+	require.NoError(t, err)
+	job, ok := registry.New("sqlite", repo, policies, Settings{Interval: time.Hour, BatchSize: 2})
+	require.True(t, ok)
+	job.now = func() time.Time { return now }
+	assert.Len(t, orderIDs(t, cached, "n7"), 3)
+
+	// when
+	job.RunOnce(context.Background())
+
+	// then
+	assert.Empty(t, orderIDs(t, cached, "n7"), "the cached listing is dropped")
+	entries, err := auditLog.Entries(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "an entry per batch")
+	var rows int64
+	for _, entry := range entries {
+		assert.Equal(t, audit.DeleteExpiredOrders, entry.Operation)
+		assert.Equal(t, "sqlite", entry.EndUser)
+		assert.Equal(t, "n7", entry.Namespace)
+		assert.Equal(t, now, entry.Time)
+		rows += entry.Rows
+	}
+	assert.Equal(t, int64(3), rows)
+}
+
+func TestSettingsValidate(t *testing.T) {
+	assert.NoError(t, Settings{Interval: time.Hour, BatchSize: 1}.Validate())
+	for _, settings := range []Settings{{Interval: time.Hour}, {BatchSize: 10}, {Interval: -time.Hour, BatchSize: 10}} {
+		assert.Error(t, settings.Validate(), settings)
+	}
+}
@@ -0,0 +1,112 @@
+// Package retention moves the orders older than an age out of the orders table of the SQL databases, so that it
+// does not grow without bounds. The orders are either archived to the `orders_archive` table or deleted, in batches
+// of their own transaction so that the orders table is never locked for long.
+//
+// The age of an order is counted from its creation, replacing it does not make it younger. The orders created
+// before the creation time was recorded are aged from the migration which added it.
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// Action tells what happens to the orders older than the age of a Policy.
+type Action string
+
+const (
+	// Archive moves the orders to the archive table.
+	Archive Action = "archive"
+	// Delete removes the orders.
+	Delete Action = "delete"
+)
+
+// AnyNamespace is the namespace of the policies applying to every namespace without a policy of its own.
+const AnyNamespace = "*"
+
+// Policy tells which orders of an end-user are moved out of the orders table.
+type Policy struct {
+	// EndUser is the `end-user` whose database is cleaned up, config.DefaultTenant standing for the end-users
+	// without a database of their own.
+	EndUser string
+	// Namespace is the namespace whose orders are moved, or AnyNamespace.
+	Namespace string
+	// MaxAge is the age from which the orders are moved.
+	MaxAge time.Duration
+	Action Action
+}
+
+// String returns the policy as it is configured.
+func (p Policy) String() string {
+	return fmt.Sprintf("%s/%s=%s:%s", p.EndUser, p.Namespace, p.MaxAge, p.Action)
+}
+
+// ParsePolicies parses the policies written `<end-user>/<namespace>=<age>[:<action>]`, such as `default/*=720h:delete`.
+// The action is Archive if it is left out. An end-user cannot have two policies for the same namespace.
+func ParsePolicies(specs []string) ([]Policy, error) {
+	policies := make([]Policy, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		policy, err := parsePolicy(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid retention policy '%s'", spec)
+		}
+		key := policy.EndUser + "/" + policy.Namespace
+		if seen[key] {
+			return nil, errors.Errorf("invalid retention policy '%s', %s has another policy", spec, key)
+		}
+		seen[key] = true
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func parsePolicy(spec string) (Policy, error) {
+	target, rule := spec, ""
+	if i := strings.Index(spec, "="); i >= 0 {
+		target, rule = spec[:i], spec[i+1:]
+	}
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || rule == "" {
+		return Policy{}, errors.New("expected <end-user>/<namespace>=<age>[:archive|delete]")
+	}
+	policy := Policy{EndUser: parts[0], Namespace: parts[1], Action: Archive}
+	if policy.Namespace != AnyNamespace {
+		if err := repository.ValidateNamespace(policy.Namespace); err != nil {
+			return Policy{}, err
+		}
+	}
+
+	age := rule
+	if i := strings.LastIndex(rule, ":"); i >= 0 {
+		age, policy.Action = rule[:i], Action(rule[i+1:])
+	}
+	if policy.Action != Archive && policy.Action != Delete {
+		return Policy{}, errors.Errorf("unknown action '%s', expected %s or %s", policy.Action, Archive, Delete)
+	}
+	maxAge, err := time.ParseDuration(age)
+	if err != nil {
+		return Policy{}, errors.Wrap(err, "invalid age")
+	}
+	if maxAge <= 0 {
+		return Policy{}, errors.Errorf("the age must be positive")
+	}
+	policy.MaxAge = maxAge
+	return policy, nil
+}
+
+// PoliciesFor returns the policies of the end-user.
+func PoliciesFor(policies []Policy, endUser string) []Policy {
+	var matching []Policy
+	for _, policy := range policies {
+		if policy.EndUser == endUser {
+			matching = append(matching, policy)
+		}
+	}
+	return matching
+}
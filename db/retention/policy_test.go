@@ -0,0 +1,47 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicies(t *testing.T) {
+	// when
+	policies, err := ParsePolicies([]string{"default/n7=720h", " tenant1/*=24h:delete", "tenant1/n7=1h30m:archive"})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []Policy{
+		{EndUser: "default", Namespace: "n7", MaxAge: 720 * time.Hour, Action: Archive},
+		{EndUser: "tenant1", Namespace: AnyNamespace, MaxAge: 24 * time.Hour, Action: Delete},
+		{EndUser: "tenant1", Namespace: "n7", MaxAge: 90 * time.Minute, Action: Archive},
+	}, policies)
+	assert.Equal(t, "tenant1/*=24h0m0s:delete", policies[1].String())
+	assert.Equal(t, policies[1:], PoliciesFor(policies, "tenant1"))
+	assert.Empty(t, PoliciesFor(policies, "tenant2"))
+}
+
+func TestParseInvalidPolicies(t *testing.T) {
+	for name, spec := range map[string]string{
+		"No namespace":      "default=24h",
+		"No end-user":       "/n7=24h",
+		"No age":            "default/n7",
+		"Invalid namespace": "default/N_7=24h",
+		"Invalid age":       "default/n7=30d",
+		"Negative age":      "default/n7=-1h",
+		"Unknown action":    "default/n7=24h:move",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParsePolicies([]string{spec})
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("Two policies for a namespace", func(t *testing.T) {
+		_, err := ParsePolicies([]string{"default/n7=24h", "default/n7=48h:delete"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,120 @@
+package retry
+
+import (
+	"math/rand"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yemramirezca/http-db-service/config"
+)
+
+const (
+	// DefaultMultiplier is the factor the backoff grows by after every failed attempt.
+	DefaultMultiplier = 2
+	// DefaultJitter is the fraction of every backoff which is randomized, so that clients failing together do not retry together.
+	DefaultJitter = 0.5
+)
+
+// Policy describes how often and how long an operation is retried, waiting an exponentially growing backoff
+// between the attempts. The zero Policy runs the operation once.
+type Policy struct {
+	// Attempts limits the number of attempts, zero meaning no limit if MaxElapsed is set and a single attempt otherwise.
+	Attempts int
+	// MaxElapsed stops retrying once the next attempt would start that long after the first one, zero meaning no limit.
+	MaxElapsed time.Duration
+	// InitialBackoff is waited after the first failure, and multiplied by Multiplier after every other one up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction, between 0 and 1, of every backoff which is randomly left out.
+	Jitter float64
+}
+
+// ForQueries returns the Policy of retrying the statements failing with transient errors, configured in cfg.
+func ForQueries(cfg config.Config) Policy {
+	return Policy{
+		Attempts:       cfg.RetryAttempts,
+		InitialBackoff: cfg.RetryBackoff,
+		MaxBackoff:     cfg.RetryMaxBackoff,
+		Multiplier:     DefaultMultiplier,
+		Jitter:         DefaultJitter,
+	}
+}
+
+// ForStartup returns the Policy of waiting for the database to accept connections when the service starts, configured in cfg.
+func ForStartup(cfg config.Config) Policy {
+	return Policy{
+		MaxElapsed:     cfg.ConnectTimeout,
+		InitialBackoff: cfg.RetryBackoff,
+		MaxBackoff:     cfg.RetryMaxBackoff,
+		Multiplier:     DefaultMultiplier,
+		Jitter:         DefaultJitter,
+	}
+}
+
+// ForOutbox returns the Policy of retrying the deliveries of the outbox events, configured in cfg.
+// Deliveries are retried until they succeed.
+func ForOutbox(cfg config.Config) Policy {
+	return Policy{
+		InitialBackoff: cfg.OutboxRetryBackoff,
+		MaxBackoff:     cfg.OutboxMaxBackoff,
+		Multiplier:     DefaultMultiplier,
+		Jitter:         DefaultJitter,
+	}
+}
+
+// Do calls fn until it succeeds, it fails with an error which is not retryable, or the policy gives up.
+// It returns the error of the last attempt.
+func (p Policy) Do(retryable func(error) bool, fn func() error) error {
+	start := time.Now()
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || p.exhausted(attempt) {
+			return err
+		}
+
+		wait := p.jitter(backoff)
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return err
+		}
+		log.Warnf("Attempt %d failed, retrying in %s: %s", attempt, wait, err)
+		time.Sleep(wait)
+		backoff = p.next(backoff)
+	}
+}
+
+// Backoff returns how long to wait after the given number of failed attempts, for callers scheduling the retries themselves.
+func (p Policy) Backoff(failures int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < failures && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff = p.next(backoff)
+	}
+	return p.jitter(backoff)
+}
+
+func (p Policy) exhausted(attempt int) bool {
+	if p.Attempts > 0 {
+		return attempt >= p.Attempts
+	}
+	return p.MaxElapsed <= 0
+}
+
+func (p Policy) next(backoff time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff = time.Duration(float64(backoff) * multiplier)
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+func (p Policy) jitter(backoff time.Duration) time.Duration {
+	if p.Jitter <= 0 || backoff <= 0 {
+		return backoff
+	}
+	return backoff - time.Duration(rand.Float64()*p.Jitter*float64(backoff))
+}
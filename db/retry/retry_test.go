@@ -0,0 +1,94 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTransient = errors.New("transient")
+
+func isTransient(err error) bool {
+	return err == errTransient
+}
+
+// failing returns a function failing with the given errors, then succeeding, and counting its calls.
+func failing(calls *int, errs ...error) func() error {
+	return func() error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}
+}
+
+func TestZeroPolicyRunsOnce(t *testing.T) {
+	calls := 0
+
+	err := Policy{}.Do(isTransient, failing(&calls, errTransient))
+
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	calls := 0
+
+	err := Policy{Attempts: 3}.Do(isTransient, failing(&calls, errTransient, errTransient))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryGivesUpAfterAttempts(t *testing.T) {
+	calls := 0
+
+	err := Policy{Attempts: 2}.Do(isTransient, failing(&calls, errTransient, errTransient))
+
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestNoRetryOfPermanentErrors(t *testing.T) {
+	calls := 0
+	permanent := errors.New("permanent")
+
+	err := Policy{Attempts: 3}.Do(isTransient, failing(&calls, permanent))
+
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryGivesUpAfterMaxElapsed(t *testing.T) {
+	calls := 0
+	p := Policy{MaxElapsed: 50 * time.Millisecond, InitialBackoff: 20 * time.Millisecond, Multiplier: DefaultMultiplier}
+
+	err := p.Do(isTransient, failing(&calls, errTransient, errTransient, errTransient, errTransient))
+
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, Multiplier: 2, Jitter: 0.5}
+
+	assert.Equal(t, 2*time.Second, p.next(time.Second))
+	assert.Equal(t, 3*time.Second, p.next(2*time.Second))
+	for i := 0; i < 100; i++ {
+		wait := p.jitter(time.Second)
+		assert.True(t, wait > 500*time.Millisecond && wait <= time.Second, "unexpected backoff %s", wait)
+	}
+}
+
+func TestBackoffGrowsUpToMaxBackoff(t *testing.T) {
+	p := Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+	assert.Equal(t, time.Second, p.Backoff(1))
+	assert.Equal(t, 2*time.Second, p.Backoff(2))
+	assert.Equal(t, 4*time.Second, p.Backoff(3))
+	assert.Equal(t, 5*time.Second, p.Backoff(4))
+	assert.Equal(t, 5*time.Second, p.Backoff(1000))
+}
@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// SQLite extended result codes recognised by Dialect.
+const (
+	Busy                 = 5
+	Locked               = 6
+	ConstraintCheck      = 275
+	ConstraintForeignKey = 787
+	ConstraintNotNull    = 1299
+	ConstraintPrimaryKey = 1555
+	ConstraintUnique     = 2067
+)
+
+// sqliteError is implemented by the errors of the SQLite driver.
+type sqliteError interface {
+	Code() int
+}
+
+// Dialect is the SQLite dialect which, on top of repository.SQLiteDialect, recognises the errors of the SQLite driver.
+type Dialect struct {
+	repository.SQLiteDialect
+}
+
+// TranslateError maps SQLite result codes to repository errors, a primary key or unique constraint becoming ErrDuplicateKey.
+func (Dialect) TranslateError(err error) error {
+	sqliteErr, ok := errors.Cause(err).(sqliteError)
+	if !ok {
+		return err
+	}
+
+	switch sqliteErr.Code() {
+	case ConstraintPrimaryKey, ConstraintUnique:
+		return repository.ErrDuplicateKey
+	case ConstraintCheck, ConstraintForeignKey, ConstraintNotNull:
+		return errors.Wrap(repository.ErrConstraintViolation, err.Error())
+	case Busy, Locked:
+		return errors.Wrap(repository.ErrTimeout, err.Error())
+	default:
+		return err
+	}
+}
@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/connection"
+	"github.com/yemramirezca/http-db-service/db/migrations"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retry"
+	_ "modernc.org/sqlite"
+)
+
+// SQLite creates OrderRepositories backed by an embedded SQLite database, stored in a file or in memory,
+// so that the service and its SQL code paths can run without any external database.
+type SQLite struct {
+	DBCfg config.Config
+}
+
+// DBConnectionString returns the configured database file, `:memory:` for a database which only lives as long as the process.
+func (db *SQLite) DBConnectionString() string {
+	return db.DBCfg.SQLitePath + "?_pragma=busy_timeout(5000)"
+}
+
+func (ds *SQLite) NewOrderRepositoryDb() (repository.OrderRepository, error) {
+	var (
+		database repository.DBQuerier
+		err      error
+	)
+
+	if database, err = ds.InitDb(); err != nil {
+		return nil, errors.Wrap(err, "Error loading db configuration.")
+	}
+	return &repository.OrderRepositorySQL{Database: database, OrdersTableName: ds.DBCfg.DbOrdersTableName, Dialect: Dialect{}, Retry: retry.ForQueries(ds.DBCfg), Outbox: ds.DBCfg.OutboxSink != ""}, nil
+}
+
+// InitDb opens the database and migrates the orders table to the latest schema.
+func (ds *SQLite) InitDb() (*sql.DB, error) {
+	db, err := ds.OpenDb()
+	if err != nil {
+		return nil, err
+	}
+	if err := migrations.Migrate(db, Dialect{}, ds.DBCfg.DbOrdersTableName); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenDb opens the database without migrating it.
+// SQLite allows a single writer at a time and every connection to `:memory:` opens a new, empty database,
+// so all queries share one connection, which is never closed while the pool is open.
+func (ds *SQLite) OpenDb() (*sql.DB, error) {
+	cfg := ds.DBCfg
+	cfg.MaxOpenConns = 1
+	cfg.ConnMaxLifetime = 0
+	cfg.ConnMaxIdleTime = 0
+	return connection.Open(config.SQLiteDriverName+" "+ds.DBCfg.SQLitePath, Dialect{}.Name(), ds.DBConnectionString(), cfg)
+}
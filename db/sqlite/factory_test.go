@@ -0,0 +1,272 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+var _ repository.Database = &SQLite{}
+
+func newRepository(t *testing.T, path string) repository.OrderRepository {
+	db := SQLite{config.Config{SQLitePath: path, DbOrdersTableName: "orders"}}
+	repo, err := db.NewOrderRepositoryDb()
+	require.NoError(t, err)
+	return repo
+}
+
+func TestSQLiteOrders(t *testing.T) {
+	repo := newRepository(t, ":memory:")
+	defer repo.CleanUp()
+
+	web := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10.5, Labels: map[string]string{"channel": "web", "region": "eu"}}
+	shop := repository.Order{OrderId: "orderId2", Namespace: "N7", Total: 20, Labels: map[string]string{"channel": "shop"}}
+	other := repository.Order{OrderId: "orderId1", Namespace: "N8", Total: 30}
+	for _, order := range []repository.Order{web, shop, other} {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+
+	t.Run("Duplicate order", func(t *testing.T) {
+		assert.Equal(t, repository.ErrDuplicateKey, repo.InsertOrder(web))
+	})
+
+	t.Run("Get orders", func(t *testing.T) {
+		orders, err := repo.GetOrders()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []repository.Order{web, shop, other}, orders)
+
+		orders, err = repo.GetNamespaceOrders("N8")
+		require.NoError(t, err)
+		assert.Equal(t, []repository.Order{other}, orders)
+	})
+
+	t.Run("Get orders by selector", func(t *testing.T) {
+		for s, expected := range map[string][]repository.Order{
+			"channel=web":             {web},
+			"channel!=web":            {shop, other},
+			"channel in (web,shop)":   {web, shop},
+			"region notin (eu)":       {shop, other},
+			"channel=shop,region!=eu": {shop},
+		} {
+			selector, err := repository.ParseLabelSelector(s)
+			require.NoError(t, err)
+
+			orders, err := repo.GetOrdersBySelector("", selector)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, expected, orders, s)
+		}
+	})
+
+	t.Run("Stream orders", func(t *testing.T) {
+		var streamed []repository.Order
+		err := repository.StreamOrders(repo, "N7", nil, func(order repository.Order) error {
+			streamed = append(streamed, order)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []repository.Order{web, shop}, streamed)
+	})
+
+	t.Run("Delete orders", func(t *testing.T) {
+		selector, err := repository.ParseLabelSelector("channel=shop")
+		require.NoError(t, err)
+		deleted, err := repo.DeleteOrdersBySelector("N7", selector)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		deleted, err = repo.DeleteNamespaceOrders("N8")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		orders, err := repo.GetOrders()
+		require.NoError(t, err)
+		assert.Equal(t, []repository.Order{web}, orders)
+
+		deleted, err = repo.DeleteOrders()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		orders, err = repo.GetOrders()
+		require.NoError(t, err)
+		assert.Len(t, orders, 0)
+	})
+}
+
+func TestSQLiteFileSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	order := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+
+	repo := newRepository(t, path)
+	require.NoError(t, repo.InsertOrder(order))
+	require.NoError(t, repo.(*repository.OrderRepositorySQL).Database.Close())
+
+	repo = newRepository(t, path)
+	defer repo.CleanUp()
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{order}, orders)
+}
+
+func TestSQLiteCleanUpRemovesTheSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	order := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+
+	repo := newRepository(t, path)
+	require.NoError(t, repo.InsertOrder(order))
+	require.NoError(t, repo.CleanUp())
+
+	// then the migrations are applied again
+	repo = newRepository(t, path)
+	defer repo.CleanUp()
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+	require.NoError(t, repo.InsertOrder(order))
+}
+
+func TestSQLiteKeepsItsConnection(t *testing.T) {
+	db := SQLite{config.Config{SQLitePath: ":memory:", DbOrdersTableName: "orders", ConnMaxLifetime: time.Millisecond, ConnMaxIdleTime: time.Millisecond}}
+	repo, err := db.NewOrderRepositoryDb()
+	require.NoError(t, err)
+	defer repo.CleanUp()
+	order := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	require.NoError(t, repo.InsertOrder(order))
+
+	// when the pool would have recycled the connection
+	time.Sleep(10 * time.Millisecond)
+
+	// then the in-memory database is still there
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{order}, orders)
+}
+
+func TestSQLiteUpsertOrders(t *testing.T) {
+	repo := newRepository(t, ":memory:")
+	defer repo.CleanUp()
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+
+	// when
+	replaced := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 20, Labels: map[string]string{"channel": "web"}}
+	created := repository.Order{OrderId: "orderId2", Namespace: "N7", Total: 30}
+	result, err := repo.UpsertOrders([]repository.Order{replaced, created})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, result)
+	orders, err := repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{replaced, created}, orders)
+
+}
+
+func TestSQLiteInsertOrders(t *testing.T) {
+	repo := newRepository(t, ":memory:")
+	defer repo.CleanUp()
+	existing := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	require.NoError(t, repo.InsertOrder(existing))
+	created := repository.Order{OrderId: "orderId2", Namespace: "N7", Total: 30}
+	orders := []repository.Order{created, {OrderId: "orderId1", Namespace: "N7", Total: 20}}
+
+	// when
+	_, err := repo.InsertOrders(orders, false)
+
+	// then the order inserted before the existing one is rolled back
+	assert.Equal(t, repository.ErrDuplicateKey, err)
+	read, err := repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{existing}, read)
+
+	// when
+	result, err := repo.InsertOrders(orders, true)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, result)
+	read, err = repo.GetNamespaceOrders("N7")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{existing, created}, read)
+}
+
+func TestSQLiteUpsertReportsAnOrderCreatedOnce(t *testing.T) {
+	repo := newRepository(t, ":memory:")
+	defer repo.CleanUp()
+	order := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+
+	// when
+	first, err := repo.UpsertOrders([]repository.Order{order, order})
+	require.NoError(t, err)
+	second, err := repo.UpsertOrders([]repository.Order{order})
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, []bool{true, false}, first)
+	assert.Equal(t, []bool{false}, second)
+}
+
+func TestSQLiteOrdersVersion(t *testing.T) {
+	repo := newRepository(t, ":memory:")
+	defer repo.CleanUp()
+	versioner := repo.(repository.OrderVersioner)
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}))
+	inserted, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.NotZero(t, inserted.Counter)
+	assert.False(t, inserted.Modified.IsZero())
+
+	// when
+	_, err = repo.UpsertOrders([]repository.Order{{OrderId: "orderId1", Namespace: "N7", Total: 20}})
+	require.NoError(t, err)
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "N8", Total: 10}))
+
+	// then
+	updated, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.Greater(t, updated.Counter, inserted.Counter)
+	all, err := versioner.OrdersVersion("")
+	require.NoError(t, err)
+	assert.Greater(t, all.Counter, updated.Counter)
+
+	// when
+	_, err = repo.DeleteNamespaceOrders("N7")
+	require.NoError(t, err)
+
+	// then
+	deleted, err := versioner.OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.Greater(t, deleted.Counter, updated.Counter)
+	unknown, err := versioner.OrdersVersion("N9")
+	require.NoError(t, err)
+	assert.Equal(t, repository.Version{}, unknown)
+}
+
+func TestSQLiteMoveNamespaceOrders(t *testing.T) {
+	repo := newRepository(t, ":memory:")
+	defer repo.CleanUp()
+	for _, order := range []repository.Order{{OrderId: "orderId1", Namespace: "N7", Total: 10}, {OrderId: "orderId2", Namespace: "N7", Total: 20}, {OrderId: "orderId1", Namespace: "N8", Total: 30}} {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+
+	// when
+	_, notFound := repo.MoveNamespaceOrders("N9", "N10", false)
+	_, renamed := repo.MoveNamespaceOrders("N7", "N8", false)
+	_, merged := repo.MoveNamespaceOrders("N7", "N8", true)
+	moved, err := repo.MoveNamespaceOrders("N7", "N9", false)
+
+	// then
+	assert.Equal(t, repository.ErrNotFound, notFound)
+	assert.Equal(t, repository.ErrDuplicateKey, renamed)
+	assert.Equal(t, repository.ErrDuplicateKey, merged)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), moved)
+	namespaces, err := repository.Namespaces(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Namespace{{Name: "N8", Orders: 1, Total: 30}, {Name: "N9", Orders: 2, Total: 30}}, namespaces)
+	version, err := repo.(repository.OrderVersioner).OrdersVersion("N7")
+	require.NoError(t, err)
+	assert.NotZero(t, version.Counter, "the triggers track the moved orders")
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/breaker"
+	"github.com/yemramirezca/http-db-service/db/cache"
+	"github.com/yemramirezca/http-db-service/db/connection"
+	"github.com/yemramirezca/http-db-service/db/encryption"
+	"github.com/yemramirezca/http-db-service/db/retention"
+	"github.com/yemramirezca/http-db-service/handler/response"
+)
+
+// Admin is used to expose operational information about the service using the HTTP route handler methods which extend it.
+type Admin struct {
+	connections *connection.Manager
+	breakers    *breaker.Registry
+	caches      *cache.Registry
+	audits      *audit.Registry
+	retentions  *retention.Registry
+	encryptions *encryption.Registry
+}
+
+// NewAdminHandler creates a new 'AdminHandler' which reports on the given connection pools, circuit breakers, caches,
+// retention and re-encryption jobs, queries the given audit logs and rotates the given data keys.
+func NewAdminHandler(connections *connection.Manager, breakers *breaker.Registry, caches *cache.Registry, audits *audit.Registry, retentions *retention.Registry, encryptions *encryption.Registry) Admin {
+	return Admin{connections: connections, breakers: breakers, caches: caches, audits: audits, retentions: retentions, encryptions: encryptions}
+}
+
+const (
+	healthUp       = "UP"
+	healthDegraded = "DEGRADED"
+)
+
+// Health is the health of the service, which is degraded while the circuit breaker of a database is not closed.
+type Health struct {
+	Status   string
+	Breakers []breaker.Status
+}
+
+// GetHealth handles an http request for the health of the service and the state of every circuit breaker.
+// A degraded service still answers with 200, since the databases of other end-users may be healthy.
+func (adminHandler Admin) GetHealth(w http.ResponseWriter, r *http.Request) {
+	health := Health{Status: healthUp, Breakers: adminHandler.breakers.Status()}
+	for _, status := range health.Breakers {
+		if status.State != breaker.Closed {
+			health.Status = healthDegraded
+		}
+	}
+	writeJSON(w, health)
+}
+
+// GetConnections handles an http request for retrieving the statistics of every database connection pool.
+func (adminHandler Admin) GetConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, adminHandler.connections.Stats())
+}
+
+// GetCacheMetrics handles an http request for retrieving the hits, misses and evictions of every repository cache.
+func (adminHandler Admin) GetCacheMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, adminHandler.caches.Metrics())
+}
+
+// GetRetention handles an http request for the status of every retention job: its last run, the orders it moved
+// out of every namespace and the errors it met.
+func (adminHandler Admin) GetRetention(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, adminHandler.retentions.Status())
+}
+
+// GetEncryption handles an http request for the data keys of every encrypted database and the status of its
+// re-encryption job.
+func (adminHandler Admin) GetEncryption(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, adminHandler.encryptions.Status())
+}
+
+// RotateEncryptionKey handles an http request for rotating the data key of the database of the end-user, `default`
+// standing for the end-users without a database of their own. The existing orders remain readable and are encrypted
+// with the new key by the next runs of the re-encryption job.
+func (adminHandler Admin) RotateEncryptionKey(w http.ResponseWriter, r *http.Request) {
+	endUser := mux.Vars(r)["endUser"]
+	job, exists := adminHandler.encryptions.Get(endUser)
+	if !exists {
+		response.WriteCodeAndMessage(http.StatusNotFound, fmt.Sprintf("The orders of end-user %s are not encrypted.", endUser), w)
+		return
+	}
+	status, err := job.Rotate()
+	if err != nil {
+		log.Error("Error rotating data key.", err)
+		response.WriteError(err, w)
+		return
+	}
+	writeJSON(w, status)
+}
+
+// GetAudit handles an http request for the audit entries of every database, the most recent first.
+// The optional `endUser`, `operation`, `namespace` and `requestId` query parameters restrict the entries to the matching ones,
+// `since` and `until` to a time range given in RFC 3339, and `limit` their number.
+func (adminHandler Admin) GetAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	entries, err := adminHandler.audits.Entries(filter)
+	if err != nil {
+		log.Error("Error reading audit entries.", err)
+		response.WriteError(err, w)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+// parseAuditFilter reads the filter of the audit entries from the query parameters of the request.
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		EndUser:   query.Get("endUser"),
+		Operation: query.Get("operation"),
+		Namespace: query.Get("namespace"),
+		RequestID: query.Get("requestId"),
+	}
+	for _, bound := range []struct {
+		param string
+		time  *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := query.Get(bound.param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s '%s', expected a time in RFC 3339 format.", bound.param, value)
+			}
+			*bound.time = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("Invalid limit '%s', expected a positive number.", value)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONCode(w, http.StatusOK, v)
+}
+
+func writeJSONCode(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Error writing response.", err)
+	}
+}
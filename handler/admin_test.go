@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/breaker"
+	"github.com/yemramirezca/http-db-service/db/cache"
+	"github.com/yemramirezca/http-db-service/db/connection"
+	"github.com/yemramirezca/http-db-service/db/encryption"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retention"
+	"github.com/yemramirezca/http-db-service/db/sqlite"
+)
+
+func TestGetConnections(t *testing.T) {
+	// given
+	manager := connection.NewManager()
+	defer manager.Close()
+	_, err := manager.Open("sqlite test", "sqlite", ":memory:", config.Config{MaxOpenConns: 3})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/connections", nil)
+	res := httptest.NewRecorder()
+
+	// when
+	NewAdminHandler(manager, &breaker.Registry{}, &cache.Registry{}, &audit.Registry{}, &retention.Registry{}, &encryption.Registry{}).GetConnections(res, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, res.Code)
+	var stats []connection.Stats
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+	require.Len(t, stats, 1)
+	assert.Equal(t, "sqlite test", stats[0].Name)
+	assert.Equal(t, 3, stats[0].MaxOpenConnections)
+}
+
+func TestGetHealth(t *testing.T) {
+	// given
+	breakers := &breaker.Registry{}
+	breakers.New("healthy", repository.PostgresDialect{}, breaker.Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	failing := breakers.New("failing", repository.PostgresDialect{}, breaker.Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	call, err := failing.Allow()
+	require.NoError(t, err)
+	failing.Done(call, repository.ErrUnavailable)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/health", nil)
+	res := httptest.NewRecorder()
+
+	// when
+	NewAdminHandler(connection.NewManager(), breakers, &cache.Registry{}, &audit.Registry{}, &retention.Registry{}, &encryption.Registry{}).GetHealth(res, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, res.Code)
+	var health struct {
+		Status   string
+		Breakers []struct{ Name, State string }
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&health))
+	assert.Equal(t, "DEGRADED", health.Status)
+	require.Len(t, health.Breakers, 2)
+	assert.Equal(t, "closed", health.Breakers[0].State)
+	assert.Equal(t, "open", health.Breakers[1].State)
+}
+
+func TestGetAudit(t *testing.T) {
+	// given
+	auditLog := audit.NewMemory()
+	audits := &audit.Registry{}
+	audits.Register(config.DefaultTenant, auditLog)
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, auditLog.Record(audit.Entry{Time: start, EndUser: "alice", Operation: audit.DeleteOrders, Rows: 2}))
+	require.NoError(t, auditLog.Record(audit.Entry{Time: start.Add(time.Hour), EndUser: "bob", Operation: audit.DeleteOrders, Rows: 1}))
+	adminHandler := NewAdminHandler(connection.NewManager(), &breaker.Registry{}, &cache.Registry{}, audits, &retention.Registry{}, &encryption.Registry{})
+
+	t.Run("Filtered entries", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit?operation=DeleteOrders&since=2020-01-01T00:30:00Z", nil)
+		res := httptest.NewRecorder()
+
+		// when
+		adminHandler.GetAudit(res, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, res.Code)
+		var entries []audit.Entry
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&entries))
+		require.Len(t, entries, 1)
+		assert.Equal(t, "bob", entries[0].EndUser)
+	})
+
+	t.Run("Invalid filter", func(t *testing.T) {
+		for _, query := range []string{"since=yesterday", "limit=-1"} {
+			req := httptest.NewRequest(http.MethodGet, "/admin/audit?"+query, nil)
+			res := httptest.NewRecorder()
+
+			// when
+			adminHandler.GetAudit(res, req)
+
+			// then
+			assert.Equal(t, http.StatusBadRequest, res.Code, query)
+		}
+	})
+}
+
+func TestGetRetention(t *testing.T) {
+	// given
+	retentions := &retention.Registry{}
+	policies, err := retention.ParsePolicies([]string{"default/n7=24h:delete"})
+	require.NoError(t, err)
+	_, ok := retentions.New("postgres", &repository.OrderRepositorySQL{OrdersTableName: "orders"}, policies, retention.Settings{BatchSize: 10})
+	require.True(t, ok)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/retention", nil)
+	res := httptest.NewRecorder()
+
+	// when
+	NewAdminHandler(connection.NewManager(), &breaker.Registry{}, &cache.Registry{}, &audit.Registry{}, retentions, &encryption.Registry{}).GetRetention(res, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, res.Code)
+	var statuses []retention.Status
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "postgres", statuses[0].Name)
+	assert.Equal(t, []string{"default/n7=24h0m0s:delete"}, statuses[0].Policies)
+	assert.Nil(t, statuses[0].LastRun, "the job did not run yet")
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	// given
+	ds := sqlite.SQLite{DBCfg: config.Config{SQLitePath: ":memory:", DbOrdersTableName: "orders"}}
+	repo, err := ds.NewOrderRepositoryDb()
+	require.NoError(t, err)
+	defer repo.CleanUp()
+	master, err := encryption.ParseMasterKeys([]byte("m1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"))
+	require.NoError(t, err)
+	encryptions := &encryption.Registry{}
+	job, err := encryptions.New(config.DefaultTenant, repo, master, encryption.Fields, encryption.Settings{BatchSize: 10})
+	require.NoError(t, err)
+	firstKey := job.Status().ActiveKey
+	adminHandler := NewAdminHandler(connection.NewManager(), &breaker.Registry{}, &cache.Registry{}, &audit.Registry{}, &retention.Registry{}, encryptions)
+
+	t.Run("Encrypted database", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/admin/encryption/default/rotate", nil), map[string]string{"endUser": "default"})
+		res := httptest.NewRecorder()
+
+		// when
+		adminHandler.RotateEncryptionKey(res, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, res.Code)
+		var status encryption.Status
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+		assert.Equal(t, 2, status.Keys)
+		assert.NotEqual(t, firstKey, status.ActiveKey)
+		assert.Equal(t, []string{"total", "labels"}, status.Fields)
+	})
+
+	t.Run("Unknown end-user", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/admin/encryption/alice/rotate", nil), map[string]string{"endUser": "alice"})
+		res := httptest.NewRecorder()
+
+		// when
+		adminHandler.RotateEncryptionKey(res, req)
+
+		// then
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		res := httptest.NewRecorder()
+
+		// when
+		adminHandler.GetEncryption(res, httptest.NewRequest(http.MethodGet, "/admin/encryption", nil))
+
+		// then
+		assert.Equal(t, http.StatusOK, res.Code)
+		var statuses []encryption.Status
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&statuses))
+		require.Len(t, statuses, 1)
+		assert.Equal(t, config.DefaultTenant, statuses[0].Name)
+		assert.Equal(t, 2, statuses[0].Keys)
+	})
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/archive"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/handler/response"
+)
+
+const (
+	conflictParam      = "conflict"
+	archiveContentType = "application/zip"
+	// maxArchiveBytes limits the size of a restored archive, whose orders are held in memory.
+	maxArchiveBytes = 64 << 20
+)
+
+// restoreReport tells which archive was restored, and how many of its orders were written.
+type restoreReport struct {
+	Archive archive.Manifest `json:"archive"`
+	archive.Result
+}
+
+// BackupOrders handles an http request for downloading the orders of the namespace specified as a path variable,
+// or of all namespaces without one, as an archive, see the archive package. The archive is streamed while the orders
+// are read from the repository of the end-user, so if reading fails midway the connection is aborted.
+func (orderHandler Order) BackupOrders(w http.ResponseWriter, r *http.Request) {
+	endUser := r.Header.Get(header)
+	ns := mux.Vars(r)["namespace"]
+	now := time.Now().UTC()
+	name := ns
+	if name == "" {
+		name = "all"
+	}
+	w.Header().Set("Content-Type", archiveContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="orders-%s-%s.zip"`, name, now.Format("20060102T150405Z")))
+
+	log.Debugf("Archiving orders of namespace '%s'", ns)
+	writer := &startedWriter{w: w}
+	manifest := archive.Manifest{CreatedAt: now, EndUser: endUser, Namespace: ns}
+	if _, err := archive.Write(writer, orderHandler.getRepository(endUser), manifest); err != nil {
+		log.Error("Error archiving orders.", err)
+		if writer.started {
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Del("Content-Disposition")
+		response.WriteError(err, w)
+	}
+}
+
+// RestoreOrders handles an http request for restoring the orders of an archive written by BackupOrders into the
+// repository of the end-user, which may differ from the archived one. With a namespace specified as a path variable
+// only the orders of that namespace are restored. The `conflict` query parameter tells what to do with the orders
+// which already exist: `fail`, the default, restores nothing, `skip` keeps them and `overwrite` replaces them.
+func (orderHandler Order) RestoreOrders(w http.ResponseWriter, r *http.Request) {
+	ns := mux.Vars(r)["namespace"]
+	policy, err := archive.ParsePolicy(r.URL.Query().Get(conflictParam))
+	if err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid %s parameter, %s.", conflictParam, err), w)
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxArchiveBytes))
+	if err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid archive, larger than %d bytes or unreadable.", maxArchiveBytes), w)
+		return
+	}
+	defer r.Body.Close()
+
+	manifest, orders, err := archive.Read(b)
+	if err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("%s.", capitalize(err.Error())), w)
+		return
+	}
+	if ns != "" {
+		orders = namespaceOrders(orders, ns)
+		if len(orders) == 0 {
+			response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("The archive has no orders of namespace %s.", ns), w)
+			return
+		}
+	}
+
+	log.Debugf("Restoring %d orders archived at %s with the %s policy", len(orders), manifest.CreatedAt, policy)
+	repo := orderHandler.getRepository(r.Header.Get(header))
+	result, err := archive.Restore(repo, orders, policy)
+	orderHandler.record(r, audit.Entry{Operation: audit.RestoreOrders, Namespace: ns, Rows: int64(result.Created + result.Updated)}, err)
+	if conflict, ok := errors.Cause(err).(*archive.ConflictError); ok {
+		response.WriteCodeAndMessage(http.StatusConflict, fmt.Sprintf("%s, nothing was restored.", capitalize(conflict.Error())), w)
+		return
+	}
+	if err != nil {
+		log.Error("Error restoring orders.", err)
+		response.WriteError(err, w)
+		return
+	}
+	writeJSON(w, restoreReport{Archive: manifest, Result: result})
+}
+
+func namespaceOrders(orders []repository.Order, ns string) []repository.Order {
+	matching := make([]repository.Order, 0, len(orders))
+	for _, order := range orders {
+		if order.Namespace == ns {
+			matching = append(matching, order)
+		}
+	}
+	return matching
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// startedWriter tells whether anything was written to the response, after which its status code cannot change.
+type startedWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (sw *startedWriter) Write(b []byte) (int, error) {
+	sw.started = true
+	return sw.w.Write(b)
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+func newArchiveRouter(orderHandler Order) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/orders/backup", orderHandler.BackupOrders).Methods(http.MethodGet)
+	router.HandleFunc("/namespace/{namespace}/orders/backup", orderHandler.BackupOrders).Methods(http.MethodGet)
+	router.HandleFunc("/orders/restore", orderHandler.RestoreOrders).Methods(http.MethodPost)
+	router.HandleFunc("/namespace/{namespace}/orders/restore", orderHandler.RestoreOrders).Methods(http.MethodPost)
+	return router
+}
+
+func serve(router http.Handler, method, path, endUser string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set(header, endUser)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestBackupAndRestoreOrders(t *testing.T) {
+	// given
+	alice := repository.NewOrderRepositoryMemory()
+	bob := repository.NewOrderRepositoryMemory()
+	n7 := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10, Labels: map[string]string{"channel": "web"}}
+	n8 := repository.Order{OrderId: "orderId1", Namespace: "n8", Total: 20}
+	require.NoError(t, alice.InsertOrder(n7))
+	require.NoError(t, alice.InsertOrder(n8))
+	router := newArchiveRouter(NewTenantOrderHandler(repository.NewOrderRepositoryMemory(), map[string]repository.OrderRepository{"alice": alice, "bob": bob}, nil, nil))
+
+	// when
+	backup := serve(router, http.MethodGet, "/orders/backup", "alice", nil)
+
+	// then
+	require.Equal(t, http.StatusOK, backup.Code)
+	assert.Equal(t, "application/zip", backup.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="orders-all-\d{8}T\d{6}Z\.zip"$`, backup.Header().Get("Content-Disposition"))
+
+	// when
+	restore := serve(router, http.MethodPost, "/namespace/n7/orders/restore", "bob", backup.Body.Bytes())
+
+	// then
+	require.Equal(t, http.StatusOK, restore.Code)
+	var report map[string]interface{}
+	require.NoError(t, json.Unmarshal(restore.Body.Bytes(), &report))
+	assert.Equal(t, float64(1), report["created"])
+	assert.Equal(t, "alice", report["archive"].(map[string]interface{})["endUser"])
+	orders, err := bob.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{n7}, orders)
+
+	t.Run("Conflicting orders", func(t *testing.T) {
+		res := serve(router, http.MethodPost, "/orders/restore", "bob", backup.Body.Bytes())
+
+		assert.Equal(t, http.StatusConflict, res.Code)
+		orders, err := bob.GetOrders()
+		require.NoError(t, err)
+		assert.Len(t, orders, 1, "nothing is restored")
+	})
+
+	t.Run("Skipped conflicting orders", func(t *testing.T) {
+		res := serve(router, http.MethodPost, "/orders/restore?conflict=skip", "bob", backup.Body.Bytes())
+
+		require.Equal(t, http.StatusOK, res.Code)
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &report))
+		assert.Equal(t, float64(1), report["created"])
+		assert.Equal(t, float64(1), report["skipped"])
+		orders, err := bob.GetOrders()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []repository.Order{n7, n8}, orders)
+	})
+}
+
+func TestRestoreOrdersBadRequest(t *testing.T) {
+	repo := repository.NewOrderRepositoryMemory()
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}))
+	router := newArchiveRouter(NewOrderHandler(repo))
+	backup := serve(router, http.MethodGet, "/namespace/n7/orders/backup", "", nil).Body.Bytes()
+
+	for name, path := range map[string]string{
+		"unknown policy":         "/orders/restore?conflict=merge",
+		"namespace not archived": "/namespace/n8/orders/restore",
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodPost, path, "", backup).Code)
+		})
+	}
+	t.Run("Not an archive", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodPost, "/orders/restore", "", []byte("orderId,total")).Code)
+	})
+}
+
+func TestBackupOrdersDatabaseUnavailable(t *testing.T) {
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	repoMock.On("GetNamespaceOrders", mock.Anything).Return(nil, pkgerrors.Wrap(repository.ErrUnavailable, "while reading orders"))
+	router := newArchiveRouter(NewOrderHandler(&repoMock))
+
+	res := serve(router, http.MethodGet, "/namespace/n7/orders/backup", "", nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	assert.Empty(t, res.Header().Get("Content-Disposition"))
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// notModified sets the `ETag` and `Last-Modified` headers of the listing of the namespace, all namespaces if it is
+// empty, from the version tracked by the repository, and answers with 304 if the preconditions of the request show
+// that the client already has the listing. `If-None-Match` takes precedence over `If-Modified-Since`.
+// Nothing is done if the repository does not track versions, or reading the version fails. The version is read
+// before the listing, so that the listing sent with its ETag is never older than it, see the `db/cache` package.
+func notModified(w http.ResponseWriter, r *http.Request, repo repository.OrderRepository, ns string) bool {
+	versioner, ok := repo.(repository.OrderVersioner)
+	if !ok {
+		return false
+	}
+	version, err := versioner.OrdersVersion(ns)
+	if err != nil {
+		log.Warnf("Reading the version of namespace '%s' failed, the orders are sent unconditionally: %s", ns, err)
+		return false
+	}
+	if version == (repository.Version{}) {
+		return false
+	}
+
+	// the listing depends on the representation and on the database of the end-user
+	w.Header().Add("Vary", "Accept, "+header)
+	etag := fmt.Sprintf(`W/"%d"`, version.Counter)
+	w.Header().Set("ETag", etag)
+	// a time within the current second would not tell apart the changes made later in the same second
+	modified := !version.Modified.IsZero() && time.Since(version.Modified) >= time.Second
+	if modified {
+		w.Header().Set("Last-Modified", version.Modified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || !modified || version.Modified.After(since.Add(time.Second-1)) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches tells whether the `If-None-Match` header lists the entity tag, using the weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
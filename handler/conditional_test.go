@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// versionedRepository reports a fixed version of the orders of the repository it wraps.
+type versionedRepository struct {
+	repository.OrderRepository
+	version repository.Version
+}
+
+func (r *versionedRepository) OrdersVersion(ns string) (repository.Version, error) {
+	return r.version, nil
+}
+
+func newConditionalRouter(repo repository.OrderRepository) *mux.Router {
+	handler := NewOrderHandler(repo)
+	router := mux.NewRouter()
+	router.HandleFunc("/orders", handler.GetOrders).Methods(http.MethodGet)
+	router.HandleFunc("/namespace/{namespace}/orders", handler.GetNamespaceOrders).Methods(http.MethodGet)
+	return router
+}
+
+func getOrders(router http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConditionalGetOrders(t *testing.T) {
+	modified := time.Date(2026, time.March, 1, 10, 0, 0, 500, time.UTC)
+	repo := &versionedRepository{repository.NewOrderRepositoryMemory(), repository.Version{Counter: 42, Modified: modified}}
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}))
+	router := newConditionalRouter(repo)
+
+	t.Run("validators", func(t *testing.T) {
+		res := getOrders(router, "/namespace/n7/orders", nil)
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, `W/"42"`, res.Header().Get("ETag"))
+		assert.Equal(t, "Sun, 01 Mar 2026 10:00:00 GMT", res.Header().Get("Last-Modified"))
+		assert.Contains(t, res.Header().Get("Vary"), "end-user")
+		assert.Contains(t, res.Body.String(), "orderId1")
+	})
+
+	for name, tc := range map[string]struct {
+		headers map[string]string
+		status  int
+	}{
+		"matching etag":  {map[string]string{"If-None-Match": `W/"42"`}, http.StatusNotModified},
+		"strong etag":    {map[string]string{"If-None-Match": `"42"`}, http.StatusNotModified},
+		"etag in list":   {map[string]string{"If-None-Match": `W/"41", W/"42"`}, http.StatusNotModified},
+		"any etag":       {map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		"stale etag":     {map[string]string{"If-None-Match": `W/"41"`}, http.StatusOK},
+		"etag over date": {map[string]string{"If-None-Match": `W/"41"`, "If-Modified-Since": "Sun, 01 Mar 2026 10:00:00 GMT"}, http.StatusOK},
+		"same date":      {map[string]string{"If-Modified-Since": "Sun, 01 Mar 2026 10:00:00 GMT"}, http.StatusNotModified},
+		"later date":     {map[string]string{"If-Modified-Since": "Sun, 01 Mar 2026 11:00:00 GMT"}, http.StatusNotModified},
+		"earlier date":   {map[string]string{"If-Modified-Since": "Sun, 01 Mar 2026 09:59:59 GMT"}, http.StatusOK},
+		"invalid date":   {map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := getOrders(router, "/orders", tc.headers)
+
+			assert.Equal(t, tc.status, res.Code)
+			assert.Equal(t, `W/"42"`, res.Header().Get("ETag"))
+			if tc.status == http.StatusNotModified {
+				assert.Empty(t, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestConditionalGetOrdersAfterAChange(t *testing.T) {
+	repo := repository.NewOrderRepositoryMemory()
+	router := newConditionalRouter(repo)
+	empty := getOrders(router, "/namespace/n7/orders", nil)
+	assert.Empty(t, empty.Header().Get("ETag"), "the version of an unknown namespace is not known")
+	etag := getOrders(router, "/orders", nil).Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.Equal(t, http.StatusNotModified, getOrders(router, "/orders", map[string]string{"If-None-Match": etag}).Code)
+
+	// when
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}))
+
+	// then
+	res := getOrders(router, "/orders", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.NotEqual(t, etag, res.Header().Get("ETag"))
+	assert.Empty(t, res.Header().Get("Last-Modified"), "a change within the last second is not told apart by its date")
+}
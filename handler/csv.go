@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/handler/response"
+)
+
+const dryRunParam = "dryRun"
+
+// maxImportOrders limits the orders imported by a single request, and maxImportBytes the size of its CSV file,
+// whose orders are held in memory.
+const (
+	maxImportOrders = 10000
+	maxImportBytes  = 16 << 20
+)
+
+// importReport tells which lines of an imported CSV file were imported, or would be in a dry run.
+type importReport struct {
+	DryRun   bool          `json:"dryRun"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors"`
+}
+
+// importError tells why a line of an imported CSV file was not imported.
+type importError struct {
+	Line    int    `json:"line"`
+	OrderId string `json:"orderId,omitempty"`
+	Message string `json:"message"`
+}
+
+// importRow is an order read from a line of a CSV file, which is invalid if it has an error.
+type importRow struct {
+	line  int
+	order repository.Order
+	err   string
+}
+
+// ImportOrders handles an http request for creating the orders given as CSV, with a header row naming the
+// `orderId` and `total` columns and optionally the `namespace` and `labels` ones, see response.CSVColumns.
+// Every line is validated and inserted like an order given to InsertOrder, and the response reports why the other
+// lines were not imported. With the `dryRun` query parameter nothing is inserted, the lines are only checked.
+// If the namespace is a path variable the orders may leave it out, and must not belong to another namespace.
+func (orderHandler Order) ImportOrders(w http.ResponseWriter, r *http.Request) {
+	ns := mux.Vars(r)["namespace"]
+	if msg := validateNamespace(ns); ns != "" && msg != "" {
+		response.WriteCodeAndMessage(http.StatusBadRequest, msg, w)
+		return
+	}
+	dryRun := false
+	if value := r.URL.Query().Get(dryRunParam); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid %s parameter '%s'.", dryRunParam, value), w)
+			return
+		}
+	}
+	defer r.Body.Close()
+	rows, err := readImportRows(http.MaxBytesReader(w, r.Body, maxImportBytes), ns)
+	if _, tooLarge := err.(*http.MaxBytesError); tooLarge {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid CSV, larger than %d bytes.", maxImportBytes), w)
+		return
+	}
+	if err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid CSV, %s.", err), w)
+		return
+	}
+	if len(rows) == 0 || len(rows) > maxImportOrders {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid CSV, between 1 and %d orders are expected.", maxImportOrders), w)
+		return
+	}
+
+	repo := orderHandler.getRepository(r.Header.Get(header))
+	if dryRun {
+		err = checkImportRows(repo, rows)
+	} else {
+		err = orderHandler.importRows(r, repo, ns, rows)
+	}
+	if err != nil {
+		log.Error("Error importing orders.", err)
+		response.WriteError(err, w)
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Errors: make([]importError, 0)}
+	for _, row := range rows {
+		if row.err == "" {
+			report.Imported++
+			continue
+		}
+		report.Failed++
+		report.Errors = append(report.Errors, importError{Line: row.line, OrderId: row.order.OrderId, Message: row.err})
+	}
+	writeJSON(w, report)
+}
+
+// readImportRows reads the orders of the CSV file, and validates them. It returns an error if the file cannot be read,
+// and sets the error of the rows which cannot be imported otherwise.
+func readImportRows(body io.Reader, ns string) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the header row is missing")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns, err := importColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []importRow
+	for len(rows) <= maxImportOrders {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !isFieldCountError(err) {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rows = append(rows, importRow{line: line, err: fmt.Sprintf("Expected %d fields, found %d.", len(header), len(record))})
+			continue
+		}
+		rows = append(rows, parseImportRow(line, record, columns, ns))
+	}
+	return rows, nil
+}
+
+func isFieldCountError(err error) bool {
+	parseErr, ok := err.(*csv.ParseError)
+	return ok && parseErr.Err == csv.ErrFieldCount
+}
+
+// importColumns returns the index of every column of the header row by name.
+func importColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// spreadsheets often start UTF-8 files with a byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !isCSVColumn(name) {
+			return nil, errors.Errorf("unknown column '%s'", name)
+		}
+		if _, exists := columns[name]; exists {
+			return nil, errors.Errorf("column '%s' is given twice", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"orderId", "total"} {
+		if _, exists := columns[name]; !exists {
+			return nil, errors.Errorf("column '%s' is missing", name)
+		}
+	}
+	return columns, nil
+}
+
+func isCSVColumn(name string) bool {
+	for _, column := range response.CSVColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseImportRow(line int, record []string, columns map[string]int, ns string) importRow {
+	field := func(name string) string {
+		if i, exists := columns[name]; exists {
+			return response.UnescapeCSVCell(strings.TrimSpace(record[i]))
+		}
+		return ""
+	}
+	row := importRow{line: line, order: repository.Order{OrderId: field("orderId"), Namespace: field("namespace")}}
+
+	if total := field("total"); total != "" {
+		value, err := strconv.ParseFloat(total, 64)
+		if err != nil {
+			row.err = fmt.Sprintf("Invalid total '%s'.", total)
+			return row
+		}
+		row.order.Total = value
+	}
+	labels, err := repository.ParseLabels(field("labels"))
+	if err != nil {
+		row.err = fmt.Sprintf("Invalid labels, %s.", err)
+		return row
+	}
+	row.order.Labels = labels
+	if msg := validateOrder(row.order); msg != "" {
+		row.err = msg
+		return row
+	}
+
+	switch {
+	case row.order.Namespace == "" && ns != "":
+		row.order.Namespace = ns
+	case row.order.Namespace == "":
+		row.order.Namespace = defaultNamespace
+	case ns != "" && row.order.Namespace != ns:
+		row.err = fmt.Sprintf("Order belongs to namespace %s, not %s.", row.order.Namespace, ns)
+	}
+	return row
+}
+
+// importRows inserts the valid rows, and records the import in the audit log of the end-user. A row which cannot
+// be inserted gets the message the client would get from InsertOrder. It stops at the first row failing because
+// the database is unavailable, as the next ones would fail as well, and gives its message to the remaining rows.
+// The error is only returned if no order was imported, so that the client learns which lines to import again.
+func (orderHandler Order) importRows(r *http.Request, repo repository.OrderRepository, ns string, rows []importRow) error {
+	var (
+		imported int64
+		failure  error
+	)
+	for i := range rows {
+		row := &rows[i]
+		if row.err != "" {
+			continue
+		}
+		err := repo.InsertOrder(row.order)
+		if err == nil {
+			imported++
+			continue
+		}
+		if err == repository.ErrDuplicateKey {
+			row.err = fmt.Sprintf("Order %s already exists.", row.order.OrderId)
+			continue
+		}
+		code, msg := response.StatusForError(err)
+		if code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout {
+			failure = errors.Wrapf(err, "while importing order %s", row.order.OrderId)
+			for j := i; j < len(rows); j++ {
+				if rows[j].err == "" {
+					rows[j].err = msg
+				}
+			}
+			break
+		}
+		log.Error(fmt.Sprintf("Error importing order: '%+v'", row.order), err)
+		row.err = msg
+	}
+	orderHandler.record(r, audit.Entry{Operation: audit.ImportOrders, Namespace: ns, Rows: imported}, failure)
+	if imported == 0 {
+		return failure
+	}
+	if failure != nil {
+		log.Error("Error importing orders, the remaining lines are reported as failed.", failure)
+	}
+	return nil
+}
+
+// checkImportRows sets the error of the valid rows which would not be inserted, as their order already exists.
+func checkImportRows(repo repository.OrderRepository, rows []importRow) error {
+	type orderKey struct{ namespace, orderID string }
+	existing := make(map[orderKey]bool)
+	read := make(map[string]bool)
+	for i := range rows {
+		row := &rows[i]
+		if row.err != "" {
+			continue
+		}
+		if !read[row.order.Namespace] {
+			err := repository.StreamOrders(repo, row.order.Namespace, nil, func(order repository.Order) error {
+				existing[orderKey{order.Namespace, order.OrderId}] = true
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "while reading the orders of namespace %s", row.order.Namespace)
+			}
+			read[row.order.Namespace] = true
+		}
+
+		key := orderKey{row.order.Namespace, row.order.OrderId}
+		if existing[key] {
+			row.err = fmt.Sprintf("Order %s already exists.", row.order.OrderId)
+			continue
+		}
+		// a later line with the same order would fail
+		existing[key] = true
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	responseObj "github.com/yemramirezca/http-db-service/handler/response"
+)
+
+func newImportRouter(orderHandler Order) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/orders", orderHandler.GetOrders).Methods(http.MethodGet)
+	router.HandleFunc("/orders/import", orderHandler.ImportOrders).Methods(http.MethodPost)
+	router.HandleFunc("/namespace/{namespace}/orders/import", orderHandler.ImportOrders).Methods(http.MethodPost)
+	return router
+}
+
+func importOrders(t *testing.T, router http.Handler, path, body string) (*httptest.ResponseRecorder, importReport) {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	var report importReport
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	}
+	return rec, report
+}
+
+func TestImportOrders(t *testing.T) {
+	// given
+	repo := repository.NewOrderRepositoryMemory()
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId9", Namespace: "n7", Total: 10}))
+	auditLog := audit.NewMemory()
+	audits := &audit.Registry{}
+	audits.Register(config.DefaultTenant, auditLog)
+	router := newImportRouter(NewTenantOrderHandler(repo, nil, audits, nil))
+	body := "\ufefforderId,total,labels,namespace\n" +
+		"orderId1,10.5,\"channel=web,region=eu\",n7\n" +
+		"orderId2,20,,\n" +
+		"orderId3,abc,,n7\n" +
+		"orderId4,0,,n7\n" +
+		"orderId5,10,channel=we b,n7\n" +
+		"orderId6,10\n" +
+		"orderId9,10,,n7\n" +
+		"orderId1,30,,n7\n"
+
+	for _, dryRun := range []bool{true, false} {
+		path := "/orders/import"
+		if dryRun {
+			path += "?dryRun=true"
+		}
+
+		// when
+		res, report := importOrders(t, router, path, body)
+
+		// then
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, dryRun, report.DryRun)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, 6, report.Failed)
+		assert.Equal(t, []importError{
+			{Line: 4, OrderId: "orderId3", Message: "Invalid total 'abc'."},
+			{Line: 5, OrderId: "orderId4", Message: missingFieldsMessage},
+			{Line: 6, OrderId: "orderId5", Message: "Invalid labels, label 'channel': invalid label value 'we b'."},
+			{Line: 7, Message: "Expected 4 fields, found 2."},
+			{Line: 8, OrderId: "orderId9", Message: "Order orderId9 already exists."},
+			{Line: 9, OrderId: "orderId1", Message: "Order orderId1 already exists."},
+		}, report.Errors)
+	}
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []repository.Order{
+		{OrderId: "orderId9", Namespace: "n7", Total: 10},
+		{OrderId: "orderId1", Namespace: "n7", Total: 10.5, Labels: map[string]string{"channel": "web", "region": "eu"}},
+		{OrderId: "orderId2", Namespace: defaultNamespace, Total: 20},
+	}, orders)
+	entries, err := auditLog.Entries(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "dry runs are not recorded")
+	assert.Equal(t, audit.ImportOrders, entries[0].Operation)
+	assert.Equal(t, int64(2), entries[0].Rows)
+}
+
+func TestImportNamespaceOrders(t *testing.T) {
+	repo := repository.NewOrderRepositoryMemory()
+	router := newImportRouter(NewOrderHandler(repo))
+
+	// when
+	res, report := importOrders(t, router, "/namespace/n7/orders/import", "orderId,namespace,total\norderId1,,10\norderId2,n8,10\n")
+
+	// then
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, []importError{{Line: 3, OrderId: "orderId2", Message: "Order belongs to namespace n8, not n7."}}, report.Errors)
+	orders, err := repo.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{{OrderId: "orderId1", Namespace: "n7", Total: 10}}, orders)
+}
+
+func TestImportOrdersBadRequest(t *testing.T) {
+	router := newImportRouter(NewOrderHandler(repository.NewOrderRepositoryMemory()))
+
+	for name, tc := range map[string]struct{ path, body string }{
+		"no header":        {"/orders/import", ""},
+		"no orders":        {"/orders/import", "orderId,total\n"},
+		"unknown column":   {"/orders/import", "orderId,total,price\norderId1,10,10\n"},
+		"duplicate column": {"/orders/import", "orderId,total,total\norderId1,10,10\n"},
+		"missing column":   {"/orders/import", "orderId,namespace\norderId1,n7\n"},
+		"malformed CSV":    {"/orders/import", "orderId,total\n\"orderId1,10\n"},
+		"invalid dry run":  {"/orders/import?dryRun=maybe", "orderId,total\norderId1,10\n"},
+		"too many orders":  {"/orders/import", "orderId,total\n" + strings.Repeat("orderId1,10\n", maxImportOrders+1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, _ := importOrders(t, router, tc.path, tc.body)
+
+			assert.Equal(t, http.StatusBadRequest, res.Code)
+		})
+	}
+}
+
+func TestImportOrdersTooLarge(t *testing.T) {
+	router := newImportRouter(NewOrderHandler(repository.NewOrderRepositoryMemory()))
+
+	res, _ := importOrders(t, router, "/orders/import", "orderId,total\norderId1,"+strings.Repeat("1", maxImportBytes)+"\n")
+
+	// then
+	var body responseObj.Body
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, fmt.Sprintf("Invalid CSV, larger than %d bytes.", maxImportBytes), body.Message)
+}
+
+func TestImportOrdersDatabaseUnavailable(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	repoMock.On("InsertOrder", mock.MatchedBy(func(order repository.Order) bool { return order.OrderId == "orderId1" })).Return(nil).Once()
+	repoMock.On("InsertOrder", mock.Anything).Return(pkgerrors.Wrap(repository.ErrUnavailable, "while inserting order")).Once()
+	router := newImportRouter(NewOrderHandler(&repoMock))
+
+	// when
+	res, report := importOrders(t, router, "/orders/import", "orderId,total\norderId1,10\norderId2,10\norderId3,10\n")
+
+	// then
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, []importError{
+		{Line: 3, OrderId: "orderId2", Message: "Database unavailable."},
+		{Line: 4, OrderId: "orderId3", Message: "Database unavailable."},
+	}, report.Errors)
+
+	t.Run("nothing imported", func(t *testing.T) {
+		repoMock.On("InsertOrder", mock.Anything).Return(pkgerrors.Wrap(repository.ErrUnavailable, "while inserting order")).Once()
+
+		res, _ := importOrders(t, router, "/orders/import", "orderId,total\norderId1,10\n")
+
+		assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	})
+}
+
+func TestExportOrdersAsCSV(t *testing.T) {
+	repo := repository.NewOrderRepositoryMemory()
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10.5, Labels: map[string]string{"channel": "web"}}))
+	require.NoError(t, repo.InsertOrder(repository.Order{OrderId: "=HYPERLINK(\"http://example.com\")", Namespace: "n7", Total: 20}))
+	router := newImportRouter(NewOrderHandler(repo))
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("Accept", "text/csv")
+
+	// when
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, res.Code)
+	lines := strings.Split(res.Body.String(), "\n")
+	assert.Equal(t, "orderId,namespace,total,labels", lines[0])
+	// the memory repository lists the orders in no particular order
+	assert.ElementsMatch(t, []string{"orderId1,n7,10.5,channel=web", "\"'=HYPERLINK(\"\"http://example.com\"\")\",n7,20,", ""}, lines[1:])
+
+	t.Run("imported again", func(t *testing.T) {
+		target := repository.NewOrderRepositoryMemory()
+
+		_, report := importOrders(t, newImportRouter(NewOrderHandler(target)), "/orders/import", res.Body.String())
+
+		assert.Equal(t, 2, report.Imported)
+		orders, err := target.GetOrders()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []repository.Order{
+			{OrderId: "orderId1", Namespace: "n7", Total: 10.5, Labels: map[string]string{"channel": "web"}},
+			{OrderId: "=HYPERLINK(\"http://example.com\")", Namespace: "n7", Total: 20},
+		}, orders)
+	})
+}
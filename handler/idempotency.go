@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/yemramirezca/http-db-service/db/idempotency"
+	"github.com/yemramirezca/http-db-service/handler/response"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// replayedHeader is set on the responses replayed to a request repeating an idempotency key.
+const replayedHeader = "Idempotent-Replayed"
+
+// insertOrderOnce inserts the order of a request carrying an idempotency key, and keeps the response in the idempotency
+// store of the end-user, to replay it to the requests repeating the key. A key repeated with another body is rejected
+// with 422, and with 409 while the first request is in progress, that is until it completes or its lease expires.
+// Responses with a 5xx status are not kept, so that the request can be retried.
+func (orderHandler Order) insertOrderOnce(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	if len(key) > idempotency.MaxKeyLength {
+		response.WriteCodeAndMessage(http.StatusBadRequest,
+			fmt.Sprintf("Invalid %s header, it cannot be longer than %d characters.", idempotencyKeyHeader, idempotency.MaxKeyLength), w)
+		return
+	}
+	endUser := r.Header.Get(header)
+	store := orderHandler.idempotency.Store(endUser)
+	if store == nil {
+		orderHandler.insertOrder(w, r, body)
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(hash[:])
+	record, reserved, err := store.Reserve(endUser, key, requestHash)
+	if err != nil {
+		log.Errorf("Error reserving idempotency key %s. %s", key, err)
+		response.WriteError(err, w)
+		return
+	}
+	if !reserved {
+		replay(w, record, requestHash)
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: w}
+	orderHandler.insertOrder(recorder, r, body)
+	if recorder.status < http.StatusInternalServerError {
+		record.Status = recorder.status
+		record.ContentType = w.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		err := store.Complete(record)
+		if err == nil {
+			return
+		}
+		log.Errorf("Error saving the response to idempotency key %s. %s", key, err)
+	}
+	// without a response to replay the key must not stay in progress
+	if err := store.Release(record); err != nil {
+		log.Errorf("Error releasing idempotency key %s. %s", key, err)
+	}
+}
+
+// replay writes the response kept for the first request with the same idempotency key.
+func replay(w http.ResponseWriter, record idempotency.Record, requestHash string) {
+	switch {
+	case record.RequestHash != requestHash:
+		response.WriteCodeAndMessage(http.StatusUnprocessableEntity,
+			fmt.Sprintf("%s %s was already used with another request body.", idempotencyKeyHeader, record.Key), w)
+	case record.InProgress():
+		response.WriteCodeAndMessage(http.StatusConflict,
+			fmt.Sprintf("A request with %s %s is still in progress.", idempotencyKeyHeader, record.Key), w)
+	default:
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set(replayedHeader, "true")
+		w.WriteHeader(record.Status)
+		if _, err := w.Write(record.Body); err != nil {
+			log.Error("Error sending response", err)
+		}
+	}
+}
+
+// responseRecorder keeps a copy of the status and body written to the ResponseWriter.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
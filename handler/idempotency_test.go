@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/idempotency"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	responseObj "github.com/yemramirezca/http-db-service/handler/response"
+)
+
+// newIdempotentHandler returns a handler keeping the responses in the returned store.
+func newIdempotentHandler(repo repository.OrderRepository) (Order, idempotency.Store) {
+	store := idempotency.NewMemory(time.Hour, time.Minute)
+	keys := &idempotency.Registry{}
+	keys.Register(config.DefaultTenant, store)
+	return NewTenantOrderHandler(repo, nil, nil, keys), store
+}
+
+func postOrder(orderHandler Order, key string, order repository.Order) *httptest.ResponseRecorder {
+	body := new(bytes.Buffer)
+	json.NewEncoder(body).Encode(order)
+	req := httptest.NewRequest(http.MethodPost, "/orders", body)
+	req.Header.Set("Idempotency-Key", key)
+	res := httptest.NewRecorder()
+	orderHandler.InsertOrder(res, req)
+	return res
+}
+
+func TestCreateOrderWithIdempotencyKeyIsReplayed(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	orderHandler, _ := newIdempotentHandler(&repoMock)
+
+	created := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
+	existing := repository.Order{OrderId: "orderId2", Namespace: "n7", Total: 10}
+	repoMock.On("InsertOrder", created).Return(nil).Once()
+	repoMock.On("InsertOrder", existing).Return(repository.ErrDuplicateKey).Once()
+
+	for key, tc := range map[string]struct {
+		order  repository.Order
+		status int
+	}{
+		"k1": {created, http.StatusCreated},
+		"k2": {existing, http.StatusConflict},
+	} {
+		// when
+		first := postOrder(orderHandler, key, tc.order)
+		retry := postOrder(orderHandler, key, tc.order)
+
+		// then
+		assert.Equal(t, tc.status, first.Code)
+		assert.Equal(t, tc.status, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestCreateOrderWithReusedIdempotencyKey(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	orderHandler, _ := newIdempotentHandler(&repoMock)
+
+	order := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
+	repoMock.On("InsertOrder", order).Return(nil).Once()
+	require.Equal(t, http.StatusCreated, postOrder(orderHandler, "k1", order).Code)
+
+	// when
+	order.Total = 20
+	res := postOrder(orderHandler, "k1", order)
+
+	// then
+	assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	var m responseObj.Body
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&m))
+	assert.Equal(t, "Idempotency-Key k1 was already used with another request body.", m.Message)
+}
+
+func TestCreateOrderWithIdempotencyKeyInProgress(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	orderHandler, store := newIdempotentHandler(&repoMock)
+
+	order := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
+	body := new(bytes.Buffer)
+	json.NewEncoder(body).Encode(order)
+	hash := sha256.Sum256(body.Bytes())
+	_, reserved, err := store.Reserve("", "k1", hex.EncodeToString(hash[:]))
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	// when
+	res := postOrder(orderHandler, "k1", order)
+
+	// then
+	assert.Equal(t, http.StatusConflict, res.Code)
+	var m responseObj.Body
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&m))
+	assert.Equal(t, "A request with Idempotency-Key k1 is still in progress.", m.Message)
+}
+
+func TestCreateOrderWithInvalidIdempotencyKey(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	orderHandler, _ := newIdempotentHandler(&repoMock)
+
+	// when
+	res := postOrder(orderHandler, strings.Repeat("k", idempotency.MaxKeyLength+1), repository.Order{OrderId: "orderId1", Total: 10})
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestCreateOrderWithIdempotencyKeyAfterFailure(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	orderHandler, _ := newIdempotentHandler(&repoMock)
+
+	order := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
+	repoMock.On("InsertOrder", order).Return(errors.New("an error")).Once()
+	repoMock.On("InsertOrder", order).Return(nil).Once()
+
+	// when
+	first := postOrder(orderHandler, "k1", order)
+	retry := postOrder(orderHandler, "k1", order)
+
+	// then the failed request is executed again
+	assert.Equal(t, http.StatusInternalServerError, first.Code)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Empty(t, retry.Header().Get("Idempotent-Replayed"))
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/handler/response"
+)
+
+// reservedNamespaces cannot be given explicitly, the default namespace is the one of the orders without a namespace.
+var reservedNamespaces = []string{defaultNamespace}
+
+// renameRequest is the body of a request renaming a namespace.
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+// mergeRequest is the body of a request merging a namespace into another one.
+type mergeRequest struct {
+	Into string `json:"into"`
+}
+
+// moveResult reports the orders moved from a namespace to another one.
+type moveResult struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Orders int64  `json:"orders"`
+}
+
+// validateNamespace returns why the namespace given by a client cannot be used, or an empty string if it can.
+func validateNamespace(ns string) string {
+	for _, reserved := range reservedNamespaces {
+		if ns == reserved {
+			return fmt.Sprintf("Invalid namespace, '%s' is reserved.", ns)
+		}
+	}
+	if err := repository.ValidateNamespace(ns); err != nil {
+		return fmt.Sprintf("Invalid namespace, %s.", err)
+	}
+	return ""
+}
+
+// GetNamespaces handles an http request for listing the namespaces having orders, with their number of orders
+// and the sum of their totals.
+func (orderHandler Order) GetNamespaces(w http.ResponseWriter, r *http.Request) {
+	repo := orderHandler.getRepository(r.Header.Get(header))
+	namespaces, err := repository.Namespaces(repo)
+	if err != nil {
+		log.Error("Error retrieving namespaces.", err)
+		response.WriteError(err, w)
+		return
+	}
+	if namespaces == nil {
+		namespaces = make([]repository.Namespace, 0)
+	}
+	writeJSON(w, namespaces)
+}
+
+// RenameNamespace handles an http request for moving the orders of the namespace specified as a path variable
+// to the namespace named in the JSON body, which must not have orders.
+func (orderHandler Order) RenameNamespace(w http.ResponseWriter, r *http.Request) {
+	var body renameRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		response.WriteCodeAndMessage(http.StatusBadRequest, "Invalid request body, expected the new name of the namespace.", w)
+		return
+	}
+	orderHandler.moveNamespace(w, r, body.Name, false)
+}
+
+// MergeNamespace handles an http request for moving the orders of the namespace specified as a path variable
+// into the namespace given in the JSON body, which must not have orders with the same OrderId.
+func (orderHandler Order) MergeNamespace(w http.ResponseWriter, r *http.Request) {
+	var body mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Into == "" {
+		response.WriteCodeAndMessage(http.StatusBadRequest, "Invalid request body, expected the namespace to merge into.", w)
+		return
+	}
+	orderHandler.moveNamespace(w, r, body.Into, true)
+}
+
+// moveNamespace moves the orders of the namespace of the path to the namespace to, and records it in the audit log.
+// The namespace of the path is not validated, so that namespaces created before validation can be renamed.
+func (orderHandler Order) moveNamespace(w http.ResponseWriter, r *http.Request, to string, merge bool) {
+	from := mux.Vars(r)["namespace"]
+	if msg := validateNamespace(to); msg != "" {
+		response.WriteCodeAndMessage(http.StatusBadRequest, msg, w)
+		return
+	}
+	if from == to {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid namespace, the orders are already in namespace %s.", to), w)
+		return
+	}
+
+	log.Debugf("Moving the orders of namespace %s to namespace %s", from, to)
+	repo := orderHandler.getRepository(r.Header.Get(header))
+	moved, err := repo.MoveNamespaceOrders(from, to, merge)
+	operation := audit.RenameNamespace
+	if merge {
+		operation = audit.MergeNamespace
+	}
+	orderHandler.record(r, audit.Entry{Operation: operation, Namespace: from, TargetNamespace: to, Rows: moved}, err)
+
+	switch {
+	case err == nil:
+		writeJSON(w, moveResult{From: from, To: to, Orders: moved})
+	case err == repository.ErrNotFound:
+		response.WriteCodeAndMessage(http.StatusNotFound, fmt.Sprintf("Namespace %s has no orders.", from), w)
+	case err == repository.ErrDuplicateKey && merge:
+		response.WriteCodeAndMessage(http.StatusConflict, fmt.Sprintf("Namespaces %s and %s have orders with the same ID, nothing was moved.", from, to), w)
+	case err == repository.ErrDuplicateKey:
+		response.WriteCodeAndMessage(http.StatusConflict, fmt.Sprintf("Namespace %s already has orders.", to), w)
+	default:
+		log.Errorf("Error moving the orders of namespace %s to namespace %s. %s", from, to, err)
+		response.WriteError(err, w)
+	}
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+func newNamespaceRouter(orderHandler Order) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/orders", orderHandler.InsertOrder).Methods(http.MethodPost)
+	router.HandleFunc("/namespace/{namespace}/orders", orderHandler.GetNamespaceOrders).Methods(http.MethodGet)
+	router.HandleFunc("/namespace/{namespace}/orders", orderHandler.UpsertNamespaceOrders).Methods(http.MethodPut)
+	router.HandleFunc("/namespace/{namespace}/orders/{orderId}", orderHandler.UpsertOrder).Methods(http.MethodPut)
+	router.HandleFunc("/namespace/{namespace}/orders/import", orderHandler.ImportOrders).Methods(http.MethodPost)
+	router.HandleFunc("/namespaces", orderHandler.GetNamespaces).Methods(http.MethodGet)
+	router.HandleFunc("/namespaces/{namespace}/rename", orderHandler.RenameNamespace).Methods(http.MethodPost)
+	router.HandleFunc("/namespaces/{namespace}/merge", orderHandler.MergeNamespace).Methods(http.MethodPost)
+	return router
+}
+
+func TestGetNamespaces(t *testing.T) {
+	repo := repository.NewOrderRepositoryMemory()
+	router := newNamespaceRouter(NewOrderHandler(repo))
+	res := serve(router, http.MethodGet, "/namespaces", "", nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, "[]", res.Body.String())
+	for _, order := range []repository.Order{{OrderId: "orderId1", Namespace: "n8", Total: 10}, {OrderId: "orderId1", Namespace: "n7", Total: 10.5}, {OrderId: "orderId2", Namespace: "n7", Total: 20}} {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+
+	// when
+	res = serve(router, http.MethodGet, "/namespaces", "", nil)
+
+	// then
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `[{"name":"n7","orders":2,"total":30.5},{"name":"n8","orders":1,"total":10}]`, res.Body.String())
+}
+
+func TestRenameAndMergeNamespaces(t *testing.T) {
+	// given
+	repo := repository.NewOrderRepositoryMemory()
+	for _, order := range []repository.Order{{OrderId: "orderId1", Namespace: "n7", Total: 10}, {OrderId: "orderId2", Namespace: "n7", Total: 20}, {OrderId: "orderId1", Namespace: "n8", Total: 30}, {OrderId: "orderId3", Namespace: "n10", Total: 40}} {
+		require.NoError(t, repo.InsertOrder(order))
+	}
+	auditLog := audit.NewMemory()
+	audits := &audit.Registry{}
+	audits.Register(config.DefaultTenant, auditLog)
+	router := newNamespaceRouter(NewTenantOrderHandler(repo, nil, audits, nil))
+
+	for _, tc := range []struct {
+		name, path, body string
+		status           int
+	}{
+		{"Rename to a namespace with orders", "/namespaces/n7/rename", `{"name": "n8"}`, http.StatusConflict},
+		{"Merge conflicting orders", "/namespaces/n7/merge", `{"into": "n8"}`, http.StatusConflict},
+		{"Rename an empty namespace", "/namespaces/n11/rename", `{"name": "n12"}`, http.StatusNotFound},
+		{"Rename to the default namespace", "/namespaces/n7/rename", `{"name": "default"}`, http.StatusBadRequest},
+		{"Merge into the default namespace", "/namespaces/n7/merge", `{"into": "default"}`, http.StatusBadRequest},
+		{"Rename to an uppercase namespace", "/namespaces/n7/rename", `{"name": "N9"}`, http.StatusBadRequest},
+		{"Rename to an invalid namespace", "/namespaces/n7/rename", `{"name": "N_9"}`, http.StatusBadRequest},
+		{"Rename to the same namespace", "/namespaces/n7/rename", `{"name": "n7"}`, http.StatusBadRequest},
+		{"Rename without a name", "/namespaces/n7/rename", `{}`, http.StatusBadRequest},
+		{"Rename", "/namespaces/n7/rename", `{"name": "n9"}`, http.StatusOK},
+		{"Merge", "/namespaces/n10/merge", `{"into": "n9"}`, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// when
+			res := serve(router, http.MethodPost, tc.path, "", []byte(tc.body))
+
+			// then
+			assert.Equal(t, tc.status, res.Code, res.Body.String())
+		})
+	}
+	namespaces, err := repository.Namespaces(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Namespace{{Name: "n8", Orders: 1, Total: 30}, {Name: "n9", Orders: 3, Total: 70}}, namespaces)
+	entries, err := auditLog.Entries(audit.Filter{Namespace: "n9", Operation: audit.MergeNamespace})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "n10", entries[0].Namespace)
+	assert.Equal(t, "n9", entries[0].TargetNamespace)
+}
+
+func TestInsertOrderValidatesNamespace(t *testing.T) {
+	router := newNamespaceRouter(NewOrderHandler(repository.NewOrderRepositoryMemory()))
+
+	for ns, status := range map[string]int{
+		"":                      http.StatusCreated,
+		"test-namespace":        http.StatusCreated,
+		"default":               http.StatusBadRequest,
+		"N7":                    http.StatusBadRequest,
+		"N_7":                   http.StatusBadRequest,
+		"-n7":                   http.StatusBadRequest,
+		strings.Repeat("a", 64): http.StatusBadRequest,
+	} {
+		t.Run(ns, func(t *testing.T) {
+			body, err := json.Marshal(repository.Order{OrderId: "orderId-" + ns, Namespace: ns, Total: 10})
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body))
+			res := httptest.NewRecorder()
+
+			router.ServeHTTP(res, req)
+
+			assert.Equal(t, status, res.Code)
+		})
+	}
+}
+
+func TestWritesRejectTheReservedNamespace(t *testing.T) {
+	repo := repository.NewOrderRepositoryMemory()
+	router := newNamespaceRouter(NewOrderHandler(repo))
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPost, "/orders", `{"orderId": "orderId1", "total": 10}`, http.StatusCreated},
+		{http.MethodPost, "/orders", `{"orderId": "orderId2", "namespace": "default", "total": 20}`, http.StatusBadRequest},
+		{http.MethodPut, "/namespace/default/orders/orderId3", `{"total": 30}`, http.StatusBadRequest},
+		{http.MethodPut, "/namespace/default/orders", `[{"orderId": "orderId4", "total": 40}]`, http.StatusBadRequest},
+		{http.MethodPost, "/namespace/default/orders/import", "orderId,total\norderId5,50\n", http.StatusBadRequest},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			// when
+			res := serve(router, tc.method, tc.path, "", []byte(tc.body))
+
+			// then
+			assert.Equal(t, tc.status, res.Code, res.Body.String())
+		})
+	}
+	// only the order written without a namespace is stored in the default one
+	orders, err := repo.GetNamespaceOrders(defaultNamespace)
+	require.NoError(t, err)
+	assert.Len(t, orders, 1)
+}
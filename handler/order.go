@@ -3,73 +3,105 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/yemramirezca/http-db-service/config"
 	"github.com/yemramirezca/http-db-service/handler/response"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/idempotency"
 	"github.com/yemramirezca/http-db-service/db/repository"
 )
 
 const defaultNamespace = "default"
 const header = "end-user"
+const labelSelectorParam = "labelSelector"
+const requestIDHeader = "X-Request-Id"
+const forwardedForHeader = "X-Forwarded-For"
+const missingFieldsMessage = "Invalid request body, orderId / total fields cannot be empty."
+
+// maxUpsertOrders limits the orders upserted by a single request.
+const maxUpsertOrders = 1000
+
+// Results of an upserted order.
+const (
+	upsertCreated = "created"
+	upsertUpdated = "updated"
+)
 
 // Order is used to expose the Order service's basic operations using the HTTP route handler methods which extend it.
 type Order struct {
-	repository1 repository.OrderRepositorySQL
-	repository2 repository.OrderRepositorySQL
-	serviceConfig config.Service
+	repository repository.OrderRepository
+	// tenants are the repositories of the end-users which have their own database.
+	tenants map[string]repository.OrderRepository
+	// audits records the changes to the orders, nothing is recorded if it is nil.
+	audits *audit.Registry
+	// idempotency keeps the responses replayed to the requests repeating an Idempotency-Key, keys are ignored if it is nil.
+	idempotency *idempotency.Registry
 }
 
 // NewOrderHandler creates a new 'OrderHandler' which provides route handlers for the given OrderRepository's operations.
-func NewOrderHandler(cfg config.Service) Order {
-	db1,err := repository.InitDb(cfg.DBConnection1)
-	if err != nil {
-		log.Fatal("Unable to connect to db 1", err)
-	}
-	db2,err := repository.InitDb(cfg.DBConnection2)
-	if err != nil {
-		log.Fatal("Unable to connect to db 2", err)
-	}
-	return Order{repository.OrderRepositorySQL{Database:db1, OrdersTableName:repository.DefaultTable},
-		repository.OrderRepositorySQL{Database:db2, OrdersTableName:repository.DefaultTable},
-		cfg}
+func NewOrderHandler(repo repository.OrderRepository) Order {
+	return Order{repository: repo}
+}
+
+// NewTenantOrderHandler creates a new 'OrderHandler' which serves the end-users found in tenants, identified by
+// the `end-user` request header, from their own OrderRepository and every other request from repo.
+// Every change to the orders is recorded in the audit log of the end-user found in audits, and the responses to the
+// requests carrying an `Idempotency-Key` header are kept in the store of the end-user found in keys.
+func NewTenantOrderHandler(repo repository.OrderRepository, tenants map[string]repository.OrderRepository, audits *audit.Registry, keys *idempotency.Registry) Order {
+	return Order{repository: repo, tenants: tenants, audits: audits, idempotency: keys}
 }
 
 // InsertOrder handles an http request for creating an Order given in JSON format.
 // The handler also validates the Order payload fields and handles duplicate entry or unexpected errors.
+// A request carrying an `Idempotency-Key` header is only executed once, see insertOrderOnce.
 func (orderHandler Order) InsertOrder(w http.ResponseWriter, r *http.Request) {
-	headerVal := r.Header.Get(header)
-
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Error("Error parsing request.", err)
 		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
 		return
 	}
-
 	defer r.Body.Close()
+
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" && orderHandler.idempotency != nil {
+		orderHandler.insertOrderOnce(w, r, key, b)
+		return
+	}
+	orderHandler.insertOrder(w, r, b)
+}
+
+// insertOrder creates the Order given in JSON format in the body of the request.
+func (orderHandler Order) insertOrder(w http.ResponseWriter, r *http.Request, b []byte) {
+	headerVal := r.Header.Get(header)
+
 	var order repository.Order
-	err = json.Unmarshal(b, &order)
-	if err != nil || order.OrderId == "" || order.Total == 0 {
-		response.WriteCodeAndMessage(http.StatusBadRequest, "Invalid request body, orderId / total fields cannot be empty.", w)
+	if err := json.Unmarshal(b, &order); err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, missingFieldsMessage, w)
+		return
+	}
+	if msg := validateOrder(order); msg != "" {
+		response.WriteCodeAndMessage(http.StatusBadRequest, msg, w)
 		return
 	}
 	if order.Namespace == "" {
 		order.Namespace = defaultNamespace
 	}
 
-
 	log.Debugf("Inserting order: '%+v'.", order)
-	repo,err := orderHandler.getRepository(headerVal)
+	repo := orderHandler.getRepository(headerVal)
+	err := repo.InsertOrder(order)
+	inserted := int64(1)
 	if err != nil {
-		response.WriteCodeAndMessage(http.StatusUnauthorized, err.Error(), w)
-		return
+		inserted = 0
 	}
-	err = repo.InsertOrder(order)
+	orderHandler.record(r, audit.Entry{Operation: audit.InsertOrder, Namespace: order.Namespace, OrderID: order.OrderId, Rows: inserted}, err)
 
 	switch err {
 	case nil:
@@ -78,37 +110,162 @@ func (orderHandler Order) InsertOrder(w http.ResponseWriter, r *http.Request) {
 		response.WriteCodeAndMessage(http.StatusConflict, fmt.Sprintf("Order %s already exists.", order.OrderId), w)
 	default:
 		log.Error(fmt.Sprintf("Error inserting order: '%+v'", order), err)
-		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
+		response.WriteError(err, w)
 	}
 }
 
-// GetOrders handles an http request for retrieving all Orders from all namespaces.
-// The orders list is marshalled in JSON format and sent to the `http.ResponseWriter`
-func (orderHandler Order) GetOrders(w http.ResponseWriter, r *http.Request) {
-	headerVal := r.Header.Get(header)
-	log.Debug("Retrieving orders")
-	repo,err := orderHandler.getRepository(headerVal)
+// UpsertOrder handles an http request for creating or replacing the Order given in JSON format, whose namespace
+// and OrderId are path variables. It answers with 201 if the order was created, and 200 if it replaced another one.
+func (orderHandler Order) UpsertOrder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ns, id := vars["namespace"], vars["orderId"]
+
+	var order repository.Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, "Invalid request body, expected an order.", w)
+		return
+	}
+	if (order.OrderId != "" && order.OrderId != id) || (order.Namespace != "" && order.Namespace != ns) {
+		response.WriteCodeAndMessage(http.StatusBadRequest, "Invalid request body, orderId / namespace fields must match the path.", w)
+		return
+	}
+	order.OrderId, order.Namespace = id, ns
+	if msg := validateOrder(order); msg != "" {
+		response.WriteCodeAndMessage(http.StatusBadRequest, msg, w)
+		return
+	}
+
+	log.Debugf("Upserting order: '%+v'.", order)
+	results, err := orderHandler.upsertOrders(r, []repository.Order{order})
 	if err != nil {
-		response.WriteCodeAndMessage(http.StatusUnauthorized, err.Error(), w)
+		log.Error(fmt.Sprintf("Error upserting order: '%+v'", order), err)
+		response.WriteError(err, w)
 		return
 	}
-	orders, err := repo.GetOrders()
+	status := http.StatusOK
+	if results[0].Result == upsertCreated {
+		status = http.StatusCreated
+	}
+	writeJSONCode(w, status, results[0])
+}
 
+// UpsertNamespaceOrders handles an http request for creating or replacing, all at once, the Orders given as a JSON
+// array in the namespace specified as a path variable. It answers with whether each order was created or replaced.
+func (orderHandler Order) UpsertNamespaceOrders(w http.ResponseWriter, r *http.Request) {
+	ns := mux.Vars(r)["namespace"]
+
+	var orders []repository.Order
+	if err := json.NewDecoder(r.Body).Decode(&orders); err != nil || len(orders) == 0 {
+		response.WriteCodeAndMessage(http.StatusBadRequest, "Invalid request body, expected a non-empty array of orders.", w)
+		return
+	}
+	if len(orders) > maxUpsertOrders {
+		response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid request body, at most %d orders can be upserted at once.", maxUpsertOrders), w)
+		return
+	}
+	ids := make(map[string]bool, len(orders))
+	for i := range orders {
+		if orders[i].Namespace != "" && orders[i].Namespace != ns {
+			response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid request body, order %s is not in namespace %s.", orders[i].OrderId, ns), w)
+			return
+		}
+		orders[i].Namespace = ns
+		if msg := validateOrder(orders[i]); msg != "" {
+			response.WriteCodeAndMessage(http.StatusBadRequest, msg, w)
+			return
+		}
+		if ids[orders[i].OrderId] {
+			response.WriteCodeAndMessage(http.StatusBadRequest, fmt.Sprintf("Invalid request body, order %s is given twice.", orders[i].OrderId), w)
+			return
+		}
+		ids[orders[i].OrderId] = true
+	}
+
+	log.Debugf("Upserting %d orders in namespace %s", len(orders), ns)
+	results, err := orderHandler.upsertOrders(r, orders)
 	if err != nil {
-		log.Error("Error retrieving orders.", err)
-		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
+		log.Errorf("Error upserting orders in namespace %s. %s", ns, err)
+		response.WriteError(err, w)
 		return
 	}
+	writeJSONCode(w, http.StatusOK, results)
+}
 
-	if err = respondOrders(orders, w); err != nil {
-		log.Error("Error sending orders response.", err)
-		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
+// upsertResult reports whether an upserted order was created or replaced an existing one.
+type upsertResult struct {
+	OrderId   string `json:"orderId"`
+	Namespace string `json:"namespace"`
+	Result    string `json:"result"`
+}
+
+// upsertOrders writes the orders to the repository of the end-user, and records the upsert in its audit log.
+func (orderHandler Order) upsertOrders(r *http.Request, orders []repository.Order) ([]upsertResult, error) {
+	repo := orderHandler.getRepository(r.Header.Get(header))
+	created, err := repo.UpsertOrders(orders)
+
+	entry := audit.Entry{Operation: audit.UpsertOrders, Namespace: orders[0].Namespace}
+	if len(orders) == 1 {
+		entry.OrderID = orders[0].OrderId
+	}
+	if err == nil {
+		entry.Rows = int64(len(orders))
+	}
+	orderHandler.record(r, entry, err)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]upsertResult, 0, len(orders))
+	for i, order := range orders {
+		result := upsertUpdated
+		if created[i] {
+			result = upsertCreated
+		}
+		results = append(results, upsertResult{OrderId: order.OrderId, Namespace: order.Namespace, Result: result})
+	}
+	return results, nil
+}
+
+// validateOrder returns why the order cannot be stored, or an empty string if it can.
+// Orders without a namespace are valid, they are stored in the default namespace.
+func validateOrder(order repository.Order) string {
+	if order.OrderId == "" || order.Total == 0 {
+		return missingFieldsMessage
+	}
+	if err := repository.ValidateLabels(order.Labels); err != nil {
+		return fmt.Sprintf("Invalid request body, %s.", err)
+	}
+	if order.Namespace != "" {
+		return validateNamespace(order.Namespace)
+	}
+	return ""
+}
+
+// GetOrders handles an http request for retrieving all Orders from all namespaces.
+// The optional `labelSelector` query parameter restricts the result to the orders whose labels match it.
+// The orders are streamed to the `http.ResponseWriter` as a JSON array, or as newline delimited JSON if the request accepts it.
+// The response carries an `ETag` and a `Last-Modified` header, it is 304 if the `If-None-Match` or `If-Modified-Since`
+// header of the request shows that the orders did not change.
+func (orderHandler Order) GetOrders(w http.ResponseWriter, r *http.Request) {
+	headerVal := r.Header.Get(header)
+	selector, err := parseLabelSelector(r)
+	if err != nil {
+		response.WriteCodeAndMessage(http.StatusBadRequest, err.Error(), w)
 		return
 	}
+
+	log.Debug("Retrieving orders")
+	repo := orderHandler.getRepository(headerVal)
+	respondOrders(w, r, repo, "", selector)
 }
 
 // GetNamespaceOrders handles an http request for retrieving all Orders from a namespace specified as a path variable.
-// The orders list is marshalled in JSON format and sent to the `http.ResponseWriter`.
+// The optional `labelSelector` query parameter restricts the result to the orders whose labels match it.
+// The orders are streamed to the `http.ResponseWriter` as a JSON array, or as newline delimited JSON if the request accepts it.
+// The response carries an `ETag` and a `Last-Modified` header, it is 304 if the `If-None-Match` or `If-Modified-Since`
+// header of the request shows that the orders did not change.
+// The orders are read from the PostgreSQL database named by the `uri` header if it is set, and from the
+// repository of the end-user otherwise.
 func (orderHandler Order) GetNamespaceOrders(w http.ResponseWriter, r *http.Request) {
 	headerVal := r.Header.Get(header)
 	ns, exists := mux.Vars(r)["namespace"]
@@ -116,60 +273,78 @@ func (orderHandler Order) GetNamespaceOrders(w http.ResponseWriter, r *http.Requ
 		response.WriteCodeAndMessage(http.StatusBadRequest, "No namespace provided.", w)
 		return
 	}
-
-	log.Debugf("Retrieving orders for namespace: %s\n", ns)
-	repo,err := orderHandler.getRepository(headerVal)
-	if err != nil {
-		response.WriteCodeAndMessage(http.StatusUnauthorized, err.Error(), w)
-		return
-	}
-	orders, err := repo.GetNamespaceOrders(ns)
+	selector, err := parseLabelSelector(r)
 	if err != nil {
-		log.Error("Error retrieving orders.", err)
-		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
+		response.WriteCodeAndMessage(http.StatusBadRequest, err.Error(), w)
 		return
 	}
 
-	if err = respondOrders(orders, w); err != nil {
-		log.Error("Error sending orders response.", err)
-		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
-		return
+	log.Debugf("Retrieving orders for namespace: %s\n", ns)
+	repo := orderHandler.getRepository(headerVal)
+	if dbURI := r.Header.Get(uriHeader); dbURI != "" {
+		if repo, err = uriRepository(dbURI); err != nil {
+			log.Error("Error connecting db.", err)
+			response.WriteError(err, w)
+			return
+		}
 	}
+	respondOrders(w, r, repo, ns, selector)
 }
 
-func respondOrders(orders []repository.Order, w http.ResponseWriter) error {
-	body, err := json.Marshal(orders)
-	if err != nil {
-		return err
+// respondOrders writes the orders matching the namespace and selector while they are read from the repository.
+// Once the first order has been sent the status code cannot change anymore, so if reading fails midway
+// the connection is aborted instead, and the client never mistakes a truncated listing for a complete one.
+// Nothing is read if the client already has the current listing.
+func respondOrders(w http.ResponseWriter, r *http.Request, repo repository.OrderRepository, ns string, selector repository.LabelSelector) {
+	if notModified(w, r, repo, ns) {
+		return
+	}
+	writer := response.NewOrderWriter(w, r)
+	err := repository.StreamOrders(repo, ns, selector, writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(body); err != nil {
-		return err
+	log.Error("Error retrieving orders.", err)
+	if writer.Started() {
+		panic(http.ErrAbortHandler)
 	}
-	return nil
+	response.WriteError(err, w)
 }
 
 // DeleteOrders handles an http request for deleting all Orders from all namespaces.
+// The optional `labelSelector` query parameter restricts the deletion to the orders whose labels match it.
 func (orderHandler Order) DeleteOrders(w http.ResponseWriter, r *http.Request) {
 	headerVal := r.Header.Get(header)
-	log.Debug("Deleting all orders")
-	repo,err := orderHandler.getRepository(headerVal)
+	selector, err := parseLabelSelector(r)
 	if err != nil {
-		response.WriteCodeAndMessage(http.StatusUnauthorized, err.Error(), w)
+		response.WriteCodeAndMessage(http.StatusBadRequest, err.Error(), w)
 		return
 	}
 
-	if err := repo.DeleteOrders(); err != nil {
+	log.Debug("Deleting all orders")
+	repo := orderHandler.getRepository(headerVal)
+
+	var deleted int64
+	if len(selector) > 0 {
+		deleted, err = repo.DeleteOrdersBySelector("", selector)
+	} else {
+		deleted, err = repo.DeleteOrders()
+	}
+	orderHandler.record(r, audit.Entry{Operation: audit.DeleteOrders, Selector: selector.String(), Rows: deleted}, err)
+	if err != nil {
 		log.Error("Error deleting orders.", err)
-		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
+		response.WriteError(err, w)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // DeleteNamespaceOrders handles an http request for deleting all Orders from a namespace specified as a path variable.
+// The optional `labelSelector` query parameter restricts the deletion to the orders whose labels match it.
 func (orderHandler Order) DeleteNamespaceOrders(w http.ResponseWriter, r *http.Request) {
 	headerVal := r.Header.Get(header)
 	ns, exists := mux.Vars(r)["namespace"]
@@ -177,21 +352,71 @@ func (orderHandler Order) DeleteNamespaceOrders(w http.ResponseWriter, r *http.R
 		response.WriteCodeAndMessage(http.StatusBadRequest, "No namespace provided.", w)
 		return
 	}
-	repo,err := orderHandler.getRepository(headerVal)
+	selector, err := parseLabelSelector(r)
 	if err != nil {
-		response.WriteCodeAndMessage(http.StatusUnauthorized, err.Error(), w)
+		response.WriteCodeAndMessage(http.StatusBadRequest, err.Error(), w)
 		return
 	}
+	repo := orderHandler.getRepository(headerVal)
 	log.Debugf("Deleting orders in namespace %s\n", ns)
-	if err := repo.DeleteNamespaceOrders(ns); err != nil {
+	var deleted int64
+	if len(selector) > 0 {
+		deleted, err = repo.DeleteOrdersBySelector(ns, selector)
+	} else {
+		deleted, err = repo.DeleteNamespaceOrders(ns)
+	}
+	orderHandler.record(r, audit.Entry{Operation: audit.DeleteNamespaceOrders, Namespace: ns, Selector: selector.String(), Rows: deleted}, err)
+	if err != nil {
 		log.Errorf("Deleting orders in namespace %s\n. %s", ns, err)
-		response.WriteCodeAndMessage(http.StatusInternalServerError, "Internal error.", w)
+		response.WriteError(err, w)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// parseLabelSelector reads the optional `labelSelector` query parameter of the request.
+func parseLabelSelector(r *http.Request) (repository.LabelSelector, error) {
+	return repository.ParseLabelSelector(r.URL.Query().Get(labelSelectorParam))
+}
 
-func (orderHandler Order) getRepository(header string) (*repository.OrderRepositorySQL, error) {
-	return &orderHandler.repository2, nil
+// getRepository returns the OrderRepository of the end-user, which is the shared one unless the end-user has its own.
+func (orderHandler Order) getRepository(endUser string) repository.OrderRepository {
+	if repo, exists := orderHandler.tenants[endUser]; exists {
+		return repo
+	}
+	return orderHandler.repository
+}
+
+// record completes the entry with the details of the request and the outcome of the operation, and writes it to the
+// audit log of the end-user. A failure to record is only logged, since the operation already happened.
+func (orderHandler Order) record(r *http.Request, entry audit.Entry, err error) {
+	if orderHandler.audits == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	entry.EndUser = r.Header.Get(header)
+	entry.RequestID = r.Header.Get(requestIDHeader)
+	entry.SourceIP = sourceIP(r)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	auditLog := orderHandler.audits.Log(entry.EndUser)
+	if auditLog == nil {
+		return
+	}
+	if err := auditLog.Record(entry); err != nil {
+		log.Errorf("Error recording audit entry '%+v'. %s", entry, err)
+	}
+}
+
+// sourceIP returns the address of the client, the first one of the `X-Forwarded-For` header if the request went through a proxy.
+func sourceIP(r *http.Request) string {
+	if forwarded := r.Header.Get(forwardedForHeader); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
 }
@@ -11,11 +11,14 @@ import (
 	"testing"
 
 	"github.com/gorilla/mux"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"io/ioutil"
 
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
 	"github.com/yemramirezca/http-db-service/db/repository"
 )
 
@@ -29,7 +32,7 @@ func TestCreateOrderSuccess(t *testing.T) {
 	ts := httptest.NewServer(router)
 	defer ts.Close()
 
-	newOrder := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	newOrder := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
 
 	repoMock.On("InsertOrder", newOrder).Return(nil)
 
@@ -89,7 +92,7 @@ func TestCreateOrderConflict(t *testing.T) {
 	defer ts.Close()
 
 	// when
-	newOrder := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	newOrder := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
 
 	repoMock.On("InsertOrder", newOrder).Return(repository.ErrDuplicateKey).Once()
 
@@ -128,7 +131,7 @@ func TestOrderCreateInternalError(t *testing.T) {
 	defer ts.Close()
 
 	// when
-	newOrder := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	newOrder := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
 
 	repoMock.On("InsertOrder", newOrder).Return(errors.New("an error")).Once()
 
@@ -229,6 +232,28 @@ func TestGetOrdersByNamespaceSuccess(t *testing.T) {
 	assert.Equal(t, "application/json;charset=UTF-8", resp.Header.Get("Content-Type"))
 }
 
+func TestGetOrdersByNamespaceFromUriDatabase(t *testing.T) {
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/namespace/{namespace}/orders", NewOrderHandler(&repoMock).GetNamespaceOrders).Methods(http.MethodGet)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// when
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/namespace/test-namespace/orders", nil)
+	require.NoError(t, err)
+	req.Header.Set("uri", "host=127.0.0.1 port=1 dbname=orders user=test password=test sslmode=disable connect_timeout=1")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// then the unreachable database of the header is read instead of the repository
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Empty(t, repoMock.Calls)
+}
+
 func TestGetOrderInternalError(t *testing.T) {
 	// given
 	repoMock := repository.MockOrderRepository{}
@@ -271,7 +296,7 @@ func TestDeletingOrdersSuccess(t *testing.T) {
 	ts := httptest.NewServer(router)
 	defer ts.Close()
 
-	repoMock.On("DeleteOrders").Return(nil).Once()
+	repoMock.On("DeleteOrders").Return(int64(0), nil).Once()
 
 	// when
 	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/orders", ts.URL), nil)
@@ -297,7 +322,7 @@ func TestDeleteOrdersByNamespaceSuccess(t *testing.T) {
 	testNS := "test-namespace"
 
 	// repo mock expects to be passed the namespace in the URL as parameter by the handler, otherwise the test will fail
-	repoMock.On("DeleteNamespaceOrders", testNS).Return(nil).Once()
+	repoMock.On("DeleteNamespaceOrders", testNS).Return(int64(0), nil).Once()
 
 	// when
 	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/namespace/%s/orders", ts.URL, testNS), nil)
@@ -319,7 +344,7 @@ func TestDeletingOrdersInternalError(t *testing.T) {
 	ts := httptest.NewServer(router)
 	defer ts.Close()
 
-	repoMock.On("DeleteOrders").Return(errors.New("an error")).Once()
+	repoMock.On("DeleteOrders").Return(int64(0), errors.New("an error")).Once()
 
 	// when
 	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/orders", ts.URL), nil)
@@ -343,3 +368,235 @@ func TestDeletingOrdersInternalError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
 	assert.Equal(t, 1, len(repoMock.Calls))
 }
+
+func TestGetOrdersByLabelSelectorSuccess(t *testing.T) {
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/namespace/{namespace}/orders", NewOrderHandler(&repoMock).GetNamespaceOrders).Methods(http.MethodGet)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	testNS := "test-namespace"
+
+	selector := repository.LabelSelector{{Key: "channel", Operator: repository.In, Values: []string{"web", "shop"}}}
+	ret := []repository.Order{{OrderId: "orderId1", Namespace: testNS, Total: 10, Labels: map[string]string{"channel": "web"}}}
+	repoMock.On("GetOrdersBySelector", testNS, selector).Return(ret, nil).Once()
+
+	// when
+	res, err := http.Get(fmt.Sprintf("%s/namespace/%s/orders?labelSelector=channel+in+(web,shop)", ts.URL, testNS))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// then
+	var orders []repository.Order
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&orders))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, ret, orders)
+}
+
+func TestDeleteOrdersInvalidLabelSelector(t *testing.T) {
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/orders", NewOrderHandler(&repoMock).DeleteOrders).Methods(http.MethodDelete)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// when
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/orders?labelSelector=channel", ts.URL), nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, 0, len(repoMock.Calls))
+}
+
+func TestGetOrdersAsNDJSON(t *testing.T) {
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/orders", NewOrderHandler(&repoMock).GetOrders).Methods(http.MethodGet)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	ret := []repository.Order{{OrderId: "orderId1", Namespace: "n7", Total: 10}, {OrderId: "orderId2", Namespace: "n7", Total: 20}}
+	repoMock.On("GetOrders").Return(ret, nil).Once()
+
+	// when
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/orders", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/x-ndjson")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// then
+	b, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"orderId\":\"orderId1\",\"namespace\":\"n7\",\"total\":10}\n{\"orderId\":\"orderId2\",\"namespace\":\"n7\",\"total\":20}\n", string(b))
+}
+
+func TestDeleteNamespaceOrdersUnavailable(t *testing.T) {
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/namespace/{namespace}/orders", NewOrderHandler(&repoMock).DeleteNamespaceOrders).Methods(http.MethodDelete)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	repoMock.On("DeleteNamespaceOrders", "test-namespace").Return(int64(0), pkgerrors.Wrap(repository.ErrUnavailable, "while deleting orders")).Once()
+
+	// when
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/namespace/test-namespace/orders", ts.URL), nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	// then
+	var m responseObj.Body
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&m))
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "Database unavailable.", m.Message)
+}
+
+func TestDeleteOrdersOfTenantEndUser(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+	tenantRepoMock := repository.MockOrderRepository{}
+	defer tenantRepoMock.AssertExpectations(t)
+
+	audits := &audit.Registry{}
+	audits.Register(config.DefaultTenant, audit.NewMemory())
+	audits.Register("alice", audit.NewMemory())
+
+	orderHandler := NewTenantOrderHandler(&repoMock, map[string]repository.OrderRepository{"alice": &tenantRepoMock}, audits, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/orders", orderHandler.DeleteOrders).Methods(http.MethodDelete)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tenantRepoMock.On("DeleteOrders").Return(int64(3), nil).Once()
+	repoMock.On("DeleteOrders").Return(int64(2), nil).Once()
+
+	for _, endUser := range []string{"alice", "bob"} {
+		// when
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/orders", ts.URL), nil)
+		require.NoError(t, err)
+		req.Header.Set("end-user", endUser)
+		req.Header.Set("X-Request-Id", "request-"+endUser)
+
+		res, err := http.DefaultClient.Do(req)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	}
+
+	for endUser, rows := range map[string]int64{"alice": 3, "bob": 2} {
+		entries, err := audits.Log(endUser).Entries(audit.Filter{})
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, endUser, entries[0].EndUser)
+		assert.Equal(t, "request-"+endUser, entries[0].RequestID)
+		assert.Equal(t, "127.0.0.1", entries[0].SourceIP)
+		assert.Equal(t, audit.DeleteOrders, entries[0].Operation)
+		assert.Equal(t, rows, entries[0].Rows)
+	}
+}
+
+func TestUpsertOrder(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/namespace/{namespace}/orders/{orderId}", NewOrderHandler(&repoMock).UpsertOrder).Methods(http.MethodPut)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	order := repository.Order{OrderId: "orderId1", Namespace: "n7", Total: 10}
+	repoMock.On("UpsertOrders", []repository.Order{order}).Return([]bool{true}, nil).Once()
+	repoMock.On("UpsertOrders", []repository.Order{order}).Return([]bool{false}, nil).Once()
+
+	for _, tc := range []struct {
+		status int
+		result string
+	}{
+		{http.StatusCreated, "created"},
+		{http.StatusOK, "updated"},
+	} {
+		// when
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/namespace/n7/orders/orderId1", bytes.NewBufferString(`{"total": 10}`))
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		// then
+		assert.Equal(t, tc.status, res.StatusCode)
+		var result map[string]string
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+		assert.Equal(t, map[string]string{"orderId": "orderId1", "namespace": "n7", "result": tc.result}, result)
+	}
+
+	t.Run("Order not matching the path", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/namespace/n7/orders/orderId1", bytes.NewBufferString(`{"orderId": "orderId2", "total": 10}`))
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestUpsertNamespaceOrders(t *testing.T) {
+	// given
+	repoMock := repository.MockOrderRepository{}
+	defer repoMock.AssertExpectations(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/namespace/{namespace}/orders", NewOrderHandler(&repoMock).UpsertNamespaceOrders).Methods(http.MethodPut)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	orders := []repository.Order{{OrderId: "orderId1", Namespace: "n7", Total: 10}, {OrderId: "orderId2", Namespace: "n7", Total: 20}}
+	repoMock.On("UpsertOrders", orders).Return([]bool{false, true}, nil).Once()
+
+	// when
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/namespace/n7/orders",
+		bytes.NewBufferString(`[{"orderId": "orderId1", "total": 10}, {"orderId": "orderId2", "namespace": "n7", "total": 20}]`))
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var results []map[string]string
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&results))
+	assert.Equal(t, []map[string]string{
+		{"orderId": "orderId1", "namespace": "n7", "result": "updated"},
+		{"orderId": "orderId2", "namespace": "n7", "result": "created"},
+	}, results)
+
+	for name, body := range map[string]string{
+		"no orders":                  `[]`,
+		"order given twice":          `[{"orderId": "orderId1", "total": 10}, {"orderId": "orderId1", "total": 20}]`,
+		"order of another namespace": `[{"orderId": "orderId1", "namespace": "n8", "total": 10}]`,
+		"order without total":        `[{"orderId": "orderId1"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, ts.URL+"/namespace/n7/orders", bytes.NewBufferString(body))
+			require.NoError(t, err)
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		})
+	}
+}
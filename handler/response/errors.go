@@ -0,0 +1,45 @@
+package response
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// StatusForError returns the HTTP status code and message reported to the client for an error of the repository.
+// Errors which are not repository errors are reported as internal errors.
+func StatusForError(err error) (int, string) {
+	if _, ok := errors.Cause(err).(*repository.UnavailableError); ok {
+		return http.StatusServiceUnavailable, "Database unavailable."
+	}
+	switch errors.Cause(err) {
+	case repository.ErrDuplicateKey:
+		return http.StatusConflict, "Already exists."
+	case repository.ErrNotFound:
+		return http.StatusNotFound, "Not found."
+	case repository.ErrConstraintViolation:
+		return http.StatusUnprocessableEntity, "Constraint violation."
+	case repository.ErrSerializationFailure:
+		return http.StatusConflict, "Conflicting concurrent update, please retry."
+	case repository.ErrUnavailable:
+		return http.StatusServiceUnavailable, "Database unavailable."
+	case repository.ErrTimeout:
+		return http.StatusGatewayTimeout, "Database timeout."
+	default:
+		return http.StatusInternalServerError, "Internal error."
+	}
+}
+
+// WriteError writes the status code and message matching the repository error, see StatusForError.
+// If the error tells when the database is worth trying again, it is sent in the `Retry-After` header.
+func WriteError(err error, w http.ResponseWriter) {
+	if unavailable, ok := errors.Cause(err).(*repository.UnavailableError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(unavailable.RetryAfter.Seconds()))))
+	}
+	code, msg := StatusForError(err)
+	WriteCodeAndMessage(code, msg, w)
+}
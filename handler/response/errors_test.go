@@ -0,0 +1,52 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+func TestStatusForError(t *testing.T) {
+	for err, expected := range map[error]int{
+		repository.ErrDuplicateKey:         http.StatusConflict,
+		repository.ErrNotFound:             http.StatusNotFound,
+		repository.ErrConstraintViolation:  http.StatusUnprocessableEntity,
+		repository.ErrSerializationFailure: http.StatusConflict,
+		repository.ErrUnavailable:          http.StatusServiceUnavailable,
+		repository.ErrTimeout:              http.StatusGatewayTimeout,
+		errors.New("an error"):             http.StatusInternalServerError,
+	} {
+		code, _ := StatusForError(pkgerrors.Wrap(err, "while reading orders from DB"))
+		assert.Equal(t, expected, code, err.Error())
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	WriteError(pkgerrors.Wrap(repository.ErrUnavailable, "while deleting orders"), recorder)
+
+	var body Body
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, Body{Status: http.StatusServiceUnavailable, Message: "Database unavailable."}, body)
+}
+
+func TestWriteErrorRetryAfter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	err := &repository.UnavailableError{Reason: "circuit breaker open", RetryAfter: 1500 * time.Millisecond}
+
+	WriteError(pkgerrors.Wrap(repository.TranslateError(err), "while reading orders from DB"), recorder)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+}
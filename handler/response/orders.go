@@ -0,0 +1,173 @@
+package response
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+const (
+	// NDJSONContentType is sent, and accepted, for listings written as one JSON order per line.
+	NDJSONContentType = "application/x-ndjson"
+	// CSVContentType is accepted for listings written as CSV, with a header row naming the CSVColumns.
+	CSVContentType  = "text/csv"
+	jsonContentType = "application/json;charset=UTF-8"
+	csvContentType  = "text/csv;charset=UTF-8"
+	flushEvery      = 100
+)
+
+// CSVColumns are the columns of an order written as CSV, labels being written as comma-separated `key=value` pairs.
+var CSVColumns = []string{"orderId", "namespace", "total", "labels"}
+
+// formulaPrefixes start the cells which spreadsheets evaluate as formulas.
+const formulaPrefixes = "=+-@\t\r"
+
+// EscapeCSVCell prefixes with a quote the text which a spreadsheet would otherwise evaluate as a formula, and the text
+// starting with a quote, so that UnescapeCSVCell reads back any text. The totals are numbers and never escaped.
+func EscapeCSVCell(text string) string {
+	if text != "" && strings.ContainsRune(formulaPrefixes+"'", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// UnescapeCSVCell returns the text of a cell written by EscapeCSVCell.
+func UnescapeCSVCell(cell string) string {
+	return strings.TrimPrefix(cell, "'")
+}
+
+// format is the representation of the orders written by an OrderWriter.
+type format int
+
+const (
+	jsonFormat format = iota
+	ndjsonFormat
+	csvFormat
+)
+
+// OrderWriter writes orders to an `http.ResponseWriter` one at a time, either as a JSON array,
+// as newline delimited JSON or as CSV, flushing regularly so that the client receives them while they are read.
+type OrderWriter struct {
+	w       http.ResponseWriter
+	format  format
+	csv     *csv.Writer
+	started bool
+	count   int
+}
+
+// NewOrderWriter creates an OrderWriter which writes newline delimited JSON or CSV if the request accepts it,
+// and a JSON array otherwise.
+func NewOrderWriter(w http.ResponseWriter, r *http.Request) *OrderWriter {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, NDJSONContentType):
+		return &OrderWriter{w: w, format: ndjsonFormat}
+	case strings.Contains(accept, CSVContentType):
+		return &OrderWriter{w: w, format: csvFormat, csv: csv.NewWriter(w)}
+	default:
+		return &OrderWriter{w: w, format: jsonFormat}
+	}
+}
+
+// Started reports whether the status code and part of the body were already sent,
+// after which an error can no longer be reported with a different status code.
+func (ow *OrderWriter) Started() bool {
+	return ow.started
+}
+
+// Write sends a single order, writing the status code and headers before the first one.
+func (ow *OrderWriter) Write(order repository.Order) error {
+	if ow.format == csvFormat {
+		return ow.writeCSV(order)
+	}
+
+	body, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	separator := ","
+	if !ow.started {
+		ow.start()
+		separator = ""
+	}
+	if ow.format == ndjsonFormat {
+		body = append(body, '\n')
+	} else {
+		body = append([]byte(separator), body...)
+	}
+	if _, err := ow.w.Write(body); err != nil {
+		return err
+	}
+	ow.written()
+	return nil
+}
+
+func (ow *OrderWriter) writeCSV(order repository.Order) error {
+	if !ow.started {
+		ow.start()
+	}
+	total := strconv.FormatFloat(order.Total, 'f', -1, 64)
+	record := []string{EscapeCSVCell(order.OrderId), EscapeCSVCell(order.Namespace), total, EscapeCSVCell(repository.FormatLabels(order.Labels))}
+	if err := ow.csv.Write(record); err != nil {
+		return err
+	}
+	ow.written()
+	return ow.csv.Error()
+}
+
+// Close completes the listing. It must only be called once every order was written successfully,
+// so that a listing interrupted by an error is never mistaken for a complete one.
+func (ow *OrderWriter) Close() error {
+	if !ow.started {
+		ow.start()
+	}
+	if ow.format == jsonFormat {
+		if _, err := ow.w.Write([]byte("]")); err != nil {
+			return err
+		}
+	}
+	ow.flush()
+	if ow.csv != nil {
+		return ow.csv.Error()
+	}
+	return nil
+}
+
+func (ow *OrderWriter) start() {
+	ow.started = true
+	switch ow.format {
+	case ndjsonFormat:
+		ow.w.Header().Set("Content-Type", NDJSONContentType)
+		ow.w.WriteHeader(http.StatusOK)
+	case csvFormat:
+		ow.w.Header().Set("Content-Type", csvContentType)
+		ow.w.WriteHeader(http.StatusOK)
+		ow.csv.Write(CSVColumns)
+	default:
+		ow.w.Header().Set("Content-Type", jsonContentType)
+		ow.w.WriteHeader(http.StatusOK)
+		ow.w.Write([]byte("["))
+	}
+}
+
+// written counts a sent order, and flushes every few orders.
+func (ow *OrderWriter) written() {
+	ow.count++
+	if ow.count%flushEvery == 0 {
+		ow.flush()
+	}
+}
+
+func (ow *OrderWriter) flush() {
+	if ow.csv != nil {
+		ow.csv.Flush()
+	}
+	if flusher, ok := ow.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
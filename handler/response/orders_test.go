@@ -0,0 +1,136 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+var orders = []repository.Order{
+	{OrderId: "orderId1", Namespace: "N7", Total: 10},
+	{OrderId: "orderId2", Namespace: "N7", Total: 20, Labels: map[string]string{"channel": "web"}},
+}
+
+func TestOrderWriterJSONArray(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := NewOrderWriter(recorder, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	for _, order := range orders {
+		require.NoError(t, writer.Write(order))
+	}
+	require.NoError(t, writer.Close())
+
+	var result []repository.Order
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, orders, result)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json;charset=UTF-8", recorder.Header().Get("Content-Type"))
+	assert.True(t, recorder.Flushed)
+}
+
+func TestOrderWriterEmptyJSONArray(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := NewOrderWriter(recorder, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	require.NoError(t, writer.Close())
+
+	assert.Equal(t, "[]", recorder.Body.String())
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestOrderWriterNDJSON(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set("Accept", NDJSONContentType)
+	writer := NewOrderWriter(recorder, request)
+
+	for _, order := range orders {
+		require.NoError(t, writer.Write(order))
+	}
+	require.NoError(t, writer.Close())
+
+	lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	for i, line := range lines {
+		var order repository.Order
+		require.NoError(t, json.Unmarshal([]byte(line), &order))
+		assert.Equal(t, orders[i], order)
+	}
+	assert.Equal(t, NDJSONContentType, recorder.Header().Get("Content-Type"))
+}
+
+func TestOrderWriterNotStartedBeforeFirstOrder(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := NewOrderWriter(recorder, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	assert.False(t, writer.Started())
+
+	require.NoError(t, writer.Write(orders[0]))
+
+	assert.True(t, writer.Started())
+	assert.Equal(t, `[{"orderId":"orderId1","namespace":"N7","total":10}`, recorder.Body.String())
+}
+
+func TestOrderWriterCSV(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set("Accept", "text/csv, */*")
+	writer := NewOrderWriter(recorder, request)
+
+	for _, order := range append(orders, repository.Order{OrderId: "order,3", Namespace: "N7", Total: 10.25, Labels: map[string]string{"region": "eu", "channel": "web"}}) {
+		require.NoError(t, writer.Write(order))
+	}
+	require.NoError(t, writer.Close())
+
+	assert.Equal(t, "orderId,namespace,total,labels\n"+
+		"orderId1,N7,10,\n"+
+		"orderId2,N7,20,channel=web\n"+
+		"\"order,3\",N7,10.25,\"channel=web,region=eu\"\n", recorder.Body.String())
+	assert.Equal(t, "text/csv;charset=UTF-8", recorder.Header().Get("Content-Type"))
+}
+
+func TestOrderWriterCSVEscapesFormulas(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set("Accept", CSVContentType)
+	writer := NewOrderWriter(recorder, request)
+
+	for _, id := range []string{"=1+1", "+1", "-1", "@SUM(A1)", "\tx", "\rx", "'x", "a=1"} {
+		require.NoError(t, writer.Write(repository.Order{OrderId: id, Namespace: "N7", Total: -10}))
+	}
+	require.NoError(t, writer.Close())
+
+	assert.Equal(t, "orderId,namespace,total,labels\n"+
+		"'=1+1,N7,-10,\n"+
+		"'+1,N7,-10,\n"+
+		"'-1,N7,-10,\n"+
+		"'@SUM(A1),N7,-10,\n"+
+		"'\tx,N7,-10,\n"+
+		"\"'\rx\",N7,-10,\n"+
+		"''x,N7,-10,\n"+
+		"a=1,N7,-10,\n", recorder.Body.String())
+}
+
+func TestUnescapeCSVCell(t *testing.T) {
+	for _, text := range []string{"=1+1", "'x", "''", "a'", ""} {
+		assert.Equal(t, text, UnescapeCSVCell(EscapeCSVCell(text)), text)
+	}
+}
+
+func TestOrderWriterEmptyCSV(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set("Accept", CSVContentType)
+	writer := NewOrderWriter(recorder, request)
+
+	require.NoError(t, writer.Close())
+
+	assert.Equal(t, "orderId,namespace,total,labels\n", recorder.Body.String())
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/postgres"
+	"github.com/yemramirezca/http-db-service/db/repository"
+)
+
+// uriHeader names the connection string of the PostgreSQL database which GetNamespaceOrders reads instead.
+const uriHeader = "uri"
+const uriOrdersTable = "orders"
+
+var (
+	uriPoolsMutex sync.Mutex
+	uriPools      = make(map[string]*sql.DB)
+)
+
+// uriRepository returns the repository of the orders table in the PostgreSQL database at conexionString.
+// Its connection pool is opened and migrated the first time it is used, and closed with every other pool on shutdown.
+func uriRepository(conexionString string) (repository.OrderRepository, error) {
+	uriPoolsMutex.Lock()
+	defer uriPoolsMutex.Unlock()
+
+	db, exists := uriPools[conexionString]
+	if !exists {
+		// the connection string contains credentials, so the pool is named after its position instead
+		name := fmt.Sprintf("%s uri %d", config.PostgresDriverName, len(uriPools)+1)
+		var err error
+		if db, err = postgres.InitDb(name, conexionString, config.Config{DbOrdersTableName: uriOrdersTable}); err != nil {
+			return nil, err
+		}
+		uriPools[conexionString] = db
+	}
+	return &repository.OrderRepositorySQL{Database: db, OrdersTableName: uriOrdersTable, Dialect: repository.PostgresDialect{}}, nil
+}
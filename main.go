@@ -1,58 +1,184 @@
 package main
 
 import (
-	"github.com/yemramirezca/http-db-service/handler/events"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
 	"github.com/rs/cors"
 	"github.com/vrischmann/envconfig"
 
-	_ "github.com/lib/pq"
 	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/audit"
+	"github.com/yemramirezca/http-db-service/db/backend"
+	"github.com/yemramirezca/http-db-service/db/breaker"
+	"github.com/yemramirezca/http-db-service/db/cache"
+	"github.com/yemramirezca/http-db-service/db/connection"
+	"github.com/yemramirezca/http-db-service/db/encryption"
+	"github.com/yemramirezca/http-db-service/db/idempotency"
+	"github.com/yemramirezca/http-db-service/db/outbox"
+	"github.com/yemramirezca/http-db-service/db/postgres"
+	"github.com/yemramirezca/http-db-service/db/repository"
+	"github.com/yemramirezca/http-db-service/db/retention"
+	"github.com/yemramirezca/http-db-service/db/retry"
 	"github.com/yemramirezca/http-db-service/handler"
-	r "github.com/yemramirezca/http-db-service/handler/dbconnections"
+	"github.com/yemramirezca/http-db-service/handler/events"
 )
 
-func main() {
-	log.Println("Starting service...")
+// shutdownTimeout is how long the requests in progress may take to complete on shutdown.
+const shutdownTimeout = 30 * time.Second
 
+// worker runs in the background until the context is done, such as the outbox relays and the retention jobs.
+type worker interface {
+	Run(ctx context.Context)
+}
+
+func main() {
 	var cfg config.Service
 	if err := envconfig.Init(&cfg); err != nil {
 		log.Panicf("Error loading main configuration %v\n", err.Error())
 	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(cfg.DbType, os.Args[2:]); err != nil {
+			log.Fatal("Unable to migrate database: ", err)
+		}
+		return
+	}
+
+	log.Println("Starting service...")
 	log.Print(cfg)
 
 	router := mux.NewRouter().StrictSlash(true)
 
-	addOrderHandlers(router, cfg)
+	workers, repos := addOrderHandlers(router, cfg)
 	addEventsHandler(router)
 	addAPIHandler(router)
+	addAdminHandlers(router)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var workersDone sync.WaitGroup
+	for _, w := range workers {
+		workersDone.Add(1)
+		go func(w worker) {
+			defer workersDone.Done()
+			w.Run(ctx)
+		}(w)
+	}
 
 	if err := startService(cfg.Port, router); err != nil {
 		log.Fatal("Unable to start server", err)
 	}
+	cancel()
+	workersDone.Wait()
+	closeRepositories(repos)
+	if err := connection.Default.Close(); err != nil {
+		log.Print("Unable to close database connections ", err)
+	}
 }
 
-func addOrderHandlers(router *mux.Router, cfg config.Service) {
+// addOrderHandlers registers the order routes and returns the workers of their databases: the purger of their
+// idempotency keys, the relays of their outboxes, their retention jobs and their re-encryption jobs, along with the
+// repositories to close on shutdown. The db configuration is loaded once and shared by every database.
+func addOrderHandlers(router *mux.Router, cfg config.Service) ([]worker, []repository.OrderRepository) {
+	dbCfg, err := loadDBConfig()
+	if err != nil {
+		log.Fatal("Unable to load db configuration", err)
+	}
+	log.Print(dbCfg)
 
-	/*repo, err := Create(dbType)
+	repo, err := backend.New(cfg.DbType, dbCfg)
 	if err != nil {
 		log.Fatal("Unable to initiate repository", err)
-	}*/
-
+	}
+	tenants, err := createTenantRepositories(cfg, dbCfg)
+	if err != nil {
+		log.Fatal("Unable to initiate end-user repositories", err)
+	}
+	auditRepositories(repo, tenants)
+	encryptionJobs, err := encryptRepositories(cfg, dbCfg, repo, tenants)
+	if err != nil {
+		log.Fatal("Unable to initiate encryption", err)
+	}
+	purger, err := registerIdempotencyStores(dbCfg, repo, tenants)
+	if err != nil {
+		log.Fatal("Unable to initiate idempotency stores", err)
+	}
+	relays := createRelays(dbCfg, repo, tenants)
+	jobs, err := createRetentionJobs(dbCfg, repo, tenants)
+	if err != nil {
+		log.Fatal("Unable to initiate retention jobs", err)
+	}
+	repos := []repository.OrderRepository{repo}
+	for _, tenantRepo := range tenants {
+		repos = append(repos, tenantRepo)
+	}
+	repo, tenants, err = cacheRepositories(cfg, dbCfg, repo, tenants)
+	if err != nil {
+		log.Fatal("Unable to initiate repository caches", err)
+	}
 
-	orderHandler := handler.NewOrderHandler(cfg)
+	orderHandler := handler.NewTenantOrderHandler(repo, tenants, audit.Default, idempotency.Default)
 
 	// orders
 	router.HandleFunc("/orders", orderHandler.InsertOrder).Methods(http.MethodPost)
+	router.HandleFunc("/orders/import", orderHandler.ImportOrders).Methods(http.MethodPost)
+	router.HandleFunc("/namespace/{namespace}/orders/import", orderHandler.ImportOrders).Methods(http.MethodPost)
+
+	router.HandleFunc("/namespace/{namespace}/orders/{orderId}", orderHandler.UpsertOrder).Methods(http.MethodPut)
+	router.HandleFunc("/namespace/{namespace}/orders", orderHandler.UpsertNamespaceOrders).Methods(http.MethodPut)
 
 	router.HandleFunc("/orders", orderHandler.GetOrders).Methods(http.MethodGet)
-	router.HandleFunc("/namespace/{namespace}/orders", r.GetNamespaceOrders).Methods(http.MethodGet)
+	router.HandleFunc("/namespace/{namespace}/orders", orderHandler.GetNamespaceOrders).Methods(http.MethodGet)
+
+	router.HandleFunc("/orders/backup", orderHandler.BackupOrders).Methods(http.MethodGet)
+	router.HandleFunc("/namespace/{namespace}/orders/backup", orderHandler.BackupOrders).Methods(http.MethodGet)
+	router.HandleFunc("/orders/restore", orderHandler.RestoreOrders).Methods(http.MethodPost)
+	router.HandleFunc("/namespace/{namespace}/orders/restore", orderHandler.RestoreOrders).Methods(http.MethodPost)
+
+	router.HandleFunc("/namespaces", orderHandler.GetNamespaces).Methods(http.MethodGet)
+	router.HandleFunc("/namespaces/{namespace}/rename", orderHandler.RenameNamespace).Methods(http.MethodPost)
+	router.HandleFunc("/namespaces/{namespace}/merge", orderHandler.MergeNamespace).Methods(http.MethodPost)
 
 	router.HandleFunc("/orders", orderHandler.DeleteOrders).Methods(http.MethodDelete)
 	router.HandleFunc("/namespace/{namespace}/orders", orderHandler.DeleteNamespaceOrders).Methods(http.MethodDelete)
+
+	workers := make([]worker, 0, len(relays)+len(jobs)+len(encryptionJobs)+1)
+	workers = append(workers, purger)
+	for _, relay := range relays {
+		workers = append(workers, relay)
+	}
+	for _, job := range jobs {
+		workers = append(workers, job)
+	}
+	for _, job := range encryptionJobs {
+		workers = append(workers, job)
+	}
+	return workers, repos
+}
+
+// closeRepositories closes the repositories which hold resources of their own, such as the snapshot of the in-memory
+// repository, written one last time, or the bolt file. The SQL connections are closed by the `db/connection` package.
+func closeRepositories(repos []repository.OrderRepository) {
+	for _, repo := range repos {
+		closer, ok := repo.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			log.Print("Unable to close repository ", err)
+		}
+	}
 }
 
 func addEventsHandler(router *mux.Router) {
@@ -66,36 +192,224 @@ func addAPIHandler(router *mux.Router) {
 	router.HandleFunc("/api.yaml", handler.SwaggerAPIHandler).Methods(http.MethodGet)
 }
 
+func addAdminHandlers(router *mux.Router) {
+	adminHandler := handler.NewAdminHandler(connection.Default, breaker.Default, cache.Default, audit.Default, retention.Default, encryption.Default)
+
+	router.HandleFunc("/admin/connections", adminHandler.GetConnections).Methods(http.MethodGet)
+	router.HandleFunc("/admin/health", adminHandler.GetHealth).Methods(http.MethodGet)
+	router.HandleFunc("/admin/cache", adminHandler.GetCacheMetrics).Methods(http.MethodGet)
+	router.HandleFunc("/admin/audit", adminHandler.GetAudit).Methods(http.MethodGet)
+	router.HandleFunc("/admin/retention", adminHandler.GetRetention).Methods(http.MethodGet)
+	router.HandleFunc("/admin/encryption", adminHandler.GetEncryption).Methods(http.MethodGet)
+	router.HandleFunc("/admin/encryption/{endUser}/rotate", adminHandler.RotateEncryptionKey).Methods(http.MethodPost)
+}
+
+// startService serves the router until the process is asked to terminate,
+// then waits for the requests in progress to complete.
 func startService(port string, router *mux.Router) error {
 	log.Printf("Starting server on port %s ", port)
 
 	c := cors.AllowAll()
-	return http.ListenAndServe(":"+port, c.Handler(router))
+	server := &http.Server{Addr: ":" + port, Handler: c.Handler(router)}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+// Create is used to create an OrderRepository based on the given dbtype, configured from the environment.
+// Every backend registered in the `db/backend` package is supported, see the constants in `config/config.go`.
+func Create(dbtype string) (repository.OrderRepository, error) {
+	dbCfg, err := loadDBConfig()
+	if err != nil {
+		return nil, err
+	}
+	log.Print(dbCfg)
+
+	return backend.New(dbtype, dbCfg)
+}
+
+func loadDBConfig() (config.Config, error) {
+	var dbCfg config.Config
+	if err := envconfig.Init(&dbCfg); err != nil {
+		return dbCfg, errors.Wrap(err, "while loading db configuration")
+	}
+	return dbCfg, nil
+}
+
+// auditRepositories registers the audit log of the database of every repository, before caches hide which database it is.
+func auditRepositories(repo repository.OrderRepository, tenants map[string]repository.OrderRepository) {
+	audit.Default.Register(config.DefaultTenant, audit.For(repo))
+	for endUser, tenantRepo := range tenants {
+		audit.Default.Register(endUser, audit.For(tenantRepo))
+	}
+}
+
+// registerIdempotencyStores registers the idempotency store of the database of every repository, before caches hide
+// which database it is, and returns the worker removing their expired keys.
+func registerIdempotencyStores(dbCfg config.Config, repo repository.OrderRepository, tenants map[string]repository.OrderRepository) (*idempotency.Purger, error) {
+	purger, err := idempotency.NewPurger(idempotency.Default, dbCfg.IdempotencyPurgeInterval)
+	if err != nil {
+		return nil, err
+	}
+	idempotency.Default.Register(config.DefaultTenant, idempotency.For(repo, dbCfg.IdempotencyKeyTTL, dbCfg.IdempotencyKeyLease))
+	for endUser, tenantRepo := range tenants {
+		idempotency.Default.Register(endUser, idempotency.For(tenantRepo, dbCfg.IdempotencyKeyTTL, dbCfg.IdempotencyKeyLease))
+	}
+	return purger, nil
+}
+
+// createRelays creates the relays delivering the events of the outbox of every database to the configured sink.
+// There are none if the outbox is disabled or the databases have no outbox.
+func createRelays(dbCfg config.Config, repo repository.OrderRepository, tenants map[string]repository.OrderRepository) []*outbox.Relay {
+	if dbCfg.OutboxSink == "" {
+		return nil
+	}
+
+	sink := outbox.NewHTTPSink(dbCfg.OutboxSink, dbCfg.OutboxTimeout)
+	var relays []*outbox.Relay
+	repos := map[string]repository.OrderRepository{config.DefaultTenant: repo}
+	for endUser, tenantRepo := range tenants {
+		repos[endUser] = tenantRepo
+	}
+	for name, r := range repos {
+		if relay, ok := outbox.For(name, r, sink, outbox.SettingsFor(dbCfg)); ok {
+			relays = append(relays, relay)
+		}
+	}
+	return relays
+}
+
+// createRetentionJobs creates the jobs applying the configured retention policies to the database of every repository,
+// before caches hide which database it is. Only the SQL databases can apply them.
+func createRetentionJobs(dbCfg config.Config, repo repository.OrderRepository, tenants map[string]repository.OrderRepository) ([]*retention.Job, error) {
+	policies, err := retention.ParsePolicies(dbCfg.RetentionPolicies)
+	if err != nil {
+		return nil, err
+	}
+	settings := retention.SettingsFor(dbCfg)
+	if err := settings.Validate(); len(policies) > 0 && err != nil {
+		return nil, err
+	}
+
+	repos := map[string]repository.OrderRepository{config.DefaultTenant: repo}
+	for endUser, tenantRepo := range tenants {
+		repos[endUser] = tenantRepo
+	}
+	for _, policy := range policies {
+		if _, exists := repos[policy.EndUser]; !exists {
+			return nil, errors.Errorf("Cannot apply the retention policy %s of unknown end-user %s", policy, policy.EndUser)
+		}
+	}
+	var jobs []*retention.Job
+	for name, r := range repos {
+		endUserPolicies := retention.PoliciesFor(policies, name)
+		if len(endUserPolicies) == 0 {
+			continue
+		}
+		job, ok := retention.Default.New(name, r, endUserPolicies, settings)
+		if !ok {
+			return nil, errors.Errorf("Cannot apply the retention policies of %s, its database is not an SQL one", name)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
 }
 
+// encryptRepositories encrypts the orders of the end-users listed in `EncryptedTenants`, `default` standing for the
+// repository of the end-users which have no database of their own, before caches hide which database it is. It returns
+// the jobs encrypting their existing orders. Only the SQL databases can be encrypted.
+func encryptRepositories(cfg config.Service, dbCfg config.Config, repo repository.OrderRepository, tenants map[string]repository.OrderRepository) ([]*encryption.Job, error) {
+	if len(cfg.EncryptedTenants) == 0 {
+		return nil, nil
+	}
+	if dbCfg.EncryptionKeyFile == "" {
+		return nil, errors.New("Cannot encrypt the orders without a master key file, see EncryptionKeyFile")
+	}
+	master, err := encryption.LoadMasterKeys(dbCfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	fields := dbCfg.EncryptedFields
+	if len(fields) == 0 {
+		fields = encryption.Fields
+	}
+	settings := encryption.SettingsFor(dbCfg)
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
 
-// Create is used to create an OrderRepository based on the given dbtype.
-// Currently the `MemoryDatabase` and `SQLServerDriverName` are supported.
-/*func Create(dbtype string) (repository.OrderRepository, error) {
+	var jobs []*encryption.Job
+	for _, tenant := range cfg.EncryptedTenants {
+		tenantRepo := repo
+		if tenant != config.DefaultTenant {
+			var exists bool
+			if tenantRepo, exists = tenants[tenant]; !exists {
+				return nil, errors.Errorf("Cannot encrypt the orders of unknown end-user %s", tenant)
+			}
+		}
+		job, err := encryption.Default.New(tenant, tenantRepo, master, fields, settings)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
 
-	var (
-		dbCfg config.Config
-		err error
-	)
-	if err = envconfig.Init(&dbCfg); err != nil {
-		return nil, errors.Wrap(err, "Error loading db configuration %v.")
+// cacheRepositories wraps the repositories of the tenants listed in `CachedTenants` with a cache, `default` standing
+// for the repository of the end-users which have no database of their own.
+func cacheRepositories(cfg config.Service, dbCfg config.Config, repo repository.OrderRepository, tenants map[string]repository.OrderRepository) (repository.OrderRepository, map[string]repository.OrderRepository, error) {
+	settings := cache.Settings{TTL: dbCfg.CacheTTL, MaxEntries: dbCfg.CacheMaxEntries}
+	for _, tenant := range cfg.CachedTenants {
+		if tenant == config.DefaultTenant {
+			repo = cache.Default.New(tenant, repo, settings)
+			continue
+		}
+		tenantRepo, exists := tenants[tenant]
+		if !exists {
+			return nil, nil, errors.Errorf("Cannot cache the repository of unknown end-user %s", tenant)
+		}
+		tenants[tenant] = cache.Default.New(tenant, tenantRepo, settings)
 	}
+	return repo, tenants, nil
+}
 
-	switch dbtype {
-	case config.MemoryDatabase:
-		return repository.NewOrderRepositoryMemory(), nil
-	case config.SQLServerDriverName:
-		mssql := mssqldb.Mssql{dbCfg}
-		return mssql.NewOrderRepositoryDb()
-	case config.PostgresDriverName:
-		postgresDB := postgres.Postgres{dbCfg}
-		return postgresDB.NewOrderRepositoryDb()
-	default:
-		return nil, errors.Errorf("Unsupported database type %s", dbtype)
+// createTenantRepositories connects to the PostgreSQL databases of the end-users which have their own.
+func createTenantRepositories(cfg config.Service, dbCfg config.Config) (map[string]repository.OrderRepository, error) {
+	tenants := make(map[string]repository.OrderRepository)
+	for _, tenant := range []struct{ endUser, connection string }{
+		{cfg.EndUser1, cfg.DBConnection1},
+		{cfg.EndUser2, cfg.DBConnection2},
+	} {
+		if tenant.endUser == "" || tenant.connection == "" {
+			continue
+		}
+		name := fmt.Sprintf("%s end-user %s", config.PostgresDriverName, tenant.endUser)
+		db, err := postgres.InitDb(name, tenant.connection, dbCfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while connecting to the database of end-user %s", tenant.endUser)
+		}
+		tenants[tenant.endUser] = &repository.OrderRepositorySQL{
+			Database:        breaker.Wrap(name, db, repository.PostgresDialect{}, dbCfg),
+			OrdersTableName: dbCfg.DbOrdersTableName,
+			Dialect:         repository.PostgresDialect{},
+			Retry:           retry.ForQueries(dbCfg),
+			Outbox:          dbCfg.OutboxSink != "",
+		}
 	}
-}*/
\ No newline at end of file
+	return tenants, nil
+}
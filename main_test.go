@@ -4,6 +4,7 @@ import (
 	"github.com/yemramirezca/http-db-service/db/repository"
 	"testing"
 	"os"
+	"path/filepath"
 
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -14,6 +15,10 @@ func TestRepositoryMemoryIntegration(t *testing.T) {
 	runTestsForRepoType("memory", t)
 }
 
+func TestRepositorySQLiteIntegration(t *testing.T) {
+	runTestsForRepoType("sqlite", t)
+}
+
 func TestRepositoryDbIntegration(t *testing.T) {
 	if os.Getenv("Host") == "" {
 		t.Skip("skipping test; DB Config not set")
@@ -84,8 +89,9 @@ func runTestsForRepoType(repositoryType string, t *testing.T) {
 
 	t.Run("Delete Namespace Orders", func(t *testing.T) {
 		//when
-		err := repo.DeleteNamespaceOrders("N7")
+		deleted, err := repo.DeleteNamespaceOrders("N7")
 		assert.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
 
 		// no orders in N7
 		resultOrders, err := repo.GetNamespaceOrders("N7")
@@ -100,8 +106,9 @@ func runTestsForRepoType(repositoryType string, t *testing.T) {
 
 	t.Run("Delete Orders", func(t *testing.T) {
 		//when
-		err := repo.DeleteOrders()
+		deleted, err := repo.DeleteOrders()
 		assert.NoError(t, err)
+		assert.Equal(t, int64(2), deleted)
 
 		resultOrders, err := repo.GetOrders()
 		assert.NoError(t, err)
@@ -109,4 +116,23 @@ func runTestsForRepoType(repositoryType string, t *testing.T) {
 	})
 
 	repo.CleanUp()
-}
\ No newline at end of file
+}
+
+func TestCloseRepositoriesWritesTheSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	order := repository.Order{OrderId: "orderId1", Namespace: "N7", Total: 10}
+	repo, err := repository.NewOrderRepositoryMemoryWithSnapshot(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, repo.InsertOrder(order))
+
+	// when
+	closeRepositories([]repository.OrderRepository{repository.NewOrderRepositoryMemory(), repo})
+
+	// then
+	reopened, err := repository.NewOrderRepositoryMemoryWithSnapshot(path, 0)
+	require.NoError(t, err)
+	defer reopened.CleanUp()
+	orders, err := reopened.GetOrders()
+	require.NoError(t, err)
+	assert.Equal(t, []repository.Order{order}, orders)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/vrischmann/envconfig"
+
+	"github.com/yemramirezca/http-db-service/config"
+	"github.com/yemramirezca/http-db-service/db/backend"
+	"github.com/yemramirezca/http-db-service/db/migrations"
+)
+
+const migrateUsage = "usage: migrate up|down|status"
+
+// migrate runs the `migrate` mode of the binary, which applies, reverts or lists the schema migrations
+// of the configured database instead of starting the service.
+func migrate(dbtype string, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	var dbCfg config.Config
+	if err := envconfig.Init(&dbCfg); err != nil {
+		return errors.Wrap(err, "while loading db configuration")
+	}
+	db, dialect, err := backend.OpenSQL(dbtype, dbCfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db, dialect, dbCfg.DbOrdersTableName)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		return migrator.Up()
+	case "down":
+		return migrator.Down()
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}